@@ -44,6 +44,7 @@ import (
 	"github.com/uptrace/uptrace/pkg/bunapp/chmigrations"
 	"github.com/uptrace/uptrace/pkg/bunapp/pgmigrations"
 	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/forwarder"
 	"github.com/uptrace/uptrace/pkg/httputil"
 	"github.com/uptrace/uptrace/pkg/metrics"
 	"github.com/uptrace/uptrace/pkg/org"
@@ -100,6 +101,7 @@ var serveCommand = &cli.Command{
 			org.Module,
 			metrics.Module,
 			tracing.Module,
+			forwarder.Module,
 
 			fx.Invoke(initPostgres),
 			fx.Invoke(initClickhouse),
@@ -57,6 +57,7 @@ const (
 
 	HostID           = "host_id"
 	HostName         = "host_name"
+	HostIP           = "host_ip"
 	HostType         = "host_type"
 	HostArch         = "host_arch"
 	HostImageName    = "host_image_name"
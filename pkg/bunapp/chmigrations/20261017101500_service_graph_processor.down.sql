@@ -0,0 +1,13 @@
+DROP VIEW IF EXISTS ?DB.metrics_uptrace_service_graph_client_duration_mv ?ON_CLUSTER
+
+--migration:split
+
+DROP VIEW IF EXISTS ?DB.metrics_uptrace_service_graph_server_duration_mv ?ON_CLUSTER
+
+--migration:split
+
+DROP VIEW IF EXISTS ?DB.metrics_uptrace_service_graph_failed_requests_mv ?ON_CLUSTER
+
+--migration:split
+
+DROP TABLE IF EXISTS ?DB.service_graph_edges ?ON_CLUSTER
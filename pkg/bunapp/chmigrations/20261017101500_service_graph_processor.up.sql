@@ -0,0 +1,180 @@
+DROP VIEW IF EXISTS ?DB.metrics_uptrace_service_graph_client_duration_mv ?ON_CLUSTER
+
+--migration:split
+
+DROP VIEW IF EXISTS ?DB.metrics_uptrace_service_graph_server_duration_mv ?ON_CLUSTER
+
+--migration:split
+
+DROP VIEW IF EXISTS ?DB.metrics_uptrace_service_graph_failed_requests_mv ?ON_CLUSTER
+
+--migration:split
+
+DROP TABLE IF EXISTS ?DB.service_graph_edges ?ON_CLUSTER
+
+--migration:split
+
+CREATE TABLE service_graph_edges ?ON_CLUSTER (
+  project_id UInt32 Codec(DoubleDelta, ?CODEC),
+  type LowCardinality(String) Codec(?CODEC),
+  time DateTime Codec(T64, ?CODEC),
+
+  client_attr LowCardinality(String) Codec(?CODEC),
+  client_name LowCardinality(String) Codec(?CODEC),
+  server_attr LowCardinality(String) Codec(?CODEC),
+  server_name LowCardinality(String) Codec(?CODEC),
+
+  deployment_environment LowCardinality(String) Codec(?CODEC),
+  service_namespace LowCardinality(String) Codec(?CODEC),
+
+  client_duration_min SimpleAggregateFunction(min, Float32) Codec(?CODEC),
+  client_duration_max SimpleAggregateFunction(max, Float32) Codec(?CODEC),
+  client_duration_sum SimpleAggregateFunction(sumWithOverflow, Float32) Codec(?CODEC),
+  client_duration_histogram AggregateFunction(quantilesBFloat16(0.5), Float32) Codec(?CODEC),
+
+  server_duration_min SimpleAggregateFunction(min, Float32) Codec(?CODEC),
+  server_duration_max SimpleAggregateFunction(max, Float32) Codec(?CODEC),
+  server_duration_sum SimpleAggregateFunction(sumWithOverflow, Float32) Codec(?CODEC),
+  server_duration_histogram AggregateFunction(quantilesBFloat16(0.5), Float32) Codec(?CODEC),
+
+  count SimpleAggregateFunction(sumWithOverflow, UInt32) Codec(Delta, ?CODEC),
+  error_count SimpleAggregateFunction(sumWithOverflow, UInt32) Codec(Delta, ?CODEC)
+)
+ENGINE = ?(REPLICATED)AggregatingMergeTree
+PARTITION BY toDate(time)
+ORDER BY (project_id, time, type, client_attr, client_name, server_attr, server_name, deployment_environment, service_namespace)
+PRIMARY KEY (project_id, time, type)
+TTL toDate(time) + INTERVAL ?SPANS_TTL DELETE
+SETTINGS ttl_only_drop_parts = 1,
+         storage_policy = ?SPANS_STORAGE
+
+--migration:split
+
+CREATE MATERIALIZED VIEW ?DB.metrics_uptrace_service_graph_client_duration_mv ?ON_CLUSTER
+TO ?DB.datapoint_minutes AS
+SELECT
+  e.project_id,
+  'uptrace_service_graph_client_duration' AS metric,
+  e.time,
+  xxHash64(
+    e.project_id,
+    e.type,
+    e.client_name,
+    e.server_name,
+    e.deployment_environment,
+    e.service_namespace
+  ) AS attrs_hash,
+
+  'histogram' AS instrument,
+  min(e.client_duration_min) AS min,
+  max(e.client_duration_max) AS max,
+  sum(e.client_duration_sum) AS sum,
+  sum(e.count) AS count,
+  quantilesBFloat16MergeState(0.5)(e.client_duration_histogram) AS histogram,
+
+  arrayConcat(
+    ['type', 'client', 'server'],
+    if(e.deployment_environment != '', ['deployment_environment'], []),
+    if(e.service_namespace != '', ['service_namespace'], [])
+  ) AS string_keys,
+  arrayConcat(
+    [e.type, e.client_name, e.server_name],
+    if(e.deployment_environment != '', [e.deployment_environment], []),
+    if(e.service_namespace != '', [e.service_namespace], [])
+  ) AS string_values
+FROM ?DB.service_graph_edges AS e
+WHERE e.count > 0 AND e.client_duration_sum > 0
+GROUP BY
+  e.project_id,
+  e.type,
+  e.time,
+  e.client_name,
+  e.server_name,
+  e.deployment_environment,
+  e.service_namespace
+
+--migration:split
+
+CREATE MATERIALIZED VIEW ?DB.metrics_uptrace_service_graph_server_duration_mv ?ON_CLUSTER
+TO ?DB.datapoint_minutes AS
+SELECT
+  e.project_id,
+  'uptrace_service_graph_server_duration' AS metric,
+  e.time,
+  xxHash64(
+    e.project_id,
+    e.type,
+    e.client_name,
+    e.server_name,
+    e.deployment_environment,
+    e.service_namespace
+  ) AS attrs_hash,
+
+  'histogram' AS instrument,
+  min(e.server_duration_min) AS min,
+  max(e.server_duration_max) AS max,
+  sum(e.server_duration_sum) AS sum,
+  sum(e.count) AS count,
+  quantilesBFloat16MergeState(0.5)(e.server_duration_histogram) AS histogram,
+
+  arrayConcat(
+    ['type', 'client', 'server'],
+    if(e.deployment_environment != '', ['deployment_environment'], []),
+    if(e.service_namespace != '', ['service_namespace'], [])
+  ) AS string_keys,
+  arrayConcat(
+    [e.type, e.client_name, e.server_name],
+    if(e.deployment_environment != '', [e.deployment_environment], []),
+    if(e.service_namespace != '', [e.service_namespace], [])
+  ) AS string_values
+FROM ?DB.service_graph_edges AS e
+WHERE e.count > 0 AND e.server_duration_sum > 0
+GROUP BY
+  e.project_id,
+  e.type,
+  e.time,
+  e.client_name,
+  e.server_name,
+  e.deployment_environment,
+  e.service_namespace
+
+--migration:split
+
+CREATE MATERIALIZED VIEW ?DB.metrics_uptrace_service_graph_failed_requests_mv ?ON_CLUSTER
+TO ?DB.datapoint_minutes AS
+SELECT
+  e.project_id,
+  'uptrace_service_graph_failed_requests' AS metric,
+  e.time,
+  xxHash64(
+    e.project_id,
+    e.type,
+    e.client_name,
+    e.server_name,
+    e.deployment_environment,
+    e.service_namespace
+  ) AS attrs_hash,
+
+  'counter' AS instrument,
+  sum(e.error_count) AS sum,
+
+  arrayConcat(
+    ['type', 'client', 'server'],
+    if(e.deployment_environment != '', ['deployment_environment'], []),
+    if(e.service_namespace != '', ['service_namespace'], [])
+  ) AS string_keys,
+  arrayConcat(
+    [e.type, e.client_name, e.server_name],
+    if(e.deployment_environment != '', [e.deployment_environment], []),
+    if(e.service_namespace != '', [e.service_namespace], [])
+  ) AS string_values
+FROM ?DB.service_graph_edges AS e
+WHERE e.error_count > 0
+GROUP BY
+  e.project_id,
+  e.type,
+  e.time,
+  e.client_name,
+  e.server_name,
+  e.deployment_environment,
+  e.service_namespace
@@ -1,8 +1,11 @@
 package bunapp
 
 import (
+	"errors"
 	"net/http"
 	"net/http/pprof"
+	"strconv"
+	"time"
 
 	"go.opentelemetry.io/otel/trace"
 
@@ -91,6 +94,12 @@ func httpErrorHandler(next bunrouter.HandlerFunc) bunrouter.HandlerFunc {
 			data["traceId"] = traceID
 		}
 
+		var retryErr interface{ RetryAfter() time.Duration }
+		if errors.As(err, &retryErr) {
+			w.Header().Set("Retry-After",
+				strconv.Itoa(int(retryErr.RetryAfter().Seconds())))
+		}
+
 		w.WriteHeader(statusCode)
 		_ = bunrouter.JSON(w, data)
 
@@ -12,9 +12,11 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/uptrace/uptrace/pkg/attrkey"
 	"github.com/wneessen/go-mail"
 	"gopkg.in/yaml.v3"
@@ -96,6 +98,7 @@ func defaultConfig() *Config {
 	conf.Listen.Scheme = "http"
 	conf.Listen.GRPC.Addr = ":14317"
 	conf.Listen.HTTP.Addr = ":14318"
+	conf.Listen.JaegerGRPC.Addr = ":14250"
 
 	conf.SMTPMailer.Port = 25
 	conf.SMTPMailer.From = "no-reply@localhost"
@@ -199,6 +202,38 @@ func validateConfig(conf *Config) error {
 	if err := conf.Listen.HTTP.init(); err != nil {
 		return fmt.Errorf("invalid listen.grpc option: %w", err)
 	}
+	if err := conf.Listen.JaegerGRPC.init(); err != nil {
+		return fmt.Errorf("invalid listen.jaeger_grpc option: %w", err)
+	}
+
+	if ff := conf.Listen.FluentForward; ff != nil && ff.Addr == "" {
+		ff.Addr = ":24224"
+	}
+	for i, sl := range conf.Listen.Syslog {
+		if err := sl.init(); err != nil {
+			return fmt.Errorf("invalid listen.syslog[%d] option: %w", i, err)
+		}
+	}
+	if sd := conf.Listen.StatsD; sd != nil {
+		if err := sd.init(); err != nil {
+			return fmt.Errorf("invalid listen.statsd option: %w", err)
+		}
+	}
+	if g := conf.Listen.Graphite; g != nil {
+		if err := g.init(); err != nil {
+			return fmt.Errorf("invalid listen.graphite option: %w", err)
+		}
+	}
+	for i, sc := range conf.ScrapeConfigs {
+		if err := sc.init(); err != nil {
+			return fmt.Errorf("invalid scrape_configs[%d] option: %w", i, err)
+		}
+	}
+	for i, fwd := range conf.Forwarders {
+		if err := fwd.init(); err != nil {
+			return fmt.Errorf("invalid forwarders[%d] option: %w", i, err)
+		}
+	}
 
 	if err := conf.initSite(); err != nil {
 		return err
@@ -214,6 +249,13 @@ func validateConfig(conf *Config) error {
 		conf.Spans.MaxWorkers = runtime.GOMAXPROCS(0)
 	}
 
+	if conf.Spans.TailSampling.DecisionWait == 0 {
+		conf.Spans.TailSampling.DecisionWait = 10 * time.Second
+	}
+	if conf.Spans.TailSampling.NumTraces == 0 {
+		conf.Spans.TailSampling.NumTraces = ScaleWithCPU(10000, 100000)
+	}
+
 	if conf.Logs.BatchSize == 0 {
 		conf.Logs.BatchSize = ScaleWithCPU(1000, 32000)
 	}
@@ -312,6 +354,17 @@ func validateProjects(projects []Project) error {
 		for i, attr := range project.PinnedAttrs {
 			project.PinnedAttrs[i] = strings.ReplaceAll(attr, ".", "_")
 		}
+
+		for i, policy := range project.TailSampling.Policies {
+			if err := policy.init(); err != nil {
+				return fmt.Errorf("project %d: invalid tail_sampling.policies[%d]: %w",
+					project.ID, i, err)
+			}
+		}
+
+		if err := project.RateLimits.init(); err != nil {
+			return fmt.Errorf("project %d: invalid rate_limits: %w", project.ID, err)
+		}
 	}
 
 	return nil
@@ -350,6 +403,13 @@ type Config struct {
 	Listen struct {
 		HTTP Listen `yaml:"http"`
 		GRPC Listen `yaml:"grpc"`
+		// JaegerGRPC is the Jaeger collector gRPC API.
+		JaegerGRPC Listen `yaml:"jaeger_grpc"`
+
+		FluentForward *FluentForwardListen `yaml:"fluent_forward"`
+		Syslog        []*SyslogListen      `yaml:"syslog"`
+		StatsD        *StatsDListen        `yaml:"statsd"`
+		Graphite      *GraphiteListen      `yaml:"graphite"`
 
 		TLS    *TLSServer `yaml:"tls"`
 		Scheme string     `yaml:"-"`
@@ -366,6 +426,13 @@ type Config struct {
 		BufferSize int `yaml:"buffer_size"`
 		BatchSize  int `yaml:"batch_size"`
 		MaxWorkers int `yaml:"max_workers"`
+
+		TailSampling struct {
+			// For how long spans are buffered before making a sampling decision.
+			DecisionWait time.Duration `yaml:"decision_wait"`
+			// Number of traces to buffer. When exceeded, the oldest trace is decided early.
+			NumTraces int `yaml:"num_traces"`
+		} `yaml:"tail_sampling"`
 	} `yaml:"spans"`
 
 	Logs struct {
@@ -388,6 +455,10 @@ type Config struct {
 		CumToDeltaSize int `yaml:"cum_to_delta_size"`
 	} `yaml:"metrics"`
 
+	ScrapeConfigs []*ScrapeConfig `yaml:"scrape_configs"`
+
+	Forwarders []*Forwarder `yaml:"forwarders"`
+
 	ServiceGraph struct {
 		Disabled bool `yaml:"disabled"`
 		Store    struct {
@@ -468,6 +539,94 @@ func (l *Listen) init() error {
 	return nil
 }
 
+// FluentForwardListen configures a TCP listener for the Fluent Forward protocol.
+type FluentForwardListen struct {
+	Addr string     `yaml:"addr"`
+	TLS  *TLSServer `yaml:"tls"`
+
+	// ProjectID is used for clients that don't use the shared-key handshake.
+	// When empty, clients must authenticate using a project token as the shared key.
+	ProjectID uint32 `yaml:"project_id"`
+}
+
+// SyslogListen configures a UDP or TCP listener for syslog messages.
+type SyslogListen struct {
+	// Network is either udp or tcp.
+	Network   string     `yaml:"network"`
+	Addr      string     `yaml:"addr"`
+	TLS       *TLSServer `yaml:"tls"`
+	ProjectID uint32     `yaml:"project_id"`
+}
+
+func (l *SyslogListen) init() error {
+	switch l.Network {
+	case "":
+		l.Network = "udp"
+	case "udp", "tcp":
+	default:
+		return fmt.Errorf("unsupported network %q (expected udp or tcp)", l.Network)
+	}
+	if l.Network == "udp" && l.TLS != nil {
+		return errors.New("tls requires tcp network")
+	}
+	if l.Addr == "" {
+		l.Addr = ":514"
+	}
+	if l.ProjectID == 0 {
+		return errors.New("project_id is required")
+	}
+	return nil
+}
+
+// StatsDListen configures a UDP listener for StatsD and DogStatsD metrics.
+type StatsDListen struct {
+	Addr      string `yaml:"addr"`
+	ProjectID uint32 `yaml:"project_id"`
+
+	// FlushInterval specifies how often aggregated metrics are flushed.
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+func (l *StatsDListen) init() error {
+	if l.Addr == "" {
+		l.Addr = ":8125"
+	}
+	if l.ProjectID == 0 {
+		return errors.New("project_id is required")
+	}
+	if l.FlushInterval == 0 {
+		l.FlushInterval = 10 * time.Second
+	}
+	return nil
+}
+
+// GraphiteListen configures TCP and UDP listeners for Graphite plaintext
+// and TCP listener for Graphite pickle protocols.
+type GraphiteListen struct {
+	Addr       string `yaml:"addr"`
+	PickleAddr string `yaml:"pickle_addr"`
+	ProjectID  uint32 `yaml:"project_id"`
+
+	// Separator is used to join metric name parts.
+	Separator string `yaml:"separator"`
+	// Templates that convert dotted metric paths into metric names and attributes,
+	// for example, "servers.* .host.measurement*".
+	Templates []string `yaml:"templates"`
+}
+
+func (l *GraphiteListen) init() error {
+	if l.Addr == "" {
+		l.Addr = ":2003"
+	}
+	if l.ProjectID == 0 {
+		return errors.New("project_id is required")
+	}
+	if l.Separator == "" {
+		l.Separator = "."
+	}
+	return nil
+}
+
 func (c *Config) SiteURL(sitePath string, args ...any) string {
 	u, err := url.Parse(c.Site.Addr)
 	if err != nil {
@@ -504,6 +663,203 @@ type CHConfig struct {
 	MaxExecutionTime time.Duration `yaml:"max_execution_time"`
 }
 
+// ScrapeConfig configures Uptrace to scrape Prometheus targets
+// using the same options as Prometheus does.
+type ScrapeConfig struct {
+	JobName        string        `yaml:"job_name"`
+	ScrapeInterval time.Duration `yaml:"scrape_interval"`
+	ScrapeTimeout  time.Duration `yaml:"scrape_timeout"`
+	MetricsPath    string        `yaml:"metrics_path"`
+	Scheme         string        `yaml:"scheme"`
+	Params         url.Values    `yaml:"params"`
+	TLS            *TLSClient    `yaml:"tls"`
+
+	// ProjectID is the project that receives metrics unless overridden by a static config.
+	ProjectID uint32 `yaml:"project_id"`
+
+	StaticConfigs        []*StaticConfig   `yaml:"static_configs"`
+	RelabelConfigs       []*relabel.Config `yaml:"relabel_configs"`
+	MetricRelabelConfigs []*relabel.Config `yaml:"metric_relabel_configs"`
+}
+
+type StaticConfig struct {
+	Targets   []string          `yaml:"targets"`
+	Labels    map[string]string `yaml:"labels"`
+	ProjectID uint32            `yaml:"project_id"`
+}
+
+func (c *ScrapeConfig) init() error {
+	if c.JobName == "" {
+		return errors.New("job_name is required")
+	}
+	if c.ScrapeInterval == 0 {
+		c.ScrapeInterval = time.Minute
+	}
+	if c.ScrapeTimeout == 0 {
+		c.ScrapeTimeout = min(10*time.Second, c.ScrapeInterval)
+	}
+	if c.ScrapeTimeout > c.ScrapeInterval {
+		return errors.New("scrape_timeout must not be greater than scrape_interval")
+	}
+	if c.MetricsPath == "" {
+		c.MetricsPath = "/metrics"
+	}
+	switch c.Scheme {
+	case "":
+		c.Scheme = "http"
+	case "http", "https":
+	default:
+		return fmt.Errorf("unsupported scheme: %q", c.Scheme)
+	}
+
+	for i, static := range c.StaticConfigs {
+		if static.ProjectID == 0 {
+			static.ProjectID = c.ProjectID
+		}
+		if static.ProjectID == 0 {
+			return fmt.Errorf("static_configs[%d]: project_id is required", i)
+		}
+	}
+
+	return nil
+}
+
+// Forwarder re-exports OTLP data received by Uptrace to a downstream endpoint.
+type Forwarder struct {
+	Name string `yaml:"name"`
+
+	// Projects whose data is forwarded. Empty means all projects.
+	Projects []uint32 `yaml:"projects"`
+	// Signals to forward: traces, logs, or metrics. Empty means all signals.
+	Signals []string `yaml:"signals"`
+
+	// Endpoint is an URL, for example, http://localhost:4317 for OTLP/gRPC
+	// or https://otlp.example.com for OTLP/HTTP.
+	Endpoint string            `yaml:"endpoint"`
+	Protocol string            `yaml:"protocol"`
+	Headers  map[string]string `yaml:"headers"`
+	TLS      *TLSClient        `yaml:"tls"`
+	Timeout  time.Duration     `yaml:"timeout"`
+
+	// Where is a TQL filter, for example, `_status_code = "error"`.
+	Where string `yaml:"where"`
+
+	Queue struct {
+		Size    int `yaml:"size"`
+		Workers int `yaml:"workers"`
+	} `yaml:"queue"`
+
+	Retry struct {
+		Disabled        bool          `yaml:"disabled"`
+		InitialInterval time.Duration `yaml:"initial_interval"`
+		MaxInterval     time.Duration `yaml:"max_interval"`
+		MaxElapsedTime  time.Duration `yaml:"max_elapsed_time"`
+	} `yaml:"retry"`
+}
+
+const (
+	ForwarderGRPC = "grpc"
+	ForwarderHTTP = "http"
+
+	SignalTraces  = "traces"
+	SignalLogs    = "logs"
+	SignalMetrics = "metrics"
+)
+
+func (f *Forwarder) init() error {
+	if f.Endpoint == "" {
+		return errors.New("endpoint is required")
+	}
+
+	u, err := url.Parse(f.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+	default:
+		return fmt.Errorf("endpoint scheme must be http or https, got %q", u.Scheme)
+	}
+
+	if f.Name == "" {
+		f.Name = u.Host
+	}
+
+	switch f.Protocol {
+	case "":
+		f.Protocol = ForwarderGRPC
+	case ForwarderGRPC, ForwarderHTTP:
+	default:
+		return fmt.Errorf("unsupported protocol: %q", f.Protocol)
+	}
+
+	if len(f.Signals) == 0 {
+		f.Signals = []string{SignalTraces, SignalLogs, SignalMetrics}
+	}
+	for _, signal := range f.Signals {
+		switch signal {
+		case SignalTraces, SignalLogs, SignalMetrics:
+		default:
+			return fmt.Errorf("unsupported signal: %q", signal)
+		}
+	}
+
+	if f.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative, got %s", f.Timeout)
+	}
+	if f.Queue.Size < 0 {
+		return fmt.Errorf("queue.size must not be negative, got %d", f.Queue.Size)
+	}
+	if f.Queue.Workers < 0 {
+		return fmt.Errorf("queue.workers must not be negative, got %d", f.Queue.Workers)
+	}
+	for _, opt := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"retry.initial_interval", f.Retry.InitialInterval},
+		{"retry.max_interval", f.Retry.MaxInterval},
+		{"retry.max_elapsed_time", f.Retry.MaxElapsedTime},
+	} {
+		if opt.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %s", opt.name, opt.value)
+		}
+	}
+
+	if f.Timeout == 0 {
+		f.Timeout = 10 * time.Second
+	}
+	if f.Queue.Size == 0 {
+		f.Queue.Size = 1000
+	}
+	if f.Queue.Workers == 0 {
+		f.Queue.Workers = runtime.GOMAXPROCS(0)
+	}
+	if f.Retry.InitialInterval == 0 {
+		f.Retry.InitialInterval = 5 * time.Second
+	}
+	if f.Retry.MaxInterval == 0 {
+		f.Retry.MaxInterval = 30 * time.Second
+	}
+	if f.Retry.MaxElapsedTime == 0 {
+		f.Retry.MaxElapsedTime = 5 * time.Minute
+	}
+	if f.Retry.MaxInterval < f.Retry.InitialInterval {
+		return fmt.Errorf("retry.max_interval (%s) must not be less than retry.initial_interval (%s)",
+			f.Retry.MaxInterval, f.Retry.InitialInterval)
+	}
+
+	return nil
+}
+
+// Forwards reports whether the data of the project and signal is forwarded.
+func (f *Forwarder) Forwards(projectID uint32, signal string) bool {
+	if len(f.Projects) > 0 && !slices.Contains(f.Projects, projectID) {
+		return false
+	}
+	return slices.Contains(f.Signals, signal)
+}
+
 func ScaleWithCPU(min, max int) int {
 	if min == 0 {
 		panic("min == 0")
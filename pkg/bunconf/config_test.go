@@ -0,0 +1,66 @@
+package bunconf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForwarderInit(t *testing.T) {
+	type Test struct {
+		name   string
+		update func(f *Forwarder)
+		err    string
+	}
+
+	tests := []Test{
+		{name: "defaults", update: func(f *Forwarder) {}},
+		{
+			name:   "negative timeout",
+			update: func(f *Forwarder) { f.Timeout = -time.Second },
+			err:    "timeout must not be negative",
+		},
+		{
+			name:   "negative queue size",
+			update: func(f *Forwarder) { f.Queue.Size = -1 },
+			err:    "queue.size must not be negative",
+		},
+		{
+			name:   "negative queue workers",
+			update: func(f *Forwarder) { f.Queue.Workers = -1 },
+			err:    "queue.workers must not be negative",
+		},
+		{
+			name:   "negative initial interval",
+			update: func(f *Forwarder) { f.Retry.InitialInterval = -time.Second },
+			err:    "retry.initial_interval must not be negative",
+		},
+		{
+			name:   "negative max elapsed time",
+			update: func(f *Forwarder) { f.Retry.MaxElapsedTime = -time.Second },
+			err:    "retry.max_elapsed_time must not be negative",
+		},
+		{
+			name:   "max interval less than initial interval",
+			update: func(f *Forwarder) { f.Retry.InitialInterval = time.Minute },
+			err:    "retry.max_interval (30s) must not be less than retry.initial_interval (1m0s)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &Forwarder{Endpoint: "http://localhost:4317"}
+			test.update(f)
+
+			err := f.init()
+			if test.err == "" {
+				require.NoError(t, err)
+				require.Equal(t, 1000, f.Queue.Size)
+				require.Equal(t, 30*time.Second, f.Retry.MaxInterval)
+				return
+			}
+			require.ErrorContains(t, err, test.err)
+		})
+	}
+}
@@ -1,5 +1,16 @@
 package bunconf
 
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/uptrace/uptrace/pkg/bunconv"
+)
+
 type User struct {
 	ID       uint64 `json:"id"`
 	Email    string `yaml:"email"`
@@ -38,4 +49,91 @@ type Project struct {
 	GroupFuncsByService bool     `yaml:"group_funcs_by_service"`
 	PromCompat          bool     `yaml:"prom_compat"`
 	ForceSpanName       []string `yaml:"force_span_name"`
+
+	TailSampling struct {
+		Policies []*SamplingPolicy `yaml:"policies"`
+	} `yaml:"tail_sampling"`
+
+	RateLimits RateLimits `yaml:"rate_limits"`
+}
+
+// RateLimits limit the ingestion rate per project. Zero means no limit.
+type RateLimits struct {
+	SpansPerSecond      int64    `yaml:"spans_per_second"`
+	LogsPerSecond       int64    `yaml:"logs_per_second"`
+	DatapointsPerSecond int64    `yaml:"datapoints_per_second"`
+	BytesPerDay         ByteSize `yaml:"bytes_per_day"`
+}
+
+func (l *RateLimits) init() error {
+	if l.SpansPerSecond < 0 || l.LogsPerSecond < 0 || l.DatapointsPerSecond < 0 ||
+		l.BytesPerDay < 0 {
+		return errors.New("limits can't be negative")
+	}
+	return nil
+}
+
+// ByteSize is a number of bytes that can be specified with units, for example, 10GB.
+type ByteSize int64
+
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	if n, err := strconv.ParseInt(value.Value, 10, 64); err == nil {
+		*b = ByteSize(n)
+		return nil
+	}
+
+	n, err := bunconv.ParseBytes(value.Value)
+	if err != nil {
+		return err
+	}
+	*b = ByteSize(n)
+	return nil
+}
+
+const (
+	SamplingPolicyError         = "error"
+	SamplingPolicyDuration      = "duration"
+	SamplingPolicyTQL           = "tql"
+	SamplingPolicyProbabilistic = "probabilistic"
+)
+
+// SamplingPolicy decides whether to keep a trace. Policies are evaluated in order
+// and traces that are not kept by any policy are dropped.
+type SamplingPolicy struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+
+	// Threshold is the minimal span duration for the duration policy.
+	Threshold time.Duration `yaml:"threshold"`
+	// Where is a TQL filter for the tql policy. The probabilistic policy
+	// uses it to select traces the rate applies to.
+	Where string `yaml:"where"`
+	// Rate is the fraction of traces kept by the probabilistic policy.
+	Rate float64 `yaml:"rate"`
+}
+
+func (p *SamplingPolicy) init() error {
+	if p.Name == "" {
+		p.Name = p.Type
+	}
+
+	switch p.Type {
+	case SamplingPolicyError:
+	case SamplingPolicyDuration:
+		if p.Threshold <= 0 {
+			return errors.New("threshold is required")
+		}
+	case SamplingPolicyTQL:
+		if p.Where == "" {
+			return errors.New("where is required")
+		}
+	case SamplingPolicyProbabilistic:
+		if p.Rate <= 0 || p.Rate > 1 {
+			return fmt.Errorf("rate must be in (0, 1], got %v", p.Rate)
+		}
+	default:
+		return fmt.Errorf("unsupported policy type: %q", p.Type)
+	}
+
+	return nil
 }
@@ -0,0 +1,214 @@
+package forwarder
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	collectorlogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	collectormetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	collectortracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/uptrace/uptrace/pkg/bunconf"
+)
+
+type exporter interface {
+	Export(ctx context.Context, signal string, msg proto.Message) error
+	Close() error
+}
+
+func newExporter(conf *bunconf.Forwarder) (exporter, error) {
+	u, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsConf *tls.Config
+	if u.Scheme == "https" {
+		if conf.TLS != nil {
+			tlsConf, err = conf.TLS.TLSConfig()
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			tlsConf = new(tls.Config)
+		}
+	}
+
+	switch conf.Protocol {
+	case bunconf.ForwarderHTTP:
+		return newHTTPExporter(conf, u, tlsConf), nil
+	default:
+		return newGRPCExporter(conf, u, tlsConf)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+type grpcExporter struct {
+	conn    *grpc.ClientConn
+	headers metadata.MD
+
+	traces  collectortracepb.TraceServiceClient
+	logs    collectorlogspb.LogsServiceClient
+	metrics collectormetricspb.MetricsServiceClient
+}
+
+func newGRPCExporter(
+	conf *bunconf.Forwarder, u *url.URL, tlsConf *tls.Config,
+) (*grpcExporter, error) {
+	creds := insecure.NewCredentials()
+	if tlsConf != nil {
+		creds = credentials.NewTLS(tlsConf)
+	}
+
+	conn, err := grpc.NewClient(u.Host, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+
+	return &grpcExporter{
+		conn:    conn,
+		headers: metadata.New(conf.Headers),
+
+		traces:  collectortracepb.NewTraceServiceClient(conn),
+		logs:    collectorlogspb.NewLogsServiceClient(conn),
+		metrics: collectormetricspb.NewMetricsServiceClient(conn),
+	}, nil
+}
+
+func (e *grpcExporter) Export(ctx context.Context, signal string, msg proto.Message) error {
+	if len(e.headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, e.headers)
+	}
+
+	var err error
+	switch msg := msg.(type) {
+	case *collectortracepb.ExportTraceServiceRequest:
+		_, err = e.traces.Export(ctx, msg)
+	case *collectorlogspb.ExportLogsServiceRequest:
+		_, err = e.logs.Export(ctx, msg)
+	case *collectormetricspb.ExportMetricsServiceRequest:
+		_, err = e.metrics.Export(ctx, msg)
+	default:
+		return fmt.Errorf("unsupported %s request: %T", signal, msg)
+	}
+	if err == nil {
+		return nil
+	}
+
+	switch status.Code(err) {
+	case codes.Canceled,
+		codes.DeadlineExceeded,
+		codes.ResourceExhausted,
+		codes.Aborted,
+		codes.OutOfRange,
+		codes.Unavailable,
+		codes.DataLoss:
+		return &retryableError{err: err}
+	default:
+		return err
+	}
+}
+
+func (e *grpcExporter) Close() error {
+	return e.conn.Close()
+}
+
+//------------------------------------------------------------------------------
+
+type httpExporter struct {
+	client  *http.Client
+	baseURL string
+	headers map[string]string
+}
+
+func newHTTPExporter(conf *bunconf.Forwarder, u *url.URL, tlsConf *tls.Config) *httpExporter {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+
+	return &httpExporter{
+		client:  &http.Client{Transport: transport},
+		baseURL: strings.TrimSuffix(u.String(), "/"),
+		headers: conf.Headers,
+	}
+}
+
+func (e *httpExporter) Export(ctx context.Context, signal string, msg proto.Message) error {
+	body, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, e.baseURL+"/v1/"+signal, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		// Network errors are usually temporary.
+		return &retryableError{err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	err = fmt.Errorf("%s %s: %s", req.URL, resp.Status, bytes.TrimSpace(b))
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return &retryableError{
+			err:   err,
+			delay: retryAfter(resp.Header.Get("Retry-After")),
+		}
+	default:
+		return err
+	}
+}
+
+func (e *httpExporter) Close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// retryAfter parses the Retry-After header that contains either
+// the number of seconds or an HTTP date.
+func retryAfter(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
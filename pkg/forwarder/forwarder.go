@@ -0,0 +1,273 @@
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/bunotel"
+	"github.com/uptrace/uptrace/pkg/run"
+	"github.com/uptrace/uptrace/pkg/tracing/tql"
+)
+
+var requestCounter, _ = bunotel.Meter.Int64Counter(
+	"uptrace.forwarder.requests",
+	metric.WithDescription("Number of OTLP requests re-exported by forwarders"),
+)
+
+var Module = fx.Module("forwarder",
+	fx.Provide(NewForwarders),
+	fx.Invoke(runForwarders),
+)
+
+type ForwardersParams struct {
+	fx.In
+
+	Logger *otelzap.Logger
+	Conf   *bunconf.Config
+}
+
+// Forwarders re-export OTLP requests to the downstream endpoints
+// configured using the forwarders option.
+type Forwarders struct {
+	list []*Forwarder
+}
+
+func NewForwarders(p ForwardersParams) (*Forwarders, error) {
+	fs := new(Forwarders)
+	for _, conf := range p.Conf.Forwarders {
+		fwd, err := NewForwarder(p.Logger, conf)
+		if err != nil {
+			return nil, fmt.Errorf("forwarder %q: %w", conf.Name, err)
+		}
+		fs.list = append(fs.list, fwd)
+	}
+	return fs, nil
+}
+
+func runForwarders(group *run.Group, fs *Forwarders) {
+	for _, fwd := range fs.list {
+		fwd.Start()
+	}
+	group.OnStop(func(context.Context, error) error {
+		for _, fwd := range fs.list {
+			fwd.Stop()
+		}
+		return nil
+	})
+}
+
+// Select returns forwarders for the project and the signal.
+func (fs *Forwarders) Select(projectID uint32, signal string) []*Forwarder {
+	if fs == nil || len(fs.list) == 0 {
+		return nil
+	}
+
+	var selected []*Forwarder
+	for _, fwd := range fs.list {
+		if fwd.conf.Forwards(projectID, signal) {
+			selected = append(selected, fwd)
+		}
+	}
+	return selected
+}
+
+//------------------------------------------------------------------------------
+
+type Forwarder struct {
+	conf    *bunconf.Forwarder
+	logger  *otelzap.Logger
+	matcher *tql.Matcher
+	exp     exporter
+
+	queue  chan *request
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type request struct {
+	signal    string
+	projectID uint32
+	msg       proto.Message
+}
+
+func NewForwarder(logger *otelzap.Logger, conf *bunconf.Forwarder) (*Forwarder, error) {
+	fwd := &Forwarder{
+		conf:   conf,
+		logger: logger,
+		queue:  make(chan *request, conf.Queue.Size),
+	}
+	fwd.ctx, fwd.cancel = context.WithCancel(context.Background())
+
+	if conf.Where != "" {
+		matcher, err := tql.ParseMatcher(conf.Where)
+		if err != nil {
+			return nil, fmt.Errorf("invalid where: %w", err)
+		}
+		fwd.matcher = matcher
+	}
+
+	exp, err := newExporter(conf)
+	if err != nil {
+		return nil, err
+	}
+	fwd.exp = exp
+
+	return fwd, nil
+}
+
+func (f *Forwarder) Name() string {
+	return f.conf.Name
+}
+
+// Matcher returns the filter or nil if all data is forwarded.
+func (f *Forwarder) Matcher() *tql.Matcher {
+	return f.matcher
+}
+
+func (f *Forwarder) Start() {
+	f.logger.Info("starting forwarder...",
+		zap.String("name", f.conf.Name),
+		zap.String("endpoint", f.conf.Endpoint),
+		zap.String("protocol", f.conf.Protocol),
+		zap.Int("queue_size", f.conf.Queue.Size),
+		zap.Int("workers", f.conf.Queue.Workers))
+
+	for i := 0; i < f.conf.Queue.Workers; i++ {
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.worker()
+		}()
+	}
+}
+
+// Stop stops the workers dropping the queued requests.
+func (f *Forwarder) Stop() {
+	f.cancel()
+	f.wg.Wait()
+	if err := f.exp.Close(); err != nil {
+		f.logger.Error("forwarder.Close failed", zap.Error(err))
+	}
+}
+
+// Enqueue adds the request to the queue without blocking. The request is dropped
+// when the queue is full so the downstream endpoint does not slow down ingestion.
+func (f *Forwarder) Enqueue(ctx context.Context, projectID uint32, signal string, msg proto.Message) {
+	req := &request{
+		signal:    signal,
+		projectID: projectID,
+		msg:       msg,
+	}
+
+	select {
+	case f.queue <- req:
+	default:
+		f.logger.Error("forwarder queue is full (consider increasing queue.size)",
+			zap.String("forwarder", f.conf.Name),
+			zap.Int("len", len(f.queue)))
+		f.count(ctx, req, "dropped")
+	}
+}
+
+func (f *Forwarder) worker() {
+	for {
+		select {
+		case <-f.ctx.Done():
+			return
+		case req := <-f.queue:
+			f.export(req)
+		}
+	}
+}
+
+func (f *Forwarder) export(req *request) {
+	ctx := f.ctx
+	interval := f.conf.Retry.InitialInterval
+	deadline := time.Now().Add(f.conf.Retry.MaxElapsedTime)
+
+	for {
+		err := f.exportOnce(ctx, req)
+		if err == nil {
+			f.count(ctx, req, "sent")
+			return
+		}
+
+		var retryable *retryableError
+		if f.conf.Retry.Disabled || !errors.As(err, &retryable) {
+			f.logger.Error("forwarder export failed",
+				zap.String("forwarder", f.conf.Name),
+				zap.String("signal", req.signal),
+				zap.Error(err))
+			f.count(ctx, req, "failed")
+			return
+		}
+
+		delay := retryable.delay
+		if delay == 0 {
+			// Add jitter so retries from multiple workers don't align.
+			delay = interval/2 + time.Duration(rand.Int63n(int64(interval)))
+			interval = min(2*interval, f.conf.Retry.MaxInterval)
+		}
+		if time.Now().Add(delay).After(deadline) {
+			f.logger.Error("forwarder gave up retrying",
+				zap.String("forwarder", f.conf.Name),
+				zap.String("signal", req.signal),
+				zap.Error(err))
+			f.count(ctx, req, "failed")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (f *Forwarder) exportOnce(ctx context.Context, req *request) error {
+	ctx, cancel := context.WithTimeout(ctx, f.conf.Timeout)
+	defer cancel()
+
+	return f.exp.Export(ctx, req.signal, req.msg)
+}
+
+func (f *Forwarder) count(ctx context.Context, req *request, typ string) {
+	requestCounter.Add(
+		ctx,
+		1,
+		metric.WithAttributes(
+			bunotel.ProjectIDAttr(req.projectID),
+			attribute.String("forwarder", f.conf.Name),
+			attribute.String("signal", req.signal),
+			attribute.String("type", typ),
+		),
+	)
+}
+
+// retryableError is returned when the request can be retried later.
+type retryableError struct {
+	err   error
+	delay time.Duration
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
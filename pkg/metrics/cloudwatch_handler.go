@@ -1,19 +1,24 @@
 package metrics
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net/http"
 	"time"
 
 	"github.com/segmentio/encoding/json"
+	collectormetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	"go.uber.org/fx"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bunrouter"
 	"github.com/uptrace/opentelemetry-go-extra/otelzap"
 	"github.com/uptrace/uptrace/pkg/attrkey"
 	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/bunconf"
 	"github.com/uptrace/uptrace/pkg/bunconv"
 	"github.com/uptrace/uptrace/pkg/httputil"
 	"github.com/uptrace/uptrace/pkg/org"
@@ -22,10 +27,13 @@ import (
 type KinesisHandlerParams struct {
 	fx.In
 
-	Logger   *otelzap.Logger
-	PG       *bun.DB
-	MP       *DatapointProcessor
-	Projects *org.ProjectGateway
+	Logger      *otelzap.Logger
+	PG          *bun.DB
+	MP          *DatapointProcessor
+	Projects    *org.ProjectGateway
+	RateLimiter *org.RateLimiter
+
+	MetricsServer *MetricsServiceServer
 }
 
 type KinesisHandler struct {
@@ -86,6 +94,12 @@ func (h *KinesisHandler) Metrics(w http.ResponseWriter, req bunrouter.Request) e
 		return err
 	}
 
+	if err := h.RateLimiter.AllowBytes(project.ID, func() int {
+		return len(body)
+	}); err != nil {
+		return err
+	}
+
 	event := new(KinesisEvent)
 
 	body, err = json.Parse(body, event, json.ZeroCopy)
@@ -93,36 +107,42 @@ func (h *KinesisHandler) Metrics(w http.ResponseWriter, req bunrouter.Request) e
 		return err
 	}
 
+	throttle := h.RateLimiter.Throttle(project.ID, bunconf.SignalMetrics)
 	p := otlpProcessor{
-		logger:  h.Logger,
-		pg:      h.PG,
-		mp:      h.MP,
-		project: project,
+		logger:   h.Logger,
+		pg:       h.PG,
+		mp:       h.MP,
+		project:  project,
+		throttle: throttle,
 	}
 	defer p.close(ctx)
 
-	var src CloudwatchDatapoint
 	for _, record := range event.Records {
-		data := record.Data
-		for len(data) > 2 {
-			src = CloudwatchDatapoint{}
-
-			var err error
-			data, err = json.Parse(data, &src, json.ZeroCopy)
-			if err != nil {
-				if err == io.ErrUnexpectedEOF {
-					break
+		// The OpenTelemetry 0.7 / 1.0 output formats contain length-delimited
+		// ExportMetricsServiceRequest messages and the JSON format contains JSON objects.
+		// The first byte can't be used to detect the format, because a message
+		// with 123 bytes is prefixed with '{'.
+		if reqs, ok := decodeCloudwatchOTLP(record.Data); ok {
+			for _, req := range reqs {
+				throttled := throttle.Throttled()
+				h.MetricsServer.processMetrics(ctx, req, project, throttle)
+				// Don't forward requests with throttled datapoints.
+				if throttle.Throttled() == throttled {
+					forwardRequest(ctx, h.MetricsServer.Forwarders, project, req)
 				}
-				return err
 			}
+			continue
+		}
 
-			dest := new(Datapoint)
-
-			if err := h.initDatapointFromAWS(project, dest, &src); err != nil {
-				return err
-			}
+		if err := h.processJSONRecord(ctx, &p, project, record.Data); err != nil {
+			return err
+		}
+	}
 
-			p.enqueue(ctx, dest)
+	if n := throttle.Throttled(); n > 0 {
+		countThrottled(ctx, project.ID, n)
+		if err := throttle.Err(); err != nil {
+			return err
 		}
 	}
 
@@ -132,6 +152,65 @@ func (h *KinesisHandler) Metrics(w http.ResponseWriter, req bunrouter.Request) e
 	})
 }
 
+func (h *KinesisHandler) processJSONRecord(
+	ctx context.Context, p *otlpProcessor, project *org.Project, data []byte,
+) error {
+	var src CloudwatchDatapoint
+	for len(data) > 2 {
+		src = CloudwatchDatapoint{}
+
+		var err error
+		data, err = json.Parse(data, &src, json.ZeroCopy)
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+
+		dest := new(Datapoint)
+		if err := h.initDatapointFromAWS(project, dest, &src); err != nil {
+			return err
+		}
+
+		p.enqueue(ctx, dest)
+	}
+	return nil
+}
+
+// decodeCloudwatchOTLP decodes length-delimited ExportMetricsServiceRequest messages.
+// It returns false if the data is not a sequence of such messages, for example, JSON.
+func decodeCloudwatchOTLP(data []byte) ([]*collectormetricspb.ExportMetricsServiceRequest, bool) {
+	if len(data) == 0 {
+		return nil, false
+	}
+
+	var reqs []*collectormetricspb.ExportMetricsServiceRequest
+	for len(data) > 0 {
+		size, n := protowire.ConsumeVarint(data)
+		if n < 0 {
+			return nil, false
+		}
+		data = data[n:]
+		if size > uint64(len(data)) {
+			return nil, false
+		}
+
+		req := new(collectormetricspb.ExportMetricsServiceRequest)
+		if err := proto.Unmarshal(data[:size], req); err != nil {
+			return nil, false
+		}
+		// JSON can be decoded as a message with unknown fields.
+		if len(req.ProtoReflect().GetUnknown()) > 0 {
+			return nil, false
+		}
+		data = data[size:]
+
+		reqs = append(reqs, req)
+	}
+	return reqs, true
+}
+
 func (h *KinesisHandler) initDatapointFromAWS(
 	project *org.Project,
 	dest *Datapoint,
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	collectormetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDecodeCloudwatchOTLP(t *testing.T) {
+	newRequest := func(size int) *collectormetricspb.ExportMetricsServiceRequest {
+		for pad := 0; pad < size; pad++ {
+			req := &collectormetricspb.ExportMetricsServiceRequest{
+				ResourceMetrics: []*metricspb.ResourceMetrics{{
+					Resource: &resourcepb.Resource{
+						Attributes: []*commonpb.KeyValue{{
+							Key: "cloud.region",
+							Value: &commonpb.AnyValue{
+								Value: &commonpb.AnyValue_StringValue{StringValue: strings.Repeat("x", pad)},
+							},
+						}},
+					},
+				}},
+			}
+			if proto.Size(req) == size {
+				return req
+			}
+		}
+		t.Fatalf("can't create a request with %d bytes", size)
+		return nil
+	}
+	appendDelimited := func(b []byte, req *collectormetricspb.ExportMetricsServiceRequest) []byte {
+		msg, err := proto.Marshal(req)
+		require.NoError(t, err)
+		b = protowire.AppendVarint(b, uint64(len(msg)))
+		return append(b, msg...)
+	}
+
+	t.Run("message with 123 bytes starts with {", func(t *testing.T) {
+		req := newRequest(123)
+		data := appendDelimited(nil, req)
+		data = appendDelimited(data, newRequest(60))
+		require.Equal(t, byte('{'), data[0])
+
+		reqs, ok := decodeCloudwatchOTLP(data)
+		require.True(t, ok)
+		require.Len(t, reqs, 2)
+		require.True(t, proto.Equal(req, reqs[0]))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		for _, s := range []string{
+			`{"metric_stream_name":"s","namespace":"AWS/EC2","metric_name":"CPUUtilization"}` + "\n",
+			`{"metric_stream_name":"stream","account_id":"123456789012","region":"us-east-1",` +
+				`"namespace":"AWS/EC2","metric_name":"CPU"}`,
+		} {
+			_, ok := decodeCloudwatchOTLP([]byte(s))
+			require.False(t, ok, s)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		data := appendDelimited(nil, newRequest(50))
+		_, ok := decodeCloudwatchOTLP(data[:len(data)-1])
+		require.False(t, ok)
+	})
+}
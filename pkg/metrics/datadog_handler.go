@@ -0,0 +1,280 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/httperror"
+	"github.com/uptrace/uptrace/pkg/httputil"
+	"github.com/uptrace/uptrace/pkg/org"
+)
+
+const datadogLibraryName = "datadog"
+
+// Metric types used by the v2 API.
+const (
+	ddTypeUnspecified = 0
+	ddTypeCount       = 1
+	ddTypeRate        = 2
+	ddTypeGauge       = 3
+)
+
+type DatadogHandlerParams struct {
+	fx.In
+
+	Logger   *otelzap.Logger
+	MP       *DatapointProcessor
+	Projects *org.ProjectGateway
+}
+
+// DatadogHandler accepts metrics sent to Datadog metrics API.
+type DatadogHandler struct {
+	*DatadogHandlerParams
+}
+
+func NewDatadogHandler(p DatadogHandlerParams) *DatadogHandler {
+	return &DatadogHandler{&p}
+}
+
+func registerDatadogHandler(h *DatadogHandler, p bunapp.RouterParams) {
+	p.Router.WithGroup("/api", func(g *bunrouter.Group) {
+		g.GET("/v1/validate", h.Validate)
+		g.POST("/v1/series", h.SeriesV1)
+		g.POST("/v2/series", h.SeriesV2)
+	})
+}
+
+func (h *DatadogHandler) Validate(w http.ResponseWriter, req bunrouter.Request) error {
+	if _, err := h.project(req); err != nil {
+		return err
+	}
+	return httputil.JSON(w, bunrouter.H{"valid": true})
+}
+
+type ddSeriesV1 struct {
+	Metric   string        `json:"metric"`
+	Type     string        `json:"type"`
+	Points   [][2]*float64 `json:"points"`
+	Interval int64         `json:"interval"`
+	Host     string        `json:"host"`
+	Device   string        `json:"device"`
+	Tags     []string      `json:"tags"`
+	Unit     string        `json:"unit"`
+}
+
+func (h *DatadogHandler) SeriesV1(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	project, err := h.project(req)
+	if err != nil {
+		return err
+	}
+
+	var in struct {
+		Series []ddSeriesV1 `json:"series"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+		return httperror.BadRequest("invalid_payload", err.Error())
+	}
+
+	p := otlpProcessor{
+		logger:  h.Logger,
+		mp:      h.MP,
+		project: project,
+	}
+	defer p.close(ctx)
+
+	for i := range in.Series {
+		series := &in.Series[i]
+
+		typ := ddTypeGauge
+		switch series.Type {
+		case "count":
+			typ = ddTypeCount
+		case "rate":
+			typ = ddTypeRate
+		}
+
+		attrs := ddAttrs(series.Tags, 2)
+		if series.Host != "" {
+			attrs[attrkey.HostName] = series.Host
+		}
+		if series.Device != "" {
+			attrs["device"] = series.Device
+		}
+
+		for _, point := range series.Points {
+			if point[0] == nil || point[1] == nil {
+				continue
+			}
+			tm := time.Unix(0, int64(*point[0]*float64(time.Second)))
+			h.enqueue(ctx, &p, series.Metric, typ, series.Interval, series.Unit,
+				attrs, tm, *point[1])
+		}
+	}
+
+	return writeDatadogAccepted(w, bunrouter.H{"status": "ok"})
+}
+
+type ddSeriesV2 struct {
+	Metric   string   `json:"metric"`
+	Type     int      `json:"type"`
+	Interval int64    `json:"interval"`
+	Unit     string   `json:"unit"`
+	Tags     []string `json:"tags"`
+	Points   []struct {
+		Timestamp int64    `json:"timestamp"`
+		Value     *float64 `json:"value"`
+	} `json:"points"`
+	Resources []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+	} `json:"resources"`
+}
+
+func (h *DatadogHandler) SeriesV2(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	project, err := h.project(req)
+	if err != nil {
+		return err
+	}
+
+	var in struct {
+		Series []ddSeriesV2 `json:"series"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+		return httperror.BadRequest("invalid_payload", err.Error())
+	}
+
+	p := otlpProcessor{
+		logger:  h.Logger,
+		mp:      h.MP,
+		project: project,
+	}
+	defer p.close(ctx)
+
+	for i := range in.Series {
+		series := &in.Series[i]
+
+		attrs := ddAttrs(series.Tags, len(series.Resources))
+		for _, res := range series.Resources {
+			if res.Type == "host" {
+				attrs[attrkey.HostName] = res.Name
+			} else if key := attrkey.Clean(res.Type); key != "" {
+				attrs[key] = res.Name
+			}
+		}
+
+		for _, point := range series.Points {
+			if point.Value == nil {
+				continue
+			}
+			h.enqueue(ctx, &p, series.Metric, series.Type, series.Interval, series.Unit,
+				attrs, time.Unix(point.Timestamp, 0), *point.Value)
+		}
+	}
+
+	return writeDatadogAccepted(w, bunrouter.H{"errors": []string{}})
+}
+
+// enqueue reports counts as deltas, converts rates to deltas using the interval,
+// and reports other types as gauges.
+func (h *DatadogHandler) enqueue(
+	ctx context.Context,
+	p *otlpProcessor,
+	metricName string,
+	typ int,
+	interval int64,
+	unit string,
+	attrs AttrMap,
+	tm time.Time,
+	value float64,
+) {
+	if metricName == "" {
+		return
+	}
+
+	// Datapoints are modified during processing, so each datapoint needs own attrs.
+	dpAttrs := make(AttrMap, len(attrs))
+	dpAttrs.Merge(attrs)
+
+	unixNano := uint64(tm.UnixNano())
+
+	var dp *Datapoint
+	switch typ {
+	case ddTypeCount:
+		dp = p.newDatapoint(metricName, InstrumentCounter, dpAttrs, unixNano)
+		dp.Sum = value
+	case ddTypeRate:
+		if interval <= 0 {
+			interval = 1
+		}
+		dp = p.newDatapoint(metricName, InstrumentCounter, dpAttrs, unixNano)
+		dp.Sum = value * float64(interval)
+	default:
+		dp = p.newDatapoint(metricName, InstrumentGauge, dpAttrs, unixNano)
+		dp.Gauge = value
+	}
+	dp.Unit = unit
+	dp.OtelLibraryName = datadogLibraryName
+	p.enqueue(ctx, dp)
+}
+
+func writeDatadogAccepted(w http.ResponseWriter, resp bunrouter.H) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	return json.NewEncoder(w).Encode(resp)
+}
+
+func ddAttrs(tags []string, extra int) AttrMap {
+	attrs := make(AttrMap, len(tags)+extra)
+	for _, tag := range tags {
+		key, value, _ := strings.Cut(tag, ":")
+		if key = attrkey.Clean(key); key != "" {
+			attrs[key] = value
+		}
+	}
+	return attrs
+}
+
+// project authenticates the request using the DD-API-KEY header or the api_key
+// query param where the API key is a project token.
+func (h *DatadogHandler) project(req bunrouter.Request) (*org.Project, error) {
+	ctx := req.Context()
+
+	apiKey := req.Header.Get("DD-API-KEY")
+	if apiKey == "" {
+		apiKey = req.URL.Query().Get("api_key")
+	}
+	if apiKey == "" {
+		return nil, httperror.Forbidden("DD-API-KEY header is empty or missing")
+	}
+
+	project, err := h.Projects.SelectByToken(ctx, apiKey)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.Forbidden("can't find project with the provided API key")
+		}
+		return nil, err
+	}
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.Int64("project", int64(project.ID)))
+	}
+
+	return project, nil
+}
@@ -36,6 +36,8 @@ type Datapoint struct {
 	Attrs        AttrMap  `ch:"-"`
 	StringKeys   []string `ch:"type:Array(LowCardinality(String))"`
 	StringValues []string
+	// Annotations is a JSON object with values that are not part of the timeseries identity.
+	Annotations string
 
 	OtelLibraryName    string `ch:",lc"`
 	OtelLibraryVersion string `ch:",lc"`
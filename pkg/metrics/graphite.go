@@ -0,0 +1,535 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/org"
+	"github.com/uptrace/uptrace/pkg/run"
+)
+
+const (
+	graphiteLibraryName   = "graphite"
+	graphiteMaxPickleSize = 16 << 20
+)
+
+type GraphiteServerParams struct {
+	fx.In
+
+	Logger   *otelzap.Logger
+	Conf     *bunconf.Config
+	MP       *DatapointProcessor
+	Projects *org.ProjectGateway
+}
+
+// GraphiteServer receives metrics using Graphite plaintext protocol over TCP and UDP
+// and Graphite pickle protocol over TCP.
+type GraphiteServer struct {
+	*GraphiteServerParams
+
+	templates *graphiteTemplates
+
+	mu      sync.Mutex
+	closers []io.Closer
+	wg      sync.WaitGroup
+}
+
+func NewGraphiteServer(p GraphiteServerParams) (*GraphiteServer, error) {
+	s := &GraphiteServer{GraphiteServerParams: &p}
+
+	if conf := p.Conf.Listen.Graphite; conf != nil {
+		templates, err := newGraphiteTemplates(conf.Templates, conf.Separator)
+		if err != nil {
+			return nil, fmt.Errorf("listen.graphite: %w", err)
+		}
+		s.templates = templates
+	}
+
+	return s, nil
+}
+
+func runGraphiteServer(group *run.Group, s *GraphiteServer) error {
+	conf := s.Conf.Listen.Graphite
+	if conf == nil {
+		return nil
+	}
+
+	project, err := s.Projects.SelectByID(context.Background(), conf.ProjectID)
+	if err != nil {
+		return fmt.Errorf("listen.graphite: can't find project with id=%d: %w", conf.ProjectID, err)
+	}
+
+	if err := s.listen(group, project, conf); err != nil {
+		_ = s.Close()
+		s.Logger.Error("graphite listen failed (edit listen.graphite YAML option)", zap.Error(err))
+		return err
+	}
+
+	group.OnStop(func(context.Context, error) error {
+		return s.Close()
+	})
+
+	return nil
+}
+
+func (s *GraphiteServer) listen(
+	group *run.Group, project *org.Project, conf *bunconf.GraphiteListen,
+) error {
+	conn, err := net.ListenPacket("udp", conf.Addr)
+	if err != nil {
+		return err
+	}
+	s.addCloser(conn)
+
+	ln, err := net.Listen("tcp", conf.Addr)
+	if err != nil {
+		return err
+	}
+	s.addCloser(ln)
+
+	group.Add("metrics.GraphiteServer.ServeUDP", func() error {
+		return s.serveUDP(conn, project)
+	})
+	group.Add("metrics.GraphiteServer.ServeTCP", func() error {
+		return s.serveTCP(ln, project, s.serveConn)
+	})
+
+	if conf.PickleAddr == "" {
+		return nil
+	}
+
+	pickleLn, err := net.Listen("tcp", conf.PickleAddr)
+	if err != nil {
+		return err
+	}
+	s.addCloser(pickleLn)
+
+	group.Add("metrics.GraphiteServer.ServePickle", func() error {
+		return s.serveTCP(pickleLn, project, s.servePickleConn)
+	})
+
+	return nil
+}
+
+func (s *GraphiteServer) addCloser(c io.Closer) {
+	s.mu.Lock()
+	s.closers = append(s.closers, c)
+	s.mu.Unlock()
+}
+
+func (s *GraphiteServer) removeCloser(c io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, closer := range s.closers {
+		if closer == c {
+			s.closers = append(s.closers[:i], s.closers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *GraphiteServer) Close() error {
+	s.mu.Lock()
+	for _, c := range s.closers {
+		_ = c.Close()
+	}
+	s.closers = nil
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+func (s *GraphiteServer) serveUDP(conn net.PacketConn, project *org.Project) error {
+	ctx := context.Background()
+	buf := make([]byte, 64<<10)
+
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		p := s.newProcessor(project)
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			s.processLine(ctx, p, line)
+		}
+		p.close(ctx)
+	}
+}
+
+func (s *GraphiteServer) serveTCP(
+	ln net.Listener,
+	project *org.Project,
+	serveConn func(net.Conn, *otlpProcessor) error,
+) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		s.addCloser(conn)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.removeCloser(conn)
+			defer conn.Close()
+
+			p := s.newProcessor(project)
+			defer p.close(context.Background())
+
+			if err := serveConn(conn, p); err != nil && !errors.Is(err, net.ErrClosed) {
+				s.Logger.Error("graphite connection failed",
+					zap.Error(err), zap.String("remote_addr", conn.RemoteAddr().String()))
+			}
+		}()
+	}
+}
+
+func (s *GraphiteServer) serveConn(conn net.Conn, p *otlpProcessor) error {
+	ctx := context.Background()
+
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+
+	for sc.Scan() {
+		s.processLine(ctx, p, sc.Text())
+	}
+	return sc.Err()
+}
+
+// servePickleConn reads pickled lists of (path, (timestamp, value)) tuples
+// prefixed with a 4-byte big-endian length.
+func (s *GraphiteServer) servePickleConn(conn net.Conn, p *otlpProcessor) error {
+	ctx := context.Background()
+	r := bufio.NewReader(conn)
+	header := make([]byte, 4)
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		size := binary.BigEndian.Uint32(header)
+		if size > graphiteMaxPickleSize {
+			return fmt.Errorf("graphite: pickle payload is too large: %d", size)
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return err
+		}
+
+		v, err := unpickle(payload)
+		if err != nil {
+			return err
+		}
+
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("graphite: expected a list, got %T", v)
+		}
+
+		now := time.Now()
+		for _, item := range items {
+			metricPath, ts, value, err := graphitePickleItem(item)
+			if err != nil {
+				s.Logger.Error("can't parse graphite pickle item", zap.Error(err))
+				continue
+			}
+			s.processMetric(ctx, p, metricPath, value, graphiteTime(ts, now))
+		}
+	}
+}
+
+func (s *GraphiteServer) newProcessor(project *org.Project) *otlpProcessor {
+	return &otlpProcessor{
+		logger:  s.Logger,
+		mp:      s.MP,
+		project: project,
+	}
+}
+
+// processLine parses lines like "servers.host01.cpu.load 0.5 1700000000".
+func (s *GraphiteServer) processLine(ctx context.Context, p *otlpProcessor, line string) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return
+	}
+	if len(fields) < 2 || len(fields) > 3 {
+		s.Logger.Error("can't parse graphite line", zap.String("line", line))
+		return
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		s.Logger.Error("can't parse graphite value", zap.Error(err), zap.String("line", line))
+		return
+	}
+
+	ts := -1.
+	if len(fields) == 3 {
+		ts, err = strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			s.Logger.Error("can't parse graphite timestamp", zap.Error(err), zap.String("line", line))
+			return
+		}
+	}
+
+	s.processMetric(ctx, p, fields[0], value, graphiteTime(ts, time.Now()))
+}
+
+func (s *GraphiteServer) processMetric(
+	ctx context.Context, p *otlpProcessor, metricPath string, value float64, tm time.Time,
+) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+
+	// Graphite tags are appended to the path, for example, "cpu.load;host=host01".
+	metricPath, tags, _ := strings.Cut(metricPath, ";")
+
+	metricName, attrs := s.templates.apply(metricPath)
+	if metricName == "" {
+		return
+	}
+	if tags != "" {
+		for _, tag := range strings.Split(tags, ";") {
+			if key, value, ok := strings.Cut(tag, "="); ok && key != "" {
+				attrs[key] = value
+			}
+		}
+	}
+
+	dp := p.newDatapoint(metricName, InstrumentGauge, attrs, uint64(tm.UnixNano()))
+	dp.Gauge = value
+	dp.OtelLibraryName = graphiteLibraryName
+	p.enqueue(ctx, dp)
+}
+
+func graphitePickleItem(item any) (metricPath string, ts, value float64, _ error) {
+	tuple, ok := item.([]any)
+	if !ok || len(tuple) != 2 {
+		return "", 0, 0, fmt.Errorf("expected (path, (timestamp, value)), got %v", item)
+	}
+	metricPath, ok = tuple[0].(string)
+	if !ok {
+		return "", 0, 0, fmt.Errorf("expected a string path, got %T", tuple[0])
+	}
+	point, ok := tuple[1].([]any)
+	if !ok || len(point) != 2 {
+		return "", 0, 0, fmt.Errorf("expected (timestamp, value), got %v", tuple[1])
+	}
+
+	ts, ok = graphiteNumber(point[0])
+	if !ok {
+		return "", 0, 0, fmt.Errorf("invalid timestamp: %v", point[0])
+	}
+	value, ok = graphiteNumber(point[1])
+	if !ok {
+		return "", 0, 0, fmt.Errorf("invalid value: %v", point[1])
+	}
+	return metricPath, ts, value, nil
+}
+
+func graphiteNumber(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// graphiteTime converts Unix seconds to time. Negative timestamps mean now.
+func graphiteTime(ts float64, now time.Time) time.Time {
+	if ts < 0 {
+		return now
+	}
+	return time.UnixMicro(int64(math.Round(ts * 1e6)))
+}
+
+//------------------------------------------------------------------------------
+
+// graphiteTemplates converts dotted metric paths into metric names and attributes
+// using templates compatible with Telegraf, for example, "servers.* .host.measurement*".
+type graphiteTemplates struct {
+	templates []*graphiteTemplate
+	separator string
+}
+
+type graphiteTemplate struct {
+	filter []string
+	parts  []string
+	tags   AttrMap
+}
+
+func newGraphiteTemplates(templates []string, separator string) (*graphiteTemplates, error) {
+	t := &graphiteTemplates{
+		templates: make([]*graphiteTemplate, 0, len(templates)),
+		separator: separator,
+	}
+
+	for _, s := range templates {
+		tpl, err := parseGraphiteTemplate(s)
+		if err != nil {
+			return nil, err
+		}
+		t.templates = append(t.templates, tpl)
+	}
+
+	return t, nil
+}
+
+// parseGraphiteTemplate parses "[filter] template [tag1=value1,tag2=value2]".
+func parseGraphiteTemplate(s string) (*graphiteTemplate, error) {
+	fields := strings.Fields(s)
+	tpl := new(graphiteTemplate)
+
+	if n := len(fields); n > 1 && strings.Contains(fields[n-1], "=") {
+		tpl.tags = make(AttrMap)
+		for _, tag := range strings.Split(fields[n-1], ",") {
+			key, value, ok := strings.Cut(tag, "=")
+			if !ok || key == "" {
+				return nil, fmt.Errorf("invalid template tag %q in %q", tag, s)
+			}
+			tpl.tags[key] = value
+		}
+		fields = fields[:n-1]
+	}
+
+	switch len(fields) {
+	case 1:
+		tpl.parts = strings.Split(fields[0], ".")
+	case 2:
+		tpl.filter = strings.Split(fields[0], ".")
+		tpl.parts = strings.Split(fields[1], ".")
+	default:
+		return nil, fmt.Errorf("invalid template %q", s)
+	}
+
+	for _, part := range tpl.filter {
+		if _, err := path.Match(part, ""); err != nil {
+			return nil, fmt.Errorf("invalid template filter %q: %w", s, err)
+		}
+	}
+
+	return tpl, nil
+}
+
+// apply uses the most specific matching template to build the metric name and attributes.
+// Without a matching template, the path is used as a metric name.
+func (t *graphiteTemplates) apply(metricPath string) (string, AttrMap) {
+	parts := strings.Split(metricPath, ".")
+
+	var best *graphiteTemplate
+	for _, tpl := range t.templates {
+		if !tpl.match(parts) {
+			continue
+		}
+		if best == nil || len(tpl.filter) > len(best.filter) {
+			best = tpl
+		}
+	}
+
+	attrs := make(AttrMap)
+	if best == nil {
+		return strings.Join(parts, t.separator), attrs
+	}
+
+	for key, value := range best.tags {
+		attrs[key] = value
+	}
+
+	var measurement, field []string
+	tagValues := make(map[string][]string)
+
+	for i, part := range best.parts {
+		if i >= len(parts) {
+			break
+		}
+
+		switch part {
+		case "":
+		case "measurement":
+			measurement = append(measurement, parts[i])
+		case "measurement*":
+			measurement = append(measurement, parts[i:]...)
+		case "field":
+			field = append(field, parts[i])
+		case "field*":
+			field = append(field, parts[i:]...)
+		default:
+			tagValues[part] = append(tagValues[part], parts[i])
+		}
+
+		if strings.HasSuffix(part, "*") {
+			break
+		}
+	}
+
+	for key, values := range tagValues {
+		attrs[key] = strings.Join(values, t.separator)
+	}
+
+	if len(measurement) == 0 {
+		measurement = parts
+	}
+	metricName := strings.Join(measurement, t.separator)
+	if len(field) > 0 {
+		metricName += "." + strings.Join(field, t.separator)
+	}
+
+	return metricName, attrs
+}
+
+// match reports whether the filter matches the path prefix.
+// An empty filter matches all paths.
+func (tpl *graphiteTemplate) match(parts []string) bool {
+	if len(tpl.filter) > len(parts) {
+		return false
+	}
+	for i, pattern := range tpl.filter {
+		if ok, _ := path.Match(pattern, parts[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,458 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Graphite only sends timestamps and values so longs are limited to 64 bits (20 digits)
+// to avoid spending quadratic CPU time on decoding huge numbers.
+const (
+	pickleMaxLongDigits = 20
+	pickleMaxLongBytes  = 9
+)
+
+// unpickle decodes a subset of Python pickle protocols 0-5 that is used by Graphite
+// clients to send lists of (path, (timestamp, value)) tuples. Only lists, tuples,
+// strings, and numbers are supported, so arbitrary objects can't be constructed.
+func unpickle(b []byte) (any, error) {
+	u := &unpickler{
+		b:    b,
+		memo: make(map[int]any),
+	}
+	return u.load()
+}
+
+type pickleMark struct{}
+
+type unpickler struct {
+	b     []byte
+	stack []any
+	memo  map[int]any
+}
+
+func (u *unpickler) load() (any, error) {
+	for {
+		b, err := u.readN(1)
+		if err != nil {
+			return nil, err
+		}
+		op := b[0]
+
+		switch op {
+		case '.': // STOP
+			return u.pop()
+		case 0x80: // PROTO
+			if _, err := u.readN(1); err != nil {
+				return nil, err
+			}
+		case 0x95: // FRAME
+			if _, err := u.readN(8); err != nil {
+				return nil, err
+			}
+		case '(': // MARK
+			u.push(pickleMark{})
+		case '0': // POP
+			if _, err := u.pop(); err != nil {
+				return nil, err
+			}
+		case '1': // POP_MARK
+			if _, err := u.popMark(); err != nil {
+				return nil, err
+			}
+		case '2': // DUP
+			v, err := u.top()
+			if err != nil {
+				return nil, err
+			}
+			u.push(v)
+		case 'N': // NONE
+			u.push(nil)
+		case 0x88: // NEWTRUE
+			u.push(true)
+		case 0x89: // NEWFALSE
+			u.push(false)
+
+		case 'I': // INT
+			line, err := u.readLine()
+			if err != nil {
+				return nil, err
+			}
+			switch line {
+			case "00":
+				u.push(false)
+			case "01":
+				u.push(true)
+			default:
+				n, err := strconv.ParseInt(line, 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				u.push(n)
+			}
+		case 'L': // LONG
+			line, err := u.readLine()
+			if err != nil {
+				return nil, err
+			}
+			line = strings.TrimSuffix(line, "L")
+			if len(strings.TrimLeft(line, "+-")) > pickleMaxLongDigits {
+				return nil, fmt.Errorf("pickle: long is too large: %d digits", len(line))
+			}
+			n, ok := new(big.Int).SetString(line, 10)
+			if !ok {
+				return nil, fmt.Errorf("pickle: invalid long %q", line)
+			}
+			u.push(bigIntValue(n))
+		case 'J': // BININT
+			b, err := u.readN(4)
+			if err != nil {
+				return nil, err
+			}
+			u.push(int64(int32(binary.LittleEndian.Uint32(b))))
+		case 'K': // BININT1
+			b, err := u.readN(1)
+			if err != nil {
+				return nil, err
+			}
+			u.push(int64(b[0]))
+		case 'M': // BININT2
+			b, err := u.readN(2)
+			if err != nil {
+				return nil, err
+			}
+			u.push(int64(binary.LittleEndian.Uint16(b)))
+		case 0x8a, 0x8b: // LONG1, LONG4
+			size, err := u.readSize(op == 0x8a)
+			if err != nil {
+				return nil, err
+			}
+			if size > pickleMaxLongBytes {
+				return nil, fmt.Errorf("pickle: long is too large: %d bytes", size)
+			}
+			b, err := u.readN(size)
+			if err != nil {
+				return nil, err
+			}
+			u.push(decodePickleLong(b))
+		case 'F': // FLOAT
+			line, err := u.readLine()
+			if err != nil {
+				return nil, err
+			}
+			f, err := strconv.ParseFloat(line, 64)
+			if err != nil {
+				return nil, err
+			}
+			u.push(f)
+		case 'G': // BINFLOAT
+			b, err := u.readN(8)
+			if err != nil {
+				return nil, err
+			}
+			u.push(math.Float64frombits(binary.BigEndian.Uint64(b)))
+
+		case 'S': // STRING
+			line, err := u.readLine()
+			if err != nil {
+				return nil, err
+			}
+			s, err := unquotePickleString(line)
+			if err != nil {
+				return nil, err
+			}
+			u.push(s)
+		case 'V': // UNICODE
+			line, err := u.readLine()
+			if err != nil {
+				return nil, err
+			}
+			u.push(line)
+		case 'T', 'B', 'X': // BINSTRING, BINBYTES, BINUNICODE
+			if err := u.pushString(false); err != nil {
+				return nil, err
+			}
+		case 'U', 'C', 0x8c: // SHORT_BINSTRING, SHORT_BINBYTES, SHORT_BINUNICODE
+			if err := u.pushString(true); err != nil {
+				return nil, err
+			}
+		case 0x8d, 0x8e: // BINUNICODE8, BINBYTES8
+			b, err := u.readN(8)
+			if err != nil {
+				return nil, err
+			}
+			size := binary.LittleEndian.Uint64(b)
+			if size > math.MaxInt32 {
+				return nil, fmt.Errorf("pickle: string is too large: %d", size)
+			}
+			s, err := u.readN(int(size))
+			if err != nil {
+				return nil, err
+			}
+			u.push(string(s))
+
+		case ']': // EMPTY_LIST
+			u.push([]any{})
+		case ')': // EMPTY_TUPLE
+			u.push([]any{})
+		case 'l', 't': // LIST, TUPLE
+			items, err := u.popMark()
+			if err != nil {
+				return nil, err
+			}
+			u.push(items)
+		case 0x85, 0x86, 0x87: // TUPLE1, TUPLE2, TUPLE3
+			n := int(op-0x85) + 1
+			if len(u.stack) < n {
+				return nil, errors.New("pickle: stack underflow")
+			}
+			items := make([]any, n)
+			copy(items, u.stack[len(u.stack)-n:])
+			u.stack = u.stack[:len(u.stack)-n]
+			u.push(items)
+		case 'a': // APPEND
+			v, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			if err := u.appendItems(v); err != nil {
+				return nil, err
+			}
+		case 'e': // APPENDS
+			items, err := u.popMark()
+			if err != nil {
+				return nil, err
+			}
+			if err := u.appendItems(items...); err != nil {
+				return nil, err
+			}
+
+		case 'p': // PUT
+			line, err := u.readLine()
+			if err != nil {
+				return nil, err
+			}
+			idx, err := strconv.Atoi(line)
+			if err != nil {
+				return nil, err
+			}
+			if err := u.put(idx); err != nil {
+				return nil, err
+			}
+		case 'q', 'r': // BINPUT, LONG_BINPUT
+			idx, err := u.readSize(op == 'q')
+			if err != nil {
+				return nil, err
+			}
+			if err := u.put(idx); err != nil {
+				return nil, err
+			}
+		case 0x94: // MEMOIZE
+			if err := u.put(len(u.memo)); err != nil {
+				return nil, err
+			}
+		case 'g': // GET
+			line, err := u.readLine()
+			if err != nil {
+				return nil, err
+			}
+			idx, err := strconv.Atoi(line)
+			if err != nil {
+				return nil, err
+			}
+			if err := u.get(idx); err != nil {
+				return nil, err
+			}
+		case 'h', 'j': // BINGET, LONG_BINGET
+			idx, err := u.readSize(op == 'h')
+			if err != nil {
+				return nil, err
+			}
+			if err := u.get(idx); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, fmt.Errorf("pickle: unsupported opcode 0x%x", op)
+		}
+	}
+}
+
+func (u *unpickler) push(v any) {
+	u.stack = append(u.stack, v)
+}
+
+func (u *unpickler) top() (any, error) {
+	if len(u.stack) == 0 {
+		return nil, errors.New("pickle: stack underflow")
+	}
+	return u.stack[len(u.stack)-1], nil
+}
+
+func (u *unpickler) pop() (any, error) {
+	v, err := u.top()
+	if err != nil {
+		return nil, err
+	}
+	u.stack = u.stack[:len(u.stack)-1]
+	return v, nil
+}
+
+func (u *unpickler) popMark() ([]any, error) {
+	for i := len(u.stack) - 1; i >= 0; i-- {
+		if _, ok := u.stack[i].(pickleMark); ok {
+			items := make([]any, len(u.stack)-i-1)
+			copy(items, u.stack[i+1:])
+			u.stack = u.stack[:i]
+			return items, nil
+		}
+	}
+	return nil, errors.New("pickle: mark not found")
+}
+
+func (u *unpickler) appendItems(items ...any) error {
+	if len(u.stack) == 0 {
+		return errors.New("pickle: stack underflow")
+	}
+	list, ok := u.stack[len(u.stack)-1].([]any)
+	if !ok {
+		return fmt.Errorf("pickle: can't append to %T", u.stack[len(u.stack)-1])
+	}
+	u.stack[len(u.stack)-1] = append(list, items...)
+	return nil
+}
+
+func (u *unpickler) put(idx int) error {
+	v, err := u.top()
+	if err != nil {
+		return err
+	}
+	u.memo[idx] = v
+	return nil
+}
+
+func (u *unpickler) get(idx int) error {
+	v, ok := u.memo[idx]
+	if !ok {
+		return fmt.Errorf("pickle: memo key %d not found", idx)
+	}
+	u.push(v)
+	return nil
+}
+
+func (u *unpickler) pushString(short bool) error {
+	size, err := u.readSize(short)
+	if err != nil {
+		return err
+	}
+	b, err := u.readN(size)
+	if err != nil {
+		return err
+	}
+	u.push(string(b))
+	return nil
+}
+
+// readSize reads a 1-byte or a 4-byte little-endian size.
+func (u *unpickler) readSize(short bool) (int, error) {
+	if short {
+		b, err := u.readN(1)
+		if err != nil {
+			return 0, err
+		}
+		return int(b[0]), nil
+	}
+
+	b, err := u.readN(4)
+	if err != nil {
+		return 0, err
+	}
+	size := binary.LittleEndian.Uint32(b)
+	if size > math.MaxInt32 {
+		return 0, fmt.Errorf("pickle: size is too large: %d", size)
+	}
+	return int(size), nil
+}
+
+func (u *unpickler) readN(n int) ([]byte, error) {
+	if n > len(u.b) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := u.b[:n]
+	u.b = u.b[n:]
+	return b, nil
+}
+
+func (u *unpickler) readLine() (string, error) {
+	i := bytes.IndexByte(u.b, '\n')
+	if i == -1 {
+		return "", io.ErrUnexpectedEOF
+	}
+	line := string(u.b[:i])
+	u.b = u.b[i+1:]
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// decodePickleLong decodes a little-endian two's complement integer.
+func decodePickleLong(b []byte) any {
+	if len(b) == 0 {
+		return int64(0)
+	}
+
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+
+	n := new(big.Int).SetBytes(be)
+	if b[len(b)-1]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+
+	return bigIntValue(n)
+}
+
+func bigIntValue(n *big.Int) any {
+	if n.IsInt64() {
+		return n.Int64()
+	}
+	f, _ := new(big.Float).SetInt(n).Float64()
+	return f
+}
+
+func unquotePickleString(s string) (string, error) {
+	if len(s) < 2 || s[0] != s[len(s)-1] || (s[0] != '\'' && s[0] != '"') {
+		return "", fmt.Errorf("pickle: invalid string %q", s)
+	}
+	s = s[1 : len(s)-1]
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), nil
+}
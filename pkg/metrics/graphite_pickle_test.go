@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnpickle(t *testing.T) {
+	// pickle.dumps([('a.b', (1700000000, 1.5)), ('c', (1700000001, 2))], protocol=N)
+	metrics := []any{
+		[]any{"a.b", []any{int64(1700000000), 1.5}},
+		[]any{"c", []any{int64(1700000001), int64(2)}},
+	}
+
+	type Test struct {
+		name    string
+		in      string
+		value   any
+		wantErr bool
+	}
+
+	tests := []Test{
+		{
+			name:  "protocol 0",
+			in:    "(lp0\n(Va.b\np1\n(I1700000000\nF1.5\ntp2\ntp3\na(Vc\np4\n(I1700000001\nI2\ntp5\ntp6\na.",
+			value: metrics,
+		},
+		{
+			name: "protocol 2",
+			in: "\x80\x02]q\x00(X\x03\x00\x00\x00a.bq\x01J\x00\xf1SeG?\xf8\x00\x00\x00\x00\x00\x00\x86q\x02" +
+				"\x86q\x03X\x01\x00\x00\x00cq\x04J\x01\xf1SeK\x02\x86q\x05\x86q\x06e.",
+			value: metrics,
+		},
+		{
+			name: "protocol 4",
+			in: "\x80\x04\x95,\x00\x00\x00\x00\x00\x00\x00]\x94(\x8c\x03a.b\x94J\x00\xf1SeG?\xf8\x00\x00" +
+				"\x00\x00\x00\x00\x86\x94\x86\x94\x8c\x01c\x94J\x01\xf1SeK\x02\x86\x94\x86\x94e.",
+			value: metrics,
+		},
+		{
+			name: "longs",
+			in: "\x80\x02]q\x00X\x01\x00\x00\x00xq\x01\x8a\t\x00\x00\x00\x00\x00\x00\x00\x80\x00" +
+				"\x8a\x08\x00\x00\x00\x00\x00\x00\x00\x80\x86q\x02\x86q\x03a.",
+			value: []any{[]any{"x", []any{9223372036854775808.0, int64(-9223372036854775808)}}},
+		},
+		{
+			name:  "text long",
+			in:    "(lp0\n(S'x'\np1\n(L9223372036854775808L\nI01\ntp2\ntp3\na.",
+			value: []any{[]any{"x", []any{9223372036854775808.0, true}}},
+		},
+		{name: "empty", in: "", wantErr: true},
+		{name: "no stop", in: "\x80\x02]q\x00", wantErr: true},
+		{name: "unsupported opcode", in: "cos\nsystem\n.", wantErr: true},
+		{name: "stack underflow", in: "\x86.", wantErr: true},
+		{name: "mark not found", in: "Nl.", wantErr: true},
+		{name: "append to non-list", in: "NNa.", wantErr: true},
+		{name: "memo key not found", in: "h\x05.", wantErr: true},
+		{name: "invalid int", in: "Iabc\n.", wantErr: true},
+		{name: "invalid string", in: "S'abc\n.", wantErr: true},
+		{name: "truncated string", in: "X\x10\x00\x00\x00abc.", wantErr: true},
+		{name: "truncated float", in: "G?\xf8.", wantErr: true},
+		{name: "long with too many digits", in: "L" + strings.Repeat("9", 21) + "L\n.", wantErr: true},
+		{name: "huge long", in: "L" + strings.Repeat("9", 100000) + "L\n.", wantErr: true},
+		{name: "long1 too large", in: "\x8a\x0a" + strings.Repeat("\x01", 10) + ".", wantErr: true},
+		{name: "long4 too large", in: "\x8b\x00\x00\x10\x00" + strings.Repeat("\x01", 1<<20) + ".", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v, err := unpickle([]byte(test.in))
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.value, v)
+
+			// Every prefix of a valid pickle is truncated.
+			for i := 0; i < len(test.in); i++ {
+				_, err := unpickle([]byte(test.in[:i]))
+				require.Error(t, err, "%d of %d bytes", i, len(test.in))
+			}
+		})
+	}
+}
+
+func TestGraphitePickleItem(t *testing.T) {
+	path, ts, value, err := graphitePickleItem([]any{"a.b", []any{int64(1700000000), "1.5"}})
+	require.NoError(t, err)
+	require.Equal(t, "a.b", path)
+	require.Equal(t, 1700000000.0, ts)
+	require.Equal(t, 1.5, value)
+
+	for _, item := range []any{
+		"a.b",
+		[]any{"a.b"},
+		[]any{int64(1), []any{int64(1), int64(2)}},
+		[]any{"a.b", int64(1)},
+		[]any{"a.b", []any{"abc", int64(2)}},
+		[]any{"a.b", []any{int64(1), nil}},
+	} {
+		_, _, _, err := graphitePickleItem(item)
+		require.Error(t, err, "%v", item)
+	}
+}
@@ -0,0 +1,185 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/org"
+)
+
+const influxLibraryName = "influxdb"
+
+type InfluxHandlerParams struct {
+	fx.In
+
+	Logger   *otelzap.Logger
+	MP       *DatapointProcessor
+	Projects *org.ProjectGateway
+}
+
+// InfluxHandler accepts metrics in InfluxDB line protocol, for example, from Telegraf.
+type InfluxHandler struct {
+	*InfluxHandlerParams
+}
+
+func NewInfluxHandler(p InfluxHandlerParams) *InfluxHandler {
+	return &InfluxHandler{&p}
+}
+
+func registerInfluxHandler(h *InfluxHandler, p bunapp.RouterParams) {
+	p.Router.POST("/api/v2/write", h.Write)
+	p.Router.POST("/write", h.Write)
+}
+
+func (h *InfluxHandler) Write(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	project, err := h.project(req)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errInfluxTokenMissing) {
+			return writeInfluxError(w, http.StatusUnauthorized, "unauthorized", "unauthorized access")
+		}
+		return err
+	}
+
+	precision, err := influxPrecision(req.URL.Query().Get("precision"))
+	if err != nil {
+		return writeInfluxError(w, http.StatusBadRequest, "invalid", err.Error())
+	}
+
+	p := otlpProcessor{
+		logger:  h.Logger,
+		mp:      h.MP,
+		project: project,
+	}
+	defer p.close(ctx)
+
+	now := time.Now()
+
+	sc := bufio.NewScanner(req.Body)
+	sc.Buffer(make([]byte, 0, 64<<10), 16<<20)
+
+	// Valid lines are written even if some lines can't be parsed,
+	// which matches InfluxDB partial writes.
+	var firstErr error
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		point, err := parseInfluxLine(line, precision, now)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		h.handlePoint(ctx, &p, point)
+	}
+	if err := sc.Err(); err != nil {
+		return writeInfluxError(w, http.StatusBadRequest, "invalid", err.Error())
+	}
+
+	if firstErr != nil {
+		return writeInfluxError(w, http.StatusBadRequest, "invalid", firstErr.Error())
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handlePoint reports each numeric field as a separate metric named measurement.field.
+// Fields that look like cumulative counters are converted to deltas.
+func (h *InfluxHandler) handlePoint(ctx context.Context, p *otlpProcessor, point *influxPoint) {
+	unixNano := uint64(point.Time.UnixNano())
+
+	for i := range point.Fields {
+		field := &point.Fields[i]
+		if field.Type == influxString {
+			continue
+		}
+
+		attrs := make(AttrMap, len(point.Tags))
+		attrs.Merge(point.Tags)
+
+		metricName := point.Measurement + "." + field.Key
+		isCumCounter, unit := _promMetadata(field.Key)
+		if field.Type == influxBool {
+			isCumCounter = false
+		}
+
+		var dp *Datapoint
+		if isCumCounter {
+			dp = p.newDatapoint(metricName, InstrumentCounter, attrs, unixNano)
+			dp.CumPoint = &NumberPoint{
+				Double: field.Value,
+			}
+		} else {
+			dp = p.newDatapoint(metricName, InstrumentGauge, attrs, unixNano)
+			dp.Gauge = field.Value
+		}
+		dp.Unit = unit
+		dp.OtelLibraryName = influxLibraryName
+		p.enqueue(ctx, dp)
+	}
+}
+
+var errInfluxTokenMissing = errors.New("influx: token is missing")
+
+// project authenticates the request using "Authorization: Token <token>" (v2 API),
+// basic auth, or the p query param (v1 API) where the token is a project token.
+func (h *InfluxHandler) project(req bunrouter.Request) (*org.Project, error) {
+	ctx := req.Context()
+
+	token := influxTokenFromRequest(req)
+	if token == "" {
+		return nil, errInfluxTokenMissing
+	}
+
+	project, err := h.Projects.SelectByToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.Int64("project", int64(project.ID)))
+	}
+
+	return project, nil
+}
+
+func influxTokenFromRequest(req bunrouter.Request) string {
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Token "); ok {
+			return strings.TrimSpace(token)
+		}
+		if _, password, ok := req.BasicAuth(); ok {
+			return password
+		}
+	}
+	return req.URL.Query().Get("p")
+}
+
+func writeInfluxError(w http.ResponseWriter, statusCode int, code, message string) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	return json.NewEncoder(w).Encode(bunrouter.H{
+		"code":    code,
+		"message": message,
+	})
+}
@@ -0,0 +1,217 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type influxPoint struct {
+	Measurement string
+	Tags        AttrMap
+	Fields      []influxField
+	Time        time.Time
+}
+
+type influxFieldType byte
+
+const (
+	influxFloat influxFieldType = iota
+	influxInt
+	influxUint
+	influxBool
+	influxString
+)
+
+type influxField struct {
+	Key   string
+	Type  influxFieldType
+	Value float64
+}
+
+// parseInfluxLine parses a single line of InfluxDB line protocol, for example,
+// `cpu,host=server01 usage_idle=98.2,usage_user=1.3 1556813561098000000`.
+// Missing timestamps are set to defaultTime.
+func parseInfluxLine(line string, precision time.Duration, defaultTime time.Time) (*influxPoint, error) {
+	point := new(influxPoint)
+
+	measurement, i := influxScan(line, 0, ", ", false)
+	if measurement == "" {
+		return nil, errors.New("influx: measurement is missing")
+	}
+	point.Measurement = measurement
+
+	for i < len(line) && line[i] == ',' {
+		key, j := influxScan(line, i+1, ",= ", false)
+		if j >= len(line) || line[j] != '=' || key == "" {
+			return nil, fmt.Errorf("influx: invalid tag in %q", measurement)
+		}
+		value, k := influxScan(line, j+1, ", ", false)
+
+		if point.Tags == nil {
+			point.Tags = make(AttrMap)
+		}
+		point.Tags[key] = value
+		i = k
+	}
+
+	if i >= len(line) || line[i] != ' ' {
+		return nil, fmt.Errorf("influx: fields are missing in %q", measurement)
+	}
+	i++
+
+	for {
+		key, j := influxScan(line, i, ",= ", false)
+		if j >= len(line) || line[j] != '=' || key == "" {
+			return nil, fmt.Errorf("influx: invalid field in %q", measurement)
+		}
+		j++
+
+		var raw string
+		var quoted bool
+		if j < len(line) && line[j] == '"' {
+			raw, j = influxScan(line, j+1, `"`, true)
+			if j >= len(line) {
+				return nil, fmt.Errorf("influx: unterminated string field %q", key)
+			}
+			j++
+			quoted = true
+		} else {
+			raw, j = influxScan(line, j, ", ", false)
+		}
+
+		field, err := parseInfluxField(key, raw, quoted)
+		if err != nil {
+			return nil, err
+		}
+		point.Fields = append(point.Fields, field)
+
+		i = j
+		if i >= len(line) || line[i] != ',' {
+			break
+		}
+		i++
+	}
+
+	ts := strings.TrimSpace(line[i:])
+	if ts == "" {
+		point.Time = defaultTime
+		return point, nil
+	}
+
+	n, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || n > math.MaxInt64/int64(precision) || n < math.MinInt64/int64(precision) {
+		return nil, fmt.Errorf("influx: invalid timestamp %q", ts)
+	}
+	point.Time = time.Unix(0, n*int64(precision))
+
+	return point, nil
+}
+
+func parseInfluxField(key, raw string, quoted bool) (influxField, error) {
+	field := influxField{Key: key}
+
+	if quoted {
+		field.Type = influxString
+		return field, nil
+	}
+	if raw == "" {
+		return field, fmt.Errorf("influx: field %q has no value", key)
+	}
+
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		field.Type = influxBool
+		field.Value = 1
+		return field, nil
+	case "f", "F", "false", "False", "FALSE":
+		field.Type = influxBool
+		return field, nil
+	}
+
+	switch raw[len(raw)-1] {
+	case 'i':
+		n, err := strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return field, fmt.Errorf("influx: invalid integer field %q: %w", key, err)
+		}
+		field.Type = influxInt
+		field.Value = float64(n)
+	case 'u':
+		n, err := strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+		if err != nil {
+			return field, fmt.Errorf("influx: invalid unsigned field %q: %w", key, err)
+		}
+		field.Type = influxUint
+		field.Value = float64(n)
+	default:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return field, fmt.Errorf("influx: invalid float field %q: %w", key, err)
+		}
+		field.Type = influxFloat
+		field.Value = f
+	}
+	return field, nil
+}
+
+// influxScan reads the line starting at i until one of the unescaped stop chars
+// and returns the unescaped token with the position of the stop char.
+func influxScan(line string, i int, stop string, quoted bool) (string, int) {
+	var b strings.Builder
+	start := i
+	escaped := false
+
+	for ; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' && i+1 < len(line) && influxEscapable(line[i+1], stop, quoted) {
+			if !escaped {
+				b.WriteString(line[start:i])
+				escaped = true
+			}
+			b.WriteByte(line[i+1])
+			i++
+			continue
+		}
+		if strings.IndexByte(stop, c) >= 0 {
+			break
+		}
+		if escaped {
+			b.WriteByte(c)
+		}
+	}
+
+	if !escaped {
+		return line[start:i], i
+	}
+	return b.String(), i
+}
+
+func influxEscapable(c byte, stop string, quoted bool) bool {
+	if quoted {
+		return c == '"' || c == '\\'
+	}
+	return c == ',' || c == '=' || c == ' ' || strings.IndexByte(stop, c) >= 0
+}
+
+func influxPrecision(s string) (time.Duration, error) {
+	switch s {
+	case "", "ns", "n":
+		return time.Nanosecond, nil
+	case "us", "u", "µ":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	default:
+		return 0, fmt.Errorf("influx: unsupported precision %q", s)
+	}
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInfluxLine(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	type Test struct {
+		line      string
+		precision time.Duration
+		point     *influxPoint
+		wantErr   bool
+	}
+
+	tests := []Test{
+		{
+			line: "cpu,host=server01,region=eu usage_idle=98.2,usage_user=1.3 1556813561098000000",
+			point: &influxPoint{
+				Measurement: "cpu",
+				Tags:        AttrMap{"host": "server01", "region": "eu"},
+				Fields: []influxField{
+					{Key: "usage_idle", Type: influxFloat, Value: 98.2},
+					{Key: "usage_user", Type: influxFloat, Value: 1.3},
+				},
+				Time: time.Unix(0, 1556813561098000000),
+			},
+		},
+		{
+			line: "mem used=10i,free=20u,ok=t,down=FALSE,msg=\"hello, \\\"world\\\"\"",
+			point: &influxPoint{
+				Measurement: "mem",
+				Fields: []influxField{
+					{Key: "used", Type: influxInt, Value: 10},
+					{Key: "free", Type: influxUint, Value: 20},
+					{Key: "ok", Type: influxBool, Value: 1},
+					{Key: "down", Type: influxBool, Value: 0},
+					{Key: "msg", Type: influxString},
+				},
+				Time: now,
+			},
+		},
+		{
+			line: `disk\ io,path=C:\dir,dev\=x=sd\ a value=-1.5e3 1700000000`,
+			point: &influxPoint{
+				Measurement: "disk io",
+				Tags:        AttrMap{"path": `C:\dir`, "dev=x": "sd a"},
+				Fields: []influxField{
+					{Key: "value", Type: influxFloat, Value: -1500},
+				},
+				Time: time.Unix(1700000000, 0),
+			},
+			precision: time.Second,
+		},
+		{line: "", wantErr: true},
+		{line: ",host=a value=1", wantErr: true},
+		{line: "cpu", wantErr: true},
+		{line: "cpu,host value=1", wantErr: true},
+		{line: "cpu,=a value=1", wantErr: true},
+		{line: "cpu,host=a", wantErr: true},
+		{line: "cpu value", wantErr: true},
+		{line: "cpu =1", wantErr: true},
+		{line: "cpu value=", wantErr: true},
+		{line: "cpu value=abc", wantErr: true},
+		{line: "cpu value=1.5i", wantErr: true},
+		{line: "cpu value=-1u", wantErr: true},
+		{line: `cpu value="unterminated`, wantErr: true},
+		{line: "cpu value=1,", wantErr: true},
+		{line: "cpu value=1 abc", wantErr: true},
+		{line: "cpu value=1 9223372036854775807", precision: time.Hour, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			precision := test.precision
+			if precision == 0 {
+				precision = time.Nanosecond
+			}
+
+			point, err := parseInfluxLine(test.line, precision, now)
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, test.point.Time.Equal(point.Time), "got %s", point.Time)
+			point.Time = test.point.Time
+			require.Equal(t, test.point, point)
+		})
+	}
+}
+
+func TestInfluxPrecision(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"":   time.Nanosecond,
+		"us": time.Microsecond,
+		"ms": time.Millisecond,
+		"s":  time.Second,
+		"h":  time.Hour,
+	} {
+		got, err := influxPrecision(s)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+
+	_, err := influxPrecision("d")
+	require.Error(t, err)
+}
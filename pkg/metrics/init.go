@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/vmihailenco/taskq/v4"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	collectormetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	"go.uber.org/fx"
@@ -30,6 +31,18 @@ var datapointCounter, _ = bunotel.Meter.Int64Counter(
 	metric.WithDescription("Number of processed datapoints"),
 )
 
+// countThrottled reports datapoints rejected because of the project rate limits.
+func countThrottled(ctx context.Context, projectID uint32, n int64) {
+	datapointCounter.Add(
+		ctx,
+		n,
+		metric.WithAttributes(
+			bunotel.ProjectIDAttr(projectID),
+			attribute.String("type", "throttled"),
+		),
+	)
+}
+
 var Module = fx.Module("metrics",
 	fx.Provide(
 		fx.Private,
@@ -46,6 +59,20 @@ var Module = fx.Module("metrics",
 		NewGridRowHandler,
 		NewKinesisHandler,
 		NewPrometheusHandler,
+		NewInfluxHandler,
+		NewPushgatewayHandler,
+		NewDatadogHandler,
+		NewPromAPIHandler,
+		NewPromScraper,
+		NewStatsDServer,
+		NewGraphiteServer,
+		NewSpanMetricsProcessor,
+	),
+	fx.Provide(
+		fx.Annotate(
+			provideSpanProcessor,
+			fx.ResultTags(`group:"span_processors"`),
+		),
 	),
 	fx.Invoke(
 		registerMetricHandler,
@@ -56,10 +83,20 @@ var Module = fx.Module("metrics",
 		registerGridRowHandler,
 		registerKinesisHandler,
 		registerPrometheusHandler,
+		registerInfluxHandler,
+		registerPushgatewayHandler,
+		registerDatadogHandler,
+		registerPromAPIHandler,
 
 		initOTLP,
 		initTasks,
 		runDatapointProcessor,
+		runPromScraper,
+		runStatsDServer,
+		runGraphiteServer,
+		runPushgateway,
+		runServiceGraphMetrics,
+		runSpanMetricsProcessor,
 	),
 )
 
@@ -25,7 +25,9 @@ import (
 	"github.com/uptrace/opentelemetry-go-extra/otelzap"
 	"github.com/uptrace/pkg/clickhouse/bfloat16"
 	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunconf"
 	"github.com/uptrace/uptrace/pkg/bunconv"
+	"github.com/uptrace/uptrace/pkg/forwarder"
 	"github.com/uptrace/uptrace/pkg/org"
 	"github.com/uptrace/uptrace/pkg/otlpconv"
 )
@@ -38,10 +40,12 @@ const (
 type MetricsServiceServerParams struct {
 	fx.In
 
-	Logger   *otelzap.Logger
-	PG       *bun.DB
-	MP       *DatapointProcessor
-	Projects *org.ProjectGateway
+	Logger      *otelzap.Logger
+	PG          *bun.DB
+	MP          *DatapointProcessor
+	Projects    *org.ProjectGateway
+	Forwarders  *forwarder.Forwarders
+	RateLimiter *org.RateLimiter
 }
 
 type MetricsServiceServer struct {
@@ -155,11 +159,50 @@ func (s *MetricsServiceServer) process(
 	req *collectormetricspb.ExportMetricsServiceRequest,
 	project *org.Project,
 ) (*collectormetricspb.ExportMetricsServiceResponse, error) {
+	if err := s.RateLimiter.AllowBytes(project.ID, func() int {
+		return proto.Size(req)
+	}); err != nil {
+		return nil, err
+	}
+
+	throttle := s.RateLimiter.Throttle(project.ID, bunconf.SignalMetrics)
+	s.processMetrics(ctx, req, project, throttle)
+
+	resp := new(collectormetricspb.ExportMetricsServiceResponse)
+
+	if n := throttle.Throttled(); n > 0 {
+		countThrottled(ctx, project.ID, n)
+		if err := throttle.Err(); err != nil {
+			return nil, err
+		}
+		resp.PartialSuccess = &collectormetricspb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: n,
+			ErrorMessage:       "project exceeded metrics rate limit",
+		}
+	}
+
+	// Throttled datapoints are dropped after they are converted so they can't be
+	// removed from the request. Requests with throttled datapoints are not forwarded
+	// to avoid forwarding data that was not accepted.
+	if throttle.Throttled() == 0 {
+		forwardRequest(ctx, s.Forwarders, project, req)
+	}
+
+	return resp, nil
+}
+
+func (s *MetricsServiceServer) processMetrics(
+	ctx context.Context,
+	req *collectormetricspb.ExportMetricsServiceRequest,
+	project *org.Project,
+	throttle *org.Throttle,
+) {
 	p := otlpProcessor{
-		logger:  s.Logger,
-		pg:      s.PG,
-		mp:      s.MP,
-		project: project,
+		logger:   s.Logger,
+		pg:       s.PG,
+		mp:       s.MP,
+		project:  project,
+		throttle: throttle,
 	}
 	defer p.close(ctx)
 
@@ -207,8 +250,6 @@ func (s *MetricsServiceServer) process(
 			}
 		}
 	}
-
-	return &collectormetricspb.ExportMetricsServiceResponse{}, nil
 }
 
 type otlpProcessor struct {
@@ -218,6 +259,8 @@ type otlpProcessor struct {
 
 	project     *org.Project
 	metricIDMap map[MetricKey]struct{}
+	// throttle is nil unless the datapoints are received by a rate limited handler.
+	throttle *org.Throttle
 }
 
 func (p *otlpProcessor) close(ctx context.Context) {}
@@ -519,6 +562,9 @@ func (p *otlpProcessor) enqueue(ctx context.Context, datapoint *Datapoint) {
 		p.logger.Error("time is empty")
 		return
 	}
+	if !p.throttle.Allow() {
+		return
+	}
 
 	p.mp.AddDatapoint(ctx, datapoint)
 }
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"context"
+
+	collectormetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/forwarder"
+	"github.com/uptrace/uptrace/pkg/org"
+	"github.com/uptrace/uptrace/pkg/otlpconv"
+	"github.com/uptrace/uptrace/pkg/tracing/tql"
+)
+
+// forwardRequest re-exports the metrics using the configured forwarders.
+// The request is not copied unless the forwarder filters metrics.
+func forwardRequest(
+	ctx context.Context,
+	forwarders *forwarder.Forwarders,
+	project *org.Project,
+	req *collectormetricspb.ExportMetricsServiceRequest,
+) {
+	for _, fwd := range forwarders.Select(project.ID, bunconf.SignalMetrics) {
+		fwdReq := req
+		if matcher := fwd.Matcher(); matcher != nil {
+			fwdReq = &collectormetricspb.ExportMetricsServiceRequest{
+				ResourceMetrics: filterResourceMetrics(req.ResourceMetrics, matcher),
+			}
+		}
+		if len(fwdReq.ResourceMetrics) == 0 {
+			continue
+		}
+		fwd.Enqueue(ctx, project.ID, bunconf.SignalMetrics, fwdReq)
+	}
+}
+
+// filterResourceMetrics filters metrics using the metric name (_name)
+// and the resource and scope attributes.
+func filterResourceMetrics(
+	resourceMetrics []*metricspb.ResourceMetrics, matcher *tql.Matcher,
+) []*metricspb.ResourceMetrics {
+	var filtered []*metricspb.ResourceMetrics
+
+	for _, rms := range resourceMetrics {
+		attrs := make(map[string]any)
+		if rms.Resource != nil {
+			otlpconv.ForEachKeyValue(rms.Resource.Attributes, func(key string, value any) {
+				attrs[attrkey.Clean(key)] = value
+			})
+		}
+
+		var scopeMetrics []*metricspb.ScopeMetrics
+		for _, sm := range rms.ScopeMetrics {
+			scopeAttrs := attrs
+			if sm.Scope != nil && len(sm.Scope.Attributes) > 0 {
+				scopeAttrs = make(map[string]any, len(attrs)+len(sm.Scope.Attributes))
+				for key, value := range attrs {
+					scopeAttrs[key] = value
+				}
+				otlpconv.ForEachKeyValue(sm.Scope.Attributes, func(key string, value any) {
+					scopeAttrs[attrkey.Clean(key)] = value
+				})
+			}
+
+			var metrics []*metricspb.Metric
+			for _, metric := range sm.Metrics {
+				if metric == nil {
+					continue
+				}
+				if matcher.Match(func(key string) (any, bool) {
+					if key == attrkey.SpanName {
+						return metric.Name, true
+					}
+					value, ok := scopeAttrs[key]
+					return value, ok
+				}) {
+					metrics = append(metrics, metric)
+				}
+			}
+			if len(metrics) == 0 {
+				continue
+			}
+
+			scopeMetrics = append(scopeMetrics, &metricspb.ScopeMetrics{
+				Scope:     sm.Scope,
+				Metrics:   metrics,
+				SchemaUrl: sm.SchemaUrl,
+			})
+		}
+		if len(scopeMetrics) == 0 {
+			continue
+		}
+
+		filtered = append(filtered, &metricspb.ResourceMetrics{
+			Resource:     rms.Resource,
+			ScopeMetrics: scopeMetrics,
+			SchemaUrl:    rms.SchemaUrl,
+		})
+	}
+
+	return filtered
+}
@@ -0,0 +1,540 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/segmentio/encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/pkg/clickhouse/ch"
+	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/httputil"
+	"github.com/uptrace/uptrace/pkg/org"
+)
+
+const (
+	promQueryTimeout    = 2 * time.Minute
+	promMaxSamples      = 50_000_000
+	promDefaultLookback = 5 * time.Minute
+	promDefaultRange    = time.Hour
+	promMaxPoints       = 11000
+)
+
+// Error types used by Prometheus HTTP API.
+const (
+	promErrorBadData   = "bad_data"
+	promErrorExecution = "execution"
+	promErrorTimeout   = "timeout"
+	promErrorCanceled  = "canceled"
+)
+
+type PromAPIHandlerParams struct {
+	fx.In
+
+	Logger *otelzap.Logger
+	PG     *bun.DB
+	CH     *ch.DB
+}
+
+// PromAPIHandler implements Prometheus HTTP query API so the project can be used
+// as a Prometheus datasource in Grafana and other tools.
+type PromAPIHandler struct {
+	*PromAPIHandlerParams
+
+	engine *promql.Engine
+}
+
+func NewPromAPIHandler(p PromAPIHandlerParams) *PromAPIHandler {
+	return &PromAPIHandler{
+		PromAPIHandlerParams: &p,
+		engine: promql.NewEngine(promql.EngineOpts{
+			MaxSamples:           promMaxSamples,
+			Timeout:              promQueryTimeout,
+			LookbackDelta:        promDefaultLookback,
+			EnableAtModifier:     true,
+			EnableNegativeOffset: true,
+		}),
+	}
+}
+
+func registerPromAPIHandler(h *PromAPIHandler, p bunapp.RouterParams, m *Middleware) {
+	p.Router.Use(m.UserAndProject).
+		WithGroup("/api/prometheus/:project_id/api/v1", func(g *bunrouter.Group) {
+			g.GET("/query", h.Query)
+			g.POST("/query", h.Query)
+			g.GET("/query_range", h.QueryRange)
+			g.POST("/query_range", h.QueryRange)
+			g.GET("/series", h.Series)
+			g.POST("/series", h.Series)
+			g.GET("/labels", h.Labels)
+			g.POST("/labels", h.Labels)
+			g.GET("/label/:name/values", h.LabelValues)
+			g.GET("/metadata", h.Metadata)
+		})
+}
+
+func (h *PromAPIHandler) Query(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	if err := req.ParseForm(); err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+
+	ts, err := parsePromTime(req.Form.Get("time"), time.Now())
+	if err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+
+	queryable, err := h.queryable(ctx, h.project(req), time.Minute)
+	if err != nil {
+		return promAPIError(w, promErrorExecution, err)
+	}
+
+	opts := promql.NewPrometheusQueryOpts(false, promDefaultLookback)
+	qry, err := h.engine.NewInstantQuery(ctx, queryable, opts, req.Form.Get("query"), ts)
+	if err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+	return h.exec(ctx, w, qry)
+}
+
+func (h *PromAPIHandler) QueryRange(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	if err := req.ParseForm(); err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+
+	start, err := parsePromTime(req.Form.Get("start"), time.Time{})
+	if err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+	end, err := parsePromTime(req.Form.Get("end"), time.Time{})
+	if err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+	if end.Before(start) {
+		return promAPIError(w, promErrorBadData,
+			errors.New("end timestamp must not be before start time"))
+	}
+
+	step, err := parsePromDuration(req.Form.Get("step"))
+	if err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+	if step <= 0 {
+		return promAPIError(w, promErrorBadData,
+			errors.New("zero or negative query resolution step widths are not accepted"))
+	}
+	if end.Sub(start)/step > promMaxPoints {
+		return promAPIError(w, promErrorBadData,
+			errors.New("exceeded maximum resolution of 11,000 points per timeseries"))
+	}
+
+	// Datapoints are stored with the minute resolution.
+	interval := max(step.Truncate(time.Minute), time.Minute)
+	queryable, err := h.queryable(ctx, h.project(req), interval)
+	if err != nil {
+		return promAPIError(w, promErrorExecution, err)
+	}
+
+	opts := promql.NewPrometheusQueryOpts(false, max(promDefaultLookback, 2*interval))
+	qry, err := h.engine.NewRangeQuery(
+		ctx, queryable, opts, req.Form.Get("query"), start, end, step)
+	if err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+	return h.exec(ctx, w, qry)
+}
+
+func (h *PromAPIHandler) exec(ctx context.Context, w http.ResponseWriter, qry promql.Query) error {
+	defer qry.Close()
+
+	res := qry.Exec(ctx)
+	if res.Err != nil {
+		var errType string
+		switch res.Err.(type) {
+		case promql.ErrQueryCanceled:
+			errType = promErrorCanceled
+		case promql.ErrQueryTimeout:
+			errType = promErrorTimeout
+		default:
+			errType = promErrorExecution
+		}
+		return promAPIError(w, errType, res.Err)
+	}
+
+	var result any = res.Value
+	if matrix, ok := res.Value.(promql.Matrix); ok {
+		result = promMatrixJSON(matrix)
+	}
+
+	return promAPIData(w, bunrouter.H{
+		"resultType": res.Value.Type(),
+		"result":     result,
+	})
+}
+
+func (h *PromAPIHandler) Series(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	if err := req.ParseForm(); err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+
+	matcherSets, err := parsePromMatchers(req.Form["match[]"])
+	if err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+	if len(matcherSets) == 0 {
+		return promAPIError(w, promErrorBadData, errors.New("no match[] parameter provided"))
+	}
+
+	start, end, err := parsePromRange(req)
+	if err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+
+	querier, err := h.querier(ctx, req, start, end)
+	if err != nil {
+		return promAPIError(w, promErrorExecution, err)
+	}
+	defer querier.Close()
+
+	series := make([]labels.Labels, 0)
+	seen := make(map[uint64]struct{})
+	for _, matchers := range matcherSets {
+		set := querier.Select(ctx, false, nil, matchers...)
+		for set.Next() {
+			lset := set.At().Labels()
+			if _, ok := seen[lset.Hash()]; ok {
+				continue
+			}
+			seen[lset.Hash()] = struct{}{}
+			series = append(series, lset)
+		}
+		if err := set.Err(); err != nil {
+			return promAPIError(w, promErrorExecution, err)
+		}
+	}
+
+	slices.SortFunc(series, labels.Compare)
+	return promAPIData(w, series)
+}
+
+func (h *PromAPIHandler) Labels(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	if err := req.ParseForm(); err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+
+	matcherSets, err := parsePromMatchers(req.Form["match[]"])
+	if err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+
+	start, end, err := parsePromRange(req)
+	if err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+
+	querier, err := h.querier(ctx, req, start, end)
+	if err != nil {
+		return promAPIError(w, promErrorExecution, err)
+	}
+	defer querier.Close()
+
+	if len(matcherSets) == 0 {
+		matcherSets = append(matcherSets, nil)
+	}
+
+	set := make(map[string]struct{})
+	for _, matchers := range matcherSets {
+		names, _, err := querier.LabelNames(ctx, matchers...)
+		if err != nil {
+			return promAPIError(w, promErrorExecution, err)
+		}
+		for _, name := range names {
+			set[name] = struct{}{}
+		}
+	}
+
+	return promAPIData(w, sortedKeys(set))
+}
+
+func (h *PromAPIHandler) LabelValues(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	name := req.Param("name")
+	if !model.LabelNameRE.MatchString(name) {
+		return promAPIError(w, promErrorBadData, fmt.Errorf("invalid label name: %q", name))
+	}
+
+	matcherSets, err := parsePromMatchers(req.URL.Query()["match[]"])
+	if err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+
+	start, end, err := parsePromRange(req)
+	if err != nil {
+		return promAPIError(w, promErrorBadData, err)
+	}
+
+	querier, err := h.querier(ctx, req, start, end)
+	if err != nil {
+		return promAPIError(w, promErrorExecution, err)
+	}
+	defer querier.Close()
+
+	if len(matcherSets) == 0 {
+		matcherSets = append(matcherSets, nil)
+	}
+
+	set := make(map[string]struct{})
+	for _, matchers := range matcherSets {
+		values, _, err := querier.LabelValues(ctx, name, matchers...)
+		if err != nil {
+			return promAPIError(w, promErrorExecution, err)
+		}
+		for _, value := range values {
+			set[value] = struct{}{}
+		}
+	}
+
+	return promAPIData(w, sortedKeys(set))
+}
+
+type PromMetadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+func (h *PromAPIHandler) Metadata(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+	project := h.project(req)
+	query := req.URL.Query()
+
+	limit := -1
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return promAPIError(w, promErrorBadData, fmt.Errorf("invalid limit: %w", err))
+		}
+		limit = n
+	}
+
+	metricMap, err := SelectMetricMap(ctx, h.PG, project.ID)
+	if err != nil {
+		return promAPIError(w, promErrorExecution, err)
+	}
+
+	names := make([]string, 0, len(metricMap))
+	for name := range metricMap {
+		if metricName := query.Get("metric"); metricName == "" || metricName == name {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	if limit >= 0 && len(names) > limit {
+		names = names[:limit]
+	}
+
+	data := make(map[string][]PromMetadata, len(names))
+	for _, name := range names {
+		metric := metricMap[name]
+		data[name] = []PromMetadata{{
+			Type: promMetricType(metric.Instrument),
+			Help: metric.Description,
+			Unit: metric.Unit,
+		}}
+	}
+
+	return promAPIData(w, data)
+}
+
+func (h *PromAPIHandler) project(req bunrouter.Request) *org.Project {
+	ctx := req.Context()
+	project := org.ProjectFromContext(ctx)
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.Int64("project", int64(project.ID)))
+	}
+
+	return project
+}
+
+func (h *PromAPIHandler) queryable(
+	ctx context.Context, project *org.Project, interval time.Duration,
+) (*promQueryable, error) {
+	metricMap, err := SelectMetricMap(ctx, h.PG, project.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &promQueryable{
+		storage: NewCHStorage(ctx, h.CH, &CHStorageConfig{
+			ProjectID: project.ID,
+			MetricMap: metricMap,
+			TableName: promTableName(interval),
+			// One more series is selected to detect that the limit is exceeded.
+			Limit: promMaxSeries + 1,
+		}),
+		ch:        h.CH,
+		projectID: project.ID,
+		metricMap: metricMap,
+		interval:  interval,
+	}, nil
+}
+
+// parsePromRange parses the optional start and end params used by the metadata APIs.
+func parsePromRange(req bunrouter.Request) (start, end time.Time, _ error) {
+	end, err := parsePromTime(req.FormValue("end"), time.Now())
+	if err != nil {
+		return start, end, err
+	}
+	start, err = parsePromTime(req.FormValue("start"), end.Add(-promDefaultRange))
+	if err != nil {
+		return start, end, err
+	}
+	return start, end, nil
+}
+
+// querier creates a querier for the time range.
+func (h *PromAPIHandler) querier(
+	ctx context.Context, req bunrouter.Request, start, end time.Time,
+) (storage.Querier, error) {
+	interval := time.Minute
+	if end.Sub(start) > 7*24*time.Hour {
+		interval = time.Hour
+	}
+
+	queryable, err := h.queryable(ctx, h.project(req), interval)
+	if err != nil {
+		return nil, err
+	}
+	return queryable.Querier(start.UnixMilli(), end.UnixMilli())
+}
+
+//------------------------------------------------------------------------------
+
+func promAPIData(w http.ResponseWriter, data any) error {
+	return httputil.JSON(w, bunrouter.H{
+		"status": "success",
+		"data":   data,
+	})
+}
+
+func promAPIError(w http.ResponseWriter, errType string, err error) error {
+	var code int
+	switch errType {
+	case promErrorBadData:
+		code = http.StatusBadRequest
+	case promErrorExecution:
+		code = http.StatusUnprocessableEntity
+	case promErrorCanceled:
+		code = 499
+	case promErrorTimeout:
+		code = http.StatusServiceUnavailable
+	default:
+		code = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	return json.NewEncoder(w).Encode(bunrouter.H{
+		"status":    "error",
+		"errorType": errType,
+		"error":     err.Error(),
+	})
+}
+
+type promSeriesJSON struct {
+	Metric labels.Labels   `json:"metric"`
+	Values []promql.FPoint `json:"values"`
+}
+
+// promMatrixJSON is needed, because promql.Series does not implement json.Marshaler.
+func promMatrixJSON(matrix promql.Matrix) []promSeriesJSON {
+	series := make([]promSeriesJSON, len(matrix))
+	for i := range matrix {
+		series[i] = promSeriesJSON{
+			Metric: matrix[i].Metric,
+			Values: matrix[i].Floats,
+		}
+	}
+	return series
+}
+
+func parsePromMatchers(ss []string) ([][]*labels.Matcher, error) {
+	matcherSets := make([][]*labels.Matcher, 0, len(ss))
+	for _, s := range ss {
+		matchers, err := parser.ParseMetricSelector(s)
+		if err != nil {
+			return nil, err
+		}
+		matcherSets = append(matcherSets, matchers)
+	}
+	return matcherSets, nil
+}
+
+// parsePromTime parses Unix timestamps with optional decimal places and RFC3339 times.
+func parsePromTime(s string, defaultTime time.Time) (time.Time, error) {
+	if s == "" {
+		if defaultTime.IsZero() {
+			return time.Time{}, errors.New("timestamp is required")
+		}
+		return defaultTime, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(math.Round(frac*1000))*int64(time.Millisecond)), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+}
+
+func parsePromDuration(s string) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	if d, err := model.ParseDuration(s); err == nil {
+		return time.Duration(d), nil
+	}
+	return 0, fmt.Errorf("cannot parse %q to a valid duration", s)
+}
+
+func promMetricType(instrument Instrument) string {
+	switch instrument {
+	case InstrumentCounter:
+		return "counter"
+	case InstrumentGauge, InstrumentAdditive:
+		return "gauge"
+	case InstrumentHistogram:
+		return "histogram"
+	case InstrumentSummary:
+		return "summary"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,535 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/util/annotations"
+
+	"github.com/uptrace/pkg/clickhouse/ch"
+	"github.com/uptrace/pkg/unixtime"
+	"github.com/uptrace/uptrace/pkg/metrics/mql"
+	"github.com/uptrace/uptrace/pkg/metrics/mql/ast"
+)
+
+// promMaxSeries limits the number of timeseries selected for a metric.
+const promMaxSeries = 10000
+
+// promLabelPrefix is added to grouping aliases so labels don't clash with the
+// time and value columns.
+const promLabelPrefix = "label_"
+
+// promStorage selects timeseries for the PromQL querier. It is implemented by CHStorage.
+type promStorage interface {
+	mql.Storage
+	SelectHistograms(f *mql.TimeseriesFilter) ([]*HistogramTimeseries, error)
+}
+
+var _ promStorage = (*CHStorage)(nil)
+
+// promQueryable serves PromQL selectors using CHStorage. Samples are aggregated
+// using the interval and counters are reported as cumulative sums so that rate
+// and increase work as expected. Histograms are reported as classic Prometheus
+// histograms with _bucket, _sum, and _count series.
+type promQueryable struct {
+	storage   promStorage
+	ch        *ch.DB
+	projectID uint32
+	metricMap map[string]*Metric
+	interval  time.Duration
+}
+
+var _ storage.Queryable = (*promQueryable)(nil)
+
+func (q *promQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	return &promQuerier{
+		promQueryable: q,
+		mint:          mint,
+		maxt:          maxt,
+	}, nil
+}
+
+func promTableName(interval time.Duration) string {
+	if interval >= time.Hour {
+		return TableDatapointHours
+	}
+	return TableDatapointMinutes
+}
+
+type promQuerier struct {
+	*promQueryable
+	mint, maxt int64
+}
+
+var _ storage.Querier = (*promQuerier)(nil)
+
+func (q *promQuerier) Select(
+	ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher,
+) storage.SeriesSet {
+	mint, maxt := q.mint, q.maxt
+	if hints != nil {
+		mint, maxt = hints.Start, hints.End
+	}
+
+	var nameMatchers []*labels.Matcher
+	var filters []ast.Filter
+	for _, m := range matchers {
+		switch m.Name {
+		case labels.MetricName:
+			nameMatchers = append(nameMatchers, m)
+			continue
+		case labels.BucketLabel:
+			// Buckets are created from histograms after the query.
+			continue
+		}
+		filter, err := promMatcherFilter(m)
+		if err != nil {
+			return storage.ErrSeriesSet(err)
+		}
+		filters = append(filters, filter)
+	}
+
+	app := &promSeriesAppender{
+		matchers: matchers,
+		mint:     mint,
+		maxt:     maxt,
+	}
+	for _, metric := range q.metricMap {
+		if !slices.ContainsFunc(promSeriesNames(metric), func(name string) bool {
+			return promMatchesAll(nameMatchers, name)
+		}) {
+			continue
+		}
+
+		f := &mql.TimeseriesFilter{
+			Metric:   metric.Name,
+			TimeGTE:  time.UnixMilli(mint).Truncate(q.interval),
+			TimeLT:   time.UnixMilli(maxt).Truncate(q.interval).Add(q.interval),
+			Interval: q.interval,
+			Filters:  filters,
+			Grouping: promGrouping(metric),
+		}
+		if err := q.appendSeries(app, metric, f); err != nil {
+			return storage.ErrSeriesSet(err)
+		}
+	}
+
+	if sortSeries {
+		slices.SortFunc(app.series, func(a, b storage.Series) int {
+			return labels.Compare(a.Labels(), b.Labels())
+		})
+	}
+	return &promSeriesSet{series: app.series}
+}
+
+// appendSeries selects the metric timeseries and creates series from them. Counters,
+// histograms, and summaries are reported as cumulative values.
+func (q *promQuerier) appendSeries(
+	app *promSeriesAppender, metric *Metric, f *mql.TimeseriesFilter,
+) error {
+	switch metric.Instrument {
+	case InstrumentCounter, InstrumentGauge, InstrumentAdditive:
+		f.CHFunc = mql.CHAggSum
+		timeseries, err := q.storage.SelectTimeseries(f)
+		if err != nil {
+			return err
+		}
+		if len(timeseries) > promMaxSeries {
+			return errPromMaxSeries(metric)
+		}
+
+		for _, ts := range timeseries {
+			lset := promSeriesLabels(metric.Name, ts.Attrs)
+			if !app.matches(lset) {
+				continue
+			}
+			if metric.Instrument == InstrumentCounter {
+				app.append(lset, ts.Time, promCumSum(ts.Value))
+			} else {
+				app.append(lset, ts.Time, ts.Value)
+			}
+		}
+		return nil
+	case InstrumentHistogram, InstrumentSummary:
+		timeseries, err := q.storage.SelectHistograms(f)
+		if err != nil {
+			return err
+		}
+		if len(timeseries) > promMaxSeries {
+			return errPromMaxSeries(metric)
+		}
+
+		// The bounds are the same for all timeseries of the metric so buckets
+		// can be aggregated by the le label.
+		var bounds []float64
+		if metric.Instrument == InstrumentHistogram {
+			bounds = promBucketBounds(timeseries)
+		}
+
+		for _, ts := range timeseries {
+			if lset := promSeriesLabels(metric.Name+"_sum", ts.Attrs); app.matches(lset) {
+				app.append(lset, ts.Time, promCumSum(ts.Sum))
+			}
+			if lset := promSeriesLabels(metric.Name+"_count", ts.Attrs); app.matches(lset) {
+				app.append(lset, ts.Time, promCumSum(ts.Count))
+			}
+
+			for _, bound := range bounds {
+				lset := promSeriesLabels(metric.Name+"_bucket", ts.Attrs)
+				lset = labels.NewBuilder(lset).Set(labels.BucketLabel, formatPromBound(bound)).Labels()
+				if !app.matches(lset) {
+					continue
+				}
+
+				counts := make([]float64, len(ts.Time))
+				for i := range counts {
+					counts[i] = promBucketCount(ts, i, bound)
+				}
+				app.append(lset, ts.Time, promCumSum(counts))
+			}
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+func errPromMaxSeries(metric *Metric) error {
+	return fmt.Errorf("metric %q has more than %d series, add label matchers to narrow the query",
+		metric.Name, promMaxSeries)
+}
+
+// promGrouping groups timeseries by all metric attributes so each timeseries
+// becomes a Prometheus series.
+func promGrouping(metric *Metric) ast.GroupingElems {
+	grouping := make(ast.GroupingElems, len(metric.AttrKeys))
+	for i, key := range metric.AttrKeys {
+		grouping[i] = ast.GroupingElem{
+			Name:     key,
+			HasAlias: true,
+			Alias:    promLabelPrefix + key,
+		}
+	}
+	return grouping
+}
+
+// promSeriesLabels creates series labels from the timeseries attrs skipping empty values.
+func promSeriesLabels(name string, attrs mql.Attrs) labels.Labels {
+	var b labels.ScratchBuilder
+	b.Add(labels.MetricName, name)
+	for _, kv := range attrs {
+		if kv.Value != "" {
+			b.Add(strings.TrimPrefix(kv.Key, promLabelPrefix), kv.Value)
+		}
+	}
+	b.Sort()
+	return b.Labels()
+}
+
+// promCumSum returns cumulative sums of the values. NaN values are kept as is.
+func promCumSum(values []float64) []float64 {
+	sums := make([]float64, len(values))
+	var sum float64
+	for i, value := range values {
+		if math.IsNaN(value) {
+			sums[i] = value
+			continue
+		}
+		sum += value
+		sums[i] = sum
+	}
+	return sums
+}
+
+// promSeriesAppender collects series that match the selector matchers.
+type promSeriesAppender struct {
+	matchers   []*labels.Matcher
+	mint, maxt int64
+
+	series []storage.Series
+}
+
+func (a *promSeriesAppender) matches(lset labels.Labels) bool {
+	return promMatchesLabels(a.matchers, lset)
+}
+
+// append adds a series with the samples within the time range. The times are
+// Unix seconds as returned by CHStorage.
+func (a *promSeriesAppender) append(lset labels.Labels, times []unixtime.Nano, values []float64) {
+	samples := make([]chunks.Sample, 0, len(times))
+	for i, tm := range times {
+		t := int64(tm) * 1000
+		f := values[i]
+		if t < a.mint || t > a.maxt || math.IsNaN(f) {
+			continue
+		}
+		samples = append(samples, promSample{t: t, f: f})
+	}
+	if len(samples) > 0 {
+		a.series = append(a.series, storage.NewListSeries(lset, samples))
+	}
+}
+
+// promSeriesNames returns the names of the series created from the metric.
+func promSeriesNames(metric *Metric) []string {
+	switch metric.Instrument {
+	case InstrumentCounter, InstrumentGauge, InstrumentAdditive:
+		return []string{metric.Name}
+	case InstrumentHistogram:
+		return []string{metric.Name + "_bucket", metric.Name + "_sum", metric.Name + "_count"}
+	case InstrumentSummary:
+		return []string{metric.Name + "_sum", metric.Name + "_count"}
+	default:
+		return nil
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// Histograms are stored as quantilesBFloat16 states so buckets are created from
+// quantiles at histogramQuantileLevels.
+const (
+	promMaxBounds    = 160
+	promBoundsPerPow = 4 // the number of bounds per power of 2
+)
+
+// promBucketBounds returns exponential bucket bounds that cover quantiles of all
+// timeseries of the metric. The bounds are powers of 2^(1/promBoundsPerPow) and are
+// the same for all timeseries and datapoints, so rate and sum by (le) can be used
+// on buckets.
+func promBucketBounds(timeseries []*HistogramTimeseries) []float64 {
+	minValue, maxValue := math.Inf(1), math.Inf(-1)
+	var hasNonPositive bool
+	for _, ts := range timeseries {
+		for _, quantiles := range ts.Quantiles {
+			for _, value := range quantiles {
+				switch {
+				case math.IsNaN(value):
+				case value <= 0:
+					hasNonPositive = true
+				default:
+					minValue = min(minValue, value)
+					maxValue = max(maxValue, value)
+				}
+			}
+		}
+	}
+
+	var bounds []float64
+	if hasNonPositive {
+		bounds = append(bounds, 0)
+	}
+	if math.IsInf(minValue, 1) {
+		return append(bounds, math.Inf(1))
+	}
+
+	lo := int(math.Floor(math.Log2(minValue) * promBoundsPerPow))
+	hi := int(math.Ceil(math.Log2(maxValue) * promBoundsPerPow))
+	step := max((hi-lo+promMaxBounds-1)/promMaxBounds, 1)
+	for k := lo; ; k += step {
+		bounds = append(bounds, math.Exp2(float64(k)/promBoundsPerPow))
+		if k >= hi {
+			break
+		}
+	}
+	return append(bounds, math.Inf(1))
+}
+
+// promBucketCount returns the number of observations less than or equal to the bound
+// at the i-th time.
+func promBucketCount(ts *HistogramTimeseries, i int, bound float64) float64 {
+	count := ts.Count[i]
+	if math.IsInf(bound, 1) {
+		return count
+	}
+	if i >= len(ts.Quantiles) || len(ts.Quantiles[i]) == 0 {
+		return 0
+	}
+
+	quantiles := ts.Quantiles[i]
+	var n int
+	for _, value := range quantiles {
+		if value <= bound {
+			n++
+		}
+	}
+	return count * float64(n) / float64(len(quantiles))
+}
+
+func formatPromBound(bound float64) string {
+	return strconv.FormatFloat(bound, 'g', -1, 64)
+}
+
+func (q *promQuerier) LabelValues(
+	ctx context.Context, name string, matchers ...*labels.Matcher,
+) ([]string, annotations.Annotations, error) {
+	if len(matchers) > 0 {
+		set := make(map[string]struct{})
+		err := q.forEachSeries(ctx, matchers, func(lset labels.Labels) {
+			if value := lset.Get(name); value != "" {
+				set[value] = struct{}{}
+			}
+		})
+		return sortedKeys(set), nil, err
+	}
+
+	if name == labels.MetricName {
+		values := make([]string, 0, len(q.metricMap))
+		for _, metric := range q.metricMap {
+			values = append(values, promSeriesNames(metric)...)
+		}
+		slices.Sort(values)
+		return values, nil, nil
+	}
+
+	values := make([]string, 0)
+	if err := q.ch.NewSelect().
+		ColumnExpr("DISTINCT ? AS value", CHExpr(name)).
+		TableExpr("? AS d", ch.Name(promTableName(q.interval))).
+		Where("d.project_id = ?", q.projectID).
+		Where("d.time >= ?", time.UnixMilli(q.mint)).
+		Where("d.time <= ?", time.UnixMilli(q.maxt)).
+		Where("has(d.string_keys, ?)", name).
+		OrderExpr("value ASC").
+		Limit(10000).
+		Scan(ctx, &values); err != nil {
+		return nil, nil, err
+	}
+	return values, nil, nil
+}
+
+func (q *promQuerier) LabelNames(
+	ctx context.Context, matchers ...*labels.Matcher,
+) ([]string, annotations.Annotations, error) {
+	set := map[string]struct{}{labels.MetricName: {}}
+
+	if len(matchers) > 0 {
+		err := q.forEachSeries(ctx, matchers, func(lset labels.Labels) {
+			lset.Range(func(l labels.Label) {
+				set[l.Name] = struct{}{}
+			})
+		})
+		return sortedKeys(set), nil, err
+	}
+
+	for _, metric := range q.metricMap {
+		for _, key := range metric.AttrKeys {
+			set[key] = struct{}{}
+		}
+		if metric.Instrument == InstrumentHistogram {
+			set[labels.BucketLabel] = struct{}{}
+		}
+	}
+	return sortedKeys(set), nil, nil
+}
+
+func (q *promQuerier) forEachSeries(
+	ctx context.Context, matchers []*labels.Matcher, fn func(labels.Labels),
+) error {
+	set := q.Select(ctx, false, nil, matchers...)
+	for set.Next() {
+		fn(set.At().Labels())
+	}
+	return set.Err()
+}
+
+func (q *promQuerier) Close() error {
+	return nil
+}
+
+func promMatcherFilter(m *labels.Matcher) (ast.Filter, error) {
+	filter := ast.Filter{
+		BoolOp: ast.BoolAnd,
+		LHS:    m.Name,
+		RHS:    ast.StringValue{Text: m.Value},
+	}
+
+	switch m.Type {
+	case labels.MatchEqual:
+		filter.Op = ast.FilterEqual
+	case labels.MatchNotEqual:
+		filter.Op = ast.FilterNotEqual
+	case labels.MatchRegexp:
+		filter.Op = ast.FilterRegexp
+		filter.RHS = ast.StringValue{Text: "^(?:" + m.Value + ")$"}
+	case labels.MatchNotRegexp:
+		filter.Op = ast.FilterNotRegexp
+		filter.RHS = ast.StringValue{Text: "^(?:" + m.Value + ")$"}
+	default:
+		return filter, fmt.Errorf("unsupported matcher type: %s", m.Type)
+	}
+
+	return filter, nil
+}
+
+func promMatchesAll(matchers []*labels.Matcher, value string) bool {
+	for _, m := range matchers {
+		if !m.Matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func promMatchesLabels(matchers []*labels.Matcher, lset labels.Labels) bool {
+	for _, m := range matchers {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+//------------------------------------------------------------------------------
+
+type promSeriesSet struct {
+	series []storage.Series
+	cur    storage.Series
+}
+
+var _ storage.SeriesSet = (*promSeriesSet)(nil)
+
+func (s *promSeriesSet) Next() bool {
+	if len(s.series) == 0 {
+		return false
+	}
+	s.cur = s.series[0]
+	s.series = s.series[1:]
+	return true
+}
+
+func (s *promSeriesSet) At() storage.Series                { return s.cur }
+func (s *promSeriesSet) Err() error                        { return nil }
+func (s *promSeriesSet) Warnings() annotations.Annotations { return nil }
+
+type promSample struct {
+	t int64
+	f float64
+}
+
+var _ chunks.Sample = (*promSample)(nil)
+
+func (s promSample) T() int64                      { return s.t }
+func (s promSample) F() float64                    { return s.f }
+func (s promSample) H() *histogram.Histogram       { return nil }
+func (s promSample) FH() *histogram.FloatHistogram { return nil }
+func (s promSample) Type() chunkenc.ValueType      { return chunkenc.ValFloat }
@@ -0,0 +1,299 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/util/annotations"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uptrace/pkg/unixtime"
+	"github.com/uptrace/uptrace/pkg/metrics/mql"
+)
+
+func TestPromQuerierHistogram(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	// Each interval has 100 observations evenly distributed between 1 and 100.
+	hist := testHistogram(start, 5, 1, 100)
+	hist.Attrs = mql.NewAttrs(promLabelPrefix+"service_name", "api", promLabelPrefix+"empty", "")
+
+	q := testPromQuerier(start, &testPromStorage{
+		timeseries: map[string][]*mql.Timeseries{
+			"http_server_requests": {{
+				Attrs: mql.NewAttrs(promLabelPrefix+"service_name", "api"),
+				Time:  testTimes(start, 3),
+				Value: []float64{10, math.NaN(), 5},
+			}},
+		},
+		histograms: map[string][]*HistogramTimeseries{
+			"http_server_duration": {hist},
+		},
+	})
+
+	byName := make(map[string][]storage.Series)
+	set := q.Select(context.Background(), false, nil,
+		labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, "http_.+"))
+	for set.Next() {
+		s := set.At()
+		name := s.Labels().Get(labels.MetricName)
+		byName[name] = append(byName[name], s)
+		require.Equal(t, "api", s.Labels().Get("service_name"))
+		require.False(t, s.Labels().Has("empty"))
+	}
+	require.NoError(t, set.Err())
+
+	require.Len(t, byName["http_server_duration_sum"], 1)
+	require.Len(t, byName["http_server_duration_count"], 1)
+	require.Equal(t, []float64{100, 200, 300, 400, 500}, seriesValues(t, byName["http_server_duration_count"][0]))
+	require.Equal(t, []float64{10, 15}, seriesValues(t, byName["http_server_requests"][0]))
+
+	buckets := byName["http_server_duration_bucket"]
+	require.NotEmpty(t, buckets)
+	infBucket := selectBucket(t, buckets, "+Inf")
+	require.Equal(t, []float64{100, 200, 300, 400, 500}, seriesValues(t, infBucket))
+
+	// Only the matching bucket is created.
+	set = q.Select(context.Background(), false, nil,
+		labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "http_server_duration_bucket"),
+		labels.MustNewMatcher(labels.MatchEqual, labels.BucketLabel, "+Inf"))
+	require.True(t, set.Next())
+	require.False(t, set.Next())
+
+	vector := testPromQuery(t, q.promQueryable,
+		"histogram_quantile(0.5, rate(http_server_duration_bucket[5m]))", start.Add(4*time.Minute))
+	require.Len(t, vector, 1)
+	require.InEpsilon(t, 50, vector[0].F, 0.1)
+}
+
+func TestPromQuerierSumByLe(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	// The series have different value ranges so buckets must use the same bounds
+	// for the sum by le to be a valid histogram.
+	fast := testHistogram(start, 5, 1, 10)
+	fast.Attrs = mql.NewAttrs(promLabelPrefix+"service_name", "fast")
+	slow := testHistogram(start, 5, 100, 1000)
+	slow.Attrs = mql.NewAttrs(promLabelPrefix+"service_name", "slow")
+
+	q := testPromQuerier(start, &testPromStorage{
+		histograms: map[string][]*HistogramTimeseries{
+			"http_server_duration": {fast, slow},
+		},
+	})
+
+	leSets := make(map[string][]string)
+	set := q.Select(context.Background(), true, nil,
+		labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "http_server_duration_bucket"))
+	for set.Next() {
+		lset := set.At().Labels()
+		service := lset.Get("service_name")
+		leSets[service] = append(leSets[service], lset.Get(labels.BucketLabel))
+	}
+	require.NoError(t, set.Err())
+	require.Len(t, leSets, 2)
+	require.ElementsMatch(t, leSets["fast"], leSets["slow"])
+
+	vector := testPromQuery(t, q.promQueryable,
+		"sum by (le) (http_server_duration_bucket)", start.Add(4*time.Minute))
+	require.Len(t, vector, len(leSets["fast"]))
+
+	counts := make(map[string]float64)
+	for _, sample := range vector {
+		counts[sample.Metric.Get(labels.BucketLabel)] = sample.F
+	}
+	require.Equal(t, 1000.0, counts["+Inf"])
+	require.InDelta(t, 500, counts["16"], 1)
+
+	vector = testPromQuery(t, q.promQueryable,
+		"histogram_quantile(0.99, sum by (le) (rate(http_server_duration_bucket[5m])))",
+		start.Add(4*time.Minute))
+	require.Len(t, vector, 1)
+	require.InEpsilon(t, 1000, vector[0].F, 0.2)
+}
+
+func TestPromQuerierMaxSeries(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+
+	timeseries := make([]*mql.Timeseries, promMaxSeries+1)
+	for i := range timeseries {
+		timeseries[i] = &mql.Timeseries{
+			Attrs: mql.NewAttrs(promLabelPrefix+"id", strconv.Itoa(i)),
+			Time:  testTimes(start, 1),
+			Value: []float64{1},
+		}
+	}
+
+	q := testPromQuerier(start, &testPromStorage{
+		timeseries: map[string][]*mql.Timeseries{
+			"http_server_requests": timeseries,
+		},
+	})
+
+	set := q.Select(context.Background(), false, nil,
+		labels.MustNewMatcher(labels.MatchEqual, labels.MetricName, "http_server_requests"))
+	require.False(t, set.Next())
+	require.ErrorContains(t, set.Err(), "more than 10000 series")
+}
+
+func TestPromAPIError(t *testing.T) {
+	w := httptest.NewRecorder()
+	require.NoError(t, promAPIError(w, promErrorExecution, errors.New("too many series")))
+	require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	require.JSONEq(t,
+		`{"status": "error", "errorType": "execution", "error": "too many series"}`,
+		w.Body.String())
+}
+
+func TestPromBucketBounds(t *testing.T) {
+	bounds := promBucketBounds([]*HistogramTimeseries{
+		{Quantiles: [][]float64{{1, 2}}},
+		{Quantiles: [][]float64{{4}}},
+	})
+	require.Equal(t, 1.0, bounds[0])
+	require.Equal(t, 4.0, bounds[len(bounds)-2])
+	require.True(t, math.IsInf(bounds[len(bounds)-1], 1))
+	require.Len(t, bounds, 2*promBoundsPerPow+2)
+
+	bounds = promBucketBounds([]*HistogramTimeseries{{Quantiles: [][]float64{{-1, 0, math.NaN()}}}})
+	require.Equal(t, []float64{0, math.Inf(1)}, bounds)
+
+	bounds = promBucketBounds([]*HistogramTimeseries{{Quantiles: [][]float64{{1e-9, 1e9}}}})
+	require.LessOrEqual(t, len(bounds), promMaxBounds+2)
+}
+
+// testHistogram returns a histogram with 100 observations per minute evenly distributed
+// between the min and max values.
+func testHistogram(start time.Time, numPoint int, minValue, maxValue float64) *HistogramTimeseries {
+	quantiles := make([]float64, histogramNumQuantile)
+	for i := range quantiles {
+		quantiles[i] = minValue + (maxValue-minValue)*float64(i)/float64(histogramNumQuantile-1)
+	}
+
+	ts := &HistogramTimeseries{Time: testTimes(start, numPoint)}
+	for range ts.Time {
+		var sum float64
+		for _, value := range quantiles {
+			sum += value
+		}
+		ts.Sum = append(ts.Sum, sum)
+		ts.Count = append(ts.Count, histogramNumQuantile)
+		ts.Quantiles = append(ts.Quantiles, quantiles)
+	}
+	return ts
+}
+
+func testTimes(start time.Time, numPoint int) []unixtime.Nano {
+	times := make([]unixtime.Nano, numPoint)
+	for i := range times {
+		times[i] = unixtime.Nano(start.Add(time.Duration(i) * time.Minute).Unix())
+	}
+	return times
+}
+
+func testPromQuerier(start time.Time, s *testPromStorage) *promQuerier {
+	return &promQuerier{
+		promQueryable: &promQueryable{
+			storage: s,
+			metricMap: map[string]*Metric{
+				"http_server_duration": {Name: "http_server_duration", Instrument: InstrumentHistogram},
+				"http_server_requests": {Name: "http_server_requests", Instrument: InstrumentCounter},
+			},
+			interval: time.Minute,
+		},
+		mint: start.UnixMilli(),
+		maxt: start.Add(5 * time.Minute).UnixMilli(),
+	}
+}
+
+func testPromQuery(t *testing.T, q storage.Queryable, query string, ts time.Time) promql.Vector {
+	engine := promql.NewEngine(promql.EngineOpts{
+		MaxSamples: 1e6,
+		Timeout:    time.Minute,
+	})
+	qry, err := engine.NewInstantQuery(context.Background(), q, nil, query, ts)
+	require.NoError(t, err)
+
+	res := qry.Exec(context.Background())
+	require.NoError(t, res.Err)
+	vector, err := res.Vector()
+	require.NoError(t, err)
+	return vector
+}
+
+func selectBucket(t *testing.T, series []storage.Series, le string) storage.Series {
+	for _, s := range series {
+		if s.Labels().Get(labels.BucketLabel) == le {
+			return s
+		}
+	}
+	t.Fatalf("can't find bucket le=%q", le)
+	return nil
+}
+
+type testPromStorage struct {
+	timeseries map[string][]*mql.Timeseries
+	histograms map[string][]*HistogramTimeseries
+}
+
+var _ promStorage = (*testPromStorage)(nil)
+
+func (s *testPromStorage) SelectTimeseries(f *mql.TimeseriesFilter) ([]*mql.Timeseries, error) {
+	return s.timeseries[f.Metric], nil
+}
+
+func (s *testPromStorage) SelectHistograms(f *mql.TimeseriesFilter) ([]*HistogramTimeseries, error) {
+	return s.histograms[f.Metric], nil
+}
+
+func seriesValues(t *testing.T, s storage.Series) []float64 {
+	var values []float64
+	it := s.Iterator(nil)
+	for it.Next() != 0 {
+		_, f := it.At()
+		values = append(values, f)
+	}
+	require.NoError(t, it.Err())
+	return values
+}
+
+type testQueryable []storage.Series
+
+func (q testQueryable) Querier(mint, maxt int64) (storage.Querier, error) {
+	return q, nil
+}
+
+func (q testQueryable) Select(
+	ctx context.Context, sortSeries bool, hints *storage.SelectHints, matchers ...*labels.Matcher,
+) storage.SeriesSet {
+	var series []storage.Series
+	for _, s := range q {
+		if promMatchesLabels(matchers, s.Labels()) {
+			series = append(series, s)
+		}
+	}
+	return &promSeriesSet{series: series}
+}
+
+func (q testQueryable) LabelValues(
+	context.Context, string, ...*labels.Matcher,
+) ([]string, annotations.Annotations, error) {
+	return nil, nil, nil
+}
+
+func (q testQueryable) LabelNames(
+	context.Context, ...*labels.Matcher,
+) ([]string, annotations.Annotations, error) {
+	return nil, nil, nil
+}
+
+func (q testQueryable) Close() error { return nil }
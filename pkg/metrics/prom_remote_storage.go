@@ -18,17 +18,19 @@ import (
 	"github.com/uptrace/opentelemetry-go-extra/otelzap"
 	"github.com/uptrace/pkg/clickhouse/ch"
 	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/bunconf"
 	"github.com/uptrace/uptrace/pkg/org"
 )
 
 type PrometheusHandlerParams struct {
 	fx.In
 
-	Logger   *otelzap.Logger
-	PG       *bun.DB
-	CH       *ch.DB
-	MP       *DatapointProcessor
-	Projects *org.ProjectGateway
+	Logger      *otelzap.Logger
+	PG          *bun.DB
+	CH          *ch.DB
+	MP          *DatapointProcessor
+	Projects    *org.ProjectGateway
+	RateLimiter *org.RateLimiter
 }
 
 type PrometheusHandler struct {
@@ -66,21 +68,36 @@ func (h *PrometheusHandler) Write(
 		return err
 	}
 
-	if err := h.handleTimeseries(ctx, project, promReq.Timeseries); err != nil {
+	if err := h.RateLimiter.AllowBytes(project.ID, func() int {
+		return promReq.Size()
+	}); err != nil {
 		return err
 	}
 
+	throttle := h.RateLimiter.Throttle(project.ID, bunconf.SignalMetrics)
+	if err := h.handleTimeseries(ctx, project, throttle, promReq.Timeseries); err != nil {
+		return err
+	}
+
+	if n := throttle.Throttled(); n > 0 {
+		countThrottled(ctx, project.ID, n)
+		if err := throttle.Err(); err != nil {
+			return err
+		}
+	}
+
 	w.WriteHeader(http.StatusAccepted)
 	return nil
 }
 
 func (h *PrometheusHandler) handleTimeseries(
-	ctx context.Context, project *org.Project, tss []prompb.TimeSeries,
+	ctx context.Context, project *org.Project, throttle *org.Throttle, tss []prompb.TimeSeries,
 ) error {
 	p := otlpProcessor{
-		logger:  h.Logger,
-		mp:      h.MP,
-		project: project,
+		logger:   h.Logger,
+		mp:       h.MP,
+		project:  project,
+		throttle: throttle,
 	}
 	defer p.close(ctx)
 
@@ -104,22 +121,7 @@ func (h *PrometheusHandler) handleTimeseries(
 		for i := range ts.Samples {
 			s := &ts.Samples[i]
 			unixNano := uint64(s.Timestamp * int64(time.Millisecond))
-
-			if isCumCounter {
-				dp := p.newDatapoint(metricName, InstrumentCounter, attrs, unixNano)
-				dp.Unit = unit
-				dp.CumPoint = &NumberPoint{
-					Double: s.Value,
-				}
-				p.enqueue(ctx, dp)
-				continue
-			}
-
-			dp := p.newDatapoint(metricName, InstrumentGauge, attrs, unixNano)
-			dp.Unit = unit
-			dp.Gauge = float64(s.Value)
-			dp.OtelLibraryName = "Prometheus"
-			p.enqueue(ctx, dp)
+			p.enqueuePromSample(ctx, metricName, isCumCounter, unit, attrs, unixNano, s.Value)
 		}
 
 		if len(ts.Histograms) > 0 {
@@ -130,6 +132,33 @@ func (h *PrometheusHandler) handleTimeseries(
 	return nil
 }
 
+// enqueuePromSample converts cumulative counters to deltas and reports other samples as gauges.
+func (p *otlpProcessor) enqueuePromSample(
+	ctx context.Context,
+	metricName string,
+	isCumCounter bool,
+	unit string,
+	attrs AttrMap,
+	unixNano uint64,
+	value float64,
+) {
+	if isCumCounter {
+		dp := p.newDatapoint(metricName, InstrumentCounter, attrs, unixNano)
+		dp.Unit = unit
+		dp.CumPoint = &NumberPoint{
+			Double: value,
+		}
+		p.enqueue(ctx, dp)
+		return
+	}
+
+	dp := p.newDatapoint(metricName, InstrumentGauge, attrs, unixNano)
+	dp.Unit = unit
+	dp.Gauge = value
+	dp.OtelLibraryName = "Prometheus"
+	p.enqueue(ctx, dp)
+}
+
 func promMetadata(labels []prompb.Label) (metric string, isCumCounter bool, unit string) {
 	const nameStr = promlabels.MetricName
 	for _, label := range labels {
@@ -0,0 +1,416 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/model/textparse"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/org"
+	"github.com/uptrace/uptrace/pkg/run"
+)
+
+const (
+	promScrapeAccept = "application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75," +
+		"text/plain;version=0.0.4;q=0.5,*/*;q=0.1"
+	promScrapeMaxBodySize = 64 << 20
+)
+
+type PromScraperParams struct {
+	fx.In
+
+	Logger   *otelzap.Logger
+	Conf     *bunconf.Config
+	MP       *DatapointProcessor
+	Projects *org.ProjectGateway
+}
+
+// PromScraper periodically scrapes Prometheus targets configured using scrape_configs.
+type PromScraper struct {
+	*PromScraperParams
+
+	targets []*promTarget
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewPromScraper(p PromScraperParams) (*PromScraper, error) {
+	s := &PromScraper{PromScraperParams: &p}
+
+	ctx := context.Background()
+	for _, conf := range p.Conf.ScrapeConfigs {
+		targets, err := s.newTargets(ctx, conf)
+		if err != nil {
+			return nil, fmt.Errorf("scrape_configs %q: %w", conf.JobName, err)
+		}
+		s.targets = append(s.targets, targets...)
+	}
+
+	return s, nil
+}
+
+func runPromScraper(group *run.Group, s *PromScraper) {
+	if len(s.targets) == 0 {
+		return
+	}
+
+	group.Add("metrics.PromScraper.Run", func() error {
+		s.Run()
+		return nil
+	})
+	group.OnStop(func(context.Context, error) error {
+		s.Stop()
+		return nil
+	})
+}
+
+func (s *PromScraper) Run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	for _, target := range s.targets {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.scrapeLoop(ctx, target)
+		}()
+	}
+
+	<-ctx.Done()
+}
+
+func (s *PromScraper) Stop() {
+	if s.cancel == nil {
+		s.Logger.Error("no cancel function registered for PromScraper")
+		return
+	}
+
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *PromScraper) scrapeLoop(ctx context.Context, target *promTarget) {
+	interval := target.conf.ScrapeInterval
+
+	// Spread scrapes over the interval to avoid spikes.
+	timer := time.NewTimer(rand.N(interval))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		timer.Reset(interval)
+
+		if err := s.scrape(ctx, target); err != nil && ctx.Err() == nil {
+			s.Logger.Error("prometheus scrape failed",
+				zap.Error(err),
+				zap.String("job", target.conf.JobName),
+				zap.String("url", target.url))
+		}
+	}
+}
+
+func (s *PromScraper) scrape(ctx context.Context, target *promTarget) error {
+	startTime := time.Now()
+
+	p := otlpProcessor{
+		logger:  s.Logger,
+		mp:      s.MP,
+		project: target.project,
+	}
+	defer p.close(ctx)
+
+	scrapeErr := s.scrapeTarget(ctx, &p, target, startTime)
+
+	unixNano := uint64(startTime.UnixNano())
+
+	up := 1.
+	if scrapeErr != nil {
+		up = 0
+	}
+	p.enqueuePromSample(ctx, "up", false, "",
+		target.labels.Map(), unixNano, up)
+	p.enqueuePromSample(ctx, "scrape_duration_seconds", false, "seconds",
+		target.labels.Map(), unixNano, time.Since(startTime).Seconds())
+
+	return scrapeErr
+}
+
+func (s *PromScraper) scrapeTarget(
+	ctx context.Context, p *otlpProcessor, target *promTarget, startTime time.Time,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, target.conf.ScrapeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", promScrapeAccept)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds",
+		strconv.FormatFloat(target.conf.ScrapeTimeout.Seconds(), 'f', -1, 64))
+
+	resp, err := target.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("got %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, promScrapeMaxBodySize))
+	if err != nil {
+		return err
+	}
+
+	parser, err := textparse.New(body, resp.Header.Get("Content-Type"), false)
+	if err != nil {
+		return err
+	}
+
+	defaultUnixNano := uint64(startTime.UnixNano())
+	types := make(map[string]textparse.MetricType)
+
+	for {
+		entry, err := parser.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		switch entry {
+		case textparse.EntryType:
+			name, typ := parser.Type()
+			types[string(name)] = typ
+			continue
+		case textparse.EntrySeries:
+		default:
+			continue
+		}
+
+		_, ts, value := parser.Series()
+		if math.IsNaN(value) {
+			continue
+		}
+
+		var lbls labels.Labels
+		parser.Metric(&lbls)
+
+		if len(target.conf.MetricRelabelConfigs) > 0 {
+			var keep bool
+			lbls, keep = relabel.Process(lbls, target.conf.MetricRelabelConfigs...)
+			if !keep {
+				continue
+			}
+		}
+
+		metricName := lbls.Get(labels.MetricName)
+		if metricName == "" || strings.HasSuffix(metricName, "_created") {
+			continue
+		}
+
+		isCumCounter, unit := promScrapeMetadata(metricName, types)
+		if target.project.PromCompat {
+			isCumCounter = false
+		}
+
+		unixNano := defaultUnixNano
+		if ts != nil {
+			unixNano = uint64(*ts * int64(time.Millisecond))
+		}
+
+		p.enqueuePromSample(ctx, metricName, isCumCounter, unit,
+			target.attrs(lbls), unixNano, value)
+	}
+}
+
+// promScrapeMetadata uses the metric family type when it is available
+// and falls back to guessing the type using the metric name.
+func promScrapeMetadata(
+	metricName string, types map[string]textparse.MetricType,
+) (isCumCounter bool, unit string) {
+	isCumCounter, unit = _promMetadata(metricName)
+
+	var suffix string
+	typ, ok := types[metricName]
+	if !ok {
+		for _, s := range []string{"_total", "_bucket", "_sum", "_count"} {
+			if name, found := strings.CutSuffix(metricName, s); found {
+				if typ, ok = types[name]; ok {
+					suffix = s
+					break
+				}
+			}
+		}
+	}
+	if !ok {
+		return isCumCounter, unit
+	}
+
+	switch typ {
+	case textparse.MetricTypeCounter:
+		return true, unit
+	case textparse.MetricTypeGauge, textparse.MetricTypeGaugeHistogram:
+		return false, unit
+	case textparse.MetricTypeHistogram:
+		return suffix != "", unit
+	case textparse.MetricTypeSummary:
+		return suffix == "_sum" || suffix == "_count", unit
+	default:
+		return isCumCounter, unit
+	}
+}
+
+//------------------------------------------------------------------------------
+
+type promTarget struct {
+	conf    *bunconf.ScrapeConfig
+	project *org.Project
+	client  *http.Client
+	url     string
+	labels  labels.Labels
+}
+
+func (s *PromScraper) newTargets(
+	ctx context.Context, conf *bunconf.ScrapeConfig,
+) ([]*promTarget, error) {
+	client := http.DefaultClient
+	if conf.TLS != nil {
+		tlsConf, err := conf.TLS.TLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConf
+		client = &http.Client{Transport: transport}
+	}
+
+	var targets []*promTarget
+
+	for _, static := range conf.StaticConfigs {
+		project, err := s.Projects.SelectByID(ctx, static.ProjectID)
+		if err != nil {
+			return nil, fmt.Errorf("can't find project with id=%d: %w", static.ProjectID, err)
+		}
+
+		for _, addr := range static.Targets {
+			lbls, ok := promTargetLabels(conf, static, addr)
+			if !ok {
+				continue
+			}
+
+			targets = append(targets, &promTarget{
+				conf:    conf,
+				project: project,
+				client:  client,
+				url:     promTargetURL(lbls),
+				labels:  promPublicLabels(lbls),
+			})
+		}
+	}
+
+	return targets, nil
+}
+
+// promTargetLabels returns target labels after relabeling just like Prometheus does.
+func promTargetLabels(
+	conf *bunconf.ScrapeConfig, static *bunconf.StaticConfig, addr string,
+) (labels.Labels, bool) {
+	lb := labels.NewBuilder(labels.EmptyLabels())
+	lb.Set("job", conf.JobName)
+	lb.Set("__address__", addr)
+	lb.Set("__scheme__", conf.Scheme)
+	lb.Set("__metrics_path__", conf.MetricsPath)
+	lb.Set("__scrape_interval__", conf.ScrapeInterval.String())
+	lb.Set("__scrape_timeout__", conf.ScrapeTimeout.String())
+	for name, values := range conf.Params {
+		if len(values) > 0 {
+			lb.Set("__param_"+name, values[0])
+		}
+	}
+	for name, value := range static.Labels {
+		lb.Set(name, value)
+	}
+
+	if !relabel.ProcessBuilder(lb, conf.RelabelConfigs...) {
+		return labels.EmptyLabels(), false
+	}
+
+	lbls := lb.Labels()
+	if !lbls.Has("instance") {
+		lb.Set("instance", lbls.Get("__address__"))
+		lbls = lb.Labels()
+	}
+	return lbls, true
+}
+
+func promTargetURL(lbls labels.Labels) string {
+	query := make(url.Values)
+	lbls.Range(func(l labels.Label) {
+		if name, ok := strings.CutPrefix(l.Name, "__param_"); ok {
+			query.Set(name, l.Value)
+		}
+	})
+
+	u := &url.URL{
+		Scheme:   lbls.Get("__scheme__"),
+		Host:     lbls.Get("__address__"),
+		Path:     lbls.Get("__metrics_path__"),
+		RawQuery: query.Encode(),
+	}
+	return u.String()
+}
+
+func promPublicLabels(lbls labels.Labels) labels.Labels {
+	lb := labels.NewBuilder(lbls)
+	lbls.Range(func(l labels.Label) {
+		if strings.HasPrefix(l.Name, "__") {
+			lb.Del(l.Name)
+		}
+	})
+	return lb.Labels()
+}
+
+// attrs merges sample labels with target labels. Conflicting sample labels
+// are renamed to exported_<name> which is the default Prometheus behavior.
+func (t *promTarget) attrs(lbls labels.Labels) AttrMap {
+	attrs := make(AttrMap, lbls.Len()+t.labels.Len())
+	lbls.Range(func(l labels.Label) {
+		if strings.HasPrefix(l.Name, "__") {
+			return
+		}
+		if t.labels.Has(l.Name) {
+			attrs["exported_"+l.Name] = l.Value
+			return
+		}
+		attrs[l.Name] = l.Value
+	})
+	t.labels.Range(func(l labels.Label) {
+		attrs[l.Name] = l.Value
+	})
+	return attrs
+}
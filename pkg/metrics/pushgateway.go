@@ -0,0 +1,408 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/httperror"
+	"github.com/uptrace/uptrace/pkg/org"
+	"github.com/uptrace/uptrace/pkg/run"
+)
+
+const (
+	pushgatewayInterval    = 15 * time.Second
+	pushgatewayMaxBodySize = 32 << 20
+	pushgatewayGroupTTL    = time.Hour
+	pushgatewayMaxGroups   = 10000
+)
+
+type PushgatewayHandlerParams struct {
+	fx.In
+
+	Logger   *otelzap.Logger
+	MP       *DatapointProcessor
+	Projects *org.ProjectGateway
+}
+
+// PushgatewayHandler implements Prometheus Pushgateway API. Pushed groups are kept
+// in memory and periodically reported as datapoints until they are deleted or
+// are not pushed for pushgatewayGroupTTL.
+type PushgatewayHandler struct {
+	*PushgatewayHandlerParams
+
+	mu     sync.Mutex
+	groups map[string]*pushGroup
+}
+
+func NewPushgatewayHandler(p PushgatewayHandlerParams) *PushgatewayHandler {
+	return &PushgatewayHandler{
+		PushgatewayHandlerParams: &p,
+		groups:                   make(map[string]*pushGroup),
+	}
+}
+
+func registerPushgatewayHandler(h *PushgatewayHandler, p bunapp.RouterParams) {
+	p.Router.WithGroup("/api/prometheus/:project_id/metrics", func(g *bunrouter.Group) {
+		g.PUT("/*grouping", h.Put)
+		g.POST("/*grouping", h.Post)
+		g.DELETE("/*grouping", h.Delete)
+	})
+}
+
+func runPushgateway(group *run.Group, h *PushgatewayHandler) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	group.Add("metrics.PushgatewayHandler.Run", func() error {
+		defer close(done)
+		h.run(ctx)
+		return nil
+	})
+	group.OnStop(func(context.Context, error) error {
+		cancel()
+		<-done
+		return nil
+	})
+}
+
+// Put replaces all metrics in the group.
+func (h *PushgatewayHandler) Put(w http.ResponseWriter, req bunrouter.Request) error {
+	return h.push(w, req, true)
+}
+
+// Post replaces metrics with the same names in the group.
+func (h *PushgatewayHandler) Post(w http.ResponseWriter, req bunrouter.Request) error {
+	return h.push(w, req, false)
+}
+
+func (h *PushgatewayHandler) Delete(w http.ResponseWriter, req bunrouter.Request) error {
+	project, err := h.project(req)
+	if err != nil {
+		return err
+	}
+
+	grouping, err := parsePushGrouping(req.Param("grouping"))
+	if err != nil {
+		return httperror.BadRequest("invalid_grouping", err.Error())
+	}
+
+	h.mu.Lock()
+	delete(h.groups, pushGroupKey(project.ID, grouping))
+	h.mu.Unlock()
+
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+func (h *PushgatewayHandler) push(
+	w http.ResponseWriter, req bunrouter.Request, replace bool,
+) error {
+	project, err := h.project(req)
+	if err != nil {
+		return err
+	}
+
+	grouping, err := parsePushGrouping(req.Param("grouping"))
+	if err != nil {
+		return httperror.BadRequest("invalid_grouping", err.Error())
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, pushgatewayMaxBodySize))
+	if err != nil {
+		return err
+	}
+
+	families, err := parsePushedMetrics(body, req.Header.Get("Content-Type"), grouping, project)
+	if err != nil {
+		return httperror.BadRequest("invalid_metrics", err.Error())
+	}
+
+	key := pushGroupKey(project.ID, grouping)
+
+	h.mu.Lock()
+	group, ok := h.groups[key]
+	if !ok && len(h.groups) >= pushgatewayMaxGroups {
+		h.mu.Unlock()
+		return httperror.New(http.StatusTooManyRequests, "too_many_groups",
+			"the number of groups exceeds %d", pushgatewayMaxGroups)
+	}
+	if !ok || replace {
+		group = &pushGroup{
+			project:  project,
+			grouping: grouping,
+			families: make(map[string][]*pushSample),
+		}
+		h.groups[key] = group
+	}
+	for name, samples := range families {
+		group.families[name] = samples
+	}
+	group.pushTime = time.Now()
+	h.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// project authenticates the request using the project token passed as a basic auth
+// password or a DSN and checks that the token belongs to the project in the URL.
+func (h *PushgatewayHandler) project(req bunrouter.Request) (*org.Project, error) {
+	ctx := req.Context()
+
+	projectID, err := req.Params().Uint32("project_id")
+	if err != nil {
+		return nil, err
+	}
+
+	var project *org.Project
+	if _, password, ok := req.BasicAuth(); ok {
+		project, err = h.Projects.SelectByToken(ctx, password)
+	} else {
+		var dsn string
+		dsn, err = org.DSNFromRequest(req)
+		if err != nil {
+			return nil, httperror.Unauthorized(err.Error())
+		}
+		project, err = h.Projects.SelectByDSN(ctx, dsn)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.Unauthorized("can't find project using the provided token")
+		}
+		return nil, err
+	}
+	if project.ID != projectID {
+		return nil, httperror.Forbidden("token does not belong to project %d", projectID)
+	}
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.Int64("project", int64(project.ID)))
+	}
+
+	return project, nil
+}
+
+func (h *PushgatewayHandler) run(ctx context.Context) {
+	ticker := time.NewTicker(pushgatewayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.flush(ctx)
+		}
+	}
+}
+
+func (h *PushgatewayHandler) flush(ctx context.Context) {
+	now := time.Now()
+	unixNano := uint64(now.UnixNano())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for key, group := range h.groups {
+		if now.Sub(group.pushTime) > pushgatewayGroupTTL {
+			delete(h.groups, key)
+			continue
+		}
+
+		p := otlpProcessor{
+			logger:  h.Logger,
+			mp:      h.MP,
+			project: group.project,
+		}
+
+		for _, samples := range group.families {
+			for _, sample := range samples {
+				p.enqueuePromSample(ctx, sample.metricName, sample.isCumCounter, sample.unit,
+					sample.attrs(), unixNano, sample.value)
+			}
+		}
+
+		p.enqueuePromSample(ctx, "push_time_seconds", false, "seconds",
+			group.attrs(), unixNano, float64(group.pushTime.UnixNano())/1e9)
+
+		p.close(ctx)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+type pushGroup struct {
+	project  *org.Project
+	grouping AttrMap
+	families map[string][]*pushSample
+	pushTime time.Time
+}
+
+func (g *pushGroup) attrs() AttrMap {
+	attrs := make(AttrMap, len(g.grouping))
+	attrs.Merge(g.grouping)
+	return attrs
+}
+
+type pushSample struct {
+	metricName   string
+	isCumCounter bool
+	unit         string
+	labels       AttrMap
+	value        float64
+}
+
+// attrs returns a copy, because datapoints are modified during processing.
+func (s *pushSample) attrs() AttrMap {
+	attrs := make(AttrMap, len(s.labels))
+	attrs.Merge(s.labels)
+	return attrs
+}
+
+func pushGroupKey(projectID uint32, grouping AttrMap) string {
+	keys := make([]string, 0, len(grouping))
+	for key := range grouping {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var b strings.Builder
+	fmt.Fprint(&b, projectID)
+	for _, key := range keys {
+		b.WriteByte(0)
+		b.WriteString(key)
+		b.WriteByte(0)
+		b.WriteString(grouping[key])
+	}
+	return b.String()
+}
+
+// parsePushGrouping parses "job/<job>{/<label>/<value>}" where label names
+// can have the "@base64" suffix to pass base64url-encoded values.
+func parsePushGrouping(s string) (AttrMap, error) {
+	parts := strings.Split(strings.Trim(s, "/"), "/")
+	if len(parts)%2 != 0 {
+		return nil, errors.New("grouping labels must be key/value pairs")
+	}
+
+	grouping := make(AttrMap, len(parts)/2)
+	for i := 0; i < len(parts); i += 2 {
+		name, value := parts[i], parts[i+1]
+
+		if before, ok := strings.CutSuffix(name, "@base64"); ok {
+			name = before
+			b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64 value for label %q: %w", name, err)
+			}
+			value = string(b)
+		}
+
+		if name == "" || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label name %q", name)
+		}
+		grouping[name] = value
+	}
+
+	if grouping["job"] == "" {
+		return nil, errors.New("job name is required")
+	}
+	return grouping, nil
+}
+
+// parsePushedMetrics parses the text exposition format and groups samples by metric family.
+func parsePushedMetrics(
+	body []byte, contentType string, grouping AttrMap, project *org.Project,
+) (map[string][]*pushSample, error) {
+	parser, err := textparse.New(body, contentType, false)
+	if err != nil {
+		return nil, err
+	}
+
+	families := make(map[string][]*pushSample)
+	types := make(map[string]textparse.MetricType)
+	var family string
+
+	for {
+		entry, err := parser.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return families, nil
+			}
+			return nil, err
+		}
+
+		switch entry {
+		case textparse.EntryType:
+			name, typ := parser.Type()
+			family = string(name)
+			types[family] = typ
+			continue
+		case textparse.EntryHelp:
+			name, _ := parser.Help()
+			family = string(name)
+			continue
+		case textparse.EntrySeries:
+		default:
+			continue
+		}
+
+		_, _, value := parser.Series()
+		if math.IsNaN(value) {
+			continue
+		}
+
+		var lbls labels.Labels
+		parser.Metric(&lbls)
+
+		metricName := lbls.Get(labels.MetricName)
+		if metricName == "" || strings.HasSuffix(metricName, "_created") {
+			continue
+		}
+
+		name := family
+		if metricName != family && !strings.HasPrefix(metricName, family+"_") {
+			name = metricName
+		}
+
+		isCumCounter, unit := promScrapeMetadata(metricName, types)
+		if project.PromCompat {
+			isCumCounter = false
+		}
+
+		sample := &pushSample{
+			metricName:   metricName,
+			isCumCounter: isCumCounter,
+			unit:         unit,
+			labels:       make(AttrMap, lbls.Len()+len(grouping)),
+			value:        value,
+		}
+		lbls.Range(func(l labels.Label) {
+			if !strings.HasPrefix(l.Name, "__") {
+				sample.labels[l.Name] = l.Value
+			}
+		})
+		sample.labels.Merge(grouping)
+
+		families[name] = append(families[name], sample)
+	}
+}
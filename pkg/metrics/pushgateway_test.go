@@ -0,0 +1,19 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushgatewayEvictsStaleGroups(t *testing.T) {
+	h := NewPushgatewayHandler(PushgatewayHandlerParams{})
+	h.groups["stale"] = &pushGroup{
+		pushTime: time.Now().Add(-pushgatewayGroupTTL - time.Minute),
+	}
+
+	h.flush(context.Background())
+	require.Empty(t, h.groups)
+}
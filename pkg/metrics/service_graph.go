@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/vmihailenco/taskq/v4"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/pkg/clickhouse/ch"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/bunconv"
+	"github.com/uptrace/uptrace/pkg/run"
+)
+
+const serviceGraphMetricsInterval = time.Minute
+
+type ServiceGraphMetricsParams struct {
+	fx.In
+
+	Logger    *otelzap.Logger
+	Conf      *bunconf.Config
+	PG        *bun.DB
+	CH        *ch.DB
+	MainQueue taskq.Queue
+}
+
+// runServiceGraphMetrics registers the uptrace_service_graph_* metrics for projects
+// with recent service graph edges. The datapoints are created by the materialized views.
+func runServiceGraphMetrics(group *run.Group, p ServiceGraphMetricsParams) {
+	if p.Conf.ServiceGraph.Disabled {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	group.Add("metrics.ServiceGraphMetrics.Run", func() error {
+		ticker := time.NewTicker(serviceGraphMetricsInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				if err := upsertServiceGraphMetrics(ctx, &p); err != nil {
+					p.Logger.Error("upsertServiceGraphMetrics failed", zap.Error(err))
+				}
+			}
+		}
+	})
+	group.OnStop(func(context.Context, error) error {
+		cancel()
+		return nil
+	})
+}
+
+func upsertServiceGraphMetrics(ctx context.Context, p *ServiceGraphMetricsParams) error {
+	var projectIDs []uint32
+
+	if err := p.CH.NewSelect().
+		TableExpr("service_graph_edges AS e").
+		ColumnExpr("DISTINCT e.project_id").
+		Where("e.time >= ?", time.Now().Add(-2*serviceGraphMetricsInterval)).
+		Scan(ctx, &projectIDs); err != nil {
+		return err
+	}
+	if len(projectIDs) == 0 {
+		return nil
+	}
+
+	attrKeys := []string{"type", "client", "server", "deployment_environment", "service_namespace"}
+	metrics := make([]Metric, 0, 3*len(projectIDs))
+
+	for _, projectID := range projectIDs {
+		metrics = append(metrics,
+			Metric{
+				ProjectID:       projectID,
+				Name:            uptraceServiceGraphClientDuration,
+				Description:     "Duration of requests between services measured by the client",
+				Instrument:      InstrumentHistogram,
+				Unit:            bunconv.UnitNanoseconds,
+				AttrKeys:        attrKeys,
+				OtelLibraryName: uptraceLibraryName,
+			},
+			Metric{
+				ProjectID:       projectID,
+				Name:            uptraceServiceGraphServerDuration,
+				Description:     "Duration of requests between services measured by the server",
+				Instrument:      InstrumentHistogram,
+				Unit:            bunconv.UnitNanoseconds,
+				AttrKeys:        attrKeys,
+				OtelLibraryName: uptraceLibraryName,
+			},
+			Metric{
+				ProjectID:       projectID,
+				Name:            uptraceServiceGraphFailedRequests,
+				Description:     "Number of failed requests between services",
+				Instrument:      InstrumentCounter,
+				AttrKeys:        attrKeys,
+				OtelLibraryName: uptraceLibraryName,
+			},
+		)
+	}
+
+	return UpsertMetrics(ctx, p.Logger, p.PG, p.MainQueue, metrics)
+}
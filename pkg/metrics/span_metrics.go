@@ -0,0 +1,415 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/fx"
+
+	"github.com/uptrace/pkg/clickhouse/bfloat16"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/bunconv"
+	"github.com/uptrace/uptrace/pkg/bunotel"
+	"github.com/uptrace/uptrace/pkg/run"
+	"github.com/uptrace/uptrace/pkg/tracing"
+	"github.com/uptrace/uptrace/pkg/tracing/tql"
+)
+
+const (
+	spanMetricsFlushInterval = 10 * time.Second
+	// Spans from the future are aggregated into the current minute so the buckets
+	// don't stay in memory until that minute is over.
+	spanMetricsMaxFuture = time.Minute
+	// Limits the number of attribute sets kept in memory between flushes.
+	spanMetricsMaxBuckets = 100_000
+)
+
+type SpanMetricsProcessorParams struct {
+	fx.In
+
+	Conf *bunconf.Config
+	MP   *DatapointProcessor
+}
+
+// SpanMetricsProcessor creates metrics from spans using the metrics_from_spans option.
+// Values are aggregated by attributes and minute and sent to the datapoint processor
+// once the minute is over.
+type SpanMetricsProcessor struct {
+	mp    *DatapointProcessor
+	rules []*spanMetricRule
+
+	mu      sync.Mutex
+	buckets map[spanMetricKey]*spanMetricBucket
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type spanMetricRule struct {
+	*bunconf.SpanMetric
+
+	instrument  Instrument
+	value       *tql.NumExpr
+	attrs       []spanMetricAttr
+	annotations []spanMetricAttr
+	matcher     *tql.Matcher
+}
+
+type spanMetricAttr struct {
+	key   string
+	alias string
+}
+
+type spanMetricKey struct {
+	projectID uint32
+	rule      int
+	time      time.Time
+	attrs     string
+}
+
+type spanMetricBucket struct {
+	attrs       AttrMap
+	annotations AttrMap
+
+	sum   float64
+	count uint64
+	min   float64
+	max   float64
+	last  float64
+	hist  map[bfloat16.T]uint64
+}
+
+// NewSpanMetricsProcessor returns nil when metrics_from_spans is empty.
+func NewSpanMetricsProcessor(p SpanMetricsProcessorParams) (*SpanMetricsProcessor, error) {
+	if len(p.Conf.MetricsFromSpans) == 0 {
+		return nil, nil
+	}
+
+	rules := make([]*spanMetricRule, len(p.Conf.MetricsFromSpans))
+	for i := range p.Conf.MetricsFromSpans {
+		metric := &p.Conf.MetricsFromSpans[i]
+		rule, err := newSpanMetricRule(metric)
+		if err != nil {
+			return nil, fmt.Errorf("metrics_from_spans: metric %q: %w", metric.Name, err)
+		}
+		rules[i] = rule
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SpanMetricsProcessor{
+		mp:      p.MP,
+		rules:   rules,
+		buckets: make(map[spanMetricKey]*spanMetricBucket),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}, nil
+}
+
+func newSpanMetricRule(metric *bunconf.SpanMetric) (*spanMetricRule, error) {
+	if metric.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	rule := &spanMetricRule{
+		SpanMetric: metric,
+		instrument: Instrument(metric.Instrument),
+	}
+
+	valueExpr := metric.Value
+	switch rule.instrument {
+	case InstrumentCounter:
+		if valueExpr == "" {
+			// Count spans by default.
+			valueExpr = "1"
+		}
+	case InstrumentGauge, InstrumentAdditive, InstrumentHistogram:
+		if valueExpr == "" {
+			return nil, fmt.Errorf("value is required for %s", metric.Instrument)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported instrument: %q", metric.Instrument)
+	}
+
+	value, err := tql.ParseNumExpr(valueExpr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value: %w", err)
+	}
+	rule.value = value
+
+	rule.attrs, err = parseSpanMetricAttrs(metric.Attrs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attrs: %w", err)
+	}
+	rule.annotations, err = parseSpanMetricAttrs(metric.Annotations)
+	if err != nil {
+		return nil, fmt.Errorf("invalid annotations: %w", err)
+	}
+
+	if metric.Where != "" {
+		matcher, err := tql.ParseMatcher(metric.Where)
+		if err != nil {
+			return nil, fmt.Errorf("invalid where: %w", err)
+		}
+		rule.matcher = matcher
+	}
+
+	return rule, nil
+}
+
+// parseSpanMetricAttrs parses attributes with optional aliases, for example,
+// `service_name as service`.
+func parseSpanMetricAttrs(ss []string) ([]spanMetricAttr, error) {
+	attrs := make([]spanMetricAttr, len(ss))
+	for i, s := range ss {
+		col, err := tql.ParseColumn(s)
+		if err != nil {
+			return nil, err
+		}
+
+		attr, ok := col.Value.(tql.Attr)
+		if !ok {
+			return nil, fmt.Errorf("%q is not an attribute", s)
+		}
+
+		key := attrkey.Clean(attr.Name)
+		alias := col.Alias
+		if alias == "" {
+			alias = strings.TrimPrefix(key, "_")
+		}
+		attrs[i] = spanMetricAttr{key: key, alias: alias}
+	}
+	return attrs, nil
+}
+
+func provideSpanProcessor(p *SpanMetricsProcessor) tracing.SpanProcessor {
+	if p == nil {
+		return nil
+	}
+	return p
+}
+
+func runSpanMetricsProcessor(group *run.Group, p *SpanMetricsProcessor) {
+	if p == nil {
+		return
+	}
+
+	group.Add("metrics.SpanMetricsProcessor.Run", func() error {
+		p.Run()
+		return nil
+	})
+	group.OnStop(func(context.Context, error) error {
+		p.Stop()
+		return nil
+	})
+}
+
+// Run flushes the buckets until Stop is called. Stop may be called before Run,
+// in which case Run does the final flush and returns.
+func (p *SpanMetricsProcessor) Run() {
+	defer close(p.done)
+	ctx := p.ctx
+
+	ticker := time.NewTicker(spanMetricsFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			p.flush(ctx, now.Truncate(time.Minute))
+		case <-ctx.Done():
+			p.flush(context.Background(), time.Time{})
+			return
+		}
+	}
+}
+
+func (p *SpanMetricsProcessor) Stop() {
+	p.cancel()
+	<-p.done
+}
+
+var _ tracing.SpanProcessor = (*SpanMetricsProcessor)(nil)
+
+func (p *SpanMetricsProcessor) ProcessSpan(ctx context.Context, span *tracing.Span) {
+	p.processSpan(ctx, span, time.Now())
+}
+
+func (p *SpanMetricsProcessor) processSpan(ctx context.Context, span *tracing.Span, now time.Time) {
+	tm := span.Time
+	if tm.After(now.Add(spanMetricsMaxFuture)) {
+		tm = now
+	}
+	tm = tm.Truncate(time.Minute)
+
+	// The number of spans the span represents when the trace is sampled.
+	count := uint64(max(math.Round(span.SampleCount()), 1))
+
+	for i, rule := range p.rules {
+		if rule.matcher != nil && !rule.matcher.Match(span.Attr) {
+			continue
+		}
+
+		value, ok := rule.value.Eval(span.Attr)
+		if !ok || math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+
+		attrs := make(AttrMap, len(rule.attrs))
+		var b strings.Builder
+		for _, attr := range rule.attrs {
+			s := spanAttrString(span, attr.key)
+			if s != "" {
+				attrs[attr.alias] = s
+			}
+			b.WriteString(s)
+			b.WriteByte(0)
+		}
+
+		key := spanMetricKey{
+			projectID: span.ProjectID,
+			rule:      i,
+			time:      tm,
+			attrs:     b.String(),
+		}
+
+		p.mu.Lock()
+
+		bucket, ok := p.buckets[key]
+		if !ok {
+			if len(p.buckets) >= spanMetricsMaxBuckets {
+				p.mu.Unlock()
+				datapointCounter.Add(
+					ctx,
+					1,
+					metric.WithAttributes(
+						bunotel.ProjectIDAttr(span.ProjectID),
+						attribute.String("type", "dropped"),
+					),
+				)
+				continue
+			}
+			bucket = &spanMetricBucket{
+				attrs: attrs,
+				min:   value,
+				max:   value,
+			}
+			if len(rule.annotations) > 0 {
+				bucket.annotations = make(AttrMap, len(rule.annotations))
+			}
+			p.buckets[key] = bucket
+		}
+		bucket.observe(rule.instrument, value, count)
+
+		for _, attr := range rule.annotations {
+			if s := spanAttrString(span, attr.key); s != "" {
+				bucket.annotations[attr.alias] = s
+			}
+		}
+
+		p.mu.Unlock()
+	}
+}
+
+// observe records the value count times, i.e. once for each span
+// that the sampled span represents.
+func (b *spanMetricBucket) observe(instrument Instrument, value float64, count uint64) {
+	b.sum += value * float64(count)
+	b.count += count
+	b.min = min(b.min, value)
+	b.max = max(b.max, value)
+	b.last = value
+
+	if instrument == InstrumentHistogram {
+		if b.hist == nil {
+			b.hist = make(map[bfloat16.T]uint64)
+		}
+		b.hist[bfloat16.From(value)] += count
+	}
+}
+
+// flush sends buckets for minutes before the given time or all buckets if the time is zero.
+func (p *SpanMetricsProcessor) flush(ctx context.Context, before time.Time) {
+	for _, dp := range p.datapoints(before) {
+		p.mp.AddDatapoint(ctx, dp)
+	}
+}
+
+// datapoints removes buckets for minutes before the given time or all buckets
+// if the time is zero and returns them as datapoints.
+func (p *SpanMetricsProcessor) datapoints(before time.Time) []*Datapoint {
+	var datapoints []*Datapoint
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, bucket := range p.buckets {
+		if !before.IsZero() && !key.time.Before(before) {
+			continue
+		}
+		delete(p.buckets, key)
+		datapoints = append(datapoints, p.rules[key.rule].datapoint(key, bucket))
+	}
+	return datapoints
+}
+
+func (r *spanMetricRule) datapoint(key spanMetricKey, bucket *spanMetricBucket) *Datapoint {
+	dp := &Datapoint{
+		ProjectID:   key.projectID,
+		Metric:      r.Name,
+		Description: r.Description,
+		Unit:        bunconv.NormUnit(r.Unit),
+		Instrument:  r.instrument,
+		Time:        key.time,
+		Attrs:       bucket.attrs,
+	}
+
+	switch r.instrument {
+	case InstrumentCounter:
+		dp.Sum = bucket.sum
+	case InstrumentGauge:
+		dp.Gauge = bucket.last
+	case InstrumentAdditive:
+		dp.Gauge = bucket.sum
+	case InstrumentHistogram:
+		dp.Min = bucket.min
+		dp.Max = bucket.max
+		dp.Sum = bucket.sum
+		dp.Count = bucket.count
+		dp.Histogram = bucket.hist
+	}
+
+	if len(bucket.annotations) > 0 {
+		if b, err := json.Marshal(bucket.annotations); err == nil {
+			dp.Annotations = string(b)
+		}
+	}
+
+	return dp
+}
+
+func spanAttrString(span *tracing.Span, key string) string {
+	value, ok := span.Attr(key)
+	if !ok {
+		return ""
+	}
+
+	switch value := value.(type) {
+	case string:
+		return value
+	case time.Duration:
+		return strconv.FormatInt(int64(value), 10)
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,282 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uptrace/pkg/clickhouse/bfloat16"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/tracing"
+)
+
+func TestNewSpanMetricRule(t *testing.T) {
+	type Test struct {
+		name    string
+		metric  bunconf.SpanMetric
+		wantErr bool
+	}
+
+	tests := []Test{
+		{
+			name:   "counter counts spans by default",
+			metric: bunconf.SpanMetric{Name: "spans", Instrument: "counter"},
+		},
+		{
+			name: "histogram",
+			metric: bunconf.SpanMetric{
+				Name:        "duration",
+				Instrument:  "histogram",
+				Value:       "_duration / 1000",
+				Attrs:       []string{"_system as system", "service_name"},
+				Annotations: []string{"service_version"},
+				Where:       `_kind = "server"`,
+			},
+		},
+		{name: "gauge", metric: bunconf.SpanMetric{Name: "g", Instrument: "gauge", Value: "queue_size"}},
+		{name: "additive", metric: bunconf.SpanMetric{Name: "a", Instrument: "additive", Value: "1"}},
+		{name: "no name", metric: bunconf.SpanMetric{Instrument: "counter"}, wantErr: true},
+		{name: "no instrument", metric: bunconf.SpanMetric{Name: "x"}, wantErr: true},
+		{name: "unknown instrument", metric: bunconf.SpanMetric{Name: "x", Instrument: "summary"}, wantErr: true},
+		{name: "gauge without value", metric: bunconf.SpanMetric{Name: "x", Instrument: "gauge"}, wantErr: true},
+		{name: "histogram without value", metric: bunconf.SpanMetric{Name: "x", Instrument: "histogram"}, wantErr: true},
+		{
+			name:    "invalid value",
+			metric:  bunconf.SpanMetric{Name: "x", Instrument: "histogram", Value: "_duration /"},
+			wantErr: true,
+		},
+		{
+			name:    "function value",
+			metric:  bunconf.SpanMetric{Name: "x", Instrument: "histogram", Value: "max(_duration)"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid attr",
+			metric:  bunconf.SpanMetric{Name: "x", Instrument: "counter", Attrs: []string{"count()"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid annotation",
+			metric:  bunconf.SpanMetric{Name: "x", Instrument: "counter", Annotations: []string{"1 +"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid where",
+			metric:  bunconf.SpanMetric{Name: "x", Instrument: "counter", Where: `_kind =`},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := newSpanMetricRule(&test.metric)
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, rule.value)
+		})
+	}
+}
+
+func TestParseSpanMetricAttrs(t *testing.T) {
+	attrs, err := parseSpanMetricAttrs([]string{"_system as system", "service.name", "_status_code"})
+	require.NoError(t, err)
+	require.Equal(t, []spanMetricAttr{
+		{key: "_system", alias: "system"},
+		{key: "service_name", alias: "service_name"},
+		{key: "_status_code", alias: "status_code"},
+	}, attrs)
+}
+
+func TestSpanMetricsProcessor(t *testing.T) {
+	ctx := context.Background()
+	minute := time.Unix(1700000040, 0).Truncate(time.Minute)
+	now := minute.Add(10 * time.Minute)
+
+	newProcessor := func(t *testing.T, metric bunconf.SpanMetric) *SpanMetricsProcessor {
+		rule, err := newSpanMetricRule(&metric)
+		require.NoError(t, err)
+		return &SpanMetricsProcessor{
+			rules:   []*spanMetricRule{rule},
+			buckets: make(map[spanMetricKey]*spanMetricBucket),
+		}
+	}
+	newSpan := func(tm time.Time, service string, dur time.Duration) *tracing.Span {
+		return &tracing.Span{
+			ProjectID: 1,
+			Time:      tm,
+			Duration:  dur,
+			Kind:      tracing.ServerSpanKind,
+			Attrs:     tracing.AttrMap{"service_name": service, "service_version": "v1"},
+		}
+	}
+	observe := func(p *SpanMetricsProcessor) {
+		p.processSpan(ctx, newSpan(minute, "a", 1*time.Millisecond), now)
+		p.processSpan(ctx, newSpan(minute.Add(30*time.Second), "a", 3*time.Millisecond), now)
+		p.processSpan(ctx, newSpan(minute.Add(20*time.Second), "b", 5*time.Millisecond), now)
+		// The next minute.
+		p.processSpan(ctx, newSpan(minute.Add(time.Minute), "a", 7*time.Millisecond), now)
+	}
+	byService := func(datapoints []*Datapoint) map[string]*Datapoint {
+		m := make(map[string]*Datapoint, len(datapoints))
+		for _, dp := range datapoints {
+			m[dp.Attrs["service"]] = dp
+		}
+		return m
+	}
+	attrs := []string{"service_name as service"}
+
+	type Test struct {
+		metric bunconf.SpanMetric
+		check  func(t *testing.T, dp *Datapoint)
+	}
+
+	tests := []Test{
+		{
+			metric: bunconf.SpanMetric{Name: "spans", Instrument: "counter", Attrs: attrs},
+			check: func(t *testing.T, dp *Datapoint) {
+				require.Equal(t, 2.0, dp.Sum)
+			},
+		},
+		{
+			metric: bunconf.SpanMetric{
+				Name: "last_duration", Instrument: "gauge", Value: "_duration / 1000000", Attrs: attrs,
+			},
+			check: func(t *testing.T, dp *Datapoint) {
+				require.Equal(t, 3.0, dp.Gauge)
+			},
+		},
+		{
+			metric: bunconf.SpanMetric{
+				Name: "total_duration", Instrument: "additive", Value: "_duration / 1000000", Attrs: attrs,
+			},
+			check: func(t *testing.T, dp *Datapoint) {
+				require.Equal(t, 4.0, dp.Gauge)
+			},
+		},
+		{
+			metric: bunconf.SpanMetric{
+				Name: "duration", Instrument: "histogram", Value: "_duration / 1000000", Attrs: attrs,
+				Annotations: []string{"service_version"},
+			},
+			check: func(t *testing.T, dp *Datapoint) {
+				require.Equal(t, 1.0, dp.Min)
+				require.Equal(t, 3.0, dp.Max)
+				require.Equal(t, 4.0, dp.Sum)
+				require.Equal(t, uint64(2), dp.Count)
+				require.Equal(t, map[bfloat16.T]uint64{
+					bfloat16.From(1): 1,
+					bfloat16.From(3): 1,
+				}, dp.Histogram)
+				require.Equal(t, `{"service_version":"v1"}`, dp.Annotations)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.metric.Instrument, func(t *testing.T) {
+			p := newProcessor(t, test.metric)
+			observe(p)
+
+			// Only the first minute is flushed.
+			datapoints := byService(p.datapoints(minute.Add(time.Minute)))
+			require.Len(t, datapoints, 2)
+			require.Contains(t, datapoints, "b")
+
+			dp := datapoints["a"]
+			require.Equal(t, uint32(1), dp.ProjectID)
+			require.Equal(t, test.metric.Name, dp.Metric)
+			require.Equal(t, Instrument(test.metric.Instrument), dp.Instrument)
+			require.Equal(t, minute, dp.Time)
+			test.check(t, dp)
+
+			require.Empty(t, p.datapoints(minute.Add(time.Minute)))
+
+			// A zero time flushes everything.
+			datapoints = byService(p.datapoints(time.Time{}))
+			require.Len(t, datapoints, 1)
+			require.Equal(t, minute.Add(time.Minute), datapoints["a"].Time)
+			require.Empty(t, p.buckets)
+		})
+	}
+
+	t.Run("where", func(t *testing.T) {
+		p := newProcessor(t, bunconf.SpanMetric{
+			Name: "spans", Instrument: "counter", Where: `service_name = "b"`,
+		})
+		observe(p)
+
+		datapoints := p.datapoints(time.Time{})
+		require.Len(t, datapoints, 1)
+		require.Equal(t, 1.0, datapoints[0].Sum)
+	})
+
+	t.Run("missing value", func(t *testing.T) {
+		p := newProcessor(t, bunconf.SpanMetric{Name: "x", Instrument: "gauge", Value: "queue_size"})
+		observe(p)
+		require.Empty(t, p.datapoints(time.Time{}))
+	})
+
+	t.Run("far-future span", func(t *testing.T) {
+		p := newProcessor(t, bunconf.SpanMetric{Name: "spans", Instrument: "counter"})
+		p.processSpan(ctx, newSpan(now.Add(24*time.Hour), "a", time.Millisecond), now)
+
+		datapoints := p.datapoints(now.Truncate(time.Minute).Add(time.Minute))
+		require.Len(t, datapoints, 1)
+		require.Equal(t, now.Truncate(time.Minute), datapoints[0].Time)
+	})
+
+	t.Run("max buckets", func(t *testing.T) {
+		p := newProcessor(t, bunconf.SpanMetric{
+			Name: "spans", Instrument: "counter", Attrs: []string{"service_name"},
+		})
+		for i := 0; len(p.buckets) < spanMetricsMaxBuckets; i++ {
+			p.buckets[spanMetricKey{attrs: strconv.Itoa(i)}] = new(spanMetricBucket)
+		}
+
+		p.processSpan(ctx, newSpan(minute, "a", time.Millisecond), now)
+		require.Len(t, p.buckets, spanMetricsMaxBuckets)
+	})
+}
+
+func TestSpanMetricBucketSampling(t *testing.T) {
+	b := &spanMetricBucket{min: 2, max: 2}
+	b.observe(InstrumentHistogram, 2, 4)
+	b.observe(InstrumentHistogram, 6, 1)
+
+	require.Equal(t, 14.0, b.sum)
+	require.Equal(t, uint64(5), b.count)
+	require.Equal(t, 2.0, b.min)
+	require.Equal(t, 6.0, b.max)
+	require.Equal(t, map[bfloat16.T]uint64{
+		bfloat16.From(2): 4,
+		bfloat16.From(6): 1,
+	}, b.hist)
+}
+
+func TestSpanMetricsProcessorStopBeforeRun(t *testing.T) {
+	p, err := NewSpanMetricsProcessor(SpanMetricsProcessorParams{
+		Conf: &bunconf.Config{
+			MetricsFromSpans: []bunconf.SpanMetric{{Name: "spans", Instrument: "counter"}},
+		},
+	})
+	require.NoError(t, err)
+
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+
+	p.Run()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return after Run")
+	}
+}
@@ -0,0 +1,417 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/pkg/clickhouse/bfloat16"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/org"
+	"github.com/uptrace/uptrace/pkg/run"
+)
+
+const (
+	statsdLibraryName = "statsd"
+
+	// statsdGaugeMaxIdle is the number of flushes after which a gauge that is not
+	// updated is forgotten, i.e. relative updates start again from zero.
+	statsdGaugeMaxIdle = 10
+)
+
+type StatsDServerParams struct {
+	fx.In
+
+	Logger   *otelzap.Logger
+	Conf     *bunconf.Config
+	MP       *DatapointProcessor
+	Projects *org.ProjectGateway
+}
+
+// StatsDServer receives StatsD and DogStatsD metrics over UDP and aggregates them
+// over the flush interval.
+type StatsDServer struct {
+	*StatsDServerParams
+
+	agg *statsdAggregator
+}
+
+func NewStatsDServer(p StatsDServerParams) *StatsDServer {
+	return &StatsDServer{
+		StatsDServerParams: &p,
+		agg:                newStatsDAggregator(),
+	}
+}
+
+func runStatsDServer(group *run.Group, s *StatsDServer) error {
+	conf := s.Conf.Listen.StatsD
+	if conf == nil {
+		return nil
+	}
+
+	project, err := s.Projects.SelectByID(context.Background(), conf.ProjectID)
+	if err != nil {
+		return fmt.Errorf("listen.statsd: can't find project with id=%d: %w", conf.ProjectID, err)
+	}
+
+	conn, err := net.ListenPacket("udp", conf.Addr)
+	if err != nil {
+		s.Logger.Error("net.ListenPacket failed (edit listen.statsd YAML option)",
+			zap.Error(err), zap.String("addr", conf.Addr))
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	flushDone := make(chan struct{})
+
+	group.Add("metrics.StatsDServer.Serve", func() error {
+		return s.serve(conn)
+	})
+	group.Add("metrics.StatsDServer.Flush", func() error {
+		defer close(flushDone)
+		s.flushLoop(ctx, project, conf.FlushInterval)
+		return nil
+	})
+	group.OnStop(func(context.Context, error) error {
+		_ = conn.Close()
+		cancel()
+		<-flushDone
+		return nil
+	})
+
+	return nil
+}
+
+func (s *StatsDServer) serve(conn net.PacketConn) error {
+	buf := make([]byte, 64<<10)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+
+			sample, err := parseStatsDLine(line)
+			if err != nil {
+				s.Logger.Error("can't parse statsd line", zap.Error(err), zap.String("line", line))
+				continue
+			}
+			if sample != nil {
+				s.agg.add(sample)
+			}
+		}
+	}
+}
+
+func (s *StatsDServer) flushLoop(ctx context.Context, project *org.Project, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.flush(context.Background(), project)
+			return
+		case <-ticker.C:
+			s.flush(ctx, project)
+		}
+	}
+}
+
+func (s *StatsDServer) flush(ctx context.Context, project *org.Project) {
+	p := otlpProcessor{
+		logger:  s.Logger,
+		mp:      s.MP,
+		project: project,
+	}
+	defer p.close(ctx)
+
+	unixNano := uint64(time.Now().UnixNano())
+	for _, m := range s.agg.flush() {
+		dp := m.datapoint(&p, unixNano)
+		dp.OtelLibraryName = statsdLibraryName
+		p.enqueue(ctx, dp)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+type statsdSample struct {
+	Name   string
+	Type   string
+	Values []string
+	Rate   float64
+	Attrs  AttrMap
+}
+
+// parseStatsDLine parses lines like "name:value|type|@rate|#tag1:value1,tag2".
+// DogStatsD events and service checks are ignored.
+func parseStatsDLine(line string) (*statsdSample, error) {
+	if strings.HasPrefix(line, "_e{") || strings.HasPrefix(line, "_sc|") {
+		return nil, nil
+	}
+
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return nil, errors.New("statsd: metric name is missing")
+	}
+
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 {
+		return nil, errors.New("statsd: metric type is missing")
+	}
+
+	sample := &statsdSample{
+		Name:   attrkey.Clean(name),
+		Type:   fields[1],
+		Values: strings.Split(fields[0], ":"),
+		Rate:   1,
+	}
+
+	switch sample.Type {
+	case "c", "g", "ms", "h", "d", "s":
+	default:
+		return nil, fmt.Errorf("statsd: unsupported metric type %q", sample.Type)
+	}
+
+	for _, field := range fields[2:] {
+		switch {
+		case strings.HasPrefix(field, "@"):
+			rate, err := strconv.ParseFloat(field[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return nil, fmt.Errorf("statsd: invalid sample rate %q", field)
+			}
+			sample.Rate = rate
+		case strings.HasPrefix(field, "#"):
+			sample.Attrs = parseStatsDTags(field[1:], sample.Attrs)
+		case strings.HasPrefix(field, "c:"):
+			if sample.Attrs == nil {
+				sample.Attrs = make(AttrMap)
+			}
+			sample.Attrs["container_id"] = field[2:]
+		}
+	}
+
+	return sample, nil
+}
+
+func parseStatsDTags(s string, attrs AttrMap) AttrMap {
+	if attrs == nil {
+		attrs = make(AttrMap)
+	}
+	for _, tag := range strings.Split(s, ",") {
+		key, value, _ := strings.Cut(tag, ":")
+		if key = attrkey.Clean(key); key != "" {
+			attrs[key] = value
+		}
+	}
+	return attrs
+}
+
+//------------------------------------------------------------------------------
+
+type statsdAggregator struct {
+	mu      sync.Mutex
+	metrics map[string]*statsdMetric
+
+	// gauges keep the last value so relative updates like "+1" work across flushes.
+	gauges map[string]*statsdGauge
+}
+
+type statsdGauge struct {
+	value float64
+	idle  int // number of flushes without updates
+}
+
+func newStatsDAggregator() *statsdAggregator {
+	return &statsdAggregator{
+		metrics: make(map[string]*statsdMetric),
+		gauges:  make(map[string]*statsdGauge),
+	}
+}
+
+func (a *statsdAggregator) add(sample *statsdSample) {
+	key := statsdKey(sample)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	m, ok := a.metrics[key]
+	if !ok {
+		m = &statsdMetric{
+			name:  sample.Name,
+			typ:   sample.Type,
+			attrs: sample.Attrs,
+		}
+		a.metrics[key] = m
+	}
+
+	for _, s := range sample.Values {
+		switch sample.Type {
+		case "s":
+			if m.set == nil {
+				m.set = make(map[string]struct{})
+			}
+			m.set[s] = struct{}{}
+			m.numValue++
+			continue
+		case "g":
+			value, err := strconv.ParseFloat(s, 64)
+			if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+
+			gauge, ok := a.gauges[key]
+			if !ok {
+				gauge = new(statsdGauge)
+				a.gauges[key] = gauge
+			}
+			// Only "+" is a relative update, because DogStatsD treats "-5" as an absolute value.
+			if s[0] == '+' {
+				value += gauge.value
+			}
+			gauge.value = value
+			gauge.idle = 0
+
+			m.gauge = value
+			m.numValue++
+			continue
+		}
+
+		value, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			continue
+		}
+
+		switch sample.Type {
+		case "c":
+			m.sum += value / sample.Rate
+		default:
+			m.observe(value, 1/sample.Rate)
+		}
+		m.numValue++
+	}
+}
+
+func (a *statsdAggregator) flush() []*statsdMetric {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	metrics := make([]*statsdMetric, 0, len(a.metrics))
+	for _, m := range a.metrics {
+		if m.numValue > 0 {
+			metrics = append(metrics, m)
+		}
+	}
+	clear(a.metrics)
+
+	for key, gauge := range a.gauges {
+		gauge.idle++
+		if gauge.idle > statsdGaugeMaxIdle {
+			delete(a.gauges, key)
+		}
+	}
+
+	return metrics
+}
+
+func statsdKey(sample *statsdSample) string {
+	keys := make([]string, 0, len(sample.Attrs))
+	for key := range sample.Attrs {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var b strings.Builder
+	b.WriteString(sample.Name)
+	b.WriteByte('|')
+	b.WriteString(sample.Type)
+	for _, key := range keys {
+		b.WriteByte('|')
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(sample.Attrs[key])
+	}
+	return b.String()
+}
+
+type statsdMetric struct {
+	name  string
+	typ   string
+	attrs AttrMap
+
+	sum   float64
+	count float64
+	min   float64
+	max   float64
+	hist  map[bfloat16.T]uint64
+	gauge float64
+	set   map[string]struct{}
+
+	numValue int
+}
+
+func (m *statsdMetric) observe(value, weight float64) {
+	if m.hist == nil {
+		m.hist = make(map[bfloat16.T]uint64)
+		m.min = value
+		m.max = value
+	}
+
+	m.sum += value * weight
+	m.count += weight
+	m.hist[bfloat16.From(value)] += uint64(math.Round(weight))
+	m.min = min(m.min, value)
+	m.max = max(m.max, value)
+}
+
+func (m *statsdMetric) datapoint(p *otlpProcessor, unixNano uint64) *Datapoint {
+	attrs := make(AttrMap, len(m.attrs))
+	attrs.Merge(m.attrs)
+
+	switch m.typ {
+	case "c":
+		dp := p.newDatapoint(m.name, InstrumentCounter, attrs, unixNano)
+		dp.Sum = m.sum
+		return dp
+	case "g":
+		dp := p.newDatapoint(m.name, InstrumentGauge, attrs, unixNano)
+		dp.Gauge = m.gauge
+		return dp
+	case "s":
+		dp := p.newDatapoint(m.name, InstrumentGauge, attrs, unixNano)
+		dp.Gauge = float64(len(m.set))
+		return dp
+	default:
+		dp := p.newDatapoint(m.name, InstrumentHistogram, attrs, unixNano)
+		if m.typ == "ms" {
+			dp.Unit = "milliseconds"
+		}
+		dp.Sum = m.sum
+		dp.Count = uint64(math.Round(m.count))
+		dp.Min = m.min
+		dp.Max = m.max
+		dp.Histogram = m.hist
+		return dp
+	}
+}
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatsDLine(t *testing.T) {
+	type Test struct {
+		line    string
+		sample  *statsdSample
+		wantErr bool
+	}
+
+	tests := []Test{
+		{
+			line:   "page.views:1|c",
+			sample: &statsdSample{Name: "page_views", Type: "c", Values: []string{"1"}, Rate: 1},
+		},
+		{
+			line: "req.latency:320|ms|@0.1|#env:prod,region:eu|c:abc123",
+			sample: &statsdSample{
+				Name:   "req_latency",
+				Type:   "ms",
+				Values: []string{"320"},
+				Rate:   0.1,
+				Attrs: AttrMap{
+					"env":          "prod",
+					"region":       "eu",
+					"container_id": "abc123",
+				},
+			},
+		},
+		{
+			line:   "temp:-5|g",
+			sample: &statsdSample{Name: "temp", Type: "g", Values: []string{"-5"}, Rate: 1},
+		},
+		{
+			line:   "size:1:2:3|d",
+			sample: &statsdSample{Name: "size", Type: "d", Values: []string{"1", "2", "3"}, Rate: 1},
+		},
+		{
+			line:   "users:alice|s|#flag",
+			sample: &statsdSample{Name: "users", Type: "s", Values: []string{"alice"}, Rate: 1, Attrs: AttrMap{"flag": ""}},
+		},
+		{line: "_e{5,4}:title|text"},
+		{line: "_sc|check|0"},
+		{line: "page.views", wantErr: true},
+		{line: ":1|c", wantErr: true},
+		{line: "page.views:1", wantErr: true},
+		{line: "page.views:1|x", wantErr: true},
+		{line: "page.views:1|c|@0", wantErr: true},
+		{line: "page.views:1|c|@1.5", wantErr: true},
+		{line: "page.views:1|c|@abc", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			sample, err := parseStatsDLine(test.line)
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.sample, sample)
+		})
+	}
+}
+
+func TestStatsDAggregator(t *testing.T) {
+	add := func(agg *statsdAggregator, line string) {
+		sample, err := parseStatsDLine(line)
+		require.NoError(t, err)
+		agg.add(sample)
+	}
+	flush := func(agg *statsdAggregator) map[string]*statsdMetric {
+		m := make(map[string]*statsdMetric)
+		for _, metric := range agg.flush() {
+			m[metric.name] = metric
+		}
+		return m
+	}
+
+	t.Run("counter and histogram", func(t *testing.T) {
+		agg := newStatsDAggregator()
+		add(agg, "hits:1|c")
+		add(agg, "hits:2|c|@0.5")
+		add(agg, "hits:abc|c")
+		add(agg, "latency:10:30|ms|@0.5")
+		add(agg, "users:a|s")
+		add(agg, "users:b|s")
+		add(agg, "users:a|s")
+
+		metrics := flush(agg)
+		require.Equal(t, 5.0, metrics["hits"].sum)
+		require.Equal(t, 80.0, metrics["latency"].sum)
+		require.Equal(t, 4.0, metrics["latency"].count)
+		require.Equal(t, 10.0, metrics["latency"].min)
+		require.Equal(t, 30.0, metrics["latency"].max)
+		require.Len(t, metrics["users"].set, 2)
+
+		require.Empty(t, flush(agg))
+	})
+
+	t.Run("gauge", func(t *testing.T) {
+		agg := newStatsDAggregator()
+		add(agg, "temp:10|g")
+		add(agg, "temp:+5|g")
+		require.Equal(t, 15.0, flush(agg)["temp"].gauge)
+
+		// Relative updates work across flushes.
+		add(agg, "temp:+1|g")
+		require.Equal(t, 16.0, flush(agg)["temp"].gauge)
+
+		// A leading minus is an absolute value.
+		add(agg, "temp:-5|g")
+		require.Equal(t, -5.0, flush(agg)["temp"].gauge)
+	})
+
+	t.Run("idle gauges are evicted", func(t *testing.T) {
+		agg := newStatsDAggregator()
+		add(agg, "temp:10|g")
+		for i := 0; i < statsdGaugeMaxIdle; i++ {
+			flush(agg)
+		}
+		require.Len(t, agg.gauges, 1)
+
+		flush(agg)
+		require.Empty(t, agg.gauges)
+
+		add(agg, "temp:+1|g")
+		require.Equal(t, 1.0, flush(agg)["temp"].gauge)
+	})
+}
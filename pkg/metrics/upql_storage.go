@@ -7,7 +7,6 @@ import (
 	"math"
 	"strconv"
 	"strings"
-	"unsafe"
 
 	"github.com/uptrace/pkg/clickhouse/ch"
 	"github.com/uptrace/pkg/clickhouse/ch/chschema"
@@ -36,6 +35,8 @@ type CHStorageConfig struct {
 	MetricMap map[string]*Metric
 	Search    []chquery.Token
 	TableName string
+	// Limit is the max number of timeseries selected by a query. Defaults to 10000.
+	Limit int
 }
 
 func NewCHStorage(ctx context.Context, db *ch.DB, conf *CHStorageConfig) *CHStorage {
@@ -49,6 +50,13 @@ func NewCHStorage(ctx context.Context, db *ch.DB, conf *CHStorageConfig) *CHStor
 
 var _ mql.Storage = (*CHStorage)(nil)
 
+func (s *CHStorage) limit() int {
+	if s.conf.Limit > 0 {
+		return s.conf.Limit
+	}
+	return 10000
+}
+
 func (s *CHStorage) SelectTimeseries(f *mql.TimeseriesFilter) ([]*mql.Timeseries, error) {
 	metric, ok := s.conf.MetricMap[f.Metric]
 	if !ok {
@@ -68,6 +76,105 @@ func (s *CHStorage) SelectTimeseries(f *mql.TimeseriesFilter) ([]*mql.Timeseries
 	return s.newTimeseries(metric, f, items)
 }
 
+// Histogram quantiles are selected at evenly spaced levels so each quantile represents
+// the same number of observations.
+const histogramNumQuantile = 100
+
+var histogramQuantileLevels = func() string {
+	var b strings.Builder
+	for i := 0; i < histogramNumQuantile; i++ {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(strconv.FormatFloat((float64(i)+0.5)/histogramNumQuantile, 'f', -1, 64))
+	}
+	return b.String()
+}()
+
+// HistogramTimeseries contains sums, counts, and quantiles of histogram or summary
+// datapoints aggregated by the interval.
+type HistogramTimeseries struct {
+	Attrs mql.Attrs
+	Time  []unixtime.Nano
+	Sum   []float64
+	Count []float64
+	// Quantiles at histogramQuantileLevels. Summaries don't have quantiles.
+	Quantiles [][]float64
+}
+
+// SelectHistograms selects histogram or summary timeseries. Only the filter metric,
+// time, filters, and grouping are used.
+func (s *CHStorage) SelectHistograms(f *mql.TimeseriesFilter) ([]*HistogramTimeseries, error) {
+	metric, ok := s.conf.MetricMap[f.Metric]
+	if !ok {
+		return nil, fmt.Errorf("can't find metric with alias %q", f.Metric)
+	}
+	if metric.Instrument != InstrumentHistogram && metric.Instrument != InstrumentSummary {
+		return nil, fmt.Errorf("%s instrument is not a histogram", metric.Instrument)
+	}
+
+	countFilter := *f
+	countFilter.CHFunc = mql.CHAggCount
+	subq, err := s.metricsSubquery(metric, &countFilter, false)
+	if err != nil {
+		return nil, err
+	}
+	subq = subq.ColumnExpr("sumWithOverflow(d.sum) AS sum")
+	if metric.Instrument == InstrumentHistogram {
+		subq = subq.ColumnExpr(
+			"arrayMap(x -> toFloat64(x), quantilesBFloat16Merge(?)(d.histogram)) AS quantiles",
+			ch.Safe(histogramQuantileLevels))
+	}
+
+	q := s.db.NewSelect().
+		ColumnExpr("groupArray(toFloat64(d.value)) AS count").
+		ColumnExpr("groupArray(toFloat64(d.sum)) AS sum").
+		ColumnExpr("groupArray(d.time) AS time").
+		TableExpr("(?) AS d", subq).
+		Limit(s.limit())
+
+	if metric.Instrument == InstrumentHistogram {
+		q = q.ColumnExpr("groupArray(d.quantiles) AS quantiles")
+	}
+	for i := range f.Grouping {
+		elem := &f.Grouping[i]
+		q = q.Column(elem.Alias).Group(elem.Alias)
+	}
+
+	var items []map[string]any
+	if err := q.Scan(s.ctx, &items); err != nil {
+		return nil, err
+	}
+
+	timeseries := make([]*HistogramTimeseries, len(items))
+	for i, m := range items {
+		ts := &HistogramTimeseries{
+			Sum:   m["sum"].([]float64),
+			Count: m["count"].([]float64),
+		}
+		timeseries[i] = ts
+
+		if quantiles, ok := m["quantiles"].([][]float64); ok {
+			ts.Quantiles = quantiles
+		}
+
+		timeCol := m["time"].([]uint32)
+		ts.Time = make([]unixtime.Nano, len(timeCol))
+		for i, t := range timeCol {
+			ts.Time[i] = unixtime.Nano(t)
+		}
+
+		if len(f.Grouping) > 0 {
+			attrs := make([]string, 0, 2*len(f.Grouping))
+			for _, elem := range f.Grouping {
+				attrs = append(attrs, elem.Alias, fmt.Sprint(m[elem.Alias]))
+			}
+			ts.Attrs = mql.NewAttrs(attrs...)
+		}
+	}
+	return timeseries, nil
+}
+
 func (s *CHStorage) compileQuery(metric *Metric, f *mql.TimeseriesFilter) (*ch.SelectQuery, error) {
 	selectAll := f.CHFunc == mql.CHAggNone
 	subq, err := s.subquery(metric, f, selectAll)
@@ -79,7 +186,7 @@ func (s *CHStorage) compileQuery(metric *Metric, f *mql.TimeseriesFilter) (*ch.S
 		ColumnExpr("groupArray(toFloat64(d.value)) AS value").
 		ColumnExpr("groupArray(d.time) AS time").
 		TableExpr("(?) AS d", subq).
-		Limit(10000)
+		Limit(s.limit())
 
 	if selectAll {
 		q = q.ColumnExpr("d.attrs_hash").GroupExpr("d.attrs_hash")
@@ -539,8 +646,13 @@ func (s *CHStorage) newTimeseries(
 		ts.Value = m["value"].([]float64)
 		delete(m, "value")
 
+		// The time column contains seconds as uint32 so it must be copied,
+		// because unixtime.Nano is 8 bytes.
 		timeCol := m["time"].([]uint32)
-		ts.Time = *(*[]unixtime.Nano)(unsafe.Pointer(&timeCol))
+		ts.Time = make([]unixtime.Nano, len(timeCol))
+		for i, t := range timeCol {
+			ts.Time[i] = unixtime.Nano(t)
+		}
 		delete(m, "time")
 
 		ts.Value = bunutil.FillUnixNum(
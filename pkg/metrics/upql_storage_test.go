@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uptrace/pkg/unixtime"
+	"github.com/uptrace/uptrace/pkg/metrics/mql"
+)
+
+func TestCHStorageNewTimeseries(t *testing.T) {
+	gte := time.Unix(1700000000, 0)
+	f := &mql.TimeseriesFilter{
+		TimeGTE:  gte,
+		TimeLT:   gte.Add(4 * time.Minute),
+		Interval: time.Minute,
+	}
+	items := []map[string]any{{
+		"value": []float64{1, 3},
+		"time":  []uint32{uint32(gte.Unix()) + 60, uint32(gte.Unix()) + 180},
+	}}
+
+	s := new(CHStorage)
+	timeseries, err := s.newTimeseries(&Metric{Instrument: InstrumentGauge}, f, items)
+	require.NoError(t, err)
+	require.Len(t, timeseries, 1)
+
+	ts := timeseries[0]
+	require.Equal(t, []unixtime.Nano{
+		unixtime.Nano(gte.Unix()),
+		unixtime.Nano(gte.Unix() + 60),
+		unixtime.Nano(gte.Unix() + 120),
+		unixtime.Nano(gte.Unix() + 180),
+	}, ts.Time)
+
+	require.Len(t, ts.Value, 4)
+	require.True(t, math.IsNaN(ts.Value[0]))
+	require.Equal(t, 1.0, ts.Value[1])
+	require.True(t, math.IsNaN(ts.Value[2]))
+	require.Equal(t, 3.0, ts.Value[3])
+}
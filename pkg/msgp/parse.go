@@ -232,7 +232,7 @@ func ParseString(b []byte, flags ParseFlags) (string, []byte, error) {
 		return "", b, nil
 	}
 	if len(b) < ln {
-		return "", b, fmt.Errorf("msgp: wanted %d bytes decoding string, got %d", ln, len(b))
+		return "", b, fmt.Errorf("msgp: wanted %d bytes decoding string, got %d: %w", ln, len(b), io.ErrUnexpectedEOF)
 	}
 	var s string
 	if flags&ZeroCopyString != 0 {
@@ -252,7 +252,7 @@ func ParseBytes(b []byte, flags ParseFlags) ([]byte, []byte, error) {
 		return nil, b, nil
 	}
 	if len(b) < ln {
-		return nil, b, fmt.Errorf("msgp: wanted %d bytes decoding bytes, got %d", ln, len(b))
+		return nil, b, fmt.Errorf("msgp: wanted %d bytes decoding bytes, got %d: %w", ln, len(b), io.ErrUnexpectedEOF)
 	}
 	var bs []byte
 	if flags&ZeroCopyBytes != 0 {
@@ -304,7 +304,7 @@ func ParseTime(b []byte) (time.Time, []byte, error) {
 }
 func parseTimeData(b []byte, size int) (time.Time, []byte, error) {
 	if len(b) < size {
-		return time.Time{}, b, fmt.Errorf("msgp: wanted %d bytes decoding time, got %d", size, len(b))
+		return time.Time{}, b, fmt.Errorf("msgp: wanted %d bytes decoding time, got %d: %w", size, len(b), io.ErrUnexpectedEOF)
 	}
 	switch size {
 	case 4:
@@ -447,7 +447,7 @@ func ParseMapLen(b []byte) (int, []byte, error) {
 		return 0, nil, err
 	}
 	if len(b) < ln {
-		return 0, nil, fmt.Errorf("msgp: wanted %d bytes decoding map len, got %d", ln, len(b))
+		return 0, nil, fmt.Errorf("msgp: wanted %d bytes decoding map len, got %d: %w", ln, len(b), io.ErrUnexpectedEOF)
 	}
 	return ln, b, nil
 }
@@ -503,7 +503,7 @@ func ParseArrayLen(b []byte) (int, []byte, error) {
 		return 0, nil, err
 	}
 	if len(b) < ln {
-		return 0, nil, fmt.Errorf("msgp: wanted %d bytes decoding array len, got %d", ln, len(b))
+		return 0, nil, fmt.Errorf("msgp: wanted %d bytes decoding array len, got %d: %w", ln, len(b), io.ErrUnexpectedEOF)
 	}
 	return ln, b, nil
 }
@@ -570,39 +570,61 @@ func parseExt(b []byte) (any, []byte, error) {
 	}
 	return nil, b, fmt.Errorf("msgp: unknown ext id %d", extID)
 }
+func ParseExt(b []byte) (extID byte, data []byte, _ []byte, err error) {
+	extID, size, b, err := parseExtHeader(b)
+	if err != nil {
+		return 0, nil, b, err
+	}
+	if len(b) < size {
+		return 0, nil, b, fmt.Errorf("msgp: wanted %d bytes decoding ext, got %d: %w", size, len(b), io.ErrUnexpectedEOF)
+	}
+	return extID, b[:size], b[size:], nil
+}
 func parseExtHeader(b []byte) (byte, int, []byte, error) {
 	c, b, err := readByte(b)
 	if err != nil {
 		return 0, 0, b, err
 	}
+	var size int
 	switch c {
 	case msgpcode.Nil:
 		return msgpcode.Nil, 0, b, nil
+	case msgpcode.FixExt1:
+		size = 1
+	case msgpcode.FixExt2:
+		size = 2
 	case msgpcode.FixExt4:
-		extID, b, err := readByte(b)
-		if err != nil {
-			return 0, 0, b, err
-		}
-		return extID, 4, b, nil
+		size = 4
 	case msgpcode.FixExt8:
-		extID, b, err := readByte(b)
+		size = 8
+	case msgpcode.FixExt16:
+		size = 16
+	case msgpcode.Ext8:
+		n, rest, err := readByte(b)
 		if err != nil {
-			return 0, 0, b, err
+			return 0, 0, rest, err
 		}
-		return extID, 8, b, nil
-	case msgpcode.Ext8:
-		size, b, err := readByte(b)
+		size, b = int(n), rest
+	case msgpcode.Ext16:
+		n, rest, err := parseUint16(b)
 		if err != nil {
-			return 0, 0, b, err
+			return 0, 0, rest, err
 		}
-		extID, b, err := readByte(b)
+		size, b = int(n), rest
+	case msgpcode.Ext32:
+		n, rest, err := parseUint32(b)
 		if err != nil {
-			return 0, 0, b, err
+			return 0, 0, rest, err
 		}
-		return extID, int(size), b, nil
+		size, b = int(n), rest
 	default:
 		return 0, 0, b, fmt.Errorf("msgp: unexpected code %x decoding ext header", c)
 	}
+	extID, b, err := readByte(b)
+	if err != nil {
+		return 0, 0, b, err
+	}
+	return extID, size, b, nil
 }
 func readByte(b []byte) (byte, []byte, error) {
 	if len(b) == 0 {
@@ -45,7 +45,7 @@ func Skip(b []byte) ([]byte, error) {
 	case msgpcode.Map16, msgpcode.Map32:
 		return skipMap(b)
 	case msgpcode.FixExt1, msgpcode.FixExt2, msgpcode.FixExt4, msgpcode.FixExt8, msgpcode.FixExt16, msgpcode.Ext8, msgpcode.Ext16, msgpcode.Ext32:
-		_, b, err := parseExt(b)
+		_, _, b, err := ParseExt(b)
 		return b, err
 	case msgpcode.Nil:
 		return b[1:], nil
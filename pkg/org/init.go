@@ -24,6 +24,7 @@ var Module = fx.Module("org",
 	fx.Provide(
 		NewProjectGateway,
 		NewUserGateway,
+		NewRateLimiter,
 		fx.Annotate(
 			NewJWTProvider,
 			fx.As(new(UserProvider)),
@@ -0,0 +1,208 @@
+package org
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"go.uber.org/fx"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/uptrace/pkg/unixtime"
+	"github.com/uptrace/pkg/unixtime/rate"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+)
+
+const bytesSignal = "bytes"
+
+type RateLimiterParams struct {
+	fx.In
+
+	Conf *bunconf.Config
+}
+
+// RateLimiter enforces the per-project ingestion limits configured
+// using the rate_limits project option.
+type RateLimiter struct {
+	limits map[uint32]*bunconf.RateLimits
+
+	spans      *rate.LimiterMap
+	logs       *rate.LimiterMap
+	datapoints *rate.LimiterMap
+	bytes      *rate.LimiterMap
+}
+
+func NewRateLimiter(p RateLimiterParams) *RateLimiter {
+	l := &RateLimiter{
+		limits: make(map[uint32]*bunconf.RateLimits, len(p.Conf.Projects)),
+	}
+	for i := range p.Conf.Projects {
+		project := &p.Conf.Projects[i]
+		l.limits[project.ID] = &project.RateLimits
+	}
+
+	l.spans = l.newLimiterMap(func(limits *bunconf.RateLimits) int64 {
+		return limits.SpansPerSecond
+	})
+	l.logs = l.newLimiterMap(func(limits *bunconf.RateLimits) int64 {
+		return limits.LogsPerSecond
+	})
+	l.datapoints = l.newLimiterMap(func(limits *bunconf.RateLimits) int64 {
+		return limits.DatapointsPerSecond
+	})
+	l.bytes = rate.NewLimiterMap(func(key uint64) *rate.Limiter {
+		limits, ok := l.limits[uint32(key)]
+		if !ok || limits.BytesPerDay == 0 {
+			return nil
+		}
+		n := int64(limits.BytesPerDay)
+		return rate.NewLimiter(rate.Limit(float64(n)/(24*time.Hour).Seconds()), n)
+	})
+
+	return l
+}
+
+func (l *RateLimiter) newLimiterMap(
+	perSecond func(limits *bunconf.RateLimits) int64,
+) *rate.LimiterMap {
+	return rate.NewLimiterMap(func(key uint64) *rate.Limiter {
+		limits, ok := l.limits[uint32(key)]
+		if !ok {
+			return nil
+		}
+		n := perSecond(limits)
+		if n == 0 {
+			return nil
+		}
+		return rate.NewLimiter(rate.Limit(n), n)
+	})
+}
+
+// Throttle returns a throttle for the items of a single request.
+// The signal is one of bunconf.SignalTraces, SignalLogs, or SignalMetrics.
+func (l *RateLimiter) Throttle(projectID uint32, signal string) *Throttle {
+	var m *rate.LimiterMap
+	switch signal {
+	case bunconf.SignalTraces:
+		m = l.spans
+	case bunconf.SignalLogs:
+		m = l.logs
+	case bunconf.SignalMetrics:
+		m = l.datapoints
+	default:
+		panic(fmt.Errorf("unsupported signal: %q", signal))
+	}
+
+	return &Throttle{
+		signal: signal,
+		lim:    m.Get(uint64(projectID)),
+		now:    unixtime.Now(),
+	}
+}
+
+// AllowBytes checks the daily quota before accepting a request. The size func
+// is only called when the project has a quota so the request is not measured otherwise.
+func (l *RateLimiter) AllowBytes(projectID uint32, size func() int) error {
+	lim := l.bytes.Get(uint64(projectID))
+	if lim == nil {
+		return nil
+	}
+
+	r := lim.ReserveN(unixtime.Now(), int64(size()))
+	if delay := r.Delay(); delay > 0 {
+		r.Cancel()
+		return &RateLimitError{Signal: bytesSignal, Delay: delay}
+	}
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// Throttle hands out rate limit tokens for the items of a single request
+// so the request can be partially accepted.
+type Throttle struct {
+	signal string
+	lim    *rate.Limiter
+	now    unixtime.Nano
+
+	accepted  int64
+	throttled int64
+}
+
+// Allow reports whether the next item can be accepted.
+func (t *Throttle) Allow() bool {
+	if t == nil || t.lim == nil {
+		return true
+	}
+	if t.lim.AllowN(t.now, 1) == 1 {
+		t.accepted++
+		return true
+	}
+	t.throttled++
+	return false
+}
+
+// Throttled returns the number of rejected items.
+func (t *Throttle) Throttled() int64 {
+	if t == nil {
+		return 0
+	}
+	return t.throttled
+}
+
+// Err returns an error if all items were rejected and the client should retry later.
+func (t *Throttle) Err() error {
+	if t == nil || t.throttled == 0 || t.accepted > 0 {
+		return nil
+	}
+	return &RateLimitError{
+		Signal: t.signal,
+		Delay:  t.lim.Delay(t.now, t.throttled),
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// RateLimitError is returned when the project exceeds the ingestion limits.
+// It is converted to 429 Too Many Requests and RESOURCE_EXHAUSTED.
+type RateLimitError struct {
+	Signal string
+	Delay  time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("project exceeded %s rate limit (retry after %s)",
+		e.Signal, e.RetryAfter())
+}
+
+// RetryAfter returns the delay rounded up to seconds as required by the Retry-After header.
+func (e *RateLimitError) RetryAfter() time.Duration {
+	return max(time.Duration(math.Ceil(e.Delay.Seconds()))*time.Second, time.Second)
+}
+
+func (e *RateLimitError) HTTPStatusCode() int {
+	return http.StatusTooManyRequests
+}
+
+func (e *RateLimitError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"status":  e.HTTPStatusCode(),
+		"code":    "rate_limited",
+		"message": e.Error(),
+	})
+}
+
+func (e *RateLimitError) GRPCStatus() *status.Status {
+	st := status.New(codes.ResourceExhausted, e.Error())
+	if withDetails, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(e.RetryAfter()),
+	}); err == nil {
+		return withDetails
+	}
+	return st
+}
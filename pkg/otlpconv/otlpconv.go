@@ -1,7 +1,9 @@
 package otlpconv
 
 import (
+	"fmt"
 	"log"
+	"slices"
 	"strconv"
 
 	"github.com/segmentio/encoding/json"
@@ -109,3 +111,69 @@ func StringValue(v *commonpb.AnyValue) (string, bool) {
 		return "", false
 	}
 }
+
+// KeyValues converts attributes back to OTLP key-value pairs sorted by key.
+func KeyValues(attrs map[string]any) []*commonpb.KeyValue {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	kvs := make([]*commonpb.KeyValue, 0, len(keys))
+	for _, key := range keys {
+		if value := NewAnyValue(attrs[key]); value != nil {
+			kvs = append(kvs, &commonpb.KeyValue{Key: key, Value: value})
+		}
+	}
+	return kvs
+}
+
+func NewAnyValue(v any) *commonpb.AnyValue {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v}}
+	case int64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v}}
+	case int:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(v)}}
+	case uint64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(v)}}
+	case float64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v}}
+	case float32:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: float64(v)}}
+	case []string:
+		values := make([]*commonpb.AnyValue, len(v))
+		for i, s := range v {
+			values[i] = NewAnyValue(s)
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{
+			ArrayValue: &commonpb.ArrayValue{Values: values},
+		}}
+	case []any:
+		values := make([]*commonpb.AnyValue, 0, len(v))
+		for _, el := range v {
+			if value := NewAnyValue(el); value != nil {
+				values = append(values, value)
+			}
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{
+			ArrayValue: &commonpb.ArrayValue{Values: values},
+		}}
+	case map[string]any:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{
+			KvlistValue: &commonpb.KeyValueList{Values: KeyValues(v)},
+		}}
+	default:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: fmt.Sprint(v)}}
+	}
+}
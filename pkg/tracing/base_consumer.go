@@ -45,6 +45,8 @@ type BaseConsumer[IT IndexRecord, DT DataRecord] struct {
 	mainQueue   taskq.Queue
 	batchSize   int
 	transformer transformer[IT, DT]
+	sampler     *tailSampler
+	processors  []SpanProcessor
 
 	cancel      context.CancelFunc
 	wg          sync.WaitGroup
@@ -141,25 +143,52 @@ func (p *BaseConsumer[IT, DT]) processLoop(ctx context.Context) {
 	timer := time.NewTimer(timeout)
 	defer timer.Stop()
 
+	var flushC <-chan time.Time
+	if p.sampler != nil {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		flushC = ticker.C
+
+		// The worker is only used from this goroutine to initialize sampled spans.
+		worker := newConsumerWorker(
+			p.logger,
+			p.pg, p.ch, p.projects,
+			p.transformer,
+			nil,
+			0,
+		)
+		p.sampler.initSpan = worker.initSpanOrEvent
+	}
+
 	spans := make([]*Span, 0, p.batchSize)
 
+	addSpan := func(span *Span) {
+		spans = append(spans, span)
+
+		if len(spans) < p.batchSize {
+			return
+		}
+
+		p.processSpans(ctx, spans)
+		spans = spans[:0]
+
+		if !timer.Stop() {
+			<-timer.C
+		}
+		timer.Reset(timeout)
+	}
+
 loop:
 	for {
 		select {
 		case span := <-p.queue:
-			spans = append(spans, span)
-
-			if len(spans) < p.batchSize {
-				break
-			}
-
-			p.processSpans(ctx, spans)
-			spans = spans[:0]
-
-			if !timer.Stop() {
-				<-timer.C
+			if p.sampler != nil {
+				p.sampler.Add(ctx, span, addSpan)
+			} else {
+				addSpan(span)
 			}
-			timer.Reset(timeout)
+		case now := <-flushC:
+			p.sampler.Flush(ctx, now, addSpan)
 		case <-timer.C:
 			if len(spans) > 0 {
 				p.processSpans(ctx, spans)
@@ -171,6 +200,9 @@ loop:
 		}
 	}
 
+	if p.sampler != nil {
+		p.sampler.FlushAll(ctx, addSpan)
+	}
 	if len(spans) > 0 {
 		p.processSpans(ctx, spans)
 	}
@@ -190,6 +222,7 @@ func (p *BaseConsumer[IT, DT]) processSpans(ctx context.Context, src []*Span) {
 				p.logger,
 				p.pg, p.ch, p.projects,
 				p.transformer,
+				p.processors,
 				cap(p.queue),
 			)
 		}
@@ -217,6 +250,7 @@ type consumerWorker[IT IndexRecord, DT DataRecord] struct {
 	ch          *ch.DB
 	projectsGW  *org.ProjectGateway
 	transformer transformer[IT, DT]
+	processors  []SpanProcessor
 
 	projects     map[uint32]*org.Project
 	digest       *xxhash.Digest
@@ -230,6 +264,7 @@ func newConsumerWorker[IT IndexRecord, DT DataRecord](
 	ch *ch.DB,
 	projects *org.ProjectGateway,
 	transformer transformer[IT, DT],
+	processors []SpanProcessor,
 	bufSize int,
 ) *consumerWorker[IT, DT] {
 	return &consumerWorker[IT, DT]{
@@ -238,6 +273,7 @@ func newConsumerWorker[IT IndexRecord, DT DataRecord](
 		ch:           ch,
 		projectsGW:   projects,
 		transformer:  transformer,
+		processors:   processors,
 		projects:     make(map[uint32]*org.Project),
 		digest:       xxhash.New(),
 		indexedSpans: make([]IT, 0, bufSize),
@@ -270,7 +306,9 @@ func (p *consumerWorker[IT, DT]) _processSpans(ctx context.Context, spans []*Spa
 	}()
 
 	for _, span := range spans {
-		p.initSpanOrEvent(ctx, span)
+		if !span.initialized {
+			p.initSpanOrEvent(ctx, span)
+		}
 		spanCounter.Add(
 			ctx,
 			1,
@@ -287,6 +325,10 @@ func (p *consumerWorker[IT, DT]) _processSpans(ctx context.Context, spans []*Spa
 			continue
 		}
 
+		for _, processor := range p.processors {
+			processor.ProcessSpan(ctx, span)
+		}
+
 		for _, event := range span.Events {
 			eventSpan := &Span{
 				Attrs: NewAttrMap(),
@@ -36,7 +36,8 @@ func (index *BaseIndex) InitFromSpan(table *Table, span *Span) {
 	index.Span = span
 
 	index.DisplayName = utf8util.TruncLarge(span.DisplayName)
-	index.Count = 1
+	// Scale counts back up so sampled traces are counted as all traces.
+	index.Count = float32(span.SampleCount())
 
 	index.TelemetrySDKName = span.Attrs.Text(attrkey.TelemetrySDKName)
 	index.TelemetrySDKLanguage = span.Attrs.Text(attrkey.TelemetrySDKLanguage)
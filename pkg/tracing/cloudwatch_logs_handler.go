@@ -0,0 +1,266 @@
+package tracing
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/httputil"
+	"github.com/uptrace/uptrace/pkg/org"
+)
+
+const (
+	cloudwatchSDK             = "cloudwatch"
+	cloudwatchLambdaLogPrefix = "/aws/lambda/"
+)
+
+type CloudwatchLogsHandlerParams struct {
+	fx.In
+
+	Logger      *otelzap.Logger
+	Projects    *org.ProjectGateway
+	Consumer    *LogConsumer
+	RateLimiter *org.RateLimiter
+}
+
+// CloudwatchLogsHandler receives CloudWatch Logs subscription records delivered by Kinesis Firehose.
+type CloudwatchLogsHandler struct {
+	*CloudwatchLogsHandlerParams
+}
+
+func NewCloudwatchLogsHandler(p CloudwatchLogsHandlerParams) *CloudwatchLogsHandler {
+	return &CloudwatchLogsHandler{&p}
+}
+
+func registerCloudwatchLogsHandler(h *CloudwatchLogsHandler, p bunapp.RouterParams) {
+	p.Router.WithGroup("/api/v1/cloudwatch", func(g *bunrouter.Group) {
+		g.POST("/logs", h.Logs)
+	})
+}
+
+type firehoseEvent struct {
+	RequestID string           `json:"requestId"`
+	Records   []firehoseRecord `json:"records"`
+}
+
+type firehoseRecord struct {
+	Data []byte `json:"data"`
+}
+
+type cloudwatchLogsData struct {
+	MessageType string               `json:"messageType"`
+	Owner       string               `json:"owner"`
+	LogGroup    string               `json:"logGroup"`
+	LogStream   string               `json:"logStream"`
+	LogEvents   []cloudwatchLogEvent `json:"logEvents"`
+}
+
+type cloudwatchLogEvent struct {
+	ID        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+func (h *CloudwatchLogsHandler) Logs(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	dsn := req.Header.Get("X-Amz-Firehose-Access-Key")
+	if dsn == "" {
+		return errors.New("X-Amz-Firehose-Access-Key header is empty or missing")
+	}
+
+	project, err := h.Projects.SelectByDSN(ctx, dsn)
+	if err != nil {
+		return err
+	}
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.Int64("project", int64(project.ID)))
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := h.RateLimiter.AllowBytes(project.ID, func() int {
+		return len(body)
+	}); err != nil {
+		return err
+	}
+
+	event := new(firehoseEvent)
+	if err := json.Unmarshal(body, event); err != nil {
+		return err
+	}
+
+	throttle := h.RateLimiter.Throttle(project.ID, bunconf.SignalLogs)
+	p := new(cloudwatchLogProcessor)
+
+	for _, record := range event.Records {
+		data, err := cloudwatchRecordData(record.Data)
+		if err != nil {
+			return err
+		}
+
+		// Firehose may concatenate several subscription messages into a single record.
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for {
+			logs := new(cloudwatchLogsData)
+			if err := dec.Decode(logs); err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+
+			// Control messages are sent to check that the destination is reachable.
+			if logs.MessageType != "DATA_MESSAGE" {
+				continue
+			}
+
+			for i := range logs.LogEvents {
+				if !throttle.Allow() {
+					continue
+				}
+
+				span := new(Span)
+				p.spanFromCloudwatch(ctx, span, logs, &logs.LogEvents[i])
+				span.ProjectID = project.ID
+				h.Consumer.AddSpan(ctx, span)
+			}
+		}
+	}
+
+	if n := throttle.Throttled(); n > 0 {
+		countThrottled(ctx, project.ID, n)
+		if err := throttle.Err(); err != nil {
+			return err
+		}
+	}
+
+	return httputil.JSON(w, bunrouter.H{
+		"requestId": event.RequestID,
+		"timestamp": time.Now().UnixMilli(),
+	})
+}
+
+// cloudwatchRecordData decompresses the record unless Firehose already did that.
+func cloudwatchRecordData(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	return io.ReadAll(zr)
+}
+
+//------------------------------------------------------------------------------
+
+type cloudwatchLogProcessor struct {
+	vectorLogProcessor
+}
+
+func (p *cloudwatchLogProcessor) spanFromCloudwatch(
+	ctx context.Context, span *Span, logs *cloudwatchLogsData, event *cloudwatchLogEvent,
+) {
+	params := make(AttrMap, 8)
+	params["message"] = strings.TrimRight(event.Message, " \t\r\n")
+	params[attrkey.CloudProvider] = "aws"
+	if logs.Owner != "" {
+		params[attrkey.CloudAccountID] = logs.Owner
+	}
+	if logs.LogGroup != "" {
+		params["aws_log_group_name"] = logs.LogGroup
+		params[attrkey.LogSource] = logs.LogGroup
+	}
+	if logs.LogStream != "" {
+		params["aws_log_stream_name"] = logs.LogStream
+	}
+
+	if funcName, ok := strings.CutPrefix(logs.LogGroup, cloudwatchLambdaLogPrefix); ok {
+		params[attrkey.CloudPlatform] = "aws_lambda"
+		params["faas_name"] = funcName
+		if _, ok := params[attrkey.ServiceName]; !ok {
+			params[attrkey.ServiceName] = funcName
+		}
+		parseLambdaPlatformLine(params, event.Message)
+	}
+
+	p.spanFromVector(ctx, span, params)
+	span.Attrs[attrkey.TelemetrySDKName] = cloudwatchSDK
+	if span.Time.IsZero() {
+		span.Time = time.UnixMilli(event.Timestamp)
+	}
+}
+
+// parseLambdaPlatformLine parses START, END, and REPORT lines that are logged
+// by the Lambda runtime for each invocation, for example,
+// "REPORT RequestId: 8f5...\tDuration: 102.25 ms\tBilled Duration: 103 ms\tMemory Size: 128 MB".
+func parseLambdaPlatformLine(params AttrMap, msg string) {
+	typ, rest, ok := strings.Cut(msg, " RequestId: ")
+	if !ok {
+		return
+	}
+	switch typ {
+	case "START", "END", "REPORT":
+	default:
+		return
+	}
+
+	params["lambda_event"] = strings.ToLower(typ)
+	params[attrkey.LogSeverity] = "INFO"
+
+	fields := strings.Split(strings.TrimSpace(rest), "\t")
+	requestID, _, _ := strings.Cut(strings.TrimSpace(fields[0]), " ")
+	params["faas_invocation_id"] = requestID
+
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, ": ")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "Duration":
+			setLambdaNumber(params, "lambda_duration_ms", value, " ms")
+		case "Billed Duration":
+			setLambdaNumber(params, "lambda_billed_duration_ms", value, " ms")
+		case "Init Duration":
+			setLambdaNumber(params, "lambda_init_duration_ms", value, " ms")
+		case "Memory Size":
+			setLambdaNumber(params, "lambda_memory_size_mb", value, " MB")
+		case "Max Memory Used":
+			setLambdaNumber(params, "lambda_max_memory_used_mb", value, " MB")
+		}
+	}
+}
+
+func setLambdaNumber(params AttrMap, key, value, unit string) {
+	f, err := strconv.ParseFloat(strings.TrimSuffix(value, unit), 64)
+	if err == nil {
+		params[key] = f
+	}
+}
@@ -0,0 +1,655 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/pkg/msgp"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/httperror"
+	"github.com/uptrace/uptrace/pkg/httputil"
+	"github.com/uptrace/uptrace/pkg/org"
+)
+
+const datadogSDK = "datadog"
+
+type DatadogHandlerParams struct {
+	fx.In
+
+	Logger     *otelzap.Logger
+	Projects   *org.ProjectGateway
+	Dispatcher *SpanDispatcher
+}
+
+// DatadogHandler implements a subset of the Datadog Agent API used by dd-trace libraries.
+type DatadogHandler struct {
+	*DatadogHandlerParams
+}
+
+func NewDatadogHandler(p DatadogHandlerParams) *DatadogHandler {
+	return &DatadogHandler{&p}
+}
+
+func registerDatadogHandler(h *DatadogHandler, p bunapp.RouterParams) {
+	register := func(g *bunrouter.Group) {
+		g.GET("/info", h.Info)
+
+		g.POST("/v0.4/traces", h.TracesV04)
+		g.PUT("/v0.4/traces", h.TracesV04)
+		g.POST("/v0.5/traces", h.TracesV05)
+		g.PUT("/v0.5/traces", h.TracesV05)
+	}
+
+	// Tracers don't allow to configure custom headers, so the project token can be passed
+	// using the agent URL, for example, DD_TRACE_AGENT_URL=http://uptrace:14318/api/datadog/<token>.
+	p.Router.WithGroup("/api/datadog/:token", register)
+	p.Router.WithGroup("", register)
+}
+
+func (h *DatadogHandler) Info(w http.ResponseWriter, req bunrouter.Request) error {
+	return httputil.JSON(w, bunrouter.H{
+		"version":   "7.50.0",
+		"endpoints": []string{"/v0.4/traces", "/v0.5/traces", "/info"},
+
+		"client_drop_p0s": false,
+	})
+}
+
+func (h *DatadogHandler) TracesV04(w http.ResponseWriter, req bunrouter.Request) error {
+	return h.traces(w, req, decodeDatadogV04)
+}
+
+func (h *DatadogHandler) TracesV05(w http.ResponseWriter, req bunrouter.Request) error {
+	return h.traces(w, req, decodeDatadogV05)
+}
+
+func (h *DatadogHandler) traces(
+	w http.ResponseWriter, req bunrouter.Request, decode func([]byte) ([][]*ddSpan, error),
+) error {
+	ctx := req.Context()
+
+	project, err := h.project(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.Int64("project", int64(project.ID)))
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+
+	traces, err := decode(body)
+	if err != nil {
+		return fmt.Errorf("can't decode Datadog traces: %w", err)
+	}
+
+	for _, ddSpans := range traces {
+		traceIDHigh := datadogTraceIDHigh(ddSpans)
+		for _, ddSpan := range ddSpans {
+			span := new(Span)
+			initSpanFromDatadog(span, ddSpan, traceIDHigh)
+			span.ProjectID = project.ID
+			h.Dispatcher.AddSpan(ctx, span)
+		}
+	}
+
+	return httputil.JSON(w, bunrouter.H{
+		"rate_by_service": bunrouter.H{
+			"service:,env:": 1,
+		},
+	})
+}
+
+func (h *DatadogHandler) project(ctx context.Context, req bunrouter.Request) (*org.Project, error) {
+	var project *org.Project
+	var err error
+
+	if token := req.Param("token"); token != "" {
+		project, err = h.Projects.SelectByToken(ctx, token)
+	} else {
+		var dsn string
+		dsn, err = org.DSNFromRequest(req)
+		if err != nil {
+			return nil, httperror.Unauthorized(err.Error())
+		}
+		project, err = h.Projects.SelectByDSN(ctx, dsn)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.Unauthorized("can't find project using the provided token")
+		}
+		return nil, err
+	}
+	return project, nil
+}
+
+//------------------------------------------------------------------------------
+
+type ddSpan struct {
+	Service  string
+	Name     string
+	Resource string
+	TraceID  uint64
+	SpanID   uint64
+	ParentID uint64
+	Start    int64
+	Duration int64
+	Error    int64
+	Meta     map[string]string
+	Metrics  map[string]float64
+	Type     string
+	Links    []ddSpanLink
+}
+
+type ddSpanLink struct {
+	TraceID     uint64
+	TraceIDHigh uint64
+	SpanID      uint64
+	Attrs       map[string]string
+}
+
+// decodeDatadogV04 decodes an array of traces where each trace is an array of span maps.
+func decodeDatadogV04(b []byte) ([][]*ddSpan, error) {
+	numTrace, b, err := msgp.ParseArrayLen(b)
+	if err != nil {
+		return nil, err
+	}
+
+	traces := make([][]*ddSpan, 0, max(numTrace, 0))
+	for i := 0; i < numTrace; i++ {
+		numSpan, rest, err := msgp.ParseArrayLen(b)
+		if err != nil {
+			return nil, err
+		}
+		b = rest
+
+		spans := make([]*ddSpan, 0, max(numSpan, 0))
+		for j := 0; j < numSpan; j++ {
+			span := new(ddSpan)
+			b, err = span.decodeMap(b)
+			if err != nil {
+				return nil, err
+			}
+			spans = append(spans, span)
+		}
+		traces = append(traces, spans)
+	}
+	return traces, nil
+}
+
+func (s *ddSpan) decodeMap(b []byte) ([]byte, error) {
+	n, b, err := msgp.ParseMapLen(b)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < n; i++ {
+		var key string
+		key, b, err = msgp.ParseString(b, msgp.ZeroCopyString)
+		if err != nil {
+			return nil, err
+		}
+
+		switch key {
+		case "service":
+			s.Service, b, err = msgp.ParseString(b, 0)
+		case "name":
+			s.Name, b, err = msgp.ParseString(b, 0)
+		case "resource":
+			s.Resource, b, err = msgp.ParseString(b, 0)
+		case "type":
+			s.Type, b, err = msgp.ParseString(b, 0)
+		case "trace_id":
+			s.TraceID, b, err = parseDatadogUint(b)
+		case "span_id":
+			s.SpanID, b, err = parseDatadogUint(b)
+		case "parent_id":
+			s.ParentID, b, err = parseDatadogUint(b)
+		case "start":
+			s.Start, b, err = parseDatadogInt(b)
+		case "duration":
+			s.Duration, b, err = parseDatadogInt(b)
+		case "error":
+			s.Error, b, err = parseDatadogInt(b)
+		case "meta":
+			s.Meta, b, err = msgp.ParseMapStringString(b, 0)
+		case "metrics":
+			s.Metrics, b, err = parseDatadogMetrics(b)
+		case "span_links":
+			s.Links, b, err = parseDatadogLinks(b)
+		default:
+			b, err = msgp.Skip(b)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't decode %q: %w", key, err)
+		}
+	}
+
+	return b, nil
+}
+
+// decodeDatadogV05 decodes [strings, traces] where strings are referenced by index
+// and each span is an array with 12 elements.
+func decodeDatadogV05(b []byte) ([][]*ddSpan, error) {
+	n, b, err := msgp.ParseArrayLen(b)
+	if err != nil {
+		return nil, err
+	}
+	if n != 2 {
+		return nil, fmt.Errorf("expected an array with 2 elements, got %d", n)
+	}
+
+	dict, b, err := msgp.ParseStringSlice(b, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	str := func(b []byte) (string, []byte, error) {
+		idx, b, err := msgp.ParseUint64(b)
+		if err != nil {
+			return "", nil, err
+		}
+		if idx >= uint64(len(dict)) {
+			return "", nil, fmt.Errorf("string index %d is out of range", idx)
+		}
+		return dict[idx], b, nil
+	}
+
+	numTrace, b, err := msgp.ParseArrayLen(b)
+	if err != nil {
+		return nil, err
+	}
+
+	traces := make([][]*ddSpan, 0, max(numTrace, 0))
+	for i := 0; i < numTrace; i++ {
+		numSpan, rest, err := msgp.ParseArrayLen(b)
+		if err != nil {
+			return nil, err
+		}
+		b = rest
+
+		spans := make([]*ddSpan, 0, max(numSpan, 0))
+		for j := 0; j < numSpan; j++ {
+			span := new(ddSpan)
+			b, err = span.decodeArray(b, str)
+			if err != nil {
+				return nil, err
+			}
+			spans = append(spans, span)
+		}
+		traces = append(traces, spans)
+	}
+	return traces, nil
+}
+
+func (s *ddSpan) decodeArray(
+	b []byte, str func([]byte) (string, []byte, error),
+) ([]byte, error) {
+	n, b, err := msgp.ParseArrayLen(b)
+	if err != nil {
+		return nil, err
+	}
+	if n != 12 {
+		return nil, fmt.Errorf("expected a span with 12 elements, got %d", n)
+	}
+
+	if s.Service, b, err = str(b); err != nil {
+		return nil, err
+	}
+	if s.Name, b, err = str(b); err != nil {
+		return nil, err
+	}
+	if s.Resource, b, err = str(b); err != nil {
+		return nil, err
+	}
+	if s.TraceID, b, err = parseDatadogUint(b); err != nil {
+		return nil, err
+	}
+	if s.SpanID, b, err = parseDatadogUint(b); err != nil {
+		return nil, err
+	}
+	if s.ParentID, b, err = parseDatadogUint(b); err != nil {
+		return nil, err
+	}
+	if s.Start, b, err = parseDatadogInt(b); err != nil {
+		return nil, err
+	}
+	if s.Duration, b, err = parseDatadogInt(b); err != nil {
+		return nil, err
+	}
+	if s.Error, b, err = parseDatadogInt(b); err != nil {
+		return nil, err
+	}
+
+	numMeta, b, err := msgp.ParseMapLen(b)
+	if err != nil {
+		return nil, err
+	}
+	s.Meta = make(map[string]string, max(numMeta, 0))
+	for i := 0; i < numMeta; i++ {
+		var key, value string
+		if key, b, err = str(b); err != nil {
+			return nil, err
+		}
+		if value, b, err = str(b); err != nil {
+			return nil, err
+		}
+		s.Meta[key] = value
+	}
+
+	numMetric, b, err := msgp.ParseMapLen(b)
+	if err != nil {
+		return nil, err
+	}
+	s.Metrics = make(map[string]float64, max(numMetric, 0))
+	for i := 0; i < numMetric; i++ {
+		var key string
+		var value float64
+		if key, b, err = str(b); err != nil {
+			return nil, err
+		}
+		if value, b, err = parseDatadogFloat(b); err != nil {
+			return nil, err
+		}
+		s.Metrics[key] = value
+	}
+
+	if s.Type, b, err = str(b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func parseDatadogLinks(b []byte) ([]ddSpanLink, []byte, error) {
+	n, b, err := msgp.ParseArrayLen(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	links := make([]ddSpanLink, max(n, 0))
+	for i := range links {
+		link := &links[i]
+
+		numField, rest, err := msgp.ParseMapLen(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		b = rest
+
+		for j := 0; j < numField; j++ {
+			var key string
+			key, b, err = msgp.ParseString(b, msgp.ZeroCopyString)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			switch key {
+			case "trace_id":
+				link.TraceID, b, err = parseDatadogUint(b)
+			case "trace_id_high":
+				link.TraceIDHigh, b, err = parseDatadogUint(b)
+			case "span_id":
+				link.SpanID, b, err = parseDatadogUint(b)
+			case "attributes":
+				link.Attrs, b, err = msgp.ParseMapStringString(b, 0)
+			default:
+				b, err = msgp.Skip(b)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return links, b, nil
+}
+
+func parseDatadogMetrics(b []byte) (map[string]float64, []byte, error) {
+	n, b, err := msgp.ParseMapLen(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := make(map[string]float64, max(n, 0))
+	for i := 0; i < n; i++ {
+		var key string
+		var value float64
+
+		key, b, err = msgp.ParseString(b, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		value, b, err = parseDatadogFloat(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		m[key] = value
+	}
+	return m, b, nil
+}
+
+// Tracers written in dynamic languages don't always use the same number types,
+// so the following functions accept any number.
+
+func parseDatadogUint(b []byte) (uint64, []byte, error) {
+	v, b, err := msgp.ParseAny(b, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	switch v := v.(type) {
+	case uint64:
+		return v, b, nil
+	case int64:
+		return uint64(v), b, nil
+	case float64:
+		return uint64(v), b, nil
+	case nil:
+		return 0, b, nil
+	default:
+		return 0, nil, fmt.Errorf("unexpected number type %T", v)
+	}
+}
+
+func parseDatadogInt(b []byte) (int64, []byte, error) {
+	n, b, err := parseDatadogUint(b)
+	return int64(n), b, err
+}
+
+func parseDatadogFloat(b []byte) (float64, []byte, error) {
+	v, b, err := msgp.ParseAny(b, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	switch v := v.(type) {
+	case float64:
+		return v, b, nil
+	case float32:
+		return float64(v), b, nil
+	case int64:
+		return float64(v), b, nil
+	case uint64:
+		return float64(v), b, nil
+	case nil:
+		return 0, b, nil
+	default:
+		return 0, nil, fmt.Errorf("unexpected number type %T", v)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+var datadogMetaNames = map[string]string{
+	"env":              attrkey.DeploymentEnvironment,
+	"version":          attrkey.ServiceVersion,
+	"language":         attrkey.TelemetrySDKLanguage,
+	"http.method":      attrkey.HTTPRequestMethod,
+	"http.url":         attrkey.URLFull,
+	"http.route":       attrkey.HTTPRoute,
+	"http.status_code": attrkey.HTTPResponseStatusCode,
+	"http.useragent":   attrkey.UserAgentOriginal,
+	"db.type":          attrkey.DBSystem,
+	"sql.query":        attrkey.DBStatement,
+	"peer.service":     attrkey.PeerService,
+	"out.host":         attrkey.ServerAddress,
+}
+
+func initSpanFromDatadog(dest *Span, src *ddSpan, traceIDHigh uint64) {
+	dest.TraceID = idgen.NewTraceIDLowHigh(traceIDHigh, src.TraceID)
+	dest.ID = idgen.SpanIDFromUint64(src.SpanID)
+	dest.ParentID = idgen.SpanIDFromUint64(src.ParentID)
+
+	dest.Name = src.Resource
+	if dest.Name == "" {
+		dest.Name = src.Name
+	}
+	dest.Kind = datadogSpanKind(src)
+	dest.Time = time.Unix(0, src.Start)
+	dest.Duration = time.Duration(src.Duration)
+	dest.StatusCode = OKStatusCode
+
+	dest.Attrs = make(AttrMap, len(src.Meta)+len(src.Metrics)+4)
+	dest.Attrs[attrkey.TelemetrySDKName] = datadogSDK
+	if src.Service != "" {
+		dest.Attrs[attrkey.ServiceName] = src.Service
+	}
+	if src.Name != "" {
+		dest.Attrs["datadog_operation_name"] = src.Name
+	}
+	if src.Type != "" {
+		dest.Attrs["datadog_span_type"] = src.Type
+	}
+
+	for key, value := range src.Meta {
+		if strings.HasPrefix(key, "_dd.") || strings.HasPrefix(key, "error.") {
+			continue
+		}
+		if key == "span.kind" {
+			continue
+		}
+
+		if name, ok := datadogMetaNames[key]; ok {
+			if name == attrkey.HTTPResponseStatusCode {
+				if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+					dest.Attrs[name] = n
+					continue
+				}
+			}
+			dest.Attrs[name] = value
+			continue
+		}
+
+		if key = attrkey.Clean(key); key != "" {
+			dest.Attrs[key] = value
+		}
+	}
+
+	for key, value := range src.Metrics {
+		// Skip internal metrics such as _sampling_priority_v1 and _dd.measured.
+		if strings.HasPrefix(key, "_") {
+			continue
+		}
+		if key = attrkey.Clean(key); key != "" {
+			dest.Attrs[key] = value
+		}
+	}
+
+	if src.Error != 0 {
+		dest.StatusCode = ErrorStatusCode
+		dest.StatusMessage = src.Meta["error.message"]
+		if dest.StatusMessage == "" {
+			dest.StatusMessage = src.Meta["error.msg"]
+		}
+
+		if errType := src.Meta["error.type"]; errType != "" || dest.StatusMessage != "" {
+			event := &SpanEvent{
+				Name:  otelEventException,
+				Time:  dest.Time.Add(dest.Duration),
+				Attrs: make(AttrMap, 3),
+			}
+			if errType != "" {
+				event.Attrs[attrkey.ExceptionType] = errType
+			}
+			if dest.StatusMessage != "" {
+				event.Attrs[attrkey.ExceptionMessage] = dest.StatusMessage
+			}
+			if stack := src.Meta["error.stack"]; stack != "" {
+				event.Attrs[attrkey.ExceptionStacktrace] = stack
+			}
+			dest.Events = append(dest.Events, event)
+		}
+	}
+
+	for i := range src.Links {
+		link := &src.Links[i]
+		attrs := make(AttrMap, len(link.Attrs))
+		for key, value := range link.Attrs {
+			if key = attrkey.Clean(key); key != "" {
+				attrs[key] = value
+			}
+		}
+		dest.Links = append(dest.Links, &SpanLink{
+			TraceID: idgen.NewTraceIDLowHigh(link.TraceIDHigh, link.TraceID),
+			SpanID:  idgen.SpanIDFromUint64(link.SpanID),
+			Attrs:   attrs,
+		})
+	}
+}
+
+// datadogTraceIDHigh returns the upper 64 bits of a 128-bit trace id. Datadog tracers
+// only set _dd.p.tid on the first span of a trace chunk so it applies to all spans.
+func datadogTraceIDHigh(spans []*ddSpan) uint64 {
+	for _, span := range spans {
+		if s := span.Meta["_dd.p.tid"]; s != "" {
+			if n, err := strconv.ParseUint(s, 16, 64); err == nil {
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+func datadogSpanKind(span *ddSpan) string {
+	switch span.Meta["span.kind"] {
+	case "server":
+		return ServerSpanKind
+	case "client":
+		return ClientSpanKind
+	case "producer":
+		return ProducerSpanKind
+	case "consumer":
+		return ConsumerSpanKind
+	case "internal":
+		return InternalSpanKind
+	}
+
+	switch span.Type {
+	case "web":
+		return ServerSpanKind
+	case "http", "grpc", "sql", "db", "cache", "redis", "memcached",
+		"mongodb", "cassandra", "elasticsearch":
+		return ClientSpanKind
+	case "queue":
+		return ProducerSpanKind
+	default:
+		return InternalSpanKind
+	}
+}
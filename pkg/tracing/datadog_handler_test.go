@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/pkg/msgp"
+)
+
+func TestDatadogTraceIDHigh(t *testing.T) {
+	appendSpan := func(b []byte, spanID, parentID uint64, meta map[string]string) []byte {
+		b = msgp.AppendMapLen(b, 6)
+		b = msgp.AppendString(b, "service")
+		b = msgp.AppendString(b, "api")
+		b = msgp.AppendString(b, "name")
+		b = msgp.AppendString(b, "http.request")
+		b = msgp.AppendString(b, "trace_id")
+		b = msgp.AppendUint64(b, 0x1234)
+		b = msgp.AppendString(b, "span_id")
+		b = msgp.AppendUint64(b, spanID)
+		b = msgp.AppendString(b, "parent_id")
+		b = msgp.AppendUint64(b, parentID)
+		b = msgp.AppendString(b, "meta")
+		b, _ = msgp.AppendMapStringString(b, meta, 0)
+		return b
+	}
+
+	// Only the first span in a chunk carries _dd.p.tid.
+	b := msgp.AppendArrayLen(nil, 1)
+	b = msgp.AppendArrayLen(b, 3)
+	b = appendSpan(b, 1, 0, map[string]string{"_dd.p.tid": "6553f10000000000"})
+	b = appendSpan(b, 2, 1, map[string]string{"http.method": "GET"})
+	b = appendSpan(b, 3, 2, nil)
+
+	traces, err := decodeDatadogV04(b)
+	require.NoError(t, err)
+	require.Len(t, traces, 1)
+	require.Len(t, traces[0], 3)
+
+	traceIDHigh := datadogTraceIDHigh(traces[0])
+	require.Equal(t, uint64(0x6553f10000000000), traceIDHigh)
+
+	wanted := idgen.NewTraceIDLowHigh(0x6553f10000000000, 0x1234)
+	for _, ddSpan := range traces[0] {
+		span := new(Span)
+		initSpanFromDatadog(span, ddSpan, traceIDHigh)
+		require.Equal(t, wanted, span.TraceID)
+		require.NotContains(t, span.Attrs, "_dd_p_tid")
+	}
+
+	require.Zero(t, datadogTraceIDHigh([]*ddSpan{{Meta: map[string]string{"_dd.p.tid": "xyz"}}}))
+	require.Zero(t, datadogTraceIDHigh(nil))
+}
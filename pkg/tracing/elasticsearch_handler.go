@@ -0,0 +1,228 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/httputil"
+	"github.com/uptrace/uptrace/pkg/org"
+	"github.com/uptrace/uptrace/pkg/tracing/anyconv"
+)
+
+const (
+	elasticsearchSDK     = "elasticsearch"
+	elasticsearchVersion = "8.11.0"
+)
+
+type ElasticsearchHandlerParams struct {
+	fx.In
+
+	Logger   *otelzap.Logger
+	Projects *org.ProjectGateway
+	Consumer *LogConsumer
+}
+
+// ElasticsearchHandler implements a subset of the Elasticsearch API
+// that is enough for Filebeat, Fluent Bit, and Logstash to ship logs using the bulk API.
+type ElasticsearchHandler struct {
+	*ElasticsearchHandlerParams
+}
+
+func NewElasticsearchHandler(p ElasticsearchHandlerParams) *ElasticsearchHandler {
+	return &ElasticsearchHandler{&p}
+}
+
+func registerElasticsearchHandler(h *ElasticsearchHandler, p bunapp.RouterParams) {
+	p.Router.WithGroup("/api/elasticsearch", func(g *bunrouter.Group) {
+		g.GET("/", h.Info)
+		g.HEAD("/", h.Info)
+		g.GET("/_license", h.License)
+
+		g.POST("/_bulk", h.Bulk)
+		g.PUT("/_bulk", h.Bulk)
+		g.POST("/:index/_bulk", h.Bulk)
+		g.PUT("/:index/_bulk", h.Bulk)
+	})
+}
+
+func (h *ElasticsearchHandler) Info(w http.ResponseWriter, req bunrouter.Request) error {
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	return httputil.JSON(w, bunrouter.H{
+		"name":         "uptrace",
+		"cluster_name": "uptrace",
+		"cluster_uuid": "uptrace",
+		"version": bunrouter.H{
+			"number":                              elasticsearchVersion,
+			"build_flavor":                        "default",
+			"build_type":                          "docker",
+			"lucene_version":                      "9.8.0",
+			"minimum_wire_compatibility_version":  "7.17.0",
+			"minimum_index_compatibility_version": "7.0.0",
+		},
+		"tagline": "You Know, for Search",
+	})
+}
+
+func (h *ElasticsearchHandler) License(w http.ResponseWriter, req bunrouter.Request) error {
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	return httputil.JSON(w, bunrouter.H{
+		"license": bunrouter.H{
+			"uid":    "uptrace",
+			"type":   "basic",
+			"status": "active",
+		},
+	})
+}
+
+func (h *ElasticsearchHandler) Bulk(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+	startTime := time.Now()
+
+	dsn, err := org.DSNFromRequest(req)
+	if err != nil {
+		return err
+	}
+
+	project, err := h.Projects.SelectByDSN(ctx, dsn)
+	if err != nil {
+		return err
+	}
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.Int64("project", int64(project.ID)))
+	}
+
+	defaultIndex := req.Param("index")
+	p := new(elasticsearchLogProcessor)
+
+	var items []bunrouter.H
+	var hasErrors bool
+
+	sc := bufio.NewScanner(req.Body)
+	sc.Buffer(make([]byte, 0, 64<<10), 32<<20)
+
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var action map[string]esBulkAction
+		if err := json.Unmarshal(line, &action); err != nil || len(action) != 1 {
+			return fmt.Errorf("can't parse bulk action: %q", line)
+		}
+
+		for op, meta := range action {
+			if meta.Index == "" {
+				meta.Index = defaultIndex
+			}
+
+			switch op {
+			case "index", "create":
+			case "delete":
+				hasErrors = true
+				items = append(items, esBulkItem(op, meta, http.StatusBadRequest,
+					"action_request_validation_exception", "delete is not supported"))
+				continue
+			default:
+				if !sc.Scan() {
+					return fmt.Errorf("bulk action %q is missing a document", op)
+				}
+				hasErrors = true
+				items = append(items, esBulkItem(op, meta, http.StatusBadRequest,
+					"action_request_validation_exception", op+" is not supported"))
+				continue
+			}
+
+			if !sc.Scan() {
+				return fmt.Errorf("bulk action %q is missing a document", op)
+			}
+
+			doc := make(AttrMap)
+			if err := json.Unmarshal(sc.Bytes(), &doc); err != nil {
+				hasErrors = true
+				items = append(items, esBulkItem(op, meta, http.StatusBadRequest,
+					"mapper_parsing_exception", err.Error()))
+				continue
+			}
+
+			span := new(Span)
+			p.spanFromElasticsearch(ctx, span, meta.Index, doc)
+			span.ProjectID = project.ID
+			h.Consumer.AddSpan(ctx, span)
+
+			items = append(items, esBulkItem(op, meta, http.StatusCreated, "", ""))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	return httputil.JSON(w, bunrouter.H{
+		"took":   time.Since(startTime).Milliseconds(),
+		"errors": hasErrors,
+		"items":  items,
+	})
+}
+
+type esBulkAction struct {
+	Index string `json:"_index"`
+	ID    string `json:"_id"`
+}
+
+func esBulkItem(op string, meta esBulkAction, status int, errType, reason string) bunrouter.H {
+	item := bunrouter.H{
+		"_index": meta.Index,
+		"_id":    meta.ID,
+		"status": status,
+	}
+	if errType != "" {
+		item["error"] = bunrouter.H{
+			"type":   errType,
+			"reason": reason,
+		}
+	} else {
+		item["result"] = "created"
+	}
+	return bunrouter.H{op: item}
+}
+
+//------------------------------------------------------------------------------
+
+type elasticsearchLogProcessor struct {
+	vectorLogProcessor
+}
+
+func (p *elasticsearchLogProcessor) spanFromElasticsearch(
+	ctx context.Context, span *Span, index string, doc AttrMap,
+) {
+	// @timestamp is removed before the keys are cleaned.
+	if ts, ok := doc["@timestamp"]; ok {
+		delete(doc, "@timestamp")
+		span.Time = anyconv.Time(ts)
+	}
+
+	params := make(AttrMap, len(doc))
+	flattenParams(params, "", doc)
+
+	if index != "" {
+		params["elasticsearch_index"] = index
+	}
+
+	p.spanFromVector(ctx, span, params)
+	span.Attrs[attrkey.TelemetrySDKName] = elasticsearchSDK
+}
@@ -0,0 +1,550 @@
+package tracing
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/pkg/msgp"
+	"github.com/uptrace/pkg/msgp/msgpcode"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/org"
+	"github.com/uptrace/uptrace/pkg/run"
+)
+
+const (
+	fluentSDK            = "fluent"
+	fluentMaxMessageSize = 64 << 20
+	fluentEventTimeExtID = 0
+)
+
+type FluentForwardServerParams struct {
+	fx.In
+
+	Logger   *otelzap.Logger
+	Conf     *bunconf.Config
+	Projects *org.ProjectGateway
+	Consumer *LogConsumer
+}
+
+// FluentForwardServer accepts logs from Fluent Bit and Fluentd using the Forward protocol.
+// See https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1.
+type FluentForwardServer struct {
+	*FluentForwardServerParams
+
+	hostname string
+
+	mu    sync.Mutex
+	ln    net.Listener
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+func NewFluentForwardServer(p FluentForwardServerParams) *FluentForwardServer {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "uptrace"
+	}
+	return &FluentForwardServer{
+		FluentForwardServerParams: &p,
+		hostname:                  hostname,
+		conns:                     make(map[net.Conn]struct{}),
+	}
+}
+
+func runFluentForwardServer(group *run.Group, srv *FluentForwardServer) error {
+	conf := srv.Conf.Listen.FluentForward
+	if conf == nil {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", conf.Addr)
+	if err != nil {
+		srv.Logger.Error("net.Listen failed (edit listen.fluent_forward YAML option)",
+			zap.Error(err), zap.String("addr", conf.Addr))
+		return err
+	}
+
+	if conf.TLS != nil {
+		tlsConf, err := conf.TLS.TLSConfig()
+		if err != nil {
+			return err
+		}
+		ln = tls.NewListener(ln, tlsConf)
+	}
+
+	group.Add("fluent.Serve", func() error {
+		return srv.Serve(ln)
+	})
+	group.OnStop(func(context.Context, error) error {
+		return srv.Close()
+	})
+
+	return nil
+}
+
+func (s *FluentForwardServer) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+			}()
+
+			if err := s.serveConn(conn); err != nil && !errors.Is(err, net.ErrClosed) {
+				s.Logger.Error("fluent forward connection failed",
+					zap.Error(err), zap.String("remote_addr", conn.RemoteAddr().String()))
+			}
+		}()
+	}
+}
+
+func (s *FluentForwardServer) Close() error {
+	s.mu.Lock()
+	if s.ln != nil {
+		_ = s.ln.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+func (s *FluentForwardServer) serveConn(conn net.Conn) error {
+	defer conn.Close()
+
+	ctx := context.Background()
+	rd := &fluentReader{rd: conn}
+
+	var project *org.Project
+	var err error
+
+	if projectID := s.Conf.Listen.FluentForward.ProjectID; projectID != 0 {
+		project, err = s.Projects.SelectByID(ctx, projectID)
+		if err != nil {
+			return fmt.Errorf("can't find project with id=%d: %w", projectID, err)
+		}
+	} else {
+		project, err = s.handshake(ctx, conn, rd)
+		if err != nil {
+			return err
+		}
+	}
+
+	p := &fluentLogProcessor{
+		consumer: s.Consumer,
+		project:  project,
+	}
+
+	for {
+		b, err := rd.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		chunk, err := p.processMessage(ctx, b)
+		if err != nil {
+			return err
+		}
+
+		if chunk != "" {
+			ack := msgp.AppendMapLen(nil, 1)
+			ack = msgp.AppendString(ack, "ack")
+			ack = msgp.AppendString(ack, chunk)
+			if _, err := conn.Write(ack); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handshake authenticates the client using the shared key, which is a project token.
+func (s *FluentForwardServer) handshake(
+	ctx context.Context, conn net.Conn, rd *fluentReader,
+) (*org.Project, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	helo := msgp.AppendArrayLen(nil, 2)
+	helo = msgp.AppendString(helo, "HELO")
+	helo = msgp.AppendMapLen(helo, 3)
+	helo = msgp.AppendString(helo, "nonce")
+	helo = msgp.AppendBytes(helo, nonce)
+	helo = msgp.AppendString(helo, "auth")
+	helo = msgp.AppendBytes(helo, nil)
+	helo = msgp.AppendString(helo, "keepalive")
+	helo = msgp.AppendBool(helo, true)
+	if _, err := conn.Write(helo); err != nil {
+		return nil, err
+	}
+
+	b, err := rd.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	ping, _, err := msgp.ParseSlice(b, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(ping) < 4 || ping[0] != "PING" {
+		return nil, errors.New("fluent: expected PING message")
+	}
+	clientHostname := fluentString(ping[1])
+	salt := fluentString(ping[2])
+	digest := fluentString(ping[3])
+
+	projects, err := s.Projects.SelectAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var project *org.Project
+	for _, p := range projects {
+		if fluentDigest(salt, clientHostname, nonce, p.Token) == digest {
+			project = p
+			break
+		}
+	}
+
+	pong := msgp.AppendArrayLen(nil, 5)
+	pong = msgp.AppendString(pong, "PONG")
+	if project == nil {
+		pong = msgp.AppendBool(pong, false)
+		pong = msgp.AppendString(pong, "shared_key mismatch")
+		pong = msgp.AppendString(pong, s.hostname)
+		pong = msgp.AppendString(pong, "")
+		_, _ = conn.Write(pong)
+		return nil, errors.New("fluent: shared_key does not match any project token")
+	}
+
+	pong = msgp.AppendBool(pong, true)
+	pong = msgp.AppendString(pong, "")
+	pong = msgp.AppendString(pong, s.hostname)
+	pong = msgp.AppendString(pong, fluentDigest(salt, s.hostname, nonce, project.Token))
+	if _, err := conn.Write(pong); err != nil {
+		return nil, err
+	}
+
+	return project, nil
+}
+
+func fluentDigest(salt, hostname string, nonce []byte, sharedKey string) string {
+	h := sha512.New()
+	h.Write([]byte(salt))
+	h.Write([]byte(hostname))
+	h.Write(nonce)
+	h.Write([]byte(sharedKey))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fluentString returns the value that can be encoded either as str or bin.
+func fluentString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return ""
+	}
+}
+
+//------------------------------------------------------------------------------
+
+// fluentReader splits a stream into MessagePack objects.
+type fluentReader struct {
+	rd       io.Reader
+	buf      []byte
+	consumed int
+}
+
+func (r *fluentReader) Next() ([]byte, error) {
+	if r.consumed > 0 {
+		r.buf = append(r.buf[:0], r.buf[r.consumed:]...)
+		r.consumed = 0
+	}
+
+	for {
+		if len(r.buf) > 0 {
+			rest, err := msgp.Skip(r.buf)
+			if err == nil {
+				r.consumed = len(r.buf) - len(rest)
+				return r.buf[:r.consumed], nil
+			}
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, err
+			}
+			if len(r.buf) >= fluentMaxMessageSize {
+				return nil, fmt.Errorf("fluent: message is larger than %d bytes", fluentMaxMessageSize)
+			}
+		}
+
+		if cap(r.buf)-len(r.buf) < 32<<10 {
+			buf := make([]byte, len(r.buf), 2*cap(r.buf)+64<<10)
+			copy(buf, r.buf)
+			r.buf = buf
+		}
+
+		n, err := r.rd.Read(r.buf[len(r.buf):cap(r.buf)])
+		r.buf = r.buf[:len(r.buf)+n]
+		if err != nil {
+			if err == io.EOF && len(r.buf) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+}
+
+//------------------------------------------------------------------------------
+
+type fluentLogProcessor struct {
+	vectorLogProcessor
+
+	consumer *LogConsumer
+	project  *org.Project
+}
+
+// processMessage handles Message, Forward, PackedForward, and CompressedPackedForward modes
+// and returns the chunk id that must be acknowledged.
+func (p *fluentLogProcessor) processMessage(ctx context.Context, b []byte) (string, error) {
+	return parseFluentMessage(b, func(tag string, tm time.Time, record map[string]any) {
+		p.processRecord(ctx, tag, tm, record)
+	})
+}
+
+type fluentRecordFunc func(tag string, tm time.Time, record map[string]any)
+
+func parseFluentMessage(b []byte, fn fluentRecordFunc) (string, error) {
+	n, b, err := msgp.ParseArrayLen(b)
+	if err != nil {
+		return "", err
+	}
+	if n < 2 {
+		return "", fmt.Errorf("fluent: got message with %d elements", n)
+	}
+
+	tag, b, err := msgp.ParseString(b, 0)
+	if err != nil {
+		return "", err
+	}
+	if len(b) == 0 {
+		return "", io.ErrUnexpectedEOF
+	}
+
+	c := b[0]
+	switch {
+	case msgpcode.IsFixedArray(c) || c == msgpcode.Array16 || c == msgpcode.Array32:
+		// Forward: [tag, [[time, record], ...], option]
+		entriesLen, rest, err := msgp.ParseArrayLen(b)
+		if err != nil {
+			return "", err
+		}
+		b = rest
+
+		for i := 0; i < entriesLen; i++ {
+			b, err = parseFluentEntry(b, tag, fn)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		option, err := parseFluentOption(b, n-2)
+		if err != nil {
+			return "", err
+		}
+		return option.Chunk, nil
+
+	case msgpcode.IsString(c) || msgpcode.IsBin(c):
+		// PackedForward: [tag, bin(entries), option]
+		entries, rest, err := msgp.ParseBytes(b, msgp.ZeroCopyBytes)
+		if err != nil {
+			return "", err
+		}
+
+		option, err := parseFluentOption(rest, n-2)
+		if err != nil {
+			return "", err
+		}
+
+		if option.Compressed == "gzip" {
+			zr, err := gzip.NewReader(bytes.NewReader(entries))
+			if err != nil {
+				return "", err
+			}
+			entries, err = io.ReadAll(zr)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		for len(entries) > 0 {
+			entries, err = parseFluentEntry(entries, tag, fn)
+			if err != nil {
+				return "", err
+			}
+		}
+		return option.Chunk, nil
+
+	default:
+		// Message: [tag, time, record, option]
+		if n < 3 {
+			return "", fmt.Errorf("fluent: got message with %d elements", n)
+		}
+
+		tm, rest, err := parseFluentTime(b)
+		if err != nil {
+			return "", err
+		}
+
+		record, rest, err := msgp.ParseMapStringAny(rest, 0)
+		if err != nil {
+			return "", err
+		}
+		fn(tag, tm, record)
+
+		option, err := parseFluentOption(rest, n-3)
+		if err != nil {
+			return "", err
+		}
+		return option.Chunk, nil
+	}
+}
+
+func parseFluentEntry(b []byte, tag string, fn fluentRecordFunc) ([]byte, error) {
+	n, b, err := msgp.ParseArrayLen(b)
+	if err != nil {
+		return nil, err
+	}
+	if n != 2 {
+		return nil, fmt.Errorf("fluent: got entry with %d elements", n)
+	}
+
+	tm, b, err := parseFluentTime(b)
+	if err != nil {
+		return nil, err
+	}
+
+	record, b, err := msgp.ParseMapStringAny(b, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fn(tag, tm, record)
+	return b, nil
+}
+
+func (p *fluentLogProcessor) processRecord(
+	ctx context.Context, tag string, tm time.Time, record map[string]any,
+) {
+	params := make(AttrMap, len(record)+1)
+	flattenParams(params, "", record)
+	params["fluent_tag"] = tag
+
+	span := new(Span)
+	span.Time = tm
+	p.spanFromVector(ctx, span, params)
+	span.Attrs[attrkey.TelemetrySDKName] = fluentSDK
+	span.ProjectID = p.project.ID
+
+	p.consumer.AddSpan(ctx, span)
+}
+
+// parseFluentTime parses either an integer number of seconds or the EventTime extension.
+func parseFluentTime(b []byte) (time.Time, []byte, error) {
+	if len(b) > 0 && msgpcode.IsExt(b[0]) {
+		extID, data, rest, err := msgp.ParseExt(b)
+		if err != nil {
+			return time.Time{}, nil, err
+		}
+		if extID != fluentEventTimeExtID || len(data) != 8 {
+			return time.Time{}, nil, fmt.Errorf("fluent: unsupported time ext id=%d", extID)
+		}
+		sec := binary.BigEndian.Uint32(data[:4])
+		nsec := binary.BigEndian.Uint32(data[4:])
+		return time.Unix(int64(sec), int64(nsec)), rest, nil
+	}
+
+	v, rest, err := msgp.ParseAny(b, 0)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	switch v := v.(type) {
+	case int64:
+		return time.Unix(v, 0), rest, nil
+	case uint64:
+		return time.Unix(int64(v), 0), rest, nil
+	case float64:
+		return time.Unix(0, int64(v*float64(time.Second))), rest, nil
+	case nil:
+		return time.Now(), rest, nil
+	default:
+		return time.Time{}, nil, fmt.Errorf("fluent: unsupported time type %T", v)
+	}
+}
+
+type fluentOption struct {
+	Chunk      string
+	Compressed string
+}
+
+func parseFluentOption(b []byte, n int) (*fluentOption, error) {
+	option := new(fluentOption)
+	if n <= 0 {
+		return option, nil
+	}
+
+	m, _, err := msgp.ParseMapStringAny(b, 0)
+	if err != nil {
+		return nil, err
+	}
+	option.Chunk, _ = m["chunk"].(string)
+	option.Compressed, _ = m["compressed"].(string)
+	return option, nil
+}
@@ -0,0 +1,291 @@
+package tracing
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uptrace/pkg/msgp"
+)
+
+type fluentTestRecord struct {
+	tag    string
+	time   time.Time
+	record map[string]any
+}
+
+func TestParseFluentMessage(t *testing.T) {
+	tm := time.Unix(1700000000, 0)
+
+	appendRecord := func(b []byte, msg string) []byte {
+		b = msgp.AppendMapLen(b, 1)
+		b = msgp.AppendString(b, "message")
+		return msgp.AppendString(b, msg)
+	}
+	appendEntry := func(b []byte, msg string) []byte {
+		b = msgp.AppendArrayLen(b, 2)
+		b = msgp.AppendVarint(b, tm.Unix())
+		return appendRecord(b, msg)
+	}
+	appendOption := func(b []byte, kv ...string) []byte {
+		b = msgp.AppendMapLen(b, len(kv)/2)
+		for _, s := range kv {
+			b = msgp.AppendString(b, s)
+		}
+		return b
+	}
+	packedEntries := func() []byte {
+		b := appendEntry(nil, "foo")
+		return appendEntry(b, "bar")
+	}
+	gzipEntries := func() []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write(packedEntries())
+		_ = zw.Close()
+		return buf.Bytes()
+	}
+
+	type Test struct {
+		name    string
+		msg     []byte
+		chunk   string
+		records []string
+		time    time.Time
+		wantErr bool
+	}
+
+	tests := []Test{
+		{
+			name: "message",
+			msg: func() []byte {
+				b := msgp.AppendArrayLen(nil, 3)
+				b = msgp.AppendString(b, "app")
+				b = msgp.AppendVarint(b, tm.Unix())
+				return appendRecord(b, "foo")
+			}(),
+			records: []string{"foo"},
+			time:    tm,
+		},
+		{
+			name: "message with option",
+			msg: func() []byte {
+				b := msgp.AppendArrayLen(nil, 4)
+				b = msgp.AppendString(b, "app")
+				b = msgp.AppendVarint(b, tm.Unix())
+				b = appendRecord(b, "foo")
+				return appendOption(b, "chunk", "c1")
+			}(),
+			chunk:   "c1",
+			records: []string{"foo"},
+			time:    tm,
+		},
+		{
+			name: "message with event time",
+			msg: func() []byte {
+				b := msgp.AppendArrayLen(nil, 3)
+				b = msgp.AppendString(b, "app")
+				b = append(b, 0xd7, fluentEventTimeExtID, 0x65, 0x53, 0xf1, 0x00, 0x00, 0x00, 0x00, 0x7b)
+				return appendRecord(b, "foo")
+			}(),
+			records: []string{"foo"},
+			time:    time.Unix(1700000000, 123),
+		},
+		{
+			name: "forward",
+			msg: func() []byte {
+				b := msgp.AppendArrayLen(nil, 3)
+				b = msgp.AppendString(b, "app")
+				b = msgp.AppendArrayLen(b, 2)
+				b = appendEntry(b, "foo")
+				b = appendEntry(b, "bar")
+				return appendOption(b, "chunk", "c2")
+			}(),
+			chunk:   "c2",
+			records: []string{"foo", "bar"},
+			time:    tm,
+		},
+		{
+			name: "packed forward",
+			msg: func() []byte {
+				b := msgp.AppendArrayLen(nil, 2)
+				b = msgp.AppendString(b, "app")
+				return msgp.AppendBytes(b, packedEntries())
+			}(),
+			records: []string{"foo", "bar"},
+			time:    tm,
+		},
+		{
+			name: "compressed packed forward",
+			msg: func() []byte {
+				b := msgp.AppendArrayLen(nil, 3)
+				b = msgp.AppendString(b, "app")
+				b = msgp.AppendBytes(b, gzipEntries())
+				return appendOption(b, "chunk", "c3", "compressed", "gzip")
+			}(),
+			chunk:   "c3",
+			records: []string{"foo", "bar"},
+			time:    tm,
+		},
+		{
+			name:    "not an array",
+			msg:     msgp.AppendString(nil, "app"),
+			wantErr: true,
+		},
+		{
+			name: "too few elements",
+			msg: func() []byte {
+				b := msgp.AppendArrayLen(nil, 1)
+				return msgp.AppendString(b, "app")
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "tag is not a string",
+			msg: func() []byte {
+				b := msgp.AppendArrayLen(nil, 3)
+				b = msgp.AppendVarint(b, 1)
+				b = msgp.AppendVarint(b, tm.Unix())
+				return appendRecord(b, "foo")
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "message without record",
+			msg: func() []byte {
+				b := msgp.AppendArrayLen(nil, 2)
+				b = msgp.AppendString(b, "app")
+				return msgp.AppendVarint(b, tm.Unix())
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "unsupported time type",
+			msg: func() []byte {
+				b := msgp.AppendArrayLen(nil, 3)
+				b = msgp.AppendString(b, "app")
+				b = msgp.AppendBool(b, true)
+				return appendRecord(b, "foo")
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "unsupported time ext",
+			msg: func() []byte {
+				b := msgp.AppendArrayLen(nil, 3)
+				b = msgp.AppendString(b, "app")
+				b = append(b, 0xd7, 0x01, 0, 0, 0, 0, 0, 0, 0, 0)
+				return appendRecord(b, "foo")
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "forward entry with 3 elements",
+			msg: func() []byte {
+				b := msgp.AppendArrayLen(nil, 2)
+				b = msgp.AppendString(b, "app")
+				b = msgp.AppendArrayLen(b, 1)
+				b = msgp.AppendArrayLen(b, 3)
+				b = msgp.AppendVarint(b, tm.Unix())
+				b = appendRecord(b, "foo")
+				return msgp.AppendNil(b)
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "invalid gzip",
+			msg: func() []byte {
+				b := msgp.AppendArrayLen(nil, 3)
+				b = msgp.AppendString(b, "app")
+				b = msgp.AppendBytes(b, []byte("not gzip"))
+				return appendOption(b, "compressed", "gzip")
+			}(),
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var records []fluentTestRecord
+			chunk, err := parseFluentMessage(test.msg, func(tag string, tm time.Time, record map[string]any) {
+				records = append(records, fluentTestRecord{tag: tag, time: tm, record: record})
+			})
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.chunk, chunk)
+
+			require.Len(t, records, len(test.records))
+			for i, rec := range records {
+				require.Equal(t, "app", rec.tag)
+				require.True(t, test.time.Equal(rec.time), "got %s", rec.time)
+				require.Equal(t, test.records[i], rec.record["message"])
+			}
+		})
+	}
+
+	t.Run("truncated", func(t *testing.T) {
+		for _, test := range tests {
+			if test.wantErr {
+				continue
+			}
+			for i := 0; i < len(test.msg); i++ {
+				_, err := parseFluentMessage(test.msg[:i], func(string, time.Time, map[string]any) {})
+				require.Error(t, err, "%s: %d of %d bytes", test.name, i, len(test.msg))
+			}
+		}
+	})
+}
+
+func TestFluentReader(t *testing.T) {
+	msg1 := msgp.AppendArrayLen(nil, 2)
+	msg1 = msgp.AppendString(msg1, "PING")
+	msg1 = msgp.AppendString(msg1, "host")
+
+	msg2 := msgp.AppendMapLen(nil, 1)
+	msg2 = msgp.AppendString(msg2, "ack")
+	msg2 = msgp.AppendString(msg2, "c1")
+
+	stream := append(append([]byte(nil), msg1...), msg2...)
+
+	t.Run("split reads", func(t *testing.T) {
+		rd := &fluentReader{rd: iotest.OneByteReader(bytes.NewReader(stream))}
+
+		b, err := rd.Next()
+		require.NoError(t, err)
+		require.Equal(t, msg1, b)
+
+		b, err = rd.Next()
+		require.NoError(t, err)
+		require.Equal(t, msg2, b)
+
+		_, err = rd.Next()
+		require.Equal(t, io.EOF, err)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		rd := &fluentReader{rd: bytes.NewReader(stream[:len(stream)-1])}
+
+		b, err := rd.Next()
+		require.NoError(t, err)
+		require.Equal(t, msg1, b)
+
+		_, err = rd.Next()
+		require.Equal(t, io.ErrUnexpectedEOF, err)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		rd := &fluentReader{rd: bytes.NewReader([]byte{0xc1})}
+
+		_, err := rd.Next()
+		require.Error(t, err)
+		require.NotEqual(t, io.ErrUnexpectedEOF, err)
+	})
+}
@@ -3,6 +3,7 @@ package tracing
 import (
 	"context"
 
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	collectorlogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
 	collectortracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
@@ -28,45 +29,93 @@ var spanCounter, _ = bunotel.Meter.Int64Counter(
 	metric.WithDescription("Number of processed spans"),
 )
 
+// countThrottled reports spans rejected because of the project rate limits.
+func countThrottled(ctx context.Context, projectID uint32, n int64) {
+	spanCounter.Add(
+		ctx,
+		n,
+		metric.WithAttributes(
+			bunotel.ProjectIDAttr(projectID),
+			attribute.String("type", "throttled"),
+		),
+	)
+}
+
 var Module = fx.Module("tracing",
 	fx.Provide(
 		fx.Private,
 
 		org.NewMiddleware,
+		NewServiceGraphProcessor,
 		NewSpanConsumer,
 		NewLogConsumer,
 		NewEventConsumer,
+		NewSpanDispatcher,
 		NewTraceServiceServer,
 		NewLogsServiceServer,
+		NewJaegerCollectorServer,
 
 		NewVectorHandler,
+		NewZipkinHandler,
+		NewSentryHandler,
+		NewLokiHandler,
+		NewElasticsearchHandler,
+		NewDatadogHandler,
+		NewSplunkHECHandler,
+		NewCloudwatchLogsHandler,
+		NewFluentForwardServer,
+		NewSyslogServer,
 		NewSystemHandler,
 		NewAttrHandler,
 		NewSpanHandler,
 		NewGroupHandler,
 		NewPublicHandler,
 		NewTraceHandler,
+		NewTempoHandler,
+		NewJaegerQueryHandler,
+		NewServiceGraphHandler,
+	),
+	fx.Provide(
+		fx.Annotate(
+			provideServiceGraphSpanProcessor,
+			fx.ResultTags(`group:"span_processors"`),
+		),
 	),
 	fx.Invoke(
 		registerVectorHandler,
+		registerZipkinHandler,
+		registerSentryHandler,
+		registerLokiHandler,
+		registerElasticsearchHandler,
+		registerDatadogHandler,
+		registerSplunkHECHandler,
+		registerCloudwatchLogsHandler,
+		runFluentForwardServer,
+		runSyslogServer,
 		registerSystemHandler,
 		registerAttrHandler,
 		registerSpanHandler,
 		registerGroupHandler,
 		registerPublicHandler,
 		registerTraceHandler,
+		registerTempoHandler,
+		registerJaegerQueryHandler,
+		registerServiceGraphHandler,
 
 		initOTLP,
 		runConsumers,
+		runServiceGraphProcessor,
+		runJaegerGRPCServer,
 	),
 )
 
 type OTLPParams struct {
 	fx.In
 
-	GRPC        *grpc.Server
-	TraceServer *TraceServiceServer
-	LogsServer  *LogsServiceServer
+	GRPC         *grpc.Server
+	TraceServer  *TraceServiceServer
+	LogsServer   *LogsServiceServer
+	JaegerServer *JaegerCollectorServer
 }
 
 func initOTLP(p OTLPParams, router bunapp.RouterParams) {
@@ -75,6 +124,7 @@ func initOTLP(p OTLPParams, router bunapp.RouterParams) {
 
 	router.Router.POST("/v1/traces", p.TraceServer.ExportHTTP)
 	router.Router.POST("/v1/logs", p.LogsServer.ExportHTTP)
+	router.Router.POST("/api/traces", p.JaegerServer.ExportHTTP)
 }
 
 func runConsumers(
@@ -0,0 +1,469 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	gogoproto "github.com/gogo/protobuf/proto"
+	jaegermodel "github.com/jaegertracing/jaeger-idl/model/v1"
+	"github.com/jaegertracing/jaeger-idl/proto-gen/api_v2"
+	"github.com/jaegertracing/jaeger-idl/thrift-gen/jaeger"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	grpcproto "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/mem"
+	"google.golang.org/grpc/status"
+
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/org"
+	"github.com/uptrace/uptrace/pkg/run"
+)
+
+const (
+	thriftContentType       = "application/x-thrift"
+	thriftBinaryContentType = "application/vnd.apache.thrift.binary"
+)
+
+type JaegerCollectorServerParams struct {
+	fx.In
+
+	Logger     *otelzap.Logger
+	Projects   *org.ProjectGateway
+	Dispatcher *SpanDispatcher
+}
+
+// JaegerCollectorServer accepts spans from Jaeger clients and agents
+// using the collector gRPC API and Thrift over HTTP.
+type JaegerCollectorServer struct {
+	api_v2.UnimplementedCollectorServiceServer
+	*JaegerCollectorServerParams
+}
+
+var _ api_v2.CollectorServiceServer = (*JaegerCollectorServer)(nil)
+
+func NewJaegerCollectorServer(p JaegerCollectorServerParams) *JaegerCollectorServer {
+	return &JaegerCollectorServer{
+		JaegerCollectorServerParams: &p,
+	}
+}
+
+func (s *JaegerCollectorServer) PostSpans(
+	ctx context.Context, req *api_v2.PostSpansRequest,
+) (*api_v2.PostSpansResponse, error) {
+	if ctx.Err() == context.Canceled {
+		return nil, status.Error(codes.Canceled, "Client cancelled, abandoning.")
+	}
+
+	dsn, err := org.DSNFromMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	project, err := s.Projects.SelectByDSN(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.Int64("project", int64(project.ID)))
+	}
+
+	s.process(ctx, project, &req.Batch)
+	return new(api_v2.PostSpansResponse), nil
+}
+
+func (s *JaegerCollectorServer) ExportHTTP(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	dsn, err := org.DSNFromRequest(req)
+	if err != nil {
+		return err
+	}
+
+	project, err := s.Projects.SelectByDSN(ctx, dsn)
+	if err != nil {
+		return err
+	}
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.Int64("project", int64(project.ID)))
+	}
+
+	switch contentType := req.Header.Get("Content-Type"); contentType {
+	case thriftContentType, thriftBinaryContentType:
+	default:
+		return fmt.Errorf("unsupported content type: %q", contentType)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+
+	tbatch := jaeger.NewBatch()
+	if err := thrift.NewTDeserializer().Read(ctx, tbatch, body); err != nil {
+		return fmt.Errorf("can't decode Jaeger Thrift batch: %w", err)
+	}
+
+	s.process(ctx, project, jaegerBatchFromThrift(tbatch))
+
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+func (s *JaegerCollectorServer) process(
+	ctx context.Context, project *org.Project, batch *jaegermodel.Batch,
+) {
+	for _, jspan := range batch.Spans {
+		process := jspan.Process
+		if process == nil {
+			process = batch.Process
+		}
+
+		span := new(Span)
+		initSpanFromJaeger(span, jspan, process)
+		span.ProjectID = project.ID
+		s.Dispatcher.AddSpan(ctx, span)
+	}
+}
+
+//------------------------------------------------------------------------------
+
+func initSpanFromJaeger(dest *Span, src *jaegermodel.Span, process *jaegermodel.Process) {
+	dest.TraceID = idgen.NewTraceIDLowHigh(src.TraceID.High, src.TraceID.Low)
+	dest.ID = idgen.SpanIDFromUint64(uint64(src.SpanID))
+	dest.ParentID = idgen.SpanIDFromUint64(uint64(src.ParentSpanID()))
+
+	dest.Name = src.OperationName
+	dest.Kind = InternalSpanKind
+	dest.Time = src.StartTime
+	dest.Duration = src.Duration
+	dest.StatusCode = OKStatusCode
+
+	dest.Attrs = make(AttrMap, len(src.Tags)+len(process.GetTags())+1)
+	if process != nil {
+		if process.ServiceName != "" {
+			dest.Attrs[attrkey.ServiceName] = process.ServiceName
+		}
+		for i := range process.Tags {
+			setJaegerProcessTag(dest.Attrs, &process.Tags[i])
+		}
+	}
+	for i := range src.Tags {
+		setJaegerTag(dest, &src.Tags[i])
+	}
+
+	for i := range src.References {
+		ref := &src.References[i]
+		if ref.TraceID == src.TraceID && uint64(ref.SpanID) == uint64(dest.ParentID) {
+			continue
+		}
+		dest.Links = append(dest.Links, &SpanLink{
+			TraceID: idgen.NewTraceIDLowHigh(ref.TraceID.High, ref.TraceID.Low),
+			SpanID:  idgen.SpanIDFromUint64(uint64(ref.SpanID)),
+			Attrs:   NewAttrMap(),
+		})
+	}
+
+	dest.Events = make([]*SpanEvent, len(src.Logs))
+	for i := range src.Logs {
+		dest.Events[i] = newSpanEventFromJaeger(&src.Logs[i])
+	}
+}
+
+func setJaegerProcessTag(attrs AttrMap, kv *jaegermodel.KeyValue) {
+	switch kv.Key {
+	case "hostname":
+		attrs[attrkey.HostName] = kv.AsString()
+	case "ip":
+		attrs[attrkey.HostIP] = kv.AsString()
+	default:
+		setJaegerAttr(attrs, kv)
+	}
+}
+
+func setJaegerTag(span *Span, kv *jaegermodel.KeyValue) {
+	switch kv.Key {
+	case "span.kind":
+		span.Kind = jaegerSpanKind(kv.AsString())
+		return
+	case "error":
+		if kv.VType != jaegermodel.BoolType || kv.Bool() {
+			span.StatusCode = ErrorStatusCode
+		}
+		return
+	case "otel.status_code":
+		if strings.EqualFold(kv.AsString(), "error") {
+			span.StatusCode = ErrorStatusCode
+		}
+		return
+	case "otel.status_description":
+		span.StatusMessage = kv.AsString()
+		return
+	case "otel.scope.name", "otel.library.name":
+		span.Attrs[attrkey.OtelLibraryName] = kv.AsString()
+		return
+	case "otel.scope.version", "otel.library.version":
+		span.Attrs[attrkey.OtelLibraryVersion] = kv.AsString()
+		return
+	case "internal.span.format", "sampler.type", "sampler.param":
+		return
+	}
+	setJaegerAttr(span.Attrs, kv)
+}
+
+func setJaegerAttr(attrs AttrMap, kv *jaegermodel.KeyValue) {
+	key := attrkey.Clean(kv.Key)
+	if key == "" {
+		return
+	}
+
+	switch kv.VType {
+	case jaegermodel.StringType:
+		attrs[key] = kv.VStr
+	case jaegermodel.BoolType:
+		attrs[key] = kv.VBool
+	case jaegermodel.Int64Type:
+		attrs[key] = kv.VInt64
+	case jaegermodel.Float64Type:
+		attrs[key] = kv.VFloat64
+	default:
+		attrs[key] = kv.AsString()
+	}
+}
+
+// newSpanEventFromJaeger converts a Jaeger span log using the OpenTracing conventions,
+// for example, event=error logs become exceptions.
+func newSpanEventFromJaeger(log *jaegermodel.Log) *SpanEvent {
+	event := &SpanEvent{
+		Name:  otelEventLog,
+		Time:  log.Timestamp,
+		Attrs: make(AttrMap, len(log.Fields)),
+	}
+
+	for i := range log.Fields {
+		kv := &log.Fields[i]
+		switch kv.Key {
+		case "event":
+			event.Name = kv.AsString()
+		case "message":
+			event.Attrs[attrkey.LogMessage] = kv.AsString()
+		case "level":
+			event.Attrs[attrkey.LogSeverity] = kv.AsString()
+		default:
+			setJaegerAttr(event.Attrs, kv)
+		}
+	}
+
+	if event.Name != otelEventError {
+		return event
+	}
+
+	event.Name = otelEventException
+	if v, ok := event.Attrs["error_kind"]; ok {
+		delete(event.Attrs, "error_kind")
+		event.Attrs[attrkey.ExceptionType] = v
+	}
+	if v, ok := event.Attrs[attrkey.LogMessage]; ok {
+		delete(event.Attrs, attrkey.LogMessage)
+		event.Attrs[attrkey.ExceptionMessage] = v
+	} else if v, ok := event.Attrs["error_object"]; ok {
+		event.Attrs[attrkey.ExceptionMessage] = v
+	}
+	if v, ok := event.Attrs["stack"]; ok {
+		delete(event.Attrs, "stack")
+		event.Attrs[attrkey.ExceptionStacktrace] = v
+	}
+	return event
+}
+
+func jaegerSpanKind(kind string) string {
+	switch kind {
+	case "server":
+		return ServerSpanKind
+	case "client":
+		return ClientSpanKind
+	case "producer":
+		return ProducerSpanKind
+	case "consumer":
+		return ConsumerSpanKind
+	}
+	return InternalSpanKind
+}
+
+//------------------------------------------------------------------------------
+
+func jaegerBatchFromThrift(src *jaeger.Batch) *jaegermodel.Batch {
+	batch := &jaegermodel.Batch{
+		Spans: make([]*jaegermodel.Span, len(src.Spans)),
+	}
+	if src.Process != nil {
+		batch.Process = &jaegermodel.Process{
+			ServiceName: src.Process.ServiceName,
+			Tags:        jaegerTagsFromThrift(src.Process.Tags),
+		}
+	}
+
+	for i, tspan := range src.Spans {
+		traceID := jaegermodel.NewTraceID(uint64(tspan.TraceIdHigh), uint64(tspan.TraceIdLow))
+
+		span := &jaegermodel.Span{
+			TraceID:       traceID,
+			SpanID:        jaegermodel.NewSpanID(uint64(tspan.SpanId)),
+			OperationName: tspan.OperationName,
+			Flags:         jaegermodel.Flags(tspan.Flags),
+			StartTime:     time.UnixMicro(tspan.StartTime),
+			Duration:      time.Duration(tspan.Duration) * time.Microsecond,
+			Tags:          jaegerTagsFromThrift(tspan.Tags),
+		}
+
+		span.References = make([]jaegermodel.SpanRef, 0, len(tspan.References)+1)
+		for _, ref := range tspan.References {
+			refType := jaegermodel.ChildOf
+			if ref.RefType == jaeger.SpanRefType_FOLLOWS_FROM {
+				refType = jaegermodel.FollowsFrom
+			}
+			span.References = append(span.References, jaegermodel.SpanRef{
+				TraceID: jaegermodel.NewTraceID(uint64(ref.TraceIdHigh), uint64(ref.TraceIdLow)),
+				SpanID:  jaegermodel.NewSpanID(uint64(ref.SpanId)),
+				RefType: refType,
+			})
+		}
+		span.References = jaegermodel.MaybeAddParentSpanID(
+			traceID, jaegermodel.NewSpanID(uint64(tspan.ParentSpanId)), span.References)
+
+		span.Logs = make([]jaegermodel.Log, len(tspan.Logs))
+		for j, log := range tspan.Logs {
+			span.Logs[j] = jaegermodel.Log{
+				Timestamp: time.UnixMicro(log.Timestamp),
+				Fields:    jaegerTagsFromThrift(log.Fields),
+			}
+		}
+
+		batch.Spans[i] = span
+	}
+
+	return batch
+}
+
+func jaegerTagsFromThrift(tags []*jaeger.Tag) []jaegermodel.KeyValue {
+	kvs := make([]jaegermodel.KeyValue, 0, len(tags))
+	for _, tag := range tags {
+		switch tag.VType {
+		case jaeger.TagType_STRING:
+			kvs = append(kvs, jaegermodel.String(tag.Key, tag.GetVStr()))
+		case jaeger.TagType_DOUBLE:
+			kvs = append(kvs, jaegermodel.Float64(tag.Key, tag.GetVDouble()))
+		case jaeger.TagType_BOOL:
+			kvs = append(kvs, jaegermodel.Bool(tag.Key, tag.GetVBool()))
+		case jaeger.TagType_LONG:
+			kvs = append(kvs, jaegermodel.Int64(tag.Key, tag.GetVLong()))
+		case jaeger.TagType_BINARY:
+			kvs = append(kvs, jaegermodel.Binary(tag.Key, tag.GetVBinary()))
+		}
+	}
+	return kvs
+}
+
+//------------------------------------------------------------------------------
+
+// runJaegerGRPCServer serves the Jaeger collector gRPC API on a separate server,
+// because the Jaeger messages require a codec that must not replace the codec
+// used by the OTLP server.
+func runJaegerGRPCServer(
+	group *run.Group, conf *bunconf.Config, logger *otelzap.Logger, jaegerServer *JaegerCollectorServer,
+) error {
+	opts := []grpc.ServerOption{
+		grpc.ForceServerCodecV2(jaegerCodec{
+			CodecV2: encoding.GetCodecV2(grpcproto.Name),
+		}),
+		grpc.UnaryInterceptor(otelgrpc.UnaryServerInterceptor()),
+		grpc.MaxRecvMsgSize(32 << 20),
+	}
+
+	if conf.Listen.TLS != nil {
+		tlsConf, err := conf.Listen.TLS.TLSConfig()
+		if err != nil {
+			return err
+		}
+		if tlsConf != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConf)))
+		}
+	}
+
+	srv := grpc.NewServer(opts...)
+	api_v2.RegisterCollectorServiceServer(srv, jaegerServer)
+
+	addr := conf.Listen.JaegerGRPC.Addr
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Error("net.Listen failed (edit listen.jaeger_grpc YAML option)",
+			zap.Error(err), zap.String("addr", addr))
+		return err
+	}
+
+	group.Add("tracing.JaegerGRPC.Serve", func() error {
+		return srv.Serve(ln)
+	})
+	group.OnStop(func(context.Context, error) error {
+		srv.Stop()
+		return nil
+	})
+
+	return nil
+}
+
+// jaegerCodec uses gogo/protobuf to (un)marshal Jaeger messages,
+// because they use gogo custom types that the standard codec does not support.
+// Other messages are handled by the standard proto codec.
+type jaegerCodec struct {
+	encoding.CodecV2
+}
+
+func (c jaegerCodec) Marshal(v any) (mem.BufferSlice, error) {
+	if msg, ok := jaegerMessage(v); ok {
+		b, err := gogoproto.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		return mem.BufferSlice{mem.SliceBuffer(b)}, nil
+	}
+	return c.CodecV2.Marshal(v)
+}
+
+func (c jaegerCodec) Unmarshal(data mem.BufferSlice, v any) error {
+	if msg, ok := jaegerMessage(v); ok {
+		return gogoproto.Unmarshal(data.Materialize(), msg)
+	}
+	return c.CodecV2.Unmarshal(data, v)
+}
+
+func jaegerMessage(v any) (gogoproto.Message, bool) {
+	msg, ok := v.(gogoproto.Message)
+	if !ok {
+		return nil, false
+	}
+	typ := reflect.TypeOf(v)
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	return msg, strings.HasPrefix(typ.PkgPath(), "github.com/jaegertracing/")
+}
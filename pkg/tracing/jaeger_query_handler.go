@@ -0,0 +1,651 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"go.uber.org/fx"
+	"golang.org/x/exp/slices"
+
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/pkg/clickhouse/ch"
+	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/httperror"
+	"github.com/uptrace/uptrace/pkg/httputil"
+	"github.com/uptrace/uptrace/pkg/org"
+	"github.com/uptrace/uptrace/pkg/tracing/tql"
+)
+
+const (
+	jaegerSearchLimit    = 20
+	jaegerMaxSearchLimit = 1500
+	jaegerLookupPeriod   = 24 * time.Hour
+)
+
+type JaegerQueryHandlerParams struct {
+	fx.In
+
+	Logger *otelzap.Logger
+	CH     *ch.DB
+}
+
+// JaegerQueryHandler implements the HTTP API used by Jaeger UI. Responses use
+// the Jaeger JSON model.
+type JaegerQueryHandler struct {
+	*JaegerQueryHandlerParams
+}
+
+func NewJaegerQueryHandler(p JaegerQueryHandlerParams) *JaegerQueryHandler {
+	return &JaegerQueryHandler{&p}
+}
+
+func registerJaegerQueryHandler(h *JaegerQueryHandler, p bunapp.RouterParams, m *org.Middleware) {
+	p.Router.Use(m.UserAndProject).
+		WithGroup("/api/jaeger/:project_id/api", func(g *bunrouter.Group) {
+			g.GET("/services", h.Services)
+			g.GET("/services/:service/operations", h.ServiceOperations)
+			g.GET("/operations", h.Operations)
+			g.GET("/traces", h.Traces)
+			g.GET("/traces/:trace_id", h.Trace)
+		})
+}
+
+type JaegerResponse struct {
+	Data   any           `json:"data"`
+	Total  int           `json:"total"`
+	Limit  int           `json:"limit"`
+	Offset int           `json:"offset"`
+	Errors []JaegerError `json:"errors"`
+}
+
+type JaegerError struct {
+	Code    int    `json:"code"`
+	Msg     string `json:"msg"`
+	TraceID string `json:"traceID,omitempty"`
+}
+
+func (h *JaegerQueryHandler) Services(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+	f := h.spanFilter(req, time.Now().Add(-jaegerLookupPeriod), time.Now())
+
+	items, _, err := SelectAttrValues(ctx, h.CH, f, attrkey.ServiceName)
+	if err != nil {
+		return err
+	}
+
+	services := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.Value != "" {
+			services = append(services, item.Value)
+		}
+	}
+	slices.Sort(services)
+
+	return httputil.JSON(w, &JaegerResponse{
+		Data:  services,
+		Total: len(services),
+	})
+}
+
+type JaegerOperation struct {
+	Name     string `json:"name"`
+	SpanKind string `json:"spanKind"`
+}
+
+// ServiceOperations is the legacy endpoint that returns operation names only.
+func (h *JaegerQueryHandler) ServiceOperations(w http.ResponseWriter, req bunrouter.Request) error {
+	ops, err := h.selectOperations(req, req.Param("service"), "")
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(ops))
+	for _, op := range ops {
+		names = append(names, op.Name)
+	}
+	names = slices.Compact(names)
+
+	return httputil.JSON(w, &JaegerResponse{
+		Data:  names,
+		Total: len(names),
+	})
+}
+
+func (h *JaegerQueryHandler) Operations(w http.ResponseWriter, req bunrouter.Request) error {
+	query := req.URL.Query()
+
+	service := query.Get("service")
+	if service == "" {
+		return httperror.BadRequest("service_required", "service parameter is required")
+	}
+
+	ops, err := h.selectOperations(req, service, query.Get("spanKind"))
+	if err != nil {
+		return err
+	}
+
+	return httputil.JSON(w, &JaegerResponse{
+		Data:  ops,
+		Total: len(ops),
+	})
+}
+
+func (h *JaegerQueryHandler) selectOperations(
+	req bunrouter.Request, service, spanKind string,
+) ([]*JaegerOperation, error) {
+	ctx := req.Context()
+	f := h.spanFilter(req, time.Now().Add(-jaegerLookupPeriod), time.Now())
+
+	q, _ := BuildSpanIndexQuery(h.CH, f, 0)
+	q = q.
+		ColumnExpr("s.name").
+		ColumnExpr("s.kind AS span_kind").
+		Where("s.service_name = ?", service).
+		GroupExpr("s.name, s.kind").
+		OrderExpr("s.name ASC, s.kind ASC").
+		Limit(10000)
+	if spanKind != "" {
+		q = q.Where("s.kind = ?", jaegerSpanKind(spanKind))
+	}
+
+	ops := make([]*JaegerOperation, 0)
+	if err := q.Scan(ctx, &ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+func (h *JaegerQueryHandler) Traces(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+	query := req.URL.Query()
+
+	if ids := query["traceID"]; len(ids) > 0 {
+		return h.tracesByID(w, req, ids)
+	}
+
+	service := query.Get("service")
+	if service == "" {
+		return httperror.BadRequest("service_required", "service parameter is required")
+	}
+
+	timeGTE, timeLT, err := jaegerTimeRange(query)
+	if err != nil {
+		return err
+	}
+	f := h.spanFilter(req, timeGTE, timeLT)
+
+	where, err := parseJaegerTags(query)
+	if err != nil {
+		return httperror.BadRequest("invalid_tags", err.Error())
+	}
+	where.Filters = append(where.Filters, tql.Filter{
+		BoolOp: tql.BoolAnd,
+		LHS:    tql.Attr{Name: attrkey.ServiceName},
+		Op:     tql.FilterEqual,
+		RHS:    tql.StringValue{Text: service},
+	})
+	if operation := query.Get("operation"); operation != "" {
+		where.Filters = append(where.Filters, tql.Filter{
+			BoolOp: tql.BoolAnd,
+			LHS:    tql.Attr{Name: attrkey.SpanName},
+			Op:     tql.FilterEqual,
+			RHS:    tql.StringValue{Text: operation},
+		})
+	}
+	f.QueryParts = []*tql.QueryPart{{Query: query.Get("tags"), AST: where}}
+
+	limit := jaegerSearchLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return httperror.BadRequest("invalid_limit", "limit must be a positive integer")
+		}
+		if n > 0 {
+			limit = min(n, jaegerMaxSearchLimit)
+		}
+	}
+
+	traceIDs, err := searchTraceIDs(ctx, h.CH, f, query, limit)
+	if err != nil {
+		return err
+	}
+
+	traces, err := h.selectTraces(ctx, f.ProjectID, traceIDs)
+	if err != nil {
+		return err
+	}
+
+	return httputil.JSON(w, &JaegerResponse{
+		Data:  traces,
+		Total: len(traces),
+	})
+}
+
+func (h *JaegerQueryHandler) tracesByID(
+	w http.ResponseWriter, req bunrouter.Request, ids []string,
+) error {
+	ctx := req.Context()
+	project := h.project(req)
+
+	traceIDs := make([]idgen.TraceID, len(ids))
+	for i, id := range ids {
+		traceID, err := parseJaegerTraceID(id)
+		if err != nil {
+			return httperror.BadRequest("invalid_trace_id", err.Error())
+		}
+		traceIDs[i] = traceID
+	}
+
+	traces, err := h.selectTraces(ctx, project.ID, traceIDs)
+	if err != nil {
+		return err
+	}
+
+	var errs []JaegerError
+	if len(traces) < len(traceIDs) {
+		found := make(map[string]bool, len(traces))
+		for _, jtrace := range traces {
+			found[jtrace.TraceID] = true
+		}
+		for _, traceID := range traceIDs {
+			if !found[traceID.String()] {
+				errs = append(errs, JaegerError{
+					Code:    http.StatusNotFound,
+					Msg:     "trace not found",
+					TraceID: traceID.String(),
+				})
+			}
+		}
+	}
+
+	return httputil.JSON(w, &JaegerResponse{
+		Data:   traces,
+		Total:  len(traces),
+		Errors: errs,
+	})
+}
+
+func (h *JaegerQueryHandler) Trace(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+	project := h.project(req)
+
+	traceID, err := parseJaegerTraceID(req.Param("trace_id"))
+	if err != nil {
+		return httperror.BadRequest("invalid_trace_id", err.Error())
+	}
+
+	jtrace, err := h.selectTrace(ctx, project.ID, traceID)
+	if err != nil {
+		return err
+	}
+	if jtrace == nil {
+		return httperror.NotFound("Trace %q not found. Try again later.", traceID)
+	}
+
+	return httputil.JSON(w, &JaegerResponse{
+		Data:  []*JaegerTrace{jtrace},
+		Total: 1,
+	})
+}
+
+// selectTrace returns nil when the trace does not have any spans in the project.
+func (h *JaegerQueryHandler) selectTrace(
+	ctx context.Context, projectID uint32, traceID idgen.TraceID,
+) (*JaegerTrace, error) {
+	spans, err := selectProjectTraceSpans(ctx, h.CH, projectID, traceID)
+	if err != nil {
+		return nil, err
+	}
+	if len(spans) == 0 {
+		return nil, nil
+	}
+	return newJaegerTrace(traceID, spans), nil
+}
+
+// selectTraces selects the traces with a single query preserving the order
+// of the trace ids. Traces without spans in the project are omitted.
+func (h *JaegerQueryHandler) selectTraces(
+	ctx context.Context, projectID uint32, traceIDs []idgen.TraceID,
+) ([]*JaegerTrace, error) {
+	spansByTrace, err := selectProjectTracesSpans(ctx, h.CH, projectID, traceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	traces := make([]*JaegerTrace, 0, len(traceIDs))
+	for _, traceID := range traceIDs {
+		spans, ok := spansByTrace[traceID]
+		if !ok {
+			continue
+		}
+		delete(spansByTrace, traceID)
+		traces = append(traces, newJaegerTrace(traceID, spans))
+	}
+	return traces, nil
+}
+
+func (h *JaegerQueryHandler) project(req bunrouter.Request) *org.Project {
+	return traceQueryProject(req)
+}
+
+func (h *JaegerQueryHandler) spanFilter(
+	req bunrouter.Request, timeGTE, timeLT time.Time,
+) *SpanFilter {
+	project := h.project(req)
+
+	f := &SpanFilter{
+		TypeFilter: TypeFilter{
+			ProjectID: project.ID,
+			System:    []string{SystemSpansAll},
+		},
+	}
+	f.TimeGTE = timeGTE
+	f.TimeLT = timeLT
+	return f
+}
+
+// jaegerTimeRange parses the start and end params in Unix microseconds
+// falling back to the lookback param.
+func jaegerTimeRange(query map[string][]string) (time.Time, time.Time, error) {
+	get := func(key string) string {
+		if values := query[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	timeLT := time.Now()
+	if s := get("end"); s != "" {
+		end, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, time.Time{}, httperror.BadRequest("invalid_end", err.Error())
+		}
+		timeLT = time.UnixMicro(end + 1)
+	}
+
+	timeGTE := timeLT.Add(-time.Hour)
+	if s := get("start"); s != "" {
+		start, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, time.Time{}, httperror.BadRequest("invalid_start", err.Error())
+		}
+		timeGTE = time.UnixMicro(start)
+	} else if s := get("lookback"); s != "" && s != "custom" {
+		lookback, err := time.ParseDuration(s)
+		if err != nil {
+			return time.Time{}, time.Time{}, httperror.BadRequest("invalid_lookback", err.Error())
+		}
+		timeGTE = timeLT.Add(-lookback)
+	}
+
+	if timeGTE.After(timeLT) {
+		return time.Time{}, time.Time{}, httperror.BadRequest(
+			"invalid_time_range", "start can't be after end")
+	}
+	return timeGTE, timeLT, nil
+}
+
+// parseJaegerTags parses tags in the JSON format used by Jaeger UI, for example,
+// `{"http.status_code":"500"}`, and the repeated `tag=key:value` params.
+func parseJaegerTags(query map[string][]string) (*tql.Where, error) {
+	tags := make(map[string]string)
+
+	for _, s := range query["tags"] {
+		if err := json.Unmarshal([]byte(s), &tags); err != nil {
+			return nil, fmt.Errorf("can't parse tags: %w", err)
+		}
+	}
+	for _, s := range query["tag"] {
+		key, value, ok := strings.Cut(s, ":")
+		if !ok {
+			return nil, fmt.Errorf("tag %q must have the key:value format", s)
+		}
+		tags[key] = value
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	where := new(tql.Where)
+	for _, key := range keys {
+		if filter, ok := jaegerTagFilter(key, tags[key]); ok {
+			where.Filters = append(where.Filters, filter)
+		}
+	}
+	return where, nil
+}
+
+// jaegerTagFilter converts Jaeger tags to filters using the same conventions
+// as the Jaeger collector.
+func jaegerTagFilter(key, value string) (tql.Filter, bool) {
+	filter := tql.Filter{
+		BoolOp: tql.BoolAnd,
+		Op:     tql.FilterEqual,
+		RHS:    tql.StringValue{Text: value},
+	}
+
+	switch key {
+	case "span.kind":
+		filter.LHS = tql.Attr{Name: attrkey.SpanKind}
+		filter.RHS = tql.StringValue{Text: jaegerSpanKind(value)}
+	case "error":
+		filter.LHS = tql.Attr{Name: attrkey.SpanStatusCode}
+		filter.RHS = tql.StringValue{Text: ErrorStatusCode}
+		if value == "false" {
+			filter.Op = tql.FilterNotEqual
+		}
+	case "otel.status_code":
+		filter.LHS = tql.Attr{Name: attrkey.SpanStatusCode}
+		filter.RHS = tql.StringValue{Text: strings.ToLower(value)}
+	case "otel.status_description":
+		filter.LHS = tql.Attr{Name: attrkey.SpanStatusMessage}
+	case "otel.scope.name", "otel.library.name":
+		filter.LHS = tql.Attr{Name: attrkey.OtelLibraryName}
+	case "otel.scope.version", "otel.library.version":
+		filter.LHS = tql.Attr{Name: attrkey.OtelLibraryVersion}
+	case "hostname":
+		filter.LHS = tql.Attr{Name: attrkey.HostName}
+	default:
+		key = attrkey.Clean(key)
+		if key == "" {
+			return filter, false
+		}
+		filter.LHS = tql.Attr{Name: key}
+	}
+
+	return filter, true
+}
+
+// parseJaegerTraceID parses trace ids that may have leading zeros omitted.
+func parseJaegerTraceID(s string) (idgen.TraceID, error) {
+	if n := len(s); n > 0 && n < 32 {
+		s = strings.Repeat("0", 32-n) + s
+	}
+	return idgen.ParseTraceID(s)
+}
+
+//------------------------------------------------------------------------------
+
+type JaegerTrace struct {
+	TraceID   string                    `json:"traceID"`
+	Spans     []*JaegerSpan             `json:"spans"`
+	Processes map[string]*JaegerProcess `json:"processes"`
+	Warnings  []string                  `json:"warnings"`
+}
+
+type JaegerSpan struct {
+	TraceID       string            `json:"traceID"`
+	SpanID        string            `json:"spanID"`
+	Flags         uint32            `json:"flags"`
+	OperationName string            `json:"operationName"`
+	References    []JaegerReference `json:"references"`
+	StartTime     int64             `json:"startTime"`
+	Duration      int64             `json:"duration"`
+	Tags          []JaegerKeyValue  `json:"tags"`
+	Logs          []JaegerLog       `json:"logs"`
+	ProcessID     string            `json:"processID"`
+	Warnings      []string          `json:"warnings"`
+}
+
+type JaegerReference struct {
+	RefType string `json:"refType"`
+	TraceID string `json:"traceID"`
+	SpanID  string `json:"spanID"`
+}
+
+type JaegerKeyValue struct {
+	Key   string `json:"key"`
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+type JaegerLog struct {
+	Timestamp int64            `json:"timestamp"`
+	Fields    []JaegerKeyValue `json:"fields"`
+}
+
+type JaegerProcess struct {
+	ServiceName string           `json:"serviceName"`
+	Tags        []JaegerKeyValue `json:"tags"`
+}
+
+func newJaegerTrace(traceID idgen.TraceID, spans []*Span) *JaegerTrace {
+	out := &JaegerTrace{
+		TraceID:   traceID.String(),
+		Spans:     make([]*JaegerSpan, 0, len(spans)),
+		Processes: make(map[string]*JaegerProcess),
+	}
+	processIDs := make(map[string]string)
+
+	for _, span := range spans {
+		serviceName, _ := span.Attrs[attrkey.ServiceName].(string)
+
+		processID, ok := processIDs[serviceName]
+		if !ok {
+			processID = "p" + strconv.Itoa(len(processIDs)+1)
+			processIDs[serviceName] = processID
+			out.Processes[processID] = &JaegerProcess{
+				ServiceName: serviceName,
+				Tags:        make([]JaegerKeyValue, 0),
+			}
+		}
+
+		out.Spans = append(out.Spans, newJaegerSpan(span, processID))
+	}
+
+	return out
+}
+
+func newJaegerSpan(span *Span, processID string) *JaegerSpan {
+	out := &JaegerSpan{
+		TraceID:       span.TraceID.String(),
+		SpanID:        jaegerSpanID(span.ID),
+		Flags:         1,
+		OperationName: span.Name,
+		References:    make([]JaegerReference, 0, len(span.Links)+1),
+		StartTime:     span.Time.UnixMicro(),
+		Duration:      span.Duration.Microseconds(),
+		Logs:          make([]JaegerLog, 0, len(span.Events)),
+		ProcessID:     processID,
+	}
+
+	if !span.ParentID.IsZero() {
+		out.References = append(out.References, JaegerReference{
+			RefType: "CHILD_OF",
+			TraceID: span.TraceID.String(),
+			SpanID:  jaegerSpanID(span.ParentID),
+		})
+	}
+	for _, link := range span.Links {
+		out.References = append(out.References, JaegerReference{
+			RefType: "FOLLOWS_FROM",
+			TraceID: link.TraceID.String(),
+			SpanID:  jaegerSpanID(link.SpanID),
+		})
+	}
+
+	out.Tags = jaegerKeyValues(span.Attrs, attrkey.ServiceName)
+	if span.Kind != "" && span.Kind != InternalSpanKind {
+		out.Tags = append(out.Tags, newJaegerKeyValue("span.kind", span.Kind))
+	}
+	switch span.StatusCode {
+	case ErrorStatusCode:
+		out.Tags = append(out.Tags,
+			newJaegerKeyValue("otel.status_code", "ERROR"),
+			newJaegerKeyValue("error", true))
+	case OKStatusCode:
+		out.Tags = append(out.Tags, newJaegerKeyValue("otel.status_code", "OK"))
+	}
+	if span.StatusMessage != "" {
+		out.Tags = append(out.Tags,
+			newJaegerKeyValue("otel.status_description", span.StatusMessage))
+	}
+
+	for _, event := range span.Events {
+		fields := []JaegerKeyValue{newJaegerKeyValue("event", event.Name)}
+		fields = append(fields, jaegerKeyValues(event.Attrs, "")...)
+		out.Logs = append(out.Logs, JaegerLog{
+			Timestamp: event.Time.UnixMicro(),
+			Fields:    fields,
+		})
+	}
+
+	return out
+}
+
+func jaegerKeyValues(attrs AttrMap, skipKey string) []JaegerKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		if key != skipKey {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	kvs := make([]JaegerKeyValue, len(keys))
+	for i, key := range keys {
+		kvs[i] = newJaegerKeyValue(key, attrs[key])
+	}
+	return kvs
+}
+
+func newJaegerKeyValue(key string, value any) JaegerKeyValue {
+	switch value := value.(type) {
+	case string:
+		return JaegerKeyValue{Key: key, Type: "string", Value: value}
+	case bool:
+		return JaegerKeyValue{Key: key, Type: "bool", Value: value}
+	case int64:
+		return JaegerKeyValue{Key: key, Type: "int64", Value: value}
+	case int:
+		return JaegerKeyValue{Key: key, Type: "int64", Value: int64(value)}
+	case uint64:
+		return JaegerKeyValue{Key: key, Type: "int64", Value: int64(value)}
+	case float64:
+		return JaegerKeyValue{Key: key, Type: "float64", Value: value}
+	case float32:
+		return JaegerKeyValue{Key: key, Type: "float64", Value: float64(value)}
+	default:
+		// Jaeger does not support arrays and maps so they are encoded as JSON.
+		b, err := json.Marshal(value)
+		if err != nil {
+			return JaegerKeyValue{Key: key, Type: "string", Value: fmt.Sprint(value)}
+		}
+		return JaegerKeyValue{Key: key, Type: "string", Value: string(b)}
+	}
+}
+
+func jaegerSpanID(id idgen.SpanID) string {
+	return fmt.Sprintf("%016x", uint64(id))
+}
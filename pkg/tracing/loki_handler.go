@@ -0,0 +1,263 @@
+package tracing
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/grafana/loki/pkg/push"
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/segmentio/encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/bunutil"
+	"github.com/uptrace/uptrace/pkg/org"
+	"github.com/uptrace/uptrace/pkg/tracing/norm"
+)
+
+const lokiSDK = "loki"
+
+type LokiHandlerParams struct {
+	fx.In
+
+	Logger   *otelzap.Logger
+	Projects *org.ProjectGateway
+	Consumer *LogConsumer
+}
+
+// LokiHandler implements the Loki push API used by Promtail and Grafana Alloy.
+type LokiHandler struct {
+	*LokiHandlerParams
+}
+
+func NewLokiHandler(p LokiHandlerParams) *LokiHandler {
+	return &LokiHandler{&p}
+}
+
+func registerLokiHandler(h *LokiHandler, p bunapp.RouterParams) {
+	p.Router.WithGroup("/loki/api/v1", func(g *bunrouter.Group) {
+		g.POST("/push", h.Push)
+	})
+}
+
+func (h *LokiHandler) Push(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	dsn, err := org.DSNFromRequest(req)
+	if err != nil {
+		return err
+	}
+
+	project, err := h.Projects.SelectByDSN(ctx, dsn)
+	if err != nil {
+		return err
+	}
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.Int64("project", int64(project.ID)))
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+
+	var streams []lokiStream
+
+	switch contentType := req.Header.Get("Content-Type"); contentType {
+	case jsonContentType:
+		streams, err = decodeLokiJSON(body)
+	case xprotobufContentType, protobufContentType, "":
+		streams, err = decodeLokiProto(body)
+	default:
+		return fmt.Errorf("unsupported content type: %q", contentType)
+	}
+	if err != nil {
+		return err
+	}
+
+	p := new(lokiLogProcessor)
+
+	for i := range streams {
+		stream := &streams[i]
+		for j := range stream.Entries {
+			span := new(Span)
+			p.spanFromLoki(span, stream.Labels, &stream.Entries[j])
+			span.ProjectID = project.ID
+			h.Consumer.AddSpan(ctx, span)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+type lokiStream struct {
+	Labels  map[string]string
+	Entries []lokiEntry
+}
+
+type lokiEntry struct {
+	Time     time.Time
+	Line     string
+	Metadata map[string]string
+}
+
+func decodeLokiProto(body []byte) ([]lokiStream, error) {
+	b, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode snappy: %w", err)
+	}
+
+	pushReq := new(push.PushRequest)
+	if err := pushReq.Unmarshal(b); err != nil {
+		return nil, err
+	}
+
+	streams := make([]lokiStream, len(pushReq.Streams))
+	for i := range pushReq.Streams {
+		src := &pushReq.Streams[i]
+
+		labels, err := parseLokiLabels(src.Labels)
+		if err != nil {
+			return nil, err
+		}
+
+		stream := &streams[i]
+		stream.Labels = labels
+		stream.Entries = make([]lokiEntry, len(src.Entries))
+
+		for j := range src.Entries {
+			entry := &src.Entries[j]
+			stream.Entries[j] = lokiEntry{
+				Time: entry.Timestamp,
+				Line: entry.Line,
+			}
+			if len(entry.StructuredMetadata) > 0 {
+				md := make(map[string]string, len(entry.StructuredMetadata))
+				for _, l := range entry.StructuredMetadata {
+					md[l.Name] = l.Value
+				}
+				stream.Entries[j].Metadata = md
+			}
+		}
+	}
+	return streams, nil
+}
+
+// parseLokiLabels parses labels in the Prometheus format, for example, {job="app", env="prod"}.
+func parseLokiLabels(s string) (map[string]string, error) {
+	labels, err := parser.ParseMetric(s)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse Loki labels %q: %w", s, err)
+	}
+
+	return labels.Map(), nil
+}
+
+func decodeLokiJSON(body []byte) ([]lokiStream, error) {
+	var in struct {
+		Streams []struct {
+			Stream map[string]string   `json:"stream"`
+			Values [][]json.RawMessage `json:"values"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, err
+	}
+
+	streams := make([]lokiStream, len(in.Streams))
+	for i := range in.Streams {
+		src := &in.Streams[i]
+
+		stream := &streams[i]
+		stream.Labels = src.Stream
+		stream.Entries = make([]lokiEntry, len(src.Values))
+
+		for j, value := range src.Values {
+			if len(value) < 2 {
+				return nil, fmt.Errorf("Loki entry must have a timestamp and a line")
+			}
+
+			entry := &stream.Entries[j]
+
+			var ts string
+			if err := json.Unmarshal(value[0], &ts); err != nil {
+				return nil, err
+			}
+			unixNano, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("can't parse Loki timestamp %q: %w", ts, err)
+			}
+			entry.Time = time.Unix(0, unixNano)
+
+			if err := json.Unmarshal(value[1], &entry.Line); err != nil {
+				return nil, err
+			}
+			if len(value) > 2 {
+				if err := json.Unmarshal(value[2], &entry.Metadata); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return streams, nil
+}
+
+//------------------------------------------------------------------------------
+
+type lokiLogProcessor struct {
+	baseLogProcessor
+}
+
+func (p *lokiLogProcessor) spanFromLoki(span *Span, labels map[string]string, entry *lokiEntry) {
+	span.ID = idgen.RandSpanID()
+	span.Kind = InternalSpanKind
+	span.EventName = otelEventLog
+	span.StatusCode = OKStatusCode
+	span.Time = entry.Time
+
+	span.Attrs = make(AttrMap, len(labels)+len(entry.Metadata)+2)
+	span.Attrs[attrkey.TelemetrySDKName] = lokiSDK
+	for key, value := range labels {
+		setLokiLabel(span.Attrs, key, value)
+	}
+	for key, value := range entry.Metadata {
+		setLokiLabel(span.Attrs, key, value)
+	}
+
+	if params, ok := bunutil.IsJSON(entry.Line); ok {
+		p.parseJSONLogMessage(span, params)
+	} else if entry.Line != "" {
+		span.Attrs[attrkey.LogMessage] = entry.Line
+	}
+}
+
+func setLokiLabel(attrs AttrMap, key, value string) {
+	switch key {
+	case "level", "detected_level", "severity":
+		if sev := norm.LogSeverity(value); sev != "" {
+			attrs[attrkey.LogSeverity] = sev
+			return
+		}
+	case "filename":
+		attrs[attrkey.LogFilePath] = value
+		return
+	}
+
+	if key = attrkey.Clean(key); key != "" {
+		attrs[key] = value
+	}
+}
@@ -94,3 +94,27 @@ func logSeverity(s string) string {
 		return ""
 	}
 }
+
+// SyslogSeverity converts a syslog severity code (0-7) to a log severity.
+func SyslogSeverity(code int) string {
+	switch code {
+	case 0: // emergency
+		return SeverityFatal2
+	case 1: // alert
+		return SeverityFatal
+	case 2: // critical
+		return SeverityError3
+	case 3: // error
+		return SeverityError
+	case 4: // warning
+		return SeverityWarn
+	case 5: // notice
+		return SeverityInfo2
+	case 6: // informational
+		return SeverityInfo
+	case 7: // debug
+		return SeverityDebug
+	default:
+		return ""
+	}
+}
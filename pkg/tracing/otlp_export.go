@@ -0,0 +1,123 @@
+package tracing
+
+import (
+	"encoding/binary"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+
+	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/otlpconv"
+)
+
+// spansToOTLP converts spans to OTLP grouping them by the service name.
+func spansToOTLP(spans []*Span) []*tracepb.ResourceSpans {
+	var resourceSpans []*tracepb.ResourceSpans
+	scopeMap := make(map[string]*tracepb.ScopeSpans)
+
+	for _, span := range spans {
+		serviceName, _ := span.Attrs[attrkey.ServiceName].(string)
+
+		scope, ok := scopeMap[serviceName]
+		if !ok {
+			scope = new(tracepb.ScopeSpans)
+			scopeMap[serviceName] = scope
+
+			resourceSpans = append(resourceSpans, &tracepb.ResourceSpans{
+				Resource: &resourcepb.Resource{
+					Attributes: []*commonpb.KeyValue{{
+						Key:   "service.name",
+						Value: otlpconv.NewAnyValue(serviceName),
+					}},
+				},
+				ScopeSpans: []*tracepb.ScopeSpans{scope},
+			})
+		}
+
+		scope.Spans = append(scope.Spans, spanToOTLP(span))
+	}
+
+	return resourceSpans
+}
+
+func spanToOTLP(span *Span) *tracepb.Span {
+	name := span.DisplayName
+	if name == "" {
+		name = span.Name
+	}
+
+	attrs := make(map[string]any, len(span.Attrs))
+	for key, value := range span.Attrs {
+		if key != attrkey.ServiceName {
+			attrs[key] = value
+		}
+	}
+
+	out := &tracepb.Span{
+		TraceId:           span.TraceID[:],
+		SpanId:            otlpSpanID(span.ID),
+		Name:              name,
+		Kind:              otlpSpanKindFrom(span.Kind),
+		StartTimeUnixNano: uint64(span.Time.UnixNano()),
+		EndTimeUnixNano:   uint64(span.EndTime().UnixNano()),
+		Attributes:        otlpconv.KeyValues(attrs),
+		Status: &tracepb.Status{
+			Code:    otlpStatusCodeFrom(span.StatusCode),
+			Message: span.StatusMessage,
+		},
+	}
+	if !span.ParentID.IsZero() {
+		out.ParentSpanId = otlpSpanID(span.ParentID)
+	}
+
+	for _, event := range span.Events {
+		out.Events = append(out.Events, &tracepb.Span_Event{
+			Name:         event.Name,
+			TimeUnixNano: uint64(event.Time.UnixNano()),
+			Attributes:   otlpconv.KeyValues(event.Attrs),
+		})
+	}
+	for _, link := range span.Links {
+		out.Links = append(out.Links, &tracepb.Span_Link{
+			TraceId:    link.TraceID[:],
+			SpanId:     otlpSpanID(link.SpanID),
+			Attributes: otlpconv.KeyValues(link.Attrs),
+		})
+	}
+
+	return out
+}
+
+func otlpSpanID(id idgen.SpanID) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(id))
+	return b
+}
+
+func otlpSpanKindFrom(kind string) tracepb.Span_SpanKind {
+	switch kind {
+	case ServerSpanKind:
+		return tracepb.Span_SPAN_KIND_SERVER
+	case ClientSpanKind:
+		return tracepb.Span_SPAN_KIND_CLIENT
+	case ProducerSpanKind:
+		return tracepb.Span_SPAN_KIND_PRODUCER
+	case ConsumerSpanKind:
+		return tracepb.Span_SPAN_KIND_CONSUMER
+	default:
+		return tracepb.Span_SPAN_KIND_INTERNAL
+	}
+}
+
+func otlpStatusCodeFrom(code string) tracepb.Status_StatusCode {
+	switch code {
+	case ErrorStatusCode:
+		return tracepb.Status_STATUS_CODE_ERROR
+	case OKStatusCode:
+		return tracepb.Status_STATUS_CODE_OK
+	default:
+		return tracepb.Status_STATUS_CODE_UNSET
+	}
+}
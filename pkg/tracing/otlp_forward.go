@@ -0,0 +1,175 @@
+package tracing
+
+import (
+	"context"
+
+	collectorlogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	collectortracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"golang.org/x/exp/maps"
+
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/forwarder"
+	"github.com/uptrace/uptrace/pkg/org"
+	"github.com/uptrace/uptrace/pkg/otlpconv"
+	"github.com/uptrace/uptrace/pkg/tracing/tql"
+)
+
+// forwardResourceSpans re-exports the spans using the configured forwarders.
+// The request is not copied unless the forwarder filters spans.
+func forwardResourceSpans(
+	ctx context.Context,
+	forwarders *forwarder.Forwarders,
+	project *org.Project,
+	resourceSpans []*tracepb.ResourceSpans,
+) {
+	for _, fwd := range forwarders.Select(project.ID, bunconf.SignalTraces) {
+		rss := resourceSpans
+		if matcher := fwd.Matcher(); matcher != nil {
+			rss = filterResourceSpans(resourceSpans, matcher)
+		}
+		if len(rss) == 0 {
+			continue
+		}
+		fwd.Enqueue(ctx, project.ID, bunconf.SignalTraces,
+			&collectortracepb.ExportTraceServiceRequest{ResourceSpans: rss})
+	}
+}
+
+func filterResourceSpans(
+	resourceSpans []*tracepb.ResourceSpans, matcher *tql.Matcher,
+) []*tracepb.ResourceSpans {
+	var filtered []*tracepb.ResourceSpans
+
+	for _, rss := range resourceSpans {
+		var resource AttrMap
+		if rss.Resource != nil {
+			resource = AttrMap(otlpconv.Map(rss.Resource.Attributes))
+		}
+
+		var scopeSpans []*tracepb.ScopeSpans
+		for _, ss := range rss.ScopeSpans {
+			scope := otlpScopeAttrs(resource, ss.Scope)
+
+			var spans []*tracepb.Span
+			for _, otlpSpan := range ss.Spans {
+				span := new(Span)
+				initSpanFromOTLP(span, scope, otlpSpan)
+				if matcher.Match(span.Attr) {
+					spans = append(spans, otlpSpan)
+				}
+			}
+			if len(spans) == 0 {
+				continue
+			}
+
+			scopeSpans = append(scopeSpans, &tracepb.ScopeSpans{
+				Scope:     ss.Scope,
+				Spans:     spans,
+				SchemaUrl: ss.SchemaUrl,
+			})
+		}
+		if len(scopeSpans) == 0 {
+			continue
+		}
+
+		filtered = append(filtered, &tracepb.ResourceSpans{
+			Resource:   rss.Resource,
+			ScopeSpans: scopeSpans,
+			SchemaUrl:  rss.SchemaUrl,
+		})
+	}
+
+	return filtered
+}
+
+// forwardResourceLogs re-exports the logs using the configured forwarders.
+func forwardResourceLogs(
+	ctx context.Context,
+	forwarders *forwarder.Forwarders,
+	project *org.Project,
+	resourceLogs []*logspb.ResourceLogs,
+) {
+	for _, fwd := range forwarders.Select(project.ID, bunconf.SignalLogs) {
+		rls := resourceLogs
+		if matcher := fwd.Matcher(); matcher != nil {
+			rls = filterResourceLogs(resourceLogs, matcher)
+		}
+		if len(rls) == 0 {
+			continue
+		}
+		fwd.Enqueue(ctx, project.ID, bunconf.SignalLogs,
+			&collectorlogspb.ExportLogsServiceRequest{ResourceLogs: rls})
+	}
+}
+
+func filterResourceLogs(
+	resourceLogs []*logspb.ResourceLogs, matcher *tql.Matcher,
+) []*logspb.ResourceLogs {
+	var filtered []*logspb.ResourceLogs
+	p := new(otlpLogProcessor)
+
+	for _, rl := range resourceLogs {
+		var resource AttrMap
+		if rl.Resource != nil {
+			resource = AttrMap(otlpconv.Map(rl.Resource.Attributes))
+		}
+
+		var scopeLogs []*logspb.ScopeLogs
+		for _, sl := range rl.ScopeLogs {
+			scope := otlpScopeAttrs(resource, sl.Scope)
+
+			var records []*logspb.LogRecord
+			for _, lr := range sl.LogRecords {
+				span := p.processLogRecord(scope, lr)
+				if matcher.Match(span.Attr) {
+					records = append(records, lr)
+				}
+			}
+			if len(records) == 0 {
+				continue
+			}
+
+			scopeLogs = append(scopeLogs, &logspb.ScopeLogs{
+				Scope:      sl.Scope,
+				LogRecords: records,
+				SchemaUrl:  sl.SchemaUrl,
+			})
+		}
+		if len(scopeLogs) == 0 {
+			continue
+		}
+
+		filtered = append(filtered, &logspb.ResourceLogs{
+			Resource:  rl.Resource,
+			ScopeLogs: scopeLogs,
+			SchemaUrl: rl.SchemaUrl,
+		})
+	}
+
+	return filtered
+}
+
+func otlpScopeAttrs(resource AttrMap, scope *commonpb.InstrumentationScope) AttrMap {
+	if scope == nil {
+		return resource
+	}
+
+	attrs := maps.Clone(resource)
+	if attrs == nil {
+		attrs = make(AttrMap)
+	}
+	if scope.Name != "" {
+		attrs[attrkey.OtelLibraryName] = scope.Name
+	}
+	if scope.Version != "" {
+		attrs[attrkey.OtelLibraryVersion] = scope.Version
+	}
+	otlpconv.ForEachKeyValue(scope.Attributes, func(key string, value any) {
+		attrs[key] = value
+	})
+	return attrs
+}
@@ -25,7 +25,9 @@ import (
 	"github.com/uptrace/bunrouter"
 	"github.com/uptrace/pkg/idgen"
 	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunconf"
 	"github.com/uptrace/uptrace/pkg/bunutil"
+	"github.com/uptrace/uptrace/pkg/forwarder"
 	"github.com/uptrace/uptrace/pkg/org"
 	"github.com/uptrace/uptrace/pkg/otlpconv"
 	"github.com/uptrace/uptrace/pkg/tracing/norm"
@@ -34,9 +36,11 @@ import (
 type LogsServiceServerParams struct {
 	fx.In
 
-	PG       *bun.DB
-	Projects *org.ProjectGateway
-	Consumer *LogConsumer
+	PG          *bun.DB
+	Projects    *org.ProjectGateway
+	Consumer    *LogConsumer
+	Forwarders  *forwarder.Forwarders
+	RateLimiter *org.RateLimiter
 }
 
 type LogsServiceServer struct {
@@ -145,6 +149,14 @@ func (s *LogsServiceServer) Export(
 func (s *LogsServiceServer) export(
 	ctx context.Context, resourceLogs []*logspb.ResourceLogs, project *org.Project,
 ) (*collectorlogspb.ExportLogsServiceResponse, error) {
+	if err := s.RateLimiter.AllowBytes(project.ID, func() int {
+		return proto.Size(&collectorlogspb.ExportLogsServiceRequest{ResourceLogs: resourceLogs})
+	}); err != nil {
+		return nil, err
+	}
+
+	throttle := s.RateLimiter.Throttle(project.ID, bunconf.SignalLogs)
+
 	p := new(otlpLogProcessor)
 	for _, rl := range resourceLogs {
 		var resource AttrMap
@@ -169,14 +181,38 @@ func (s *LogsServiceServer) export(
 				scope = resource
 			}
 
+			// Only accepted log records are kept for the forwarders.
+			accepted := sl.LogRecords[:0]
 			for _, lr := range sl.LogRecords {
+				if !throttle.Allow() {
+					continue
+				}
+				accepted = append(accepted, lr)
+
 				span := p.processLogRecord(scope, lr)
 				span.ProjectID = project.ID
 				s.Consumer.AddSpan(ctx, span)
 			}
+			sl.LogRecords = accepted
 		}
 	}
-	return &collectorlogspb.ExportLogsServiceResponse{}, nil
+
+	resp := new(collectorlogspb.ExportLogsServiceResponse)
+
+	if n := throttle.Throttled(); n > 0 {
+		countThrottled(ctx, project.ID, n)
+		if err := throttle.Err(); err != nil {
+			return nil, err
+		}
+		resp.PartialSuccess = &collectorlogspb.ExportLogsPartialSuccess{
+			RejectedLogRecords: n,
+			ErrorMessage:       "project exceeded logs rate limit",
+		}
+	}
+
+	forwardResourceLogs(ctx, s.Forwarders, project, resourceLogs)
+
+	return resp, nil
 }
 
 //-----------------------------------------------------------------------------------------
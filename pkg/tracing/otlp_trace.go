@@ -11,7 +11,6 @@ import (
 	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
 	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
 	"go.uber.org/fx"
-	"go.uber.org/zap"
 	"golang.org/x/exp/maps"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -22,6 +21,8 @@ import (
 	"github.com/uptrace/bunrouter"
 	"github.com/uptrace/opentelemetry-go-extra/otelzap"
 	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/forwarder"
 	"github.com/uptrace/uptrace/pkg/org"
 	"github.com/uptrace/uptrace/pkg/otlpconv"
 )
@@ -29,12 +30,12 @@ import (
 type TraceServiceServerParams struct {
 	fx.In
 
-	Logger        *otelzap.Logger
-	PG            *bun.DB
-	Projects      *org.ProjectGateway
-	SpanConsumer  *SpanConsumer
-	LogConsumer   *LogConsumer
-	EventConsumer *EventConsumer
+	Logger      *otelzap.Logger
+	PG          *bun.DB
+	Projects    *org.ProjectGateway
+	Dispatcher  *SpanDispatcher
+	Forwarders  *forwarder.Forwarders
+	RateLimiter *org.RateLimiter
 }
 
 type TraceServiceServer struct {
@@ -149,6 +150,14 @@ func (s *TraceServiceServer) Export(
 func (s *TraceServiceServer) process(
 	ctx context.Context, project *org.Project, resourceSpans []*tracepb.ResourceSpans,
 ) (*collectortrace.ExportTraceServiceResponse, error) {
+	if err := s.RateLimiter.AllowBytes(project.ID, func() int {
+		return proto.Size(&collectortrace.ExportTraceServiceRequest{ResourceSpans: resourceSpans})
+	}); err != nil {
+		return nil, err
+	}
+
+	throttle := s.RateLimiter.Throttle(project.ID, bunconf.SignalTraces)
+
 	for _, rss := range resourceSpans {
 		var resource AttrMap
 		if rss.Resource != nil {
@@ -173,35 +182,37 @@ func (s *TraceServiceServer) process(
 			}
 
 			mem := make([]Span, len(ss.Spans))
+			// Only accepted spans are kept for the forwarders.
+			accepted := ss.Spans[:0]
 			for i, otlpSpan := range ss.Spans {
+				if !throttle.Allow() {
+					continue
+				}
+				accepted = append(accepted, otlpSpan)
+
 				span := &mem[i]
 				initSpanFromOTLP(span, scope, otlpSpan)
 				span.ProjectID = project.ID
+				s.Dispatcher.AddSpan(ctx, span)
+			}
+			ss.Spans = accepted
+		}
+	}
 
-				for _, event := range span.Events {
-					eventSpan := &Span{
-						Attrs: NewAttrMap(),
-					}
-					initEventFromHostSpan(eventSpan, event, span)
-
-					if eventSpan.IsLog() {
-						s.LogConsumer.AddSpan(ctx, eventSpan)
-					} else if eventSpan.IsEvent() {
-						s.EventConsumer.AddSpan(ctx, eventSpan)
-					} else {
-						s.Logger.Error(
-							"Span is neither log nor event",
-							zap.String("name", span.Name),
-							zap.String("eventName", span.EventName),
-						)
-					}
-				}
+	resp := new(collectortrace.ExportTraceServiceResponse)
 
-				span.Events = nil
-				s.SpanConsumer.AddSpan(ctx, span)
-			}
+	if n := throttle.Throttled(); n > 0 {
+		countThrottled(ctx, project.ID, n)
+		if err := throttle.Err(); err != nil {
+			return nil, err
+		}
+		resp.PartialSuccess = &collectortrace.ExportTracePartialSuccess{
+			RejectedSpans: n,
+			ErrorMessage:  "project exceeded traces rate limit",
 		}
 	}
 
-	return &collectortrace.ExportTraceServiceResponse{}, nil
+	forwardResourceSpans(ctx, s.Forwarders, project, resourceSpans)
+
+	return resp, nil
 }
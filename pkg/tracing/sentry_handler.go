@@ -0,0 +1,729 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/httperror"
+	"github.com/uptrace/uptrace/pkg/httputil"
+	"github.com/uptrace/uptrace/pkg/org"
+	"github.com/uptrace/uptrace/pkg/tracing/norm"
+)
+
+type SentryHandlerParams struct {
+	fx.In
+
+	Logger      *otelzap.Logger
+	Projects    *org.ProjectGateway
+	Dispatcher  *SpanDispatcher
+	LogConsumer *LogConsumer
+}
+
+// SentryHandler accepts errors and transactions from Sentry SDKs.
+// The Sentry DSN uses the project token as a public key, for example,
+// http://project2_secret_token@localhost:14318/2.
+type SentryHandler struct {
+	*SentryHandlerParams
+}
+
+func NewSentryHandler(p SentryHandlerParams) *SentryHandler {
+	return &SentryHandler{&p}
+}
+
+func registerSentryHandler(h *SentryHandler, p bunapp.RouterParams) {
+	p.Router.WithGroup("/api/:project_id", func(g *bunrouter.Group) {
+		g.POST("/envelope/", h.Envelope)
+		g.POST("/envelope", h.Envelope)
+		g.POST("/store/", h.Store)
+		g.POST("/store", h.Store)
+	})
+}
+
+func (h *SentryHandler) Envelope(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	project, err := h.sentryProject(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+
+	envelope, err := parseSentryEnvelope(body)
+	if err != nil {
+		return httperror.BadRequest("invalid_envelope", err.Error())
+	}
+
+	for _, item := range envelope.Items {
+		switch item.Type {
+		case "event", "transaction":
+		default:
+			continue
+		}
+
+		event := new(sentryEvent)
+		if err := json.Unmarshal(item.Payload, event); err != nil {
+			return httperror.BadRequest("invalid_event", err.Error())
+		}
+		if event.Type == "" {
+			event.Type = item.Type
+		}
+		h.processEvent(ctx, project, event)
+	}
+
+	return httputil.JSON(w, bunrouter.H{
+		"id": envelope.EventID,
+	})
+}
+
+func (h *SentryHandler) Store(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	project, err := h.sentryProject(req)
+	if err != nil {
+		return err
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+
+	event := new(sentryEvent)
+	if err := json.Unmarshal(body, event); err != nil {
+		return httperror.BadRequest("invalid_event", err.Error())
+	}
+	h.processEvent(ctx, project, event)
+
+	return httputil.JSON(w, bunrouter.H{
+		"id": event.EventID,
+	})
+}
+
+func (h *SentryHandler) sentryProject(req bunrouter.Request) (*org.Project, error) {
+	ctx := req.Context()
+
+	projectID, err := req.Params().Uint32("project_id")
+	if err != nil {
+		return nil, err
+	}
+
+	key := sentryKeyFromRequest(req)
+	if key == "" {
+		return nil, httperror.Unauthorized("sentry_key is empty or missing")
+	}
+
+	project, err := h.Projects.SelectByToken(ctx, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, httperror.Unauthorized("can't find project with token=%q", key)
+		}
+		return nil, err
+	}
+	if project.ID != projectID {
+		return nil, httperror.Forbidden("sentry_key does not belong to project %d", projectID)
+	}
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.Int64("project", int64(project.ID)))
+	}
+
+	return project, nil
+}
+
+// sentryKeyFromRequest returns the public key from the X-Sentry-Auth header, for example,
+// "Sentry sentry_version=7, sentry_client=sentry.go/0.20.0, sentry_key=<token>".
+// Browser SDKs pass the key using the sentry_key query param.
+func sentryKeyFromRequest(req bunrouter.Request) string {
+	for _, header := range []string{"X-Sentry-Auth", "Authorization"} {
+		auth := req.Header.Get(header)
+		if !strings.HasPrefix(auth, "Sentry ") {
+			continue
+		}
+
+		for _, pair := range strings.Split(auth[len("Sentry "):], ",") {
+			key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			if key == "sentry_key" && value != "" {
+				return value
+			}
+		}
+	}
+
+	return req.URL.Query().Get("sentry_key")
+}
+
+func (h *SentryHandler) processEvent(
+	ctx context.Context, project *org.Project, event *sentryEvent,
+) {
+	resource := event.resourceAttrs()
+
+	if event.Type == "transaction" {
+		for _, span := range newSpansFromSentryTransaction(resource, event) {
+			span.ProjectID = project.ID
+			h.Dispatcher.AddSpan(ctx, span)
+		}
+		return
+	}
+
+	span := newSpanFromSentryEvent(resource, event)
+	span.ProjectID = project.ID
+	h.LogConsumer.AddSpan(ctx, span)
+}
+
+//------------------------------------------------------------------------------
+
+type sentryEnvelope struct {
+	EventID string
+	Items   []sentryEnvelopeItem
+}
+
+type sentryEnvelopeItem struct {
+	Type    string
+	Payload []byte
+}
+
+// parseSentryEnvelope parses the newline-delimited envelope format:
+// an envelope header followed by items that consist of a header and a payload.
+// See https://develop.sentry.dev/sdk/envelopes/.
+func parseSentryEnvelope(b []byte) (*sentryEnvelope, error) {
+	line, b := cutSentryLine(b)
+
+	var header struct {
+		EventID string `json:"event_id"`
+	}
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, fmt.Errorf("can't parse envelope header: %w", err)
+	}
+
+	envelope := &sentryEnvelope{
+		EventID: header.EventID,
+	}
+
+	for len(b) > 0 {
+		line, b = cutSentryLine(b)
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var itemHeader struct {
+			Type   string `json:"type"`
+			Length *int   `json:"length"`
+		}
+		if err := json.Unmarshal(line, &itemHeader); err != nil {
+			return nil, fmt.Errorf("can't parse item header: %w", err)
+		}
+
+		var payload []byte
+		if itemHeader.Length != nil {
+			n := *itemHeader.Length
+			if n < 0 || n > len(b) {
+				return nil, fmt.Errorf("item length %d is out of range", n)
+			}
+			payload, b = b[:n], b[n:]
+			if len(b) > 0 && b[0] == '\n' {
+				b = b[1:]
+			}
+		} else {
+			payload, b = cutSentryLine(b)
+		}
+
+		envelope.Items = append(envelope.Items, sentryEnvelopeItem{
+			Type:    itemHeader.Type,
+			Payload: payload,
+		})
+	}
+
+	return envelope, nil
+}
+
+func cutSentryLine(b []byte) (line, rest []byte) {
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		return b[:i], b[i+1:]
+	}
+	return b, nil
+}
+
+//------------------------------------------------------------------------------
+
+type sentryEvent struct {
+	EventID        string     `json:"event_id"`
+	Type           string     `json:"type"`
+	Timestamp      sentryTime `json:"timestamp"`
+	StartTimestamp sentryTime `json:"start_timestamp"`
+	Level          string     `json:"level"`
+	Platform       string     `json:"platform"`
+	Logger         string     `json:"logger"`
+	Transaction    string     `json:"transaction"`
+	ServerName     string     `json:"server_name"`
+	Release        string     `json:"release"`
+	Environment    string     `json:"environment"`
+
+	Message  sentryMessage     `json:"message"`
+	LogEntry sentryMessage     `json:"logentry"`
+	Tags     sentryStringMap   `json:"tags"`
+	Extra    map[string]any    `json:"extra"`
+	Contexts sentryContexts    `json:"contexts"`
+	SDK      sentryNameVersion `json:"sdk"`
+	User     *sentryUser       `json:"user"`
+	Request  *sentryRequest    `json:"request"`
+
+	Exception sentryExceptions `json:"exception"`
+	Spans     []*sentrySpan    `json:"spans"`
+}
+
+type sentryContexts struct {
+	Trace   *sentryTraceContext `json:"trace"`
+	OS      *sentryNameVersion  `json:"os"`
+	Runtime *sentryNameVersion  `json:"runtime"`
+	Browser *sentryNameVersion  `json:"browser"`
+}
+
+type sentryTraceContext struct {
+	TraceID      string         `json:"trace_id"`
+	SpanID       string         `json:"span_id"`
+	ParentSpanID string         `json:"parent_span_id"`
+	Op           string         `json:"op"`
+	Status       string         `json:"status"`
+	Data         map[string]any `json:"data"`
+}
+
+type sentryNameVersion struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type sentryUser struct {
+	ID        any    `json:"id"`
+	Email     string `json:"email"`
+	Username  string `json:"username"`
+	IPAddress string `json:"ip_address"`
+}
+
+type sentryRequest struct {
+	URL     string          `json:"url"`
+	Method  string          `json:"method"`
+	Headers sentryStringMap `json:"headers"`
+}
+
+type sentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Module     string            `json:"module"`
+	Stacktrace *sentryStacktrace `json:"stacktrace"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Module   string `json:"module"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	Colno    int    `json:"colno"`
+}
+
+type sentrySpan struct {
+	TraceID        string          `json:"trace_id"`
+	SpanID         string          `json:"span_id"`
+	ParentSpanID   string          `json:"parent_span_id"`
+	Op             string          `json:"op"`
+	Description    string          `json:"description"`
+	Status         string          `json:"status"`
+	StartTimestamp sentryTime      `json:"start_timestamp"`
+	Timestamp      sentryTime      `json:"timestamp"`
+	Tags           sentryStringMap `json:"tags"`
+	Data           map[string]any  `json:"data"`
+}
+
+// sentryTime is either a number of seconds since the epoch or an RFC 3339 string.
+type sentryTime time.Time
+
+func (t *sentryTime) UnmarshalJSON(b []byte) error {
+	if len(b) == 0 || bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+
+	if b[0] != '"' {
+		sec, err := strconv.ParseFloat(string(b), 64)
+		if err != nil {
+			return err
+		}
+		*t = sentryTime(time.Unix(0, int64(sec*float64(time.Second))))
+		return nil
+	}
+
+	s := string(b[1 : len(b)-1])
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"} {
+		if tm, err := time.Parse(layout, s); err == nil {
+			*t = sentryTime(tm)
+			return nil
+		}
+	}
+	return fmt.Errorf("can't parse Sentry timestamp: %q", s)
+}
+
+func (t sentryTime) Time() time.Time {
+	return time.Time(t)
+}
+
+// sentryMessage is either a string or an object with message and formatted fields.
+type sentryMessage string
+
+func (m *sentryMessage) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*m = sentryMessage(s)
+		return nil
+	}
+
+	var obj struct {
+		Message   string `json:"message"`
+		Formatted string `json:"formatted"`
+	}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return err
+	}
+	if obj.Formatted != "" {
+		*m = sentryMessage(obj.Formatted)
+	} else {
+		*m = sentryMessage(obj.Message)
+	}
+	return nil
+}
+
+// sentryStringMap is either an object or a list of key-value pairs.
+type sentryStringMap map[string]string
+
+func (m *sentryStringMap) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '[' {
+		var pairs [][2]string
+		if err := json.Unmarshal(b, &pairs); err != nil {
+			return err
+		}
+		*m = make(sentryStringMap, len(pairs))
+		for _, pair := range pairs {
+			(*m)[pair[0]] = pair[1]
+		}
+		return nil
+	}
+
+	var values map[string]any
+	if err := json.Unmarshal(b, &values); err != nil {
+		return err
+	}
+	*m = make(sentryStringMap, len(values))
+	for k, v := range values {
+		if s, ok := v.(string); ok {
+			(*m)[k] = s
+		} else if v != nil {
+			(*m)[k] = fmt.Sprint(v)
+		}
+	}
+	return nil
+}
+
+// sentryExceptions is either an object with values or a list of exceptions.
+type sentryExceptions []sentryException
+
+func (e *sentryExceptions) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '[' {
+		return json.Unmarshal(b, (*[]sentryException)(e))
+	}
+
+	var obj struct {
+		Values []sentryException `json:"values"`
+	}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return err
+	}
+	*e = obj.Values
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+func (e *sentryEvent) resourceAttrs() AttrMap {
+	attrs := make(AttrMap, len(e.Tags)+16)
+
+	for key, value := range e.Tags {
+		if key = attrkey.Clean(key); key != "" {
+			attrs[key] = value
+		}
+	}
+	for key, value := range e.Extra {
+		if key = attrkey.Clean(key); key != "" {
+			attrs[key] = value
+		}
+	}
+
+	if e.Release != "" {
+		attrs[attrkey.ServiceVersion] = e.Release
+	}
+	if e.Environment != "" {
+		attrs[attrkey.DeploymentEnvironment] = e.Environment
+	}
+	if e.ServerName != "" {
+		attrs[attrkey.HostName] = e.ServerName
+	}
+	if e.Platform != "" {
+		attrs[attrkey.TelemetrySDKLanguage] = e.Platform
+	}
+	if e.SDK.Name != "" {
+		attrs[attrkey.TelemetrySDKName] = e.SDK.Name
+		attrs[attrkey.TelemetrySDKVersion] = e.SDK.Version
+	}
+	if rt := e.Contexts.Runtime; rt != nil && rt.Name != "" {
+		attrs[attrkey.ProcessRuntimeName] = rt.Name
+		attrs[attrkey.ProcessRuntimeVersion] = rt.Version
+	}
+
+	if user := e.User; user != nil {
+		if user.ID != nil {
+			attrs[attrkey.EnduserID] = fmt.Sprint(user.ID)
+		}
+		if user.Email != "" {
+			attrs["enduser_email"] = user.Email
+		}
+		if user.Username != "" {
+			attrs["enduser_username"] = user.Username
+		}
+		if user.IPAddress != "" {
+			attrs[attrkey.ClientAddress] = user.IPAddress
+		}
+	}
+
+	if req := e.Request; req != nil {
+		if req.URL != "" {
+			attrs[attrkey.URLFull] = req.URL
+		}
+		if req.Method != "" {
+			attrs[attrkey.HTTPRequestMethod] = req.Method
+		}
+		for key, value := range req.Headers {
+			if strings.EqualFold(key, "User-Agent") {
+				attrs[attrkey.UserAgentOriginal] = value
+			}
+		}
+	}
+
+	return attrs
+}
+
+// newSpanFromSentryEvent converts an error event to an exception
+// or to a log record when the event does not have an exception.
+func newSpanFromSentryEvent(resource AttrMap, event *sentryEvent) *Span {
+	span := new(Span)
+
+	if tc := event.Contexts.Trace; tc != nil {
+		span.TraceID, _ = idgen.ParseTraceID(tc.TraceID)
+		span.ParentID, _ = idgen.ParseSpanID(tc.SpanID)
+	}
+	span.ID = idgen.RandSpanID()
+	span.Kind = InternalSpanKind
+	span.Time = event.Timestamp.Time()
+
+	span.Attrs = resource
+	if event.Level != "" {
+		span.Attrs[attrkey.LogSeverity] = norm.LogSeverity(event.Level)
+	}
+	if event.Logger != "" {
+		span.Attrs[attrkey.LogSource] = event.Logger
+	}
+
+	msg := string(event.LogEntry)
+	if msg == "" {
+		msg = string(event.Message)
+	}
+
+	if len(event.Exception) == 0 {
+		span.EventName = otelEventLog
+		span.Attrs[attrkey.LogMessage] = msg
+		return span
+	}
+
+	// The last exception is the one that was raised.
+	exc := &event.Exception[len(event.Exception)-1]
+
+	span.EventName = otelEventException
+	if exc.Type != "" {
+		span.Attrs[attrkey.ExceptionType] = exc.Type
+	}
+	if exc.Value != "" {
+		span.Attrs[attrkey.ExceptionMessage] = exc.Value
+	} else if msg != "" {
+		span.Attrs[attrkey.ExceptionMessage] = msg
+	}
+	if s := formatSentryStacktrace(event.Exception); s != "" {
+		span.Attrs[attrkey.ExceptionStacktrace] = s
+	}
+
+	return span
+}
+
+// formatSentryStacktrace formats chained exceptions starting with the raised one,
+// printing the most recent frames first.
+func formatSentryStacktrace(excs []sentryException) string {
+	var b strings.Builder
+
+	for i := len(excs) - 1; i >= 0; i-- {
+		exc := &excs[i]
+
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(joinTypeMessage(exc.Type, exc.Value))
+		b.WriteString("\n")
+
+		if exc.Stacktrace == nil {
+			continue
+		}
+		frames := exc.Stacktrace.Frames
+		for j := len(frames) - 1; j >= 0; j-- {
+			frame := &frames[j]
+
+			file := frame.AbsPath
+			if file == "" {
+				file = frame.Filename
+			}
+			fn := frame.Function
+			if frame.Module != "" && fn != "" {
+				fn = frame.Module + "." + fn
+			}
+
+			fmt.Fprintf(&b, "\tat %s (%s:%d", fn, file, frame.Lineno)
+			if frame.Colno > 0 {
+				fmt.Fprintf(&b, ":%d", frame.Colno)
+			}
+			b.WriteString(")\n")
+		}
+	}
+
+	return b.String()
+}
+
+func newSpansFromSentryTransaction(resource AttrMap, event *sentryEvent) []*Span {
+	tc := event.Contexts.Trace
+	if tc == nil {
+		tc = new(sentryTraceContext)
+	}
+
+	traceID, _ := idgen.ParseTraceID(tc.TraceID)
+	spans := make([]*Span, 0, len(event.Spans)+1)
+
+	root := &Span{
+		TraceID:  traceID,
+		Name:     event.Transaction,
+		Kind:     sentrySpanKind(tc.Op),
+		Time:     event.StartTimestamp.Time(),
+		Duration: event.Timestamp.Time().Sub(event.StartTimestamp.Time()),
+		Attrs:    resource.Clone(),
+	}
+	root.ID, _ = idgen.ParseSpanID(tc.SpanID)
+	root.ParentID, _ = idgen.ParseSpanID(tc.ParentSpanID)
+	if tc.Op != "" {
+		root.Attrs["sentry_op"] = tc.Op
+	}
+	for key, value := range tc.Data {
+		if key = attrkey.Clean(key); key != "" {
+			root.Attrs[key] = value
+		}
+	}
+	setSentrySpanStatus(root, tc.Status)
+	spans = append(spans, root)
+
+	for _, src := range event.Spans {
+		span := &Span{
+			TraceID:  traceID,
+			Kind:     sentrySpanKind(src.Op),
+			Time:     src.StartTimestamp.Time(),
+			Duration: src.Timestamp.Time().Sub(src.StartTimestamp.Time()),
+			Attrs:    resource.Clone(),
+		}
+		if src.TraceID != "" {
+			span.TraceID, _ = idgen.ParseTraceID(src.TraceID)
+		}
+		span.ID, _ = idgen.ParseSpanID(src.SpanID)
+		span.ParentID, _ = idgen.ParseSpanID(src.ParentSpanID)
+
+		span.Name = src.Description
+		if span.Name == "" {
+			span.Name = src.Op
+		}
+		if src.Op != "" {
+			span.Attrs["sentry_op"] = src.Op
+			if strings.HasPrefix(src.Op, "db") && src.Description != "" {
+				span.Attrs[attrkey.DBStatement] = src.Description
+			}
+		}
+
+		for key, value := range src.Tags {
+			if key = attrkey.Clean(key); key != "" {
+				span.Attrs[key] = value
+			}
+		}
+		for key, value := range src.Data {
+			if key = attrkey.Clean(key); key != "" {
+				span.Attrs[key] = value
+			}
+		}
+		setSentrySpanStatus(span, src.Status)
+
+		spans = append(spans, span)
+	}
+
+	return spans
+}
+
+func setSentrySpanStatus(span *Span, status string) {
+	switch status {
+	case "", "ok":
+		span.StatusCode = OKStatusCode
+	default:
+		span.StatusCode = ErrorStatusCode
+		span.StatusMessage = status
+	}
+}
+
+func sentrySpanKind(op string) string {
+	switch {
+	case op == "http.server" || strings.HasPrefix(op, "http.server."),
+		op == "rpc.server":
+		return ServerSpanKind
+	case op == "http.client" || strings.HasPrefix(op, "http.client."),
+		op == "rpc.client", op == "db" || strings.HasPrefix(op, "db."),
+		strings.HasPrefix(op, "cache."):
+		return ClientSpanKind
+	case op == "queue.publish" || op == "queue.submit":
+		return ProducerSpanKind
+	case op == "queue.process" || op == "queue.task":
+		return ConsumerSpanKind
+	}
+	return InternalSpanKind
+}
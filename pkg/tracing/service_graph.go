@@ -0,0 +1,486 @@
+package tracing
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/pkg/clickhouse/bfloat16"
+	"github.com/uptrace/pkg/clickhouse/ch"
+	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/bunotel"
+	"github.com/uptrace/uptrace/pkg/run"
+)
+
+const (
+	EdgeTypeUnset     = "unset"
+	EdgeTypeHTTP      = "http"
+	EdgeTypeDB        = "db"
+	EdgeTypeMessaging = "messaging"
+)
+
+const serviceGraphFlushInterval = 15 * time.Second
+
+var serviceGraphCounter, _ = bunotel.Meter.Int64Counter(
+	"uptrace.service_graph.edges",
+	metric.WithDescription("Number of processed service graph edges"),
+)
+
+// ServiceGraphEdge is a call between two services aggregated by minute.
+// Durations are in nanoseconds.
+type ServiceGraphEdge struct {
+	ch.CHModel `ch:"table:service_graph_edges,alias:e"`
+
+	ProjectID uint32
+	Type      string    `ch:",lc"`
+	Time      time.Time `ch:"type:DateTime"`
+
+	ClientAttr string `ch:",lc"`
+	ClientName string `ch:",lc"`
+	ServerAttr string `ch:",lc"`
+	ServerName string `ch:",lc"`
+
+	DeploymentEnvironment string `ch:",lc"`
+	ServiceNamespace      string `ch:",lc"`
+
+	ClientDurationMin       float32
+	ClientDurationMax       float32
+	ClientDurationSum       float32
+	ClientDurationHistogram map[bfloat16.T]uint64 `ch:"type:AggregateFunction(quantilesBFloat16(0.5), Float32)"`
+
+	ServerDurationMin       float32
+	ServerDurationMax       float32
+	ServerDurationSum       float32
+	ServerDurationHistogram map[bfloat16.T]uint64 `ch:"type:AggregateFunction(quantilesBFloat16(0.5), Float32)"`
+
+	Count      uint32
+	ErrorCount uint32
+}
+
+type serviceGraphEdgeKey struct {
+	projectID uint32
+	typ       string
+	time      time.Time
+
+	clientAttr string
+	clientName string
+	serverAttr string
+	serverName string
+
+	env string
+	ns  string
+}
+
+func (e *ServiceGraphEdge) observeClient(dur time.Duration, count uint32) {
+	observeDuration(
+		&e.ClientDurationMin, &e.ClientDurationMax, &e.ClientDurationSum,
+		&e.ClientDurationHistogram, e.Count == 0, dur, count)
+}
+
+func (e *ServiceGraphEdge) observeServer(dur time.Duration, count uint32) {
+	observeDuration(
+		&e.ServerDurationMin, &e.ServerDurationMax, &e.ServerDurationSum,
+		&e.ServerDurationHistogram, e.Count == 0, dur, count)
+}
+
+// observeDuration records the duration count times, i.e. once for each span
+// that the sampled span represents.
+func observeDuration(
+	minDur, maxDur, sumDur *float32,
+	hist *map[bfloat16.T]uint64,
+	first bool,
+	dur time.Duration,
+	count uint32,
+) {
+	value := float32(dur.Nanoseconds())
+
+	if first {
+		*minDur = value
+		*maxDur = value
+	} else {
+		*minDur = min(*minDur, value)
+		*maxDur = max(*maxDur, value)
+	}
+	*sumDur += value * float32(count)
+
+	if *hist == nil {
+		*hist = make(map[bfloat16.T]uint64)
+	}
+	(*hist)[bfloat16.From(float64(value))] += uint64(count)
+}
+
+//------------------------------------------------------------------------------
+
+type ServiceGraphProcessorParams struct {
+	fx.In
+
+	Logger *otelzap.Logger
+	Conf   *bunconf.Config
+	CH     *ch.DB
+}
+
+// ServiceGraphProcessor pairs client and producer spans with the server and consumer
+// spans they call and aggregates the pairs into service_graph_edges.
+// Incomplete pairs are kept in memory for the configured TTL.
+type ServiceGraphProcessor struct {
+	logger *otelzap.Logger
+	ch     *ch.DB
+
+	storeSize int
+	storeTTL  time.Duration
+
+	mu    sync.Mutex
+	store map[spanKey]*pendingEdge
+	fifo  *list.List
+	edges map[serviceGraphEdgeKey]*ServiceGraphEdge
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type spanKey struct {
+	projectID uint32
+	traceID   idgen.TraceID
+	spanID    idgen.SpanID
+}
+
+type pendingEdge struct {
+	key      spanKey
+	deadline time.Time
+	elem     *list.Element
+
+	client *edgeSpan
+	server *edgeSpan
+}
+
+// edgeSpan holds the span fields required to create an edge
+// so the span itself can be released.
+type edgeSpan struct {
+	typ      string
+	time     time.Time
+	duration time.Duration
+	isError  bool
+	// count is the number of spans the span represents when the trace is sampled.
+	count uint32
+
+	service string
+	env     string
+	ns      string
+
+	dbSystem      string
+	serverAddress string
+}
+
+// NewServiceGraphProcessor returns nil when the service graph is disabled.
+func NewServiceGraphProcessor(p ServiceGraphProcessorParams) *ServiceGraphProcessor {
+	conf := p.Conf.ServiceGraph
+	if conf.Disabled {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ServiceGraphProcessor{
+		logger:    p.Logger,
+		ch:        p.CH,
+		storeSize: conf.Store.Size,
+		storeTTL:  conf.Store.TTL,
+		store:     make(map[spanKey]*pendingEdge),
+		fifo:      list.New(),
+		edges:     make(map[serviceGraphEdgeKey]*ServiceGraphEdge),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+}
+
+func provideServiceGraphSpanProcessor(p *ServiceGraphProcessor) SpanProcessor {
+	if p == nil {
+		return nil
+	}
+	return p
+}
+
+func runServiceGraphProcessor(group *run.Group, p *ServiceGraphProcessor) {
+	if p == nil {
+		return
+	}
+
+	group.Add("tracing.ServiceGraphProcessor.Run", func() error {
+		p.Run()
+		return nil
+	})
+	group.OnStop(func(context.Context, error) error {
+		p.Stop()
+		return nil
+	})
+}
+
+// Run flushes the edges until Stop is called. Stop may be called before Run,
+// in which case Run does the final flush and returns.
+func (p *ServiceGraphProcessor) Run() {
+	defer close(p.done)
+	ctx := p.ctx
+
+	expireTicker := time.NewTicker(time.Second)
+	defer expireTicker.Stop()
+
+	flushTicker := time.NewTicker(serviceGraphFlushInterval)
+	defer flushTicker.Stop()
+
+	for {
+		select {
+		case now := <-expireTicker.C:
+			p.expire(ctx, now)
+		case <-flushTicker.C:
+			p.flush(ctx)
+		case <-ctx.Done():
+			ctx = context.Background()
+			p.expire(ctx, time.Now().Add(p.storeTTL))
+			p.flush(ctx)
+			return
+		}
+	}
+}
+
+func (p *ServiceGraphProcessor) Stop() {
+	p.cancel()
+	<-p.done
+}
+
+var _ SpanProcessor = (*ServiceGraphProcessor)(nil)
+
+// ProcessSpan must be called after the span attributes are normalized.
+func (p *ServiceGraphProcessor) ProcessSpan(ctx context.Context, span *Span) {
+	if span.TraceID.IsZero() {
+		return
+	}
+
+	var key spanKey
+	var isClient bool
+
+	switch span.Kind {
+	case ClientSpanKind, ProducerSpanKind:
+		key = spanKey{projectID: span.ProjectID, traceID: span.TraceID, spanID: span.ID}
+		isClient = true
+	case ServerSpanKind, ConsumerSpanKind:
+		if span.ParentID.IsZero() {
+			return
+		}
+		key = spanKey{projectID: span.ProjectID, traceID: span.TraceID, spanID: span.ParentID}
+	default:
+		return
+	}
+
+	es := newEdgeSpan(span)
+	if es.service == "" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	edge, ok := p.store[key]
+	if !ok {
+		if len(p.store) >= p.storeSize {
+			p.count(ctx, span.ProjectID, "dropped")
+			return
+		}
+		edge = &pendingEdge{
+			key:      key,
+			deadline: time.Now().Add(p.storeTTL),
+		}
+		edge.elem = p.fifo.PushBack(edge)
+		p.store[key] = edge
+	}
+
+	if isClient {
+		edge.client = es
+	} else {
+		edge.server = es
+	}
+
+	if edge.client != nil && edge.server != nil {
+		p.remove(edge)
+		p.addEdge(key.projectID, edge.client, serverNode{
+			typ:  edge.server.typ,
+			attr: attrkey.ServiceName,
+			name: edge.server.service,
+			span: edge.server,
+		})
+		p.count(ctx, key.projectID, "paired")
+	}
+}
+
+func newEdgeSpan(span *Span) *edgeSpan {
+	es := &edgeSpan{
+		typ:      edgeType(span),
+		time:     span.Time,
+		duration: span.Duration,
+		isError:  span.StatusCode == ErrorStatusCode,
+		count:    uint32(max(math.Round(span.SampleCount()), 1)),
+	}
+	es.service, _ = span.Attrs[attrkey.ServiceName].(string)
+	es.env, _ = span.Attrs[attrkey.DeploymentEnvironment].(string)
+	es.ns, _ = span.Attrs[attrkey.ServiceNamespace].(string)
+	es.dbSystem, _ = span.Attrs[attrkey.DBSystem].(string)
+	es.serverAddress, _ = span.Attrs[attrkey.ServerAddress].(string)
+	return es
+}
+
+func edgeType(span *Span) string {
+	switch {
+	case span.Attrs.Exists(attrkey.DBSystem):
+		return EdgeTypeDB
+	case span.Attrs.Exists(attrkey.MessagingSystem):
+		return EdgeTypeMessaging
+	case span.Attrs.Exists(attrkey.HTTPRequestMethod), span.Attrs.Exists(attrkey.URLFull):
+		return EdgeTypeHTTP
+	default:
+		return EdgeTypeUnset
+	}
+}
+
+// expire removes incomplete pairs older than the TTL. Client spans without a server
+// span create edges to virtual nodes, for example, databases and external HTTP hosts.
+func (p *ServiceGraphProcessor) expire(ctx context.Context, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for elem := p.fifo.Front(); elem != nil; elem = p.fifo.Front() {
+		edge := elem.Value.(*pendingEdge)
+		if edge.deadline.After(now) {
+			break
+		}
+		p.remove(edge)
+
+		if edge.client == nil {
+			p.count(ctx, edge.key.projectID, "expired")
+			continue
+		}
+
+		server, ok := virtualServer(edge.client)
+		if !ok {
+			p.count(ctx, edge.key.projectID, "expired")
+			continue
+		}
+
+		p.addEdge(edge.key.projectID, edge.client, server)
+		p.count(ctx, edge.key.projectID, "virtual")
+	}
+}
+
+// serverNode is the callee of an edge. The span is nil for virtual nodes,
+// i.e. uninstrumented databases and external HTTP hosts.
+type serverNode struct {
+	typ  string
+	attr string
+	name string
+	span *edgeSpan
+}
+
+func virtualServer(client *edgeSpan) (serverNode, bool) {
+	switch {
+	case client.dbSystem != "":
+		return serverNode{typ: EdgeTypeDB, attr: attrkey.DBSystem, name: client.dbSystem}, true
+	case client.serverAddress != "":
+		typ := client.typ
+		if typ == EdgeTypeUnset {
+			typ = EdgeTypeHTTP
+		}
+		return serverNode{typ: typ, attr: attrkey.ServerAddress, name: client.serverAddress}, true
+	default:
+		return serverNode{}, false
+	}
+}
+
+func (p *ServiceGraphProcessor) remove(edge *pendingEdge) {
+	p.fifo.Remove(edge.elem)
+	delete(p.store, edge.key)
+}
+
+// addEdge must be called with the mutex held.
+func (p *ServiceGraphProcessor) addEdge(projectID uint32, client *edgeSpan, server serverNode) {
+	key := serviceGraphEdgeKey{
+		projectID:  projectID,
+		typ:        client.typ,
+		time:       client.time.Truncate(time.Minute),
+		clientAttr: attrkey.ServiceName,
+		clientName: client.service,
+		serverAttr: server.attr,
+		serverName: server.name,
+		env:        client.env,
+		ns:         client.ns,
+	}
+	if key.typ == EdgeTypeUnset {
+		key.typ = server.typ
+	}
+
+	edge, ok := p.edges[key]
+	if !ok {
+		edge = &ServiceGraphEdge{
+			ProjectID:             key.projectID,
+			Type:                  key.typ,
+			Time:                  key.time,
+			ClientAttr:            key.clientAttr,
+			ClientName:            key.clientName,
+			ServerAttr:            key.serverAttr,
+			ServerName:            key.serverName,
+			DeploymentEnvironment: key.env,
+			ServiceNamespace:      key.ns,
+		}
+		p.edges[key] = edge
+	}
+
+	// Both spans belong to the same trace and have the same sampling ratio.
+	count := client.count
+	edge.observeClient(client.duration, count)
+	if server.span != nil {
+		edge.observeServer(server.span.duration, count)
+	}
+	edge.Count += count
+	if client.isError || server.span != nil && server.span.isError {
+		edge.ErrorCount += count
+	}
+}
+
+func (p *ServiceGraphProcessor) flush(ctx context.Context) {
+	p.mu.Lock()
+	if len(p.edges) == 0 {
+		p.mu.Unlock()
+		return
+	}
+	edges := make([]*ServiceGraphEdge, 0, len(p.edges))
+	for _, edge := range p.edges {
+		edges = append(edges, edge)
+	}
+	p.edges = make(map[serviceGraphEdgeKey]*ServiceGraphEdge, len(edges))
+	p.mu.Unlock()
+
+	if _, err := p.ch.NewInsert().Model(&edges).Exec(ctx); err != nil {
+		p.logger.Error("ch.Insert failed",
+			zap.Error(err),
+			zap.String("table", "service_graph_edges"))
+	}
+}
+
+func (p *ServiceGraphProcessor) count(ctx context.Context, projectID uint32, typ string) {
+	serviceGraphCounter.Add(
+		ctx,
+		1,
+		metric.WithAttributes(
+			bunotel.ProjectIDAttr(projectID),
+			attribute.String("type", typ),
+		),
+	)
+}
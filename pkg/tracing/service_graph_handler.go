@@ -0,0 +1,205 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.uber.org/fx"
+
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/pkg/clickhouse/ch"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/httputil"
+	"github.com/uptrace/uptrace/pkg/org"
+	"github.com/uptrace/uptrace/pkg/tracing/tql"
+)
+
+type ServiceGraphHandlerParams struct {
+	fx.In
+
+	Logger *otelzap.Logger
+	CH     *ch.DB
+}
+
+type ServiceGraphHandler struct {
+	*ServiceGraphHandlerParams
+}
+
+func NewServiceGraphHandler(p ServiceGraphHandlerParams) *ServiceGraphHandler {
+	return &ServiceGraphHandler{&p}
+}
+
+func registerServiceGraphHandler(h *ServiceGraphHandler, p bunapp.RouterParams, m *org.Middleware) {
+	p.RouterInternalV1.
+		Use(m.UserAndProject).
+		WithGroup("/tracing/:project_id", func(g *bunrouter.Group) {
+			g.GET("/service-graph", h.List)
+		})
+}
+
+type ServiceGraphLink struct {
+	Type string `json:"type"`
+
+	ClientAttr string `json:"clientAttr"`
+	ClientName string `json:"clientName"`
+	ServerAttr string `json:"serverAttr"`
+	ServerName string `json:"serverName"`
+
+	DeploymentEnvironment string `json:"-"`
+	ServiceNamespace      string `json:"-"`
+
+	DurationMin float64 `json:"durationMin"`
+	DurationMax float64 `json:"durationMax"`
+	DurationSum float64 `json:"durationSum"`
+	DurationAvg float64 `json:"durationAvg"`
+
+	Count      uint64  `json:"count"`
+	Rate       float64 `json:"rate"`
+	ErrorCount uint64  `json:"errorCount"`
+	ErrorRate  float64 `json:"errorRate"`
+}
+
+func (h *ServiceGraphHandler) List(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	f := &SpanFilter{}
+	if err := DecodeSpanFilter(req, f); err != nil {
+		return err
+	}
+
+	matcher, err := serviceGraphMatcher(f.QueryParts)
+	if err != nil {
+		return err
+	}
+
+	links, err := h.selectLinks(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	edges := make([]*ServiceGraphLink, 0, len(links))
+	index := make(map[ServiceGraphLink]*ServiceGraphLink, len(links))
+	minutes := f.TimeFilter.Duration().Minutes()
+
+	for _, link := range links {
+		if !link.match(matcher) {
+			continue
+		}
+
+		key := ServiceGraphLink{
+			Type:       link.Type,
+			ClientAttr: link.ClientAttr,
+			ClientName: link.ClientName,
+			ServerAttr: link.ServerAttr,
+			ServerName: link.ServerName,
+		}
+		edge, ok := index[key]
+		if !ok {
+			edge = &key
+			edge.DurationMin = link.DurationMin
+			edge.DurationMax = link.DurationMax
+			index[key] = edge
+			edges = append(edges, edge)
+		}
+
+		edge.DurationMin = min(edge.DurationMin, link.DurationMin)
+		edge.DurationMax = max(edge.DurationMax, link.DurationMax)
+		edge.DurationSum += link.DurationSum
+		edge.Count += link.Count
+		edge.ErrorCount += link.ErrorCount
+	}
+
+	for _, edge := range edges {
+		if edge.Count > 0 {
+			edge.DurationAvg = edge.DurationSum / float64(edge.Count)
+			edge.ErrorRate = float64(edge.ErrorCount) / float64(edge.Count)
+		}
+		edge.Rate = float64(edge.Count) / minutes
+	}
+
+	return httputil.JSON(w, bunrouter.H{
+		"edges": edges,
+	})
+}
+
+func (h *ServiceGraphHandler) selectLinks(
+	ctx context.Context, f *SpanFilter,
+) ([]*ServiceGraphLink, error) {
+	var links []*ServiceGraphLink
+
+	if err := h.CH.NewSelect().
+		Model((*ServiceGraphEdge)(nil)).
+		ColumnExpr("e.type").
+		ColumnExpr("e.client_attr, e.client_name").
+		ColumnExpr("e.server_attr, e.server_name").
+		ColumnExpr("e.deployment_environment, e.service_namespace").
+		ColumnExpr("min(e.client_duration_min) AS duration_min").
+		ColumnExpr("max(e.client_duration_max) AS duration_max").
+		ColumnExpr("sum(e.client_duration_sum) AS duration_sum").
+		ColumnExpr("sum(e.count) AS count").
+		ColumnExpr("sum(e.error_count) AS error_count").
+		Where("e.project_id = ?", f.ProjectID).
+		Where("e.time >= ?", f.TimeGTE).
+		Where("e.time < ?", f.TimeLT).
+		GroupExpr("e.type, e.client_attr, e.client_name, e.server_attr, e.server_name").
+		GroupExpr("e.deployment_environment, e.service_namespace").
+		Limit(10000).
+		Scan(ctx, &links); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// serviceGraphMatcher builds a matcher from the filters that can be applied to edges,
+// i.e. filters on the service name, environment, and namespace. Other filters are ignored.
+func serviceGraphMatcher(parts []*tql.QueryPart) (*tql.Matcher, error) {
+	where := new(tql.Where)
+
+	for _, part := range parts {
+		if part.Disabled {
+			continue
+		}
+
+		ast, ok := part.AST.(*tql.Where)
+		if !ok {
+			continue
+		}
+
+		for _, filter := range ast.Filters {
+			attr, ok := filter.LHS.(tql.Attr)
+			if !ok {
+				continue
+			}
+
+			switch attrkey.Clean(attr.Name) {
+			case attrkey.ServiceName, attrkey.DeploymentEnvironment, attrkey.ServiceNamespace:
+				where.Filters = append(where.Filters, filter)
+			}
+		}
+	}
+
+	return tql.NewMatcher(where)
+}
+
+// match reports whether either side of the link matches the filters.
+func (l *ServiceGraphLink) match(matcher *tql.Matcher) bool {
+	return matcher.Match(l.attrGetter(l.ClientAttr, l.ClientName)) ||
+		matcher.Match(l.attrGetter(l.ServerAttr, l.ServerName))
+}
+
+func (l *ServiceGraphLink) attrGetter(attr, name string) tql.AttrGetter {
+	return func(key string) (any, bool) {
+		switch key {
+		case attr:
+			return name, true
+		case attrkey.DeploymentEnvironment:
+			return l.DeploymentEnvironment, l.DeploymentEnvironment != ""
+		case attrkey.ServiceNamespace:
+			return l.ServiceNamespace, l.ServiceNamespace != ""
+		}
+		return nil, false
+	}
+}
@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uptrace/pkg/clickhouse/bfloat16"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+)
+
+func TestServiceGraphEdgeSampling(t *testing.T) {
+	p := &ServiceGraphProcessor{edges: make(map[serviceGraphEdgeKey]*ServiceGraphEdge)}
+
+	newSpan := func(service string, dur time.Duration, samplingRatio float64) *Span {
+		span := &Span{
+			Time:     time.Unix(1700000000, 0),
+			Duration: dur,
+			Attrs:    AttrMap{attrkey.ServiceName: service},
+		}
+		span.samplingRatio = samplingRatio
+		return span
+	}
+
+	// 1 span sampled with 25% ratio represents 4 spans.
+	client := newEdgeSpan(newSpan("frontend", 2*time.Millisecond, 0.25))
+	client.isError = true
+	server := newEdgeSpan(newSpan("backend", time.Millisecond, 0.25))
+	p.addEdge(1, client, serverNode{attr: attrkey.ServiceName, name: "backend", span: server})
+
+	// Not sampled.
+	client = newEdgeSpan(newSpan("frontend", 2*time.Millisecond, 0))
+	server = newEdgeSpan(newSpan("backend", time.Millisecond, 0))
+	p.addEdge(1, client, serverNode{attr: attrkey.ServiceName, name: "backend", span: server})
+
+	require.Len(t, p.edges, 1)
+	for _, edge := range p.edges {
+		require.Equal(t, uint32(5), edge.Count)
+		require.Equal(t, uint32(4), edge.ErrorCount)
+		require.Equal(t, float32(10*time.Millisecond), edge.ClientDurationSum)
+		require.Equal(t, float32(5*time.Millisecond), edge.ServerDurationSum)
+		require.Equal(t, map[bfloat16.T]uint64{
+			bfloat16.From(float64(2 * time.Millisecond)): 5,
+		}, edge.ClientDurationHistogram)
+	}
+}
+
+func TestSpanSampleCount(t *testing.T) {
+	span := new(Span)
+	require.Equal(t, 1.0, span.SampleCount())
+
+	span.samplingRatio = 0.1
+	require.InDelta(t, 10.0, span.SampleCount(), 1e-9)
+}
+
+func TestServiceGraphProcessorStopBeforeRun(t *testing.T) {
+	p := NewServiceGraphProcessor(ServiceGraphProcessorParams{Conf: new(bunconf.Config)})
+
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+
+	p.Run()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return after Run")
+	}
+}
@@ -2,10 +2,12 @@ package tracing
 
 import (
 	"errors"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/uptrace/pkg/attrkey"
 )
 
 const (
@@ -54,6 +56,11 @@ type Span struct {
 	Children []*Span `json:"children,omitempty" msgpack:"-" ch:"-"`
 
 	logMessageHash uint64
+	// samplingRatio is set by the tail sampler for kept traces.
+	samplingRatio float64
+	// initialized is set when the span system and group are assigned. The tail sampler
+	// initializes spans before the consumer so policies can use the group.
+	initialized bool
 }
 
 type SpanEvent struct {
@@ -93,6 +100,15 @@ func (s *Span) IsError() bool {
 	return isErrorSystem(s.System)
 }
 
+// SampleCount returns the number of spans the span represents when the trace
+// was sampled by the tail sampler.
+func (s *Span) SampleCount() float64 {
+	if s.samplingRatio > 0 {
+		return 1 / s.samplingRatio
+	}
+	return 1
+}
+
 func (s *Span) Event() *SpanEvent {
 	return &SpanEvent{
 		Name:  s.DisplayName,
@@ -104,6 +120,42 @@ func (s *Span) Event() *SpanEvent {
 	}
 }
 
+// Attr returns the value of the span field or attribute using the cleaned
+// attribute key. It is used to evaluate TQL filters in memory.
+func (s *Span) Attr(key string) (any, bool) {
+	switch key {
+	case attrkey.SpanName:
+		return s.Name, true
+	case attrkey.SpanEventName:
+		return s.EventName, s.EventName != ""
+	case attrkey.SpanKind:
+		return s.Kind, true
+	case attrkey.SpanDuration:
+		return s.Duration, true
+	case attrkey.SpanStatusCode:
+		return s.StatusCode, true
+	case attrkey.SpanStatusMessage:
+		return s.StatusMessage, true
+	case attrkey.SpanSystem:
+		return s.System, s.System != ""
+	case attrkey.SpanGroupID:
+		return s.GroupID, s.GroupID != 0
+	case attrkey.DisplayName:
+		return s.DisplayName, s.DisplayName != ""
+	}
+
+	if value, ok := s.Attrs[key]; ok {
+		return value, true
+	}
+	// Attributes are not cleaned until the span is processed.
+	for attrKey, value := range s.Attrs {
+		if attrkey.Clean(attrKey) == key {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
 func (s *Span) TreeStartEndTime() (time.Time, time.Time) {
 	startTime := s.Time
 	endTime := s.EndTime()
@@ -223,6 +275,35 @@ func buildSpanTree(spans []*Span) (*Span, int) {
 	return root, len(m) + 1
 }
 
+// foldSpanEvents attaches events to their parent spans like buildSpanTree does
+// and removes them from the list. Events without a parent are attached to the root span.
+func foldSpanEvents(spans []*Span) []*Span {
+	var root *Span
+	m := make(map[idgen.SpanID]*Span, len(spans))
+
+	for _, s := range spans {
+		if s.IsEvent() {
+			continue
+		}
+		if s.ParentID == 0 && root == nil {
+			root = s
+		}
+		m[s.ID] = s
+	}
+
+	return slices.DeleteFunc(spans, func(s *Span) bool {
+		if !s.IsEvent() {
+			return false
+		}
+		if span, ok := m[s.ParentID]; ok {
+			span.AddEvent(s.Event())
+		} else if root != nil {
+			root.AddEvent(s.Event())
+		}
+		return true
+	})
+}
+
 func newFakeRoot(sample *Span) *Span {
 	span := &Span{
 		ID:      idgen.RandSpanID(),
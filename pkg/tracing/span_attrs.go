@@ -49,6 +49,7 @@ func (p *consumerWorker[IT, DT]) initSpanOrEvent(ctx context.Context, span *Span
 	}
 
 	span.System = utf8util.TruncSmall(span.System)
+	span.initialized = true
 }
 
 func (p *consumerWorker[IT, DT]) processAttrs(span *Span) {
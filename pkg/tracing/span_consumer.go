@@ -1,6 +1,8 @@
 package tracing
 
 import (
+	"context"
+
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 
@@ -11,13 +13,20 @@ type SpanConsumerParams struct {
 	fx.In
 
 	BaseConsumerParams
+	Processors []SpanProcessor `group:"span_processors"`
+}
+
+// SpanProcessor is called for spans after the attributes are normalized
+// and before the spans are stored. It must be safe for concurrent use.
+type SpanProcessor interface {
+	ProcessSpan(ctx context.Context, span *Span)
 }
 
 type SpanConsumer struct {
 	*BaseConsumer[SpanIndex, SpanData]
 }
 
-func NewSpanConsumer(p SpanConsumerParams) *SpanConsumer {
+func NewSpanConsumer(p SpanConsumerParams) (*SpanConsumer, error) {
 	batchSize := p.Conf.Spans.BatchSize
 	bufferSize := p.Conf.Spans.BufferSize
 	maxWorkers := p.Conf.Spans.MaxWorkers
@@ -37,13 +46,26 @@ func NewSpanConsumer(p SpanConsumerParams) *SpanConsumer {
 		),
 	}
 
+	sampler, err := newTailSampler(p.Conf)
+	if err != nil {
+		return nil, err
+	}
+	c.sampler = sampler
+	for _, processor := range p.Processors {
+		if processor != nil {
+			c.processors = append(c.processors, processor)
+		}
+	}
+
 	p.Logger.Info("starting processing spans...",
 		zap.Int("batch_size", batchSize),
 		zap.Int("buffer_size", bufferSize),
 		zap.Int("max_workers", maxWorkers),
+		zap.Bool("tail_sampling", sampler != nil),
+		zap.Int("span_processors", len(c.processors)),
 	)
 
-	return c
+	return c, nil
 }
 
 type spanTransformer struct {
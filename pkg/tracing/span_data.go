@@ -106,21 +106,26 @@ func SelectSpan(
 	return found, nil
 }
 
+// SelectTraceSpans selects spans of one or more traces with a single query.
 func SelectTraceSpans(
-	ctx context.Context, ch *ch.DB, traceID idgen.TraceID,
+	ctx context.Context, db *ch.DB, traceIDs []idgen.TraceID,
 ) ([]*Span, bool, error) {
 	const limit = 10000
 
+	if len(traceIDs) == 0 {
+		return nil, false, nil
+	}
+
 	var data []SpanData
 
-	if err := ch.NewSelect().
-		DistinctOn("id").
+	if err := db.NewSelect().
+		DistinctOn("trace_id, id").
 		ColumnExpr("project_id, trace_id, id, parent_id, time, data").
 		Model(&data).
 		Column("data").
-		Where("trace_id = ?", traceID).
+		Where("trace_id IN ?", ch.In(traceIDs)).
 		OrderExpr("time ASC").
-		Limit(limit).
+		Limit(limit * len(traceIDs)).
 		Scan(ctx); err != nil {
 		return nil, false, err
 	}
@@ -135,5 +140,5 @@ func SelectTraceSpans(
 		}
 	}
 
-	return spans, len(spans) == limit, nil
+	return spans, len(spans) == limit*len(traceIDs), nil
 }
@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"context"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+)
+
+type SpanDispatcherParams struct {
+	fx.In
+
+	Logger        *otelzap.Logger
+	SpanConsumer  *SpanConsumer
+	LogConsumer   *LogConsumer
+	EventConsumer *EventConsumer
+}
+
+// SpanDispatcher splits span events into logs and events
+// and sends them along with the span to the matching consumers.
+type SpanDispatcher struct {
+	*SpanDispatcherParams
+}
+
+func NewSpanDispatcher(p SpanDispatcherParams) *SpanDispatcher {
+	return &SpanDispatcher{&p}
+}
+
+func (d *SpanDispatcher) AddSpan(ctx context.Context, span *Span) {
+	for _, event := range span.Events {
+		eventSpan := &Span{
+			Attrs: NewAttrMap(),
+		}
+		initEventFromHostSpan(eventSpan, event, span)
+
+		if eventSpan.IsLog() {
+			d.LogConsumer.AddSpan(ctx, eventSpan)
+		} else if eventSpan.IsEvent() {
+			d.EventConsumer.AddSpan(ctx, eventSpan)
+		} else {
+			d.Logger.Error(
+				"Span is neither log nor event",
+				zap.String("name", span.Name),
+				zap.String("eventName", span.EventName),
+			)
+		}
+	}
+
+	span.Events = nil
+	d.SpanConsumer.AddSpan(ctx, span)
+}
@@ -222,7 +222,8 @@ func (h *SpanHandler) Percentiles(w http.ResponseWriter, req bunrouter.Request)
 				return q
 			}
 			return q.
-				WithAlias("qsNaN", "quantilesTDigest(0.5, 0.9, 0.99)(s.duration)").
+				WithAlias("qsNaN",
+					"quantilesTDigestWeighted(0.5, 0.9, 0.99)(s.duration, toUInt32(round(s.count)))").
 				WithAlias("qs", "if(isNaN(qsNaN[1]), [0, 0, 0], qsNaN)").
 				ColumnExpr("sumIf(s.count, s.status_code = 'error') AS errorCount").
 				ColumnExpr("sumIf(s.count, s.status_code = 'error') / ? AS errorRate",
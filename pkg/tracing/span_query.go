@@ -145,7 +145,8 @@ func (qb *QueryBuilder) appendCHFuncCall(b []byte, fn *tql.FuncCall, dur time.Du
 	case "per_sec":
 		return chschema.AppendQuery(b, "? / ?", arg, dur.Seconds()), nil
 	case "p50", "p75", "p90", "p99":
-		return chschema.AppendQuery(b, "quantileTDigest(?)(?)",
+		// Weight by count so tail-sampled spans are scaled back up.
+		return chschema.AppendQuery(b, "quantileTDigestWeighted(?)(?, toUInt32(round(s.count)))",
 			quantileLevel(funcName), arg), nil
 	case "top3":
 		return chschema.AppendQuery(b, "topK(3)(?)", arg), nil
@@ -0,0 +1,450 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/httputil"
+	"github.com/uptrace/uptrace/pkg/org"
+)
+
+const splunkSDK = "splunk"
+
+// HEC status codes, see https://docs.splunk.com/Documentation/Splunk/latest/Data/TroubleshootHTTPEventCollector.
+const (
+	hecCodeSuccess       = 0
+	hecCodeTokenRequired = 2
+	hecCodeInvalidAuth   = 3
+	hecCodeInvalidToken  = 4
+	hecCodeNoData        = 5
+	hecCodeInvalidFormat = 6
+	hecCodeInternalError = 8
+	hecCodeNoChannel     = 10
+	hecCodeHealthy       = 17
+)
+
+type SplunkHECHandlerParams struct {
+	fx.In
+
+	Logger   *otelzap.Logger
+	Projects *org.ProjectGateway
+	Consumer *LogConsumer
+}
+
+// SplunkHECHandler implements the Splunk HTTP Event Collector API.
+type SplunkHECHandler struct {
+	*SplunkHECHandlerParams
+
+	acks *hecAcks
+}
+
+func NewSplunkHECHandler(p SplunkHECHandlerParams) *SplunkHECHandler {
+	return &SplunkHECHandler{
+		SplunkHECHandlerParams: &p,
+		acks:                   newHECAcks(),
+	}
+}
+
+func registerSplunkHECHandler(h *SplunkHECHandler, p bunapp.RouterParams) {
+	p.Router.WithGroup("/services/collector", func(g *bunrouter.Group) {
+		g.POST("", h.Event)
+		g.POST("/event", h.Event)
+		g.POST("/event/1.0", h.Event)
+
+		g.POST("/raw", h.Raw)
+		g.POST("/raw/1.0", h.Raw)
+
+		g.POST("/ack", h.Ack)
+
+		g.GET("/health", h.Health)
+		g.GET("/health/1.0", h.Health)
+	})
+}
+
+func (h *SplunkHECHandler) Health(w http.ResponseWriter, req bunrouter.Request) error {
+	return httputil.JSON(w, bunrouter.H{
+		"text": "HEC is healthy",
+		"code": hecCodeHealthy,
+	})
+}
+
+// Ack reports that the events are indexed for the ack ids issued to the channel,
+// because events are queued for processing as soon as they are accepted.
+func (h *SplunkHECHandler) Ack(w http.ResponseWriter, req bunrouter.Request) error {
+	project, ok := h.project(w, req)
+	if !ok {
+		return nil
+	}
+
+	channel := hecChannel(req)
+	if channel == "" {
+		return writeHECError(w, http.StatusBadRequest, hecCodeNoChannel, "Data channel is missing")
+	}
+
+	var in struct {
+		Acks []int64 `json:"acks"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+		return writeHECError(w, http.StatusBadRequest, hecCodeInvalidFormat, "Invalid data format")
+	}
+
+	acks := make(map[string]bool, len(in.Acks))
+	for _, id := range in.Acks {
+		acks[strconv.FormatInt(id, 10)] = h.acks.query(project.ID, channel, id)
+	}
+	return httputil.JSON(w, bunrouter.H{"acks": acks})
+}
+
+func (h *SplunkHECHandler) Event(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	project, ok := h.project(w, req)
+	if !ok {
+		return nil
+	}
+
+	// Decode all events first so a malformed batch is rejected as a whole
+	// and the client can retry it without creating duplicates.
+	var events []*hecEvent
+	dec := json.NewDecoder(req.Body)
+	for {
+		event := new(hecEvent)
+		if err := dec.Decode(event); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return writeHECError(w, http.StatusBadRequest, hecCodeInvalidFormat, "Invalid data format")
+		}
+		if event.Event == nil {
+			return writeHECError(w, http.StatusBadRequest, hecCodeNoData, "No data")
+		}
+		events = append(events, event)
+	}
+
+	if len(events) == 0 {
+		return writeHECError(w, http.StatusBadRequest, hecCodeNoData, "No data")
+	}
+
+	h.addEvents(ctx, project, events)
+	return h.success(w, req, project)
+}
+
+// Raw accepts newline-delimited events with metadata passed using query params.
+func (h *SplunkHECHandler) Raw(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	project, ok := h.project(w, req)
+	if !ok {
+		return nil
+	}
+
+	query := req.URL.Query()
+	meta := hecEvent{
+		Host:       query.Get("host"),
+		Source:     query.Get("source"),
+		SourceType: query.Get("sourcetype"),
+		Index:      query.Get("index"),
+	}
+	if s := query.Get("time"); s != "" {
+		if err := meta.Time.UnmarshalJSON([]byte(s)); err != nil {
+			return writeHECError(w, http.StatusBadRequest, hecCodeInvalidFormat, "Invalid data format")
+		}
+	}
+
+	sc := bufio.NewScanner(req.Body)
+	sc.Buffer(make([]byte, 0, 64<<10), 32<<20)
+
+	var events []*hecEvent
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		event := meta
+		event.Event = string(line)
+		events = append(events, &event)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	if len(events) == 0 {
+		return writeHECError(w, http.StatusBadRequest, hecCodeNoData, "No data")
+	}
+
+	h.addEvents(ctx, project, events)
+	return h.success(w, req, project)
+}
+
+func (h *SplunkHECHandler) addEvents(ctx context.Context, project *org.Project, events []*hecEvent) {
+	p := new(splunkLogProcessor)
+	for _, event := range events {
+		span := new(Span)
+		p.spanFromHEC(ctx, span, event)
+		span.ProjectID = project.ID
+		h.Consumer.AddSpan(ctx, span)
+	}
+}
+
+func (h *SplunkHECHandler) success(
+	w http.ResponseWriter, req bunrouter.Request, project *org.Project,
+) error {
+	resp := bunrouter.H{
+		"text": "Success",
+		"code": hecCodeSuccess,
+	}
+	if channel := hecChannel(req); channel != "" {
+		resp["ackId"] = h.acks.issue(project.ID, channel)
+	}
+	return httputil.JSON(w, resp)
+}
+
+// project authenticates the request using the HEC token which is a project token.
+// It writes an HEC error and returns false when the request is not authenticated.
+func (h *SplunkHECHandler) project(
+	w http.ResponseWriter, req bunrouter.Request,
+) (*org.Project, bool) {
+	ctx := req.Context()
+
+	token, ok := hecTokenFromRequest(req)
+	if !ok {
+		_ = writeHECError(w, http.StatusUnauthorized, hecCodeTokenRequired, "Token is required")
+		return nil, false
+	}
+	if token == "" {
+		_ = writeHECError(w, http.StatusUnauthorized, hecCodeInvalidAuth, "Invalid authorization")
+		return nil, false
+	}
+
+	project, err := h.Projects.SelectByToken(ctx, token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = writeHECError(w, http.StatusForbidden, hecCodeInvalidToken, "Invalid token")
+		} else {
+			_ = writeHECError(w, http.StatusInternalServerError, hecCodeInternalError, "Internal server error")
+		}
+		return nil, false
+	}
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.Int64("project", int64(project.ID)))
+	}
+
+	return project, true
+}
+
+// hecTokenFromRequest supports "Authorization: Splunk <token>", basic auth
+// with the token as a password, and the token query param.
+func hecTokenFromRequest(req bunrouter.Request) (string, bool) {
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		if token, ok := strings.CutPrefix(auth, "Splunk "); ok {
+			return strings.TrimSpace(token), true
+		}
+		if _, password, ok := req.BasicAuth(); ok {
+			return password, true
+		}
+		return "", true
+	}
+	if token := req.URL.Query().Get("token"); token != "" {
+		return token, true
+	}
+	return "", false
+}
+
+func hecChannel(req bunrouter.Request) string {
+	if channel := req.Header.Get("X-Splunk-Request-Channel"); channel != "" {
+		return channel
+	}
+	return req.URL.Query().Get("channel")
+}
+
+func writeHECError(w http.ResponseWriter, statusCode, code int, text string) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	return json.NewEncoder(w).Encode(bunrouter.H{
+		"text": text,
+		"code": code,
+	})
+}
+
+//------------------------------------------------------------------------------
+
+const (
+	hecMaxPendingAcks   = 10000
+	hecChannelIdleAfter = 10 * time.Minute
+)
+
+// hecAcks tracks ack ids issued to each channel until they are queried.
+// Like Splunk, an ack id is reported only once and idle channels are dropped.
+type hecAcks struct {
+	mu       sync.Mutex
+	lastID   int64
+	channels map[hecChannelKey]*hecChannelAcks
+	gcAt     time.Time
+}
+
+type hecChannelKey struct {
+	projectID uint32
+	channel   string
+}
+
+type hecChannelAcks struct {
+	pending  map[int64]struct{}
+	queue    []int64 // ack ids in the order they were issued
+	lastSeen time.Time
+}
+
+func newHECAcks() *hecAcks {
+	return &hecAcks{
+		channels: make(map[hecChannelKey]*hecChannelAcks),
+	}
+}
+
+func (a *hecAcks) issue(projectID uint32, channel string) int64 {
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if now.Sub(a.gcAt) > hecChannelIdleAfter {
+		a.gcAt = now
+		for key, ch := range a.channels {
+			if now.Sub(ch.lastSeen) > hecChannelIdleAfter {
+				delete(a.channels, key)
+			}
+		}
+	}
+
+	key := hecChannelKey{projectID: projectID, channel: channel}
+	ch, ok := a.channels[key]
+	if !ok {
+		ch = &hecChannelAcks{pending: make(map[int64]struct{})}
+		a.channels[key] = ch
+	}
+	ch.lastSeen = now
+
+	a.lastID++
+	id := a.lastID
+	ch.pending[id] = struct{}{}
+	ch.queue = append(ch.queue, id)
+
+	// Forget the oldest ack ids when the client does not query them.
+	for len(ch.pending) > hecMaxPendingAcks {
+		delete(ch.pending, ch.queue[0])
+		ch.queue = ch.queue[1:]
+	}
+	if len(ch.queue) > 2*hecMaxPendingAcks {
+		queue := make([]int64, 0, len(ch.pending))
+		for _, id := range ch.queue {
+			if _, ok := ch.pending[id]; ok {
+				queue = append(queue, id)
+			}
+		}
+		ch.queue = queue
+	}
+
+	return id
+}
+
+// query reports whether the ack id was issued to the channel and removes it.
+func (a *hecAcks) query(projectID uint32, channel string, id int64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	ch, ok := a.channels[hecChannelKey{projectID: projectID, channel: channel}]
+	if !ok {
+		return false
+	}
+	ch.lastSeen = time.Now()
+
+	if _, ok := ch.pending[id]; !ok {
+		return false
+	}
+	delete(ch.pending, id)
+	return true
+}
+
+//------------------------------------------------------------------------------
+
+type hecEvent struct {
+	Time       hecTime        `json:"time"`
+	Host       string         `json:"host"`
+	Source     string         `json:"source"`
+	SourceType string         `json:"sourcetype"`
+	Index      string         `json:"index"`
+	Event      any            `json:"event"`
+	Fields     map[string]any `json:"fields"`
+}
+
+// hecTime is the number of seconds since the epoch encoded as a number or a string,
+// for example, 1426279439.123 or "1426279439.123".
+type hecTime struct {
+	time.Time
+}
+
+func (t *hecTime) UnmarshalJSON(b []byte) error {
+	s := string(bytes.Trim(b, `"`))
+	if s == "" || s == "null" {
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+
+	t.Time = time.UnixMicro(int64(math.Round(f * 1e6)))
+	return nil
+}
+
+type splunkLogProcessor struct {
+	vectorLogProcessor
+}
+
+func (p *splunkLogProcessor) spanFromHEC(ctx context.Context, span *Span, event *hecEvent) {
+	params := make(AttrMap, len(event.Fields)+5)
+
+	if m, ok := event.Event.(map[string]any); ok {
+		flattenParams(params, "", m)
+	} else {
+		params["message"] = event.Event
+	}
+
+	flattenParams(params, "", event.Fields)
+	if event.Host != "" {
+		params[attrkey.HostName] = event.Host
+	}
+	if event.Source != "" {
+		params[attrkey.LogSource] = event.Source
+	}
+	if event.SourceType != "" {
+		params["splunk_sourcetype"] = event.SourceType
+	}
+	if event.Index != "" {
+		params["splunk_index"] = event.Index
+	}
+
+	span.Time = event.Time.Time
+	p.spanFromVector(ctx, span, params)
+	span.Attrs[attrkey.TelemetrySDKName] = splunkSDK
+}
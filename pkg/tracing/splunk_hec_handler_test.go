@@ -0,0 +1,31 @@
+package tracing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHECAcks(t *testing.T) {
+	acks := newHECAcks()
+
+	id1 := acks.issue(1, "foo")
+	id2 := acks.issue(1, "foo")
+	id3 := acks.issue(2, "foo")
+
+	require.False(t, acks.query(1, "foo", 12345), "never issued")
+	require.False(t, acks.query(1, "bar", id1), "another channel")
+	require.False(t, acks.query(1, "foo", id3), "another project")
+
+	require.True(t, acks.query(1, "foo", id1))
+	require.False(t, acks.query(1, "foo", id1), "already queried")
+	require.True(t, acks.query(1, "foo", id2))
+	require.True(t, acks.query(2, "foo", id3))
+
+	first := acks.issue(1, "foo")
+	for i := 0; i < hecMaxPendingAcks; i++ {
+		acks.issue(1, "foo")
+	}
+	require.False(t, acks.query(1, "foo", first), "evicted")
+	require.True(t, acks.query(1, "foo", first+1))
+}
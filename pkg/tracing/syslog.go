@@ -0,0 +1,318 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/bunutil"
+	"github.com/uptrace/uptrace/pkg/run"
+	"github.com/uptrace/uptrace/pkg/tracing/norm"
+)
+
+const (
+	syslogSDK            = "syslog"
+	syslogMaxMessageSize = 1 << 20
+	// syslogMaxFrameSize leaves room for the octet count and the space
+	// before the message.
+	syslogMaxFrameSize = syslogMaxMessageSize + 16
+)
+
+type SyslogServerParams struct {
+	fx.In
+
+	Logger   *otelzap.Logger
+	Conf     *bunconf.Config
+	Consumer *LogConsumer
+}
+
+// SyslogServer receives RFC 5424 and RFC 3164 messages over UDP and TCP.
+type SyslogServer struct {
+	*SyslogServerParams
+
+	mu      sync.Mutex
+	closers []io.Closer
+	wg      sync.WaitGroup
+}
+
+func NewSyslogServer(p SyslogServerParams) *SyslogServer {
+	return &SyslogServer{SyslogServerParams: &p}
+}
+
+func runSyslogServer(group *run.Group, srv *SyslogServer) error {
+	if len(srv.Conf.Listen.Syslog) == 0 {
+		return nil
+	}
+
+	for _, conf := range srv.Conf.Listen.Syslog {
+		if err := srv.listen(group, conf); err != nil {
+			srv.Logger.Error("syslog listen failed (edit listen.syslog YAML option)",
+				zap.Error(err), zap.String("network", conf.Network), zap.String("addr", conf.Addr))
+			return err
+		}
+	}
+
+	group.OnStop(func(context.Context, error) error {
+		return srv.Close()
+	})
+
+	return nil
+}
+
+func (s *SyslogServer) listen(group *run.Group, conf *bunconf.SyslogListen) error {
+	switch conf.Network {
+	case "udp":
+		conn, err := net.ListenPacket("udp", conf.Addr)
+		if err != nil {
+			return err
+		}
+		s.addCloser(conn)
+
+		group.Add("syslog.ServeUDP", func() error {
+			return s.serveUDP(conn, conf)
+		})
+		return nil
+	case "tcp":
+		ln, err := net.Listen("tcp", conf.Addr)
+		if err != nil {
+			return err
+		}
+
+		if conf.TLS != nil {
+			tlsConf, err := conf.TLS.TLSConfig()
+			if err != nil {
+				_ = ln.Close()
+				return err
+			}
+			ln = tls.NewListener(ln, tlsConf)
+		}
+		s.addCloser(ln)
+
+		group.Add("syslog.ServeTCP", func() error {
+			return s.serveTCP(ln, conf)
+		})
+		return nil
+	default:
+		return fmt.Errorf("unsupported network: %q", conf.Network)
+	}
+}
+
+func (s *SyslogServer) addCloser(c io.Closer) {
+	s.mu.Lock()
+	s.closers = append(s.closers, c)
+	s.mu.Unlock()
+}
+
+func (s *SyslogServer) removeCloser(c io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, closer := range s.closers {
+		if closer == c {
+			s.closers = append(s.closers[:i], s.closers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *SyslogServer) Close() error {
+	s.mu.Lock()
+	for _, c := range s.closers {
+		_ = c.Close()
+	}
+	s.closers = nil
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+func (s *SyslogServer) serveUDP(conn net.PacketConn, conf *bunconf.SyslogListen) error {
+	ctx := context.Background()
+	p := new(syslogLogProcessor)
+	buf := make([]byte, 64<<10)
+
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		s.process(ctx, p, conf, buf[:n])
+	}
+}
+
+func (s *SyslogServer) serveTCP(ln net.Listener, conf *bunconf.SyslogListen) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		s.addCloser(conn)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.removeCloser(conn)
+			defer conn.Close()
+
+			if err := s.serveConn(conn, conf); err != nil && !errors.Is(err, net.ErrClosed) {
+				s.Logger.Error("syslog connection failed",
+					zap.Error(err), zap.String("remote_addr", conn.RemoteAddr().String()))
+			}
+		}()
+	}
+}
+
+func (s *SyslogServer) serveConn(conn net.Conn, conf *bunconf.SyslogListen) error {
+	ctx := context.Background()
+	p := new(syslogLogProcessor)
+
+	sc := newSyslogScanner(conn)
+	for sc.Scan() {
+		s.process(ctx, p, conf, sc.Bytes())
+	}
+	return sc.Err()
+}
+
+func (s *SyslogServer) process(
+	ctx context.Context, p *syslogLogProcessor, conf *bunconf.SyslogListen, b []byte,
+) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return
+	}
+
+	msg, err := parseSyslog(b, time.Now())
+	if err != nil {
+		s.Logger.Error("can't parse syslog message", zap.Error(err))
+		return
+	}
+
+	span := new(Span)
+	p.spanFromSyslog(span, msg)
+	span.ProjectID = conf.ProjectID
+	s.Consumer.AddSpan(ctx, span)
+}
+
+func newSyslogScanner(r io.Reader) *bufio.Scanner {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), syslogMaxFrameSize)
+	sc.Split(scanSyslogFrames)
+	return sc
+}
+
+// scanSyslogFrames splits a TCP stream using either octet counting (RFC 6587),
+// for example, "11 <13>1 - - -", or newline-delimited framing.
+func scanSyslogFrames(data []byte, atEOF bool) (int, []byte, error) {
+	start := 0
+	for start < len(data) && (data[start] == '\n' || data[start] == '\r') {
+		start++
+	}
+	b := data[start:]
+
+	if len(b) == 0 {
+		return start, nil, nil
+	}
+
+	if b[0] >= '0' && b[0] <= '9' {
+		sp := bytes.IndexByte(b, ' ')
+		if sp == -1 {
+			if atEOF || len(b) > 10 {
+				return 0, nil, errors.New("syslog: invalid octet count")
+			}
+			return start, nil, nil
+		}
+
+		n, err := strconv.Atoi(string(b[:sp]))
+		if err != nil || n > syslogMaxMessageSize {
+			return 0, nil, fmt.Errorf("syslog: invalid octet count: %q", b[:sp])
+		}
+
+		if len(b) < sp+1+n {
+			if atEOF {
+				return 0, nil, io.ErrUnexpectedEOF
+			}
+			return start, nil, nil
+		}
+		return start + sp + 1 + n, b[sp+1 : sp+1+n], nil
+	}
+
+	if idx := bytes.IndexByte(b, '\n'); idx >= 0 {
+		return start + idx + 1, b[:idx], nil
+	}
+	if atEOF {
+		return len(data), b, nil
+	}
+	return start, nil, nil
+}
+
+//------------------------------------------------------------------------------
+
+type syslogLogProcessor struct {
+	baseLogProcessor
+}
+
+func (p *syslogLogProcessor) spanFromSyslog(span *Span, msg *syslogMessage) {
+	span.ID = idgen.RandSpanID()
+	span.Kind = InternalSpanKind
+	span.EventName = otelEventLog
+	span.StatusCode = OKStatusCode
+
+	span.Time = msg.Time
+	if span.Time.IsZero() {
+		span.Time = time.Now()
+	}
+
+	span.Attrs = make(AttrMap, 8)
+	span.Attrs[attrkey.TelemetrySDKName] = syslogSDK
+	span.Attrs["syslog_facility"] = msg.FacilityName()
+	if sev := norm.SyslogSeverity(msg.Severity); sev != "" {
+		span.Attrs[attrkey.LogSeverity] = sev
+	}
+	if msg.Hostname != "" {
+		span.Attrs[attrkey.HostName] = msg.Hostname
+	}
+	if msg.AppName != "" {
+		span.Attrs["syslog_app_name"] = msg.AppName
+	}
+	if msg.ProcID != "" {
+		span.Attrs["syslog_proc_id"] = msg.ProcID
+	}
+	if msg.MsgID != "" {
+		span.Attrs["syslog_msg_id"] = msg.MsgID
+	}
+	for id, params := range msg.StructuredData {
+		for name, value := range params {
+			if key := attrkey.Clean("syslog_sd_" + id + "_" + name); key != "" {
+				span.Attrs[key] = value
+			}
+		}
+	}
+
+	if params, ok := bunutil.IsJSON(msg.Message); ok {
+		p.parseJSONLogMessage(span, params)
+	} else if msg.Message != "" {
+		span.Attrs[attrkey.LogMessage] = msg.Message
+	}
+}
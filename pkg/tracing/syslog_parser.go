@@ -0,0 +1,250 @@
+package tracing
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const syslogNilValue = "-"
+
+var syslogFacilities = [...]string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+type syslogMessage struct {
+	Facility int
+	Severity int
+
+	Time     time.Time
+	Hostname string
+	AppName  string
+	ProcID   string
+	MsgID    string
+
+	StructuredData map[string]map[string]string
+	Message        string
+}
+
+func (m *syslogMessage) FacilityName() string {
+	if m.Facility >= 0 && m.Facility < len(syslogFacilities) {
+		return syslogFacilities[m.Facility]
+	}
+	return strconv.Itoa(m.Facility)
+}
+
+// parseSyslog parses messages in the RFC 5424 and RFC 3164 (BSD) formats.
+func parseSyslog(b []byte, now time.Time) (*syslogMessage, error) {
+	b = bytes.TrimRight(b, "\r\n\x00")
+
+	msg := new(syslogMessage)
+
+	pri, rest, err := parseSyslogPRI(b)
+	if err != nil {
+		return nil, err
+	}
+	msg.Facility = pri / 8
+	msg.Severity = pri % 8
+
+	if len(rest) >= 2 && rest[0] == '1' && rest[1] == ' ' {
+		if err := parseRFC5424(msg, rest[2:]); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+
+	parseRFC3164(msg, rest, now)
+	return msg, nil
+}
+
+func parseSyslogPRI(b []byte) (int, []byte, error) {
+	if len(b) < 3 || b[0] != '<' {
+		return 0, nil, errors.New("syslog: message must start with <PRI>")
+	}
+
+	end := bytes.IndexByte(b[:min(len(b), 5)], '>')
+	if end < 2 {
+		return 0, nil, errors.New("syslog: invalid <PRI>")
+	}
+
+	pri, err := strconv.Atoi(string(b[1:end]))
+	if err != nil || pri < 0 || pri > 191 {
+		return 0, nil, fmt.Errorf("syslog: invalid <PRI>: %q", b[:end+1])
+	}
+	return pri, b[end+1:], nil
+}
+
+// parseRFC5424 parses "TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD [MSG]".
+func parseRFC5424(msg *syslogMessage, b []byte) error {
+	var fields [5]string
+	for i := range fields {
+		idx := bytes.IndexByte(b, ' ')
+		if idx == -1 {
+			if i < len(fields)-1 {
+				return errors.New("syslog: RFC 5424 header is truncated")
+			}
+			idx = len(b)
+		}
+		fields[i] = string(b[:idx])
+		b = b[min(idx+1, len(b)):]
+	}
+
+	if fields[0] != syslogNilValue {
+		tm, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return fmt.Errorf("syslog: can't parse timestamp %q: %w", fields[0], err)
+		}
+		msg.Time = tm
+	}
+	msg.Hostname = syslogValue(fields[1])
+	msg.AppName = syslogValue(fields[2])
+	msg.ProcID = syslogValue(fields[3])
+	msg.MsgID = syslogValue(fields[4])
+
+	if len(b) == 0 {
+		return nil
+	}
+
+	if b[0] == '-' {
+		b = b[1:]
+	} else {
+		sd, rest, err := parseSyslogStructuredData(b)
+		if err != nil {
+			return err
+		}
+		msg.StructuredData = sd
+		b = rest
+	}
+
+	if len(b) > 0 && b[0] == ' ' {
+		b = b[1:]
+	}
+	b = bytes.TrimPrefix(b, []byte("\xef\xbb\xbf")) // UTF-8 BOM
+	msg.Message = string(b)
+
+	return nil
+}
+
+// parseSyslogStructuredData parses one or more `[SD-ID PARAM="VALUE" ...]` elements.
+func parseSyslogStructuredData(b []byte) (map[string]map[string]string, []byte, error) {
+	sd := make(map[string]map[string]string)
+
+	for len(b) > 0 && b[0] == '[' {
+		b = b[1:]
+
+		end := bytes.IndexAny(b, " ]")
+		if end == -1 {
+			return nil, nil, errors.New("syslog: structured data is truncated")
+		}
+		params := make(map[string]string)
+		sd[string(b[:end])] = params
+		b = b[end:]
+
+		for {
+			if len(b) == 0 {
+				return nil, nil, errors.New("syslog: structured data is truncated")
+			}
+			if b[0] == ']' {
+				b = b[1:]
+				break
+			}
+			if b[0] != ' ' {
+				return nil, nil, fmt.Errorf("syslog: unexpected %q in structured data", b[0])
+			}
+			b = b[1:]
+
+			eq := bytes.IndexByte(b, '=')
+			if eq == -1 || eq+1 >= len(b) || b[eq+1] != '"' {
+				return nil, nil, errors.New("syslog: invalid structured data param")
+			}
+			name := string(b[:eq])
+			b = b[eq+2:]
+
+			var value strings.Builder
+			for {
+				if len(b) == 0 {
+					return nil, nil, errors.New("syslog: structured data is truncated")
+				}
+				c := b[0]
+				b = b[1:]
+				if c == '"' {
+					break
+				}
+				if c == '\\' && len(b) > 0 && (b[0] == '"' || b[0] == '\\' || b[0] == ']') {
+					c = b[0]
+					b = b[1:]
+				}
+				value.WriteByte(c)
+			}
+			params[name] = value.String()
+		}
+	}
+
+	return sd, b, nil
+}
+
+// parseRFC3164 parses "TIMESTAMP HOSTNAME TAG[PID]: MSG". RFC 3164 is not strict
+// so the parser falls back to using the rest of the line as a message.
+func parseRFC3164(msg *syslogMessage, b []byte, now time.Time) {
+	if tm, rest, ok := parseRFC3164Time(b, now); ok {
+		msg.Time = tm
+		b = rest
+
+		if idx := bytes.IndexByte(b, ' '); idx > 0 && !isSyslogTag(b[:idx]) {
+			msg.Hostname = string(b[:idx])
+			b = b[idx+1:]
+		}
+	}
+
+	if idx := bytes.IndexByte(b, ' '); idx > 0 && isSyslogTag(b[:idx]) {
+		tag := b[:idx-1]
+		if open := bytes.IndexByte(tag, '['); open > 0 && tag[len(tag)-1] == ']' {
+			msg.ProcID = string(tag[open+1 : len(tag)-1])
+			tag = tag[:open]
+		}
+		msg.AppName = string(tag)
+		b = b[idx+1:]
+	}
+
+	msg.Message = string(b)
+}
+
+func parseRFC3164Time(b []byte, now time.Time) (time.Time, []byte, bool) {
+	const stampLen = len(time.Stamp)
+
+	if len(b) > stampLen && b[stampLen] == ' ' {
+		if tm, err := time.ParseInLocation(time.Stamp, string(b[:stampLen]), time.Local); err == nil {
+			tm = tm.AddDate(now.Year(), 0, 0)
+			// The year is missing so handle messages sent at the end of the previous year.
+			if tm.Sub(now) > 24*time.Hour {
+				tm = tm.AddDate(-1, 0, 0)
+			}
+			return tm, b[stampLen+1:], true
+		}
+	}
+
+	// Some daemons, for example, rsyslog use RFC 3339 timestamps.
+	if idx := bytes.IndexByte(b, ' '); idx > 0 {
+		if tm, err := time.Parse(time.RFC3339Nano, string(b[:idx])); err == nil {
+			return tm, b[idx+1:], true
+		}
+	}
+
+	return time.Time{}, b, false
+}
+
+func isSyslogTag(b []byte) bool {
+	return len(b) > 1 && b[len(b)-1] == ':'
+}
+
+func syslogValue(s string) string {
+	if s == syslogNilValue {
+		return ""
+	}
+	return s
+}
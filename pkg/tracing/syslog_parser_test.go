@@ -0,0 +1,199 @@
+package tracing
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSyslog(t *testing.T) {
+	now := time.Date(2024, time.March, 10, 12, 0, 0, 0, time.Local)
+
+	type Test struct {
+		in      string
+		msg     *syslogMessage
+		wantErr bool
+	}
+
+	tests := []Test{
+		{
+			in: `<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - 'su root' failed`,
+			msg: &syslogMessage{
+				Facility: 4,
+				Severity: 2,
+				Time:     time.Date(2003, time.October, 11, 22, 14, 15, 3e6, time.UTC),
+				Hostname: "mymachine.example.com",
+				AppName:  "su",
+				MsgID:    "ID47",
+				Message:  "'su root' failed",
+			},
+		},
+		{
+			in: `<165>1 - host app 1234 - [exampleSDID@32473 iut="3" eventSource="App\"lication\]"][meta x="1"] ` +
+				"\xef\xbb\xbfhello\r\n",
+			msg: &syslogMessage{
+				Facility: 20,
+				Severity: 5,
+				Hostname: "host",
+				AppName:  "app",
+				ProcID:   "1234",
+				StructuredData: map[string]map[string]string{
+					"exampleSDID@32473": {"iut": "3", "eventSource": `App"lication]`},
+					"meta":              {"x": "1"},
+				},
+				Message: "hello",
+			},
+		},
+		{
+			in: `<13>1 - - - - -`,
+			msg: &syslogMessage{
+				Facility: 1,
+				Severity: 5,
+			},
+		},
+		{
+			in: `<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed`,
+			msg: &syslogMessage{
+				Facility: 4,
+				Severity: 2,
+				Time:     time.Date(2023, time.October, 11, 22, 14, 15, 0, time.Local),
+				Hostname: "mymachine",
+				AppName:  "su",
+				ProcID:   "123",
+				Message:  "'su root' failed",
+			},
+		},
+		{
+			in: `<13>Mar  9 22:14:15 sshd: hello world`,
+			msg: &syslogMessage{
+				Facility: 1,
+				Severity: 5,
+				Time:     time.Date(2024, time.March, 9, 22, 14, 15, 0, time.Local),
+				AppName:  "sshd",
+				Message:  "hello world",
+			},
+		},
+		{
+			in: `<13>2024-03-10T11:00:00Z host cron: job done`,
+			msg: &syslogMessage{
+				Facility: 1,
+				Severity: 5,
+				Time:     time.Date(2024, time.March, 10, 11, 0, 0, 0, time.UTC),
+				Hostname: "host",
+				AppName:  "cron",
+				Message:  "job done",
+			},
+		},
+		{
+			in: `<13>just a message`,
+			msg: &syslogMessage{
+				Facility: 1,
+				Severity: 5,
+				Message:  "just a message",
+			},
+		},
+		{in: ``, wantErr: true},
+		{in: `hello`, wantErr: true},
+		{in: `<>hello`, wantErr: true},
+		{in: `<13hello`, wantErr: true},
+		{in: `<1234>hello`, wantErr: true},
+		{in: `<192>hello`, wantErr: true},
+		{in: `<-1>hello`, wantErr: true},
+		{in: `<ab>hello`, wantErr: true},
+		{in: `<13>1 2003-10-11T22:14:15Z host`, wantErr: true},
+		{in: `<13>1 yesterday host app - - - hello`, wantErr: true},
+		{in: `<13>1 - host app - - [id a="1"`, wantErr: true},
+		{in: `<13>1 - host app - - [id a="1`, wantErr: true},
+		{in: `<13>1 - host app - - [id a=1]`, wantErr: true},
+		{in: `<13>1 - host app - - [id`, wantErr: true},
+		{in: `<13>1 - host app - - [id a="1"x]`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			msg, err := parseSyslog([]byte(test.in), now)
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			require.True(t, test.msg.Time.Equal(msg.Time), "got %s", msg.Time)
+			msg.Time = test.msg.Time
+			require.Equal(t, test.msg, msg)
+		})
+	}
+}
+
+func TestScanSyslogFrames(t *testing.T) {
+	type Test struct {
+		in      string
+		frames  []string
+		wantErr bool
+	}
+
+	tests := []Test{
+		{
+			in:     "<13>1 - - - - - foo\n<13>bar\r\n\n<13>baz",
+			frames: []string{"<13>1 - - - - - foo", "<13>bar\r", "<13>baz"},
+		},
+		{
+			in:     "7 <13>foo11 <13>foo\nbar",
+			frames: []string{"<13>foo", "<13>foo\nbar"},
+		},
+		{
+			in:      "10 <13>foo",
+			frames:  []string{},
+			wantErr: true,
+		},
+		{
+			in:      "12345678901 <13>foo",
+			frames:  []string{},
+			wantErr: true,
+		},
+		{
+			in:      "1x <13>foo",
+			frames:  []string{},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			scanner := newSyslogScanner(strings.NewReader(test.in))
+
+			frames := []string{}
+			for scanner.Scan() {
+				frames = append(frames, scanner.Text())
+			}
+			require.Equal(t, test.frames, frames)
+
+			if test.wantErr {
+				require.Error(t, scanner.Err())
+			} else {
+				require.NoError(t, scanner.Err())
+			}
+		})
+	}
+}
+
+func TestSyslogScannerMaxFrame(t *testing.T) {
+	msg := "<13>" + strings.Repeat("x", syslogMaxMessageSize-4)
+	in := strconv.Itoa(len(msg)) + " " + msg + "7 <13>foo"
+
+	scanner := newSyslogScanner(strings.NewReader(in))
+	require.True(t, scanner.Scan())
+	require.Equal(t, msg, scanner.Text())
+	require.True(t, scanner.Scan())
+	require.Equal(t, "<13>foo", scanner.Text())
+	require.False(t, scanner.Scan())
+	require.NoError(t, scanner.Err())
+
+	in = strconv.Itoa(syslogMaxMessageSize+1) + " " + msg + "xx"
+	scanner = newSyslogScanner(strings.NewReader(in))
+	require.False(t, scanner.Scan())
+	require.ErrorContains(t, scanner.Err(), "invalid octet count")
+}
@@ -0,0 +1,221 @@
+package tracing
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zyedidia/generic/cache"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/bunotel"
+	"github.com/uptrace/uptrace/pkg/tracing/tql"
+)
+
+// tailSampler buffers spans by trace id and decides whether to keep
+// the whole trace after the decision wait using the project policies.
+// It is not thread-safe and is only used from the consumer process loop.
+type tailSampler struct {
+	decisionWait time.Duration
+	numTraces    int
+
+	policies map[uint32][]*samplingPolicy
+
+	traces map[traceKey]*sampledTrace
+	fifo   *list.List
+
+	// Decisions for recently decided traces so late spans follow the trace.
+	// The value is the sampling ratio or 0 if the trace was dropped.
+	decisions *cache.Cache[traceKey, float64]
+
+	// initSpan assigns the span system and group before the span is buffered
+	// so policies can use _system and _group_id.
+	initSpan func(ctx context.Context, span *Span)
+}
+
+type traceKey struct {
+	projectID uint32
+	traceID   idgen.TraceID
+}
+
+type sampledTrace struct {
+	key      traceKey
+	deadline time.Time
+	spans    []*Span
+	elem     *list.Element
+}
+
+type samplingPolicy struct {
+	*bunconf.SamplingPolicy
+	matcher *tql.Matcher
+}
+
+// newTailSampler returns nil when none of the projects has sampling policies.
+func newTailSampler(conf *bunconf.Config) (*tailSampler, error) {
+	policies := make(map[uint32][]*samplingPolicy)
+	for _, project := range conf.Projects {
+		for _, policy := range project.TailSampling.Policies {
+			p := &samplingPolicy{SamplingPolicy: policy}
+			if policy.Where != "" {
+				matcher, err := tql.ParseMatcher(policy.Where)
+				if err != nil {
+					return nil, fmt.Errorf("project %d: policy %q: invalid where: %w",
+						project.ID, policy.Name, err)
+				}
+				p.matcher = matcher
+			}
+			policies[project.ID] = append(policies[project.ID], p)
+		}
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+
+	numTraces := conf.Spans.TailSampling.NumTraces
+	return &tailSampler{
+		decisionWait: conf.Spans.TailSampling.DecisionWait,
+		numTraces:    numTraces,
+		policies:     policies,
+		traces:       make(map[traceKey]*sampledTrace, numTraces),
+		fifo:         list.New(),
+		decisions:    cache.New[traceKey, float64](numTraces),
+	}, nil
+}
+
+// Add buffers the span until the trace is decided. Spans from projects
+// without policies and spans of already decided traces are not buffered.
+func (s *tailSampler) Add(ctx context.Context, span *Span, keep func(*Span)) {
+	if _, ok := s.policies[span.ProjectID]; !ok || span.TraceID.IsZero() {
+		keep(span)
+		return
+	}
+
+	key := traceKey{projectID: span.ProjectID, traceID: span.TraceID}
+
+	if ratio, ok := s.decisions.Get(key); ok {
+		s.emit(ctx, ratio, []*Span{span}, keep)
+		return
+	}
+
+	if s.initSpan != nil {
+		s.initSpan(ctx, span)
+	}
+
+	trace, ok := s.traces[key]
+	if !ok {
+		trace = &sampledTrace{
+			key:      key,
+			deadline: time.Now().Add(s.decisionWait),
+		}
+		trace.elem = s.fifo.PushBack(trace)
+		s.traces[key] = trace
+	}
+	trace.spans = append(trace.spans, span)
+
+	if len(s.traces) > s.numTraces {
+		// Decide the oldest trace early to limit memory usage.
+		s.decide(ctx, s.fifo.Front().Value.(*sampledTrace), keep)
+	}
+}
+
+// Flush decides traces that have been waiting for longer than the decision wait.
+func (s *tailSampler) Flush(ctx context.Context, now time.Time, keep func(*Span)) {
+	for elem := s.fifo.Front(); elem != nil; elem = s.fifo.Front() {
+		trace := elem.Value.(*sampledTrace)
+		if trace.deadline.After(now) {
+			break
+		}
+		s.decide(ctx, trace, keep)
+	}
+}
+
+// FlushAll decides all buffered traces, for example, on shutdown.
+func (s *tailSampler) FlushAll(ctx context.Context, keep func(*Span)) {
+	for elem := s.fifo.Front(); elem != nil; elem = s.fifo.Front() {
+		s.decide(ctx, elem.Value.(*sampledTrace), keep)
+	}
+}
+
+func (s *tailSampler) decide(ctx context.Context, trace *sampledTrace, keep func(*Span)) {
+	s.fifo.Remove(trace.elem)
+	delete(s.traces, trace.key)
+
+	ratio := s.samplingRatio(trace)
+	s.decisions.Put(trace.key, ratio)
+	s.emit(ctx, ratio, trace.spans, keep)
+}
+
+func (s *tailSampler) emit(ctx context.Context, ratio float64, spans []*Span, keep func(*Span)) {
+	if ratio == 0 {
+		spanCounter.Add(
+			ctx,
+			int64(len(spans)),
+			metric.WithAttributes(
+				bunotel.ProjectIDAttr(spans[0].ProjectID),
+				attribute.String("type", "sampled_out"),
+			),
+		)
+		return
+	}
+
+	for _, span := range spans {
+		span.samplingRatio = ratio
+		keep(span)
+	}
+}
+
+// samplingRatio evaluates the policies in order and returns the ratio
+// of the first policy that keeps the trace or 0 to drop the trace.
+func (s *tailSampler) samplingRatio(trace *sampledTrace) float64 {
+	for _, policy := range s.policies[trace.key.projectID] {
+		switch policy.Type {
+		case bunconf.SamplingPolicyError:
+			if trace.anySpan(func(span *Span) bool {
+				return span.StatusCode == ErrorStatusCode
+			}) {
+				return 1
+			}
+		case bunconf.SamplingPolicyDuration:
+			if trace.anySpan(func(span *Span) bool {
+				return span.Duration >= policy.Threshold
+			}) {
+				return 1
+			}
+		case bunconf.SamplingPolicyTQL:
+			if trace.anySpan(policy.match) {
+				return 1
+			}
+		case bunconf.SamplingPolicyProbabilistic:
+			if policy.matcher != nil && !trace.anySpan(policy.match) {
+				continue
+			}
+			// Hash the trace id so all spans of the trace get the same decision
+			// even when they are received by different Uptrace instances.
+			hash := xxhash.Sum64(trace.key.traceID[:])
+			if float64(hash) < policy.Rate*math.MaxUint64 {
+				return policy.Rate
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+func (p *samplingPolicy) match(span *Span) bool {
+	return p.matcher.Match(span.Attr)
+}
+
+func (t *sampledTrace) anySpan(fn func(span *Span) bool) bool {
+	for _, span := range t.spans {
+		if fn(span) {
+			return true
+		}
+	}
+	return false
+}
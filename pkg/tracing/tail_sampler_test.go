@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"container/list"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zyedidia/generic/cache"
+
+	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/uptrace/pkg/bunconf"
+	"github.com/uptrace/uptrace/pkg/tracing/tql"
+)
+
+func TestTailSamplerGroupPolicy(t *testing.T) {
+	const numTrace = 1000
+
+	matcher, err := tql.ParseMatcher(`_group_id = 42`)
+	require.NoError(t, err)
+
+	s := &tailSampler{
+		decisionWait: time.Minute,
+		numTraces:    2 * numTrace,
+		policies: map[uint32][]*samplingPolicy{
+			1: {{
+				SamplingPolicy: &bunconf.SamplingPolicy{
+					Type: bunconf.SamplingPolicyProbabilistic,
+					Rate: 0.5,
+				},
+				matcher: matcher,
+			}},
+		},
+		traces:    make(map[traceKey]*sampledTrace),
+		fifo:      list.New(),
+		decisions: cache.New[traceKey, float64](2 * numTrace),
+		// The group is assigned by the consumer before the span is buffered.
+		initSpan: func(ctx context.Context, span *Span) {
+			if span.Name == "GET /health" {
+				span.GroupID = 42
+			}
+		},
+	}
+
+	ctx := context.Background()
+	kept := make(map[string][]*Span)
+	keep := func(span *Span) {
+		kept[span.Name] = append(kept[span.Name], span)
+	}
+
+	for i := 0; i < numTrace; i++ {
+		for _, name := range []string{"GET /health", "GET /users"} {
+			s.Add(ctx, &Span{
+				ProjectID: 1,
+				TraceID:   idgen.RandTraceID(),
+				ID:        idgen.RandSpanID(),
+				Name:      name,
+			}, keep)
+		}
+	}
+	s.FlushAll(ctx, keep)
+
+	// Traces of other groups don't match any policy and are dropped.
+	require.Empty(t, kept["GET /users"])
+
+	require.InDelta(t, numTrace/2, len(kept["GET /health"]), numTrace/10)
+	for _, span := range kept["GET /health"] {
+		require.Equal(t, 2.0, span.SampleCount())
+	}
+}
+
+func TestSpanAttrGroupID(t *testing.T) {
+	span := new(Span)
+	_, ok := span.Attr("_group_id")
+	require.False(t, ok)
+
+	span.GroupID = 42
+	value, ok := span.Attr("_group_id")
+	require.True(t, ok)
+	require.Equal(t, uint64(42), value)
+}
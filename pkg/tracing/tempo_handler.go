@@ -0,0 +1,347 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logfmt/logfmt"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"go.uber.org/fx"
+	"golang.org/x/exp/slices"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/pkg/clickhouse/ch"
+	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/httperror"
+	"github.com/uptrace/uptrace/pkg/httputil"
+	"github.com/uptrace/uptrace/pkg/org"
+	"github.com/uptrace/uptrace/pkg/tracing/tql"
+)
+
+const (
+	tempoSearchLimit    = 20
+	tempoMaxSearchLimit = 1000
+)
+
+type TempoHandlerParams struct {
+	fx.In
+
+	Logger *otelzap.Logger
+	CH     *ch.DB
+}
+
+// TempoHandler implements the subset of Grafana Tempo HTTP API used by the Grafana
+// Tempo datasource.
+type TempoHandler struct {
+	*TempoHandlerParams
+}
+
+func NewTempoHandler(p TempoHandlerParams) *TempoHandler {
+	return &TempoHandler{&p}
+}
+
+func registerTempoHandler(h *TempoHandler, p bunapp.RouterParams, m *org.Middleware) {
+	p.Router.Use(m.UserAndProject).
+		WithGroup("/api/tempo/:project_id/api", func(g *bunrouter.Group) {
+			g.GET("/echo", h.Echo)
+			g.GET("/traces/:trace_id", h.Trace)
+			g.GET("/search", h.Search)
+			g.GET("/search/tags", h.Tags)
+			g.GET("/search/tag/:tag/values", h.TagValues)
+		})
+}
+
+// Echo is used by Grafana to test the datasource.
+func (h *TempoHandler) Echo(w http.ResponseWriter, req bunrouter.Request) error {
+	_, err := w.Write([]byte("echo"))
+	return err
+}
+
+func (h *TempoHandler) Trace(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+	project := h.project(req)
+
+	traceID, err := idgen.ParseTraceID(req.Param("trace_id"))
+	if err != nil {
+		return httperror.BadRequest("invalid_trace_id", err.Error())
+	}
+
+	spans, err := selectProjectTraceSpans(ctx, h.CH, project.ID, traceID)
+	if err != nil {
+		return err
+	}
+	if len(spans) == 0 {
+		return httperror.NotFound("Trace %q not found. Try again later.", traceID)
+	}
+
+	resourceSpans := spansToOTLP(spans)
+
+	if strings.Contains(req.Header.Get("Accept"), protobufContentType) {
+		// TracesData has the same wire format as tempopb.Trace.
+		b, err := proto.Marshal(&tracepb.TracesData{ResourceSpans: resourceSpans})
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", protobufContentType)
+		_, err = w.Write(b)
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"batches":[`)
+	for i, rs := range resourceSpans {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		b, err := protojson.Marshal(rs)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	}
+	buf.WriteString("]}")
+
+	w.Header().Set("Content-Type", jsonContentType)
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+type TempoTrace struct {
+	TraceID           string `json:"traceID"`
+	RootServiceName   string `json:"rootServiceName"`
+	RootTraceName     string `json:"rootTraceName"`
+	StartTimeUnixNano string `json:"startTimeUnixNano"`
+	DurationMs        int64  `json:"durationMs"`
+}
+
+func (h *TempoHandler) Search(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+	query := req.URL.Query()
+
+	f, err := h.spanFilter(req, time.Hour)
+	if err != nil {
+		return err
+	}
+
+	if q := query.Get("q"); q != "" && q != "{}" {
+		return httperror.BadRequest("unsupported_query", "TraceQL queries are not supported")
+	}
+
+	where, err := parseTempoTags(query.Get("tags"))
+	if err != nil {
+		return httperror.BadRequest("invalid_tags", err.Error())
+	}
+	if len(where.Filters) > 0 {
+		f.QueryParts = []*tql.QueryPart{{Query: query.Get("tags"), AST: where}}
+	}
+
+	limit := tempoSearchLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return httperror.BadRequest("invalid_limit", "limit must be a positive integer")
+		}
+		limit = min(n, tempoMaxSearchLimit)
+	}
+
+	traceIDs, err := searchTraceIDs(ctx, h.CH, f, query, limit)
+	if err != nil {
+		return err
+	}
+
+	traces, err := h.selectTraces(ctx, f, traceIDs)
+	if err != nil {
+		return err
+	}
+
+	return httputil.JSON(w, bunrouter.H{
+		"traces": traces,
+	})
+}
+
+// selectTraces selects the root span of each trace or the earliest span
+// when the root span is missing.
+func (h *TempoHandler) selectTraces(
+	ctx context.Context, f *SpanFilter, traceIDs []idgen.TraceID,
+) ([]*TempoTrace, error) {
+	traces := make([]*TempoTrace, 0, len(traceIDs))
+	if len(traceIDs) == 0 {
+		return traces, nil
+	}
+
+	var rows []struct {
+		TraceID     idgen.TraceID `ch:"type:UUID"`
+		ServiceName string
+		Name        string
+		Time        time.Time
+		Duration    int64
+	}
+
+	f = &SpanFilter{TypeFilter: f.TypeFilter}
+	q, _ := BuildSpanIndexQuery(h.CH, f, 0)
+	if err := q.
+		ColumnExpr("s.trace_id").
+		ColumnExpr("argMin(s.service_name, (s.parent_id != 0, s.time)) AS service_name").
+		ColumnExpr("argMin(s.name, (s.parent_id != 0, s.time)) AS name").
+		ColumnExpr("min(s.time) AS time").
+		ColumnExpr("argMin(s.duration, (s.parent_id != 0, s.time)) AS duration").
+		Where("s.trace_id IN ?", ch.In(traceIDs)).
+		GroupExpr("s.trace_id").
+		OrderExpr("time DESC").
+		Scan(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	for i := range rows {
+		row := &rows[i]
+		traces = append(traces, &TempoTrace{
+			TraceID:           row.TraceID.String(),
+			RootServiceName:   row.ServiceName,
+			RootTraceName:     row.Name,
+			StartTimeUnixNano: strconv.FormatInt(row.Time.UnixNano(), 10),
+			DurationMs:        time.Duration(row.Duration).Milliseconds(),
+		})
+	}
+	return traces, nil
+}
+
+func (h *TempoHandler) Tags(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	f, err := h.spanFilter(req, 24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	keys, err := SelectAttrKeys(ctx, h.CH, f)
+	if err != nil {
+		return err
+	}
+	keys = append(keys, "name", "status")
+	slices.Sort(keys)
+
+	return httputil.JSON(w, bunrouter.H{
+		"tagNames": slices.Compact(keys),
+	})
+}
+
+func (h *TempoHandler) TagValues(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	f, err := h.spanFilter(req, 24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	items, _, err := SelectAttrValues(ctx, h.CH, f, tempoAttrKey(req.Param("tag")))
+	if err != nil {
+		return httperror.BadRequest("invalid_tag", err.Error())
+	}
+
+	values := make([]string, len(items))
+	for i, item := range items {
+		values[i] = item.Value
+	}
+
+	return httputil.JSON(w, bunrouter.H{
+		"tagValues": values,
+	})
+}
+
+func (h *TempoHandler) project(req bunrouter.Request) *org.Project {
+	return traceQueryProject(req)
+}
+
+// spanFilter creates a filter for the project using the start and end params
+// in Unix seconds that default to the specified period.
+func (h *TempoHandler) spanFilter(req bunrouter.Request, period time.Duration) (*SpanFilter, error) {
+	query := req.URL.Query()
+	project := h.project(req)
+
+	f := &SpanFilter{
+		TypeFilter: TypeFilter{
+			ProjectID: project.ID,
+			System:    []string{SystemSpansAll},
+		},
+	}
+
+	f.TimeLT = time.Now()
+	if s := query.Get("end"); s != "" {
+		end, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, httperror.BadRequest("invalid_end", err.Error())
+		}
+		f.TimeLT = time.Unix(end+1, 0)
+	}
+
+	f.TimeGTE = f.TimeLT.Add(-period)
+	if s := query.Get("start"); s != "" {
+		start, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, httperror.BadRequest("invalid_start", err.Error())
+		}
+		f.TimeGTE = time.Unix(start, 0)
+	}
+
+	if f.TimeGTE.After(f.TimeLT) {
+		return nil, httperror.BadRequest("invalid_time_range", "start can't be after end")
+	}
+	return f, nil
+}
+
+// parseTempoTags parses tags in logfmt format, for example,
+// `service.name="frontend" http.status_code=500`.
+func parseTempoTags(s string) (*tql.Where, error) {
+	where := new(tql.Where)
+
+	dec := logfmt.NewDecoder(strings.NewReader(s))
+	for dec.ScanRecord() {
+		for dec.ScanKeyval() {
+			key := tempoAttrKey(string(dec.Key()))
+			if key == "" {
+				continue
+			}
+
+			value := string(dec.Value())
+			if key == attrkey.SpanStatusCode && value == "unset" {
+				value = OKStatusCode
+			}
+
+			where.Filters = append(where.Filters, tql.Filter{
+				BoolOp: tql.BoolAnd,
+				LHS:    tql.Attr{Name: key},
+				Op:     tql.FilterEqual,
+				RHS:    tql.StringValue{Text: value},
+			})
+		}
+	}
+	if err := dec.Err(); err != nil {
+		return nil, err
+	}
+
+	return where, nil
+}
+
+// tempoAttrKey converts Tempo tag names to Uptrace attribute keys.
+func tempoAttrKey(tag string) string {
+	switch tag {
+	case "name":
+		return attrkey.SpanName
+	case "status":
+		return attrkey.SpanStatusCode
+	case "kind":
+		return attrkey.SpanKind
+	default:
+		return attrkey.Clean(tag)
+	}
+}
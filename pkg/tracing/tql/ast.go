@@ -143,7 +143,9 @@ type FuncCall struct {
 func (c *FuncCall) AppendString(b []byte) []byte {
 	b = append(b, c.Func...)
 	b = append(b, '(')
-	b = c.Arg.AppendString(b)
+	if c.Arg != nil {
+		b = c.Arg.AppendString(b)
+	}
 	b = append(b, ')')
 	return b
 }
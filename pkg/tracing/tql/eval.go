@@ -0,0 +1,102 @@
+package tql
+
+import (
+	"fmt"
+	"math"
+)
+
+// NumExpr evaluates arithmetic expressions over attributes in memory,
+// for example, `_duration / 1000` or `http_request_body_size`.
+type NumExpr struct {
+	eval func(get AttrGetter) (float64, bool)
+}
+
+func ParseNumExpr(s string) (*NumExpr, error) {
+	col, err := ParseColumn(s)
+	if err != nil {
+		return nil, err
+	}
+
+	eval, err := compileNumExpr(col.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &NumExpr{eval: eval}, nil
+}
+
+// Eval returns the value of the expression or false if any of the attributes is missing.
+func (e *NumExpr) Eval(get AttrGetter) (float64, bool) {
+	return e.eval(get)
+}
+
+func compileNumExpr(expr Expr) (func(get AttrGetter) (float64, bool), error) {
+	switch expr := expr.(type) {
+	case Attr:
+		key := matchKey(expr.Name)
+		return func(get AttrGetter) (float64, bool) {
+			value, ok := get(key)
+			if !ok {
+				return 0, false
+			}
+			return toFloat64(value), true
+		}, nil
+	case NumberValue:
+		num, err := parseNumber(expr)
+		if err != nil {
+			return nil, err
+		}
+		return func(AttrGetter) (float64, bool) {
+			return num, true
+		}, nil
+	case ParenExpr:
+		return compileNumExpr(expr.Expr)
+	case *BinaryExpr:
+		lhs, err := compileNumExpr(expr.LHS)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := compileNumExpr(expr.RHS)
+		if err != nil {
+			return nil, err
+		}
+
+		op, err := numBinaryOp(expr.Op)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(get AttrGetter) (float64, bool) {
+			a, ok := lhs(get)
+			if !ok {
+				return 0, false
+			}
+			b, ok := rhs(get)
+			if !ok {
+				return 0, false
+			}
+			return op(a, b), true
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported expression %q: only attributes, numbers, "+
+			"and arithmetic operators are supported", String(expr))
+	}
+}
+
+func numBinaryOp(op BinaryOp) (func(a, b float64) float64, error) {
+	switch op {
+	case "+":
+		return func(a, b float64) float64 { return a + b }, nil
+	case "-":
+		return func(a, b float64) float64 { return a - b }, nil
+	case "*":
+		return func(a, b float64) float64 { return a * b }, nil
+	case "/":
+		return func(a, b float64) float64 { return a / b }, nil
+	case "%":
+		return math.Mod, nil
+	case "^":
+		return math.Pow, nil
+	default:
+		return nil, fmt.Errorf("unsupported operator: %q", op)
+	}
+}
@@ -0,0 +1,73 @@
+package tql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNumExpr(t *testing.T) {
+	attrs := map[string]any{
+		"_duration":              1500 * time.Millisecond,
+		"http_request_body_size": int64(2048),
+		"ratio":                  "0.5",
+		"count":                  float64(3),
+	}
+	get := func(key string) (any, bool) {
+		value, ok := attrs[key]
+		return value, ok
+	}
+
+	type Test struct {
+		expr  string
+		value float64
+		ok    bool
+	}
+
+	tests := []Test{
+		{expr: "1", value: 1, ok: true},
+		{expr: "1 + 2 * 3", value: 7, ok: true},
+		{expr: "(1 + 2) * 3", value: 9, ok: true},
+		{expr: "10 - 4 - 3", value: 3, ok: true},
+		{expr: "7 % 4", value: 3, ok: true},
+		{expr: "_duration", value: float64(1500 * time.Millisecond), ok: true},
+		{expr: "_duration / 1000000", value: 1500, ok: true},
+		{expr: "span.duration / 1000000", value: 1500, ok: true},
+		{expr: ".duration / 1000000", value: 1500, ok: true},
+		{expr: "http.request.body_size / 1024", value: 2, ok: true},
+		{expr: "2 * 1.5 / 4", value: 0.75, ok: true},
+		{expr: "count * ratio", value: 1.5, ok: true},
+		{expr: "missing", ok: false},
+		{expr: "count + missing", ok: false},
+		{expr: "missing * 2", ok: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			expr, err := ParseNumExpr(test.expr)
+			require.NoError(t, err)
+
+			value, ok := expr.Eval(get)
+			require.Equal(t, test.ok, ok)
+			if test.ok {
+				require.InDelta(t, test.value, value, 1e-9)
+			}
+		})
+	}
+}
+
+func TestParseNumExprError(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"1 +",
+		"count(_duration)",
+		"_duration > 1",
+		"_duration / 1ms",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			_, err := ParseNumExpr(expr)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package tql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunconv"
+)
+
+// AttrGetter returns the value of the attribute with the cleaned key.
+type AttrGetter func(key string) (any, bool)
+
+// Matcher evaluates filters in memory, for example, to filter spans before
+// they are stored. It follows the semantics of the ClickHouse queries, i.e.
+// missing attributes are treated as empty strings and zero numbers.
+type Matcher struct {
+	// Filters joined with OR that contain filters joined with AND.
+	groups [][]*matchFilter
+}
+
+type matchFilter struct {
+	key    string
+	op     FilterOp
+	str    string
+	values []string
+	num    float64
+	isNum  bool
+	re     *regexp.Regexp
+}
+
+// ParseMatcher parses filters with an optional where prefix, for example,
+// `where _status_code = "error" and _duration > 1s`.
+func ParseMatcher(query string) (*Matcher, error) {
+	query = strings.TrimSpace(query)
+	if !strings.HasPrefix(strings.ToLower(query), "where ") {
+		query = "where " + query
+	}
+
+	ast, err := ParsePart(query)
+	if err != nil {
+		return nil, err
+	}
+
+	where, ok := ast.(*Where)
+	if !ok {
+		return nil, fmt.Errorf("expected a where clause, got %q", query)
+	}
+	return NewMatcher(where)
+}
+
+func NewMatcher(where *Where) (*Matcher, error) {
+	m := new(Matcher)
+
+	var group []*matchFilter
+	for i := range where.Filters {
+		filter := &where.Filters[i]
+
+		mf, err := newMatchFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		if filter.BoolOp == BoolOr && len(group) > 0 {
+			m.groups = append(m.groups, group)
+			group = nil
+		}
+		group = append(group, mf)
+	}
+	if len(group) > 0 {
+		m.groups = append(m.groups, group)
+	}
+
+	return m, nil
+}
+
+func newMatchFilter(filter *Filter) (*matchFilter, error) {
+	attr, ok := filter.LHS.(Attr)
+	if !ok {
+		return nil, fmt.Errorf("unsupported filter %q: only attributes are supported",
+			String(filter.LHS))
+	}
+
+	mf := &matchFilter{
+		key: matchKey(attr.Name),
+		op:  filter.Op,
+	}
+	if filter.RHS != nil {
+		mf.str = filter.RHS.String()
+		mf.values = filter.RHS.Values()
+	}
+
+	switch filter.Op {
+	case FilterExists, FilterNotExists, FilterIn, FilterNotIn:
+	case FilterContains, FilterNotContains:
+		mf.values = strings.Split(strings.ToLower(mf.str), "|")
+	case FilterLike, FilterNotLike:
+		re, err := regexp.Compile(likeToRegexp(mf.str))
+		if err != nil {
+			return nil, err
+		}
+		mf.re = re
+	case FilterRegexp, FilterNotRegexp:
+		re, err := regexp.Compile(mf.str)
+		if err != nil {
+			return nil, err
+		}
+		mf.re = re
+	case FilterEqual, FilterNotEqual, "<", "<=", ">", ">=":
+		if value, ok := filter.RHS.(NumberValue); ok {
+			num, err := parseNumber(value)
+			if err != nil {
+				return nil, err
+			}
+			mf.num = num
+			mf.isNum = true
+		}
+	default:
+		return nil, fmt.Errorf("unsupported filter operator: %q", filter.Op)
+	}
+
+	return mf, nil
+}
+
+// Match reports whether the attributes satisfy the filters.
+// A matcher without filters matches everything.
+func (m *Matcher) Match(get AttrGetter) bool {
+	if len(m.groups) == 0 {
+		return true
+	}
+
+	for _, group := range m.groups {
+		matched := true
+		for _, filter := range group {
+			if !filter.match(get) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *matchFilter) match(get AttrGetter) bool {
+	value, found := get(f.key)
+
+	switch f.op {
+	case FilterExists:
+		return found
+	case FilterNotExists:
+		return !found
+	}
+
+	if f.isNum {
+		num := toFloat64(value)
+		switch f.op {
+		case FilterEqual:
+			return num == f.num
+		case FilterNotEqual:
+			return num != f.num
+		case "<":
+			return num < f.num
+		case "<=":
+			return num <= f.num
+		case ">":
+			return num > f.num
+		case ">=":
+			return num >= f.num
+		}
+		return false
+	}
+
+	var str string
+	if found {
+		str = toString(value)
+	}
+
+	switch f.op {
+	case FilterEqual:
+		return str == f.str
+	case FilterNotEqual:
+		return str != f.str
+	case "<", "<=", ">", ">=":
+		switch cmp := strings.Compare(str, f.str); f.op {
+		case "<":
+			return cmp < 0
+		case "<=":
+			return cmp <= 0
+		case ">":
+			return cmp > 0
+		default:
+			return cmp >= 0
+		}
+	case FilterIn, FilterNotIn:
+		var in bool
+		for _, value := range f.values {
+			if str == value {
+				in = true
+				break
+			}
+		}
+		return in == (f.op == FilterIn)
+	case FilterContains, FilterNotContains:
+		str = strings.ToLower(str)
+		var contains bool
+		for _, value := range f.values {
+			if strings.Contains(str, value) {
+				contains = true
+				break
+			}
+		}
+		return contains == (f.op == FilterContains)
+	case FilterLike, FilterRegexp:
+		return f.re.MatchString(str)
+	case FilterNotLike, FilterNotRegexp:
+		return !f.re.MatchString(str)
+	}
+	return false
+}
+
+// matchKey returns the cleaned attribute key. Span fields, for example,
+// span.duration and .duration, use the underscore prefix.
+func matchKey(name string) string {
+	name = clean(name)
+	if strings.HasPrefix(name, ".") {
+		return "_" + attrkey.Clean(name[1:])
+	}
+	return attrkey.Clean(name)
+}
+
+func parseNumber(value NumberValue) (float64, error) {
+	switch value.Kind {
+	case NumberDuration:
+		dur, err := time.ParseDuration(value.Text)
+		if err != nil {
+			return 0, err
+		}
+		return float64(dur), nil
+	case NumberBytes:
+		n, err := bunconv.ParseBytes(value.Text)
+		if err != nil {
+			return 0, err
+		}
+		return float64(n), nil
+	default:
+		return strconv.ParseFloat(value.Text, 64)
+	}
+}
+
+func toFloat64(value any) float64 {
+	switch value := value.(type) {
+	case float64:
+		return value
+	case float32:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case int:
+		return float64(value)
+	case int32:
+		return float64(value)
+	case uint64:
+		return float64(value)
+	case uint32:
+		return float64(value)
+	case time.Duration:
+		return float64(value)
+	case string:
+		f, _ := strconv.ParseFloat(value, 64)
+		return f
+	default:
+		return 0
+	}
+}
+
+func toString(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case time.Duration:
+		return strconv.FormatInt(int64(value), 10)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// likeToRegexp converts SQL LIKE patterns to regular expressions.
+func likeToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^(?s:")
+	for _, c := range pattern {
+		switch c {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteByte('.')
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString(")$")
+	return b.String()
+}
@@ -0,0 +1,92 @@
+package tql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatcher(t *testing.T) {
+	attrs := map[string]any{
+		"_status_code":     "error",
+		"_duration":        2 * time.Second,
+		"service_name":     "frontend",
+		"http_status_code": int64(500),
+		"http_route":       "/api/users/:id",
+		"db_rows":          float64(10),
+	}
+	get := func(key string) (any, bool) {
+		value, ok := attrs[key]
+		return value, ok
+	}
+
+	type Test struct {
+		query string
+		match bool
+	}
+
+	tests := []Test{
+		{query: `_status_code = "error"`, match: true},
+		{query: `where _status_code = "error"`, match: true},
+		{query: `_status_code != "error"`, match: false},
+		{query: `_duration > 1s`, match: true},
+		{query: `_duration > 1s and _duration < 2s`, match: false},
+		{query: `_duration >= 2000ms`, match: true},
+		{query: `span.duration >= 2s`, match: true},
+		{query: `.status_code = "error"`, match: true},
+		{query: `http.status_code >= 500`, match: true},
+		{query: `http.status_code = 200 or service.name = "frontend"`, match: true},
+		{query: `http.status_code = 200 or service.name = "backend"`, match: false},
+		{query: `db.rows <= 10`, match: true},
+		{query: `service.name in ("frontend", "backend")`, match: true},
+		{query: `service.name not in ("frontend", "backend")`, match: false},
+		{query: `service.name contains "FRONT"`, match: true},
+		{query: `service.name contains "back|end"`, match: true},
+		{query: `service.name not contains "back"`, match: true},
+		{query: `http.route like "/api/%"`, match: true},
+		{query: `http.route like "/api/_"`, match: false},
+		{query: `http.route not like "/api/%"`, match: false},
+		{query: `http.route ~ "^/api/users"`, match: true},
+		{query: `service.name exists`, match: true},
+		{query: `peer.service exists`, match: false},
+		{query: `peer.service not exists`, match: true},
+		// Missing attributes are empty strings and zero numbers.
+		{query: `peer.service = ""`, match: true},
+		{query: `peer.port = 0`, match: true},
+		{query: `peer.port > 0`, match: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			m, err := ParseMatcher(test.query)
+			require.NoError(t, err)
+			require.Equal(t, test.match, m.Match(get))
+		})
+	}
+}
+
+func TestParseMatcherError(t *testing.T) {
+	for _, query := range []string{
+		`_status_code =`,
+		`_status_code = "error" and`,
+		`service.name in (`,
+		`service.name in ("frontend"`,
+		`http.route ~ "("`,
+		`http.route ~`,
+		`count() > 1`,
+	} {
+		t.Run(query, func(t *testing.T) {
+			_, err := ParseMatcher(query)
+			require.Error(t, err)
+		})
+	}
+
+	// Every prefix of a valid query is either valid or an error.
+	query := `_status_code = "error" and (_duration > 1s or service.name in ("a", "b"))`
+	for i := 0; i <= len(query); i++ {
+		require.NotPanics(t, func() {
+			_, _ = ParseMatcher(query[:i])
+		}, query[:i])
+	}
+}
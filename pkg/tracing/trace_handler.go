@@ -83,7 +83,7 @@ func (h *TraceHandler) ShowTrace(w http.ResponseWriter, req bunrouter.Request) e
 		return err
 	}
 
-	spans, hasMore, err := SelectTraceSpans(ctx, h.CH, traceID)
+	spans, hasMore, err := SelectTraceSpans(ctx, h.CH, []idgen.TraceID{traceID})
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/pkg/clickhouse/ch"
+	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/uptrace/pkg/httperror"
+	"github.com/uptrace/uptrace/pkg/org"
+)
+
+// Helpers shared by the Tempo and Jaeger query APIs.
+
+func traceQueryProject(req bunrouter.Request) *org.Project {
+	ctx := req.Context()
+	project := org.ProjectFromContext(ctx)
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.Int64("project", int64(project.ID)))
+	}
+
+	return project
+}
+
+// searchTraceIDs returns ids of the most recent traces that match the filter
+// and the optional minDuration and maxDuration params.
+func searchTraceIDs(
+	ctx context.Context, db *ch.DB, f *SpanFilter, query url.Values, limit int,
+) ([]idgen.TraceID, error) {
+	q, _ := BuildSpanIndexQuery(db, f, f.TimeFilter.Duration())
+	for _, part := range f.QueryParts {
+		if part.Error.Wrapped != nil {
+			return nil, httperror.BadRequest("invalid_tags", part.Error.Wrapped.Error())
+		}
+	}
+
+	for _, param := range []struct {
+		name string
+		op   string
+	}{
+		{"minDuration", ">="},
+		{"maxDuration", "<="},
+	} {
+		s := query.Get(param.name)
+		if s == "" {
+			continue
+		}
+		dur, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, httperror.BadRequest("invalid_duration", err.Error())
+		}
+		q = q.Where("s.duration "+param.op+" ?", int64(dur))
+	}
+
+	traceIDs := make([]idgen.TraceID, 0)
+	if err := q.
+		ColumnExpr("s.trace_id").
+		GroupExpr("s.trace_id").
+		OrderExpr("max(s.time) DESC").
+		Limit(limit).
+		Scan(ctx, &traceIDs); err != nil {
+		return nil, err
+	}
+	return traceIDs, nil
+}
+
+// selectProjectTraceSpans returns the trace spans that belong to the project
+// with events attached to their parent spans.
+func selectProjectTraceSpans(
+	ctx context.Context, db *ch.DB, projectID uint32, traceID idgen.TraceID,
+) ([]*Span, error) {
+	m, err := selectProjectTracesSpans(ctx, db, projectID, []idgen.TraceID{traceID})
+	if err != nil {
+		return nil, err
+	}
+	return m[traceID], nil
+}
+
+// selectProjectTracesSpans selects spans of multiple traces with a single query
+// and groups them by the trace id. Traces without spans in the project are omitted.
+func selectProjectTracesSpans(
+	ctx context.Context, db *ch.DB, projectID uint32, traceIDs []idgen.TraceID,
+) (map[idgen.TraceID][]*Span, error) {
+	spans, _, err := SelectTraceSpans(ctx, db, traceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[idgen.TraceID][]*Span, len(traceIDs))
+	for _, span := range spans {
+		// Trace spans are selected by the trace id only.
+		if span.ProjectID != projectID {
+			continue
+		}
+		m[span.TraceID] = append(m[span.TraceID], span)
+	}
+	for traceID, spans := range m {
+		if spans = foldSpanEvents(spans); len(spans) > 0 {
+			m[traceID] = spans
+		} else {
+			delete(m, traceID)
+		}
+	}
+	return m, nil
+}
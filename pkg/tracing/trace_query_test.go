@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+)
+
+func TestFoldSpanEvents(t *testing.T) {
+	traceID := idgen.RandTraceID()
+	tm := time.Unix(1700000000, 0)
+
+	root := &Span{
+		TraceID: traceID,
+		ID:      1,
+		Name:    "GET /",
+		Time:    tm,
+		Attrs:   AttrMap{attrkey.ServiceName: "frontend"},
+	}
+	child := &Span{
+		TraceID:  traceID,
+		ID:       2,
+		ParentID: 1,
+		Name:     "SELECT",
+		Time:     tm.Add(time.Millisecond),
+		Attrs:    AttrMap{attrkey.ServiceName: "frontend"},
+	}
+	event := &Span{
+		TraceID:     traceID,
+		ID:          3,
+		ParentID:    2,
+		EventName:   "exception",
+		DisplayName: "exception",
+		Time:        tm.Add(2 * time.Millisecond),
+		Attrs:       AttrMap{"exception.message": "boom"},
+	}
+	orphan := &Span{
+		TraceID:     traceID,
+		ID:          4,
+		ParentID:    100,
+		EventName:   "log",
+		DisplayName: "log",
+		Time:        tm.Add(3 * time.Millisecond),
+		Attrs:       AttrMap{},
+	}
+
+	spans := foldSpanEvents([]*Span{root, event, child, orphan})
+	require.Equal(t, []*Span{root, child}, spans)
+	require.Len(t, child.Events, 1)
+	require.Equal(t, "exception", child.Events[0].Name)
+	require.Len(t, root.Events, 1)
+	require.Equal(t, "log", root.Events[0].Name)
+
+	t.Run("otlp", func(t *testing.T) {
+		resourceSpans := spansToOTLP(spans)
+		require.Len(t, resourceSpans, 1)
+
+		otlpSpans := resourceSpans[0].ScopeSpans[0].Spans
+		require.Len(t, otlpSpans, 2)
+		require.Len(t, otlpSpans[1].Events, 1)
+		require.Equal(t, "exception", otlpSpans[1].Events[0].Name)
+	})
+
+	t.Run("jaeger", func(t *testing.T) {
+		jtrace := newJaegerTrace(traceID, spans)
+		require.Len(t, jtrace.Spans, 2)
+		require.Len(t, jtrace.Spans[1].Logs, 1)
+		require.Equal(t, newJaegerKeyValue("event", "exception"), jtrace.Spans[1].Logs[0].Fields[0])
+	})
+}
@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/segmentio/encoding/json"
+
+	"github.com/uptrace/uptrace/pkg/attrkey"
 )
 
 func asString(v any) string {
@@ -78,3 +80,22 @@ func lazyCopy[S ~[]E, E any](s S) S {
 	}
 	return s[:len(s):len(s)]
 }
+
+// flattenParams flattens nested objects using cleaned attribute keys,
+// for example, {"host": {"name": "foo"}} becomes host_name=foo.
+func flattenParams(dest AttrMap, prefix string, src map[string]any) {
+	for key, value := range src {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if m, ok := value.(map[string]any); ok {
+			flattenParams(dest, key, m)
+			continue
+		}
+
+		if key = attrkey.Clean(key); key != "" {
+			dest[key] = value
+		}
+	}
+}
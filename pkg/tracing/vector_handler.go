@@ -1,7 +1,9 @@
 package tracing
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -18,19 +20,25 @@ import (
 	"github.com/uptrace/pkg/idgen"
 	"github.com/uptrace/uptrace/pkg/attrkey"
 	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/bunconf"
 	"github.com/uptrace/uptrace/pkg/bunutil"
+	"github.com/uptrace/uptrace/pkg/httperror"
 	"github.com/uptrace/uptrace/pkg/org"
 )
 
-const vectorSDK = "vector"
+const (
+	vectorSDK         = "vector"
+	vectorMaxBodySize = 32 << 20
+)
 
 type VectorHandlerParams struct {
 	fx.In
 
-	Logger   *otelzap.Logger
-	PG       *bun.DB
-	Projects *org.ProjectGateway
-	Consumer *LogConsumer
+	Logger      *otelzap.Logger
+	PG          *bun.DB
+	Projects    *org.ProjectGateway
+	Consumer    *LogConsumer
+	RateLimiter *org.RateLimiter
 }
 
 type VectorHandler struct {
@@ -75,9 +83,25 @@ func (h *VectorHandler) Create(w http.ResponseWriter, req bunrouter.Request) err
 			ct, "application/json")
 	}
 
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, vectorMaxBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return httperror.New(http.StatusRequestEntityTooLarge, "request_too_large", err.Error())
+		}
+		return err
+	}
+
+	if err := h.RateLimiter.AllowBytes(project.ID, func() int {
+		return len(body)
+	}); err != nil {
+		return err
+	}
+
+	throttle := h.RateLimiter.Throttle(project.ID, bunconf.SignalLogs)
 	p := new(vectorLogProcessor)
 
-	dec := json.NewDecoder(req.Body)
+	dec := json.NewDecoder(bytes.NewReader(body))
 	m := make(map[string]any)
 	for {
 		clear(m)
@@ -89,12 +113,20 @@ func (h *VectorHandler) Create(w http.ResponseWriter, req bunrouter.Request) err
 			return err
 		}
 
+		if !throttle.Allow() {
+			continue
+		}
+
 		span := new(Span)
 		p.spanFromVector(ctx, span, m)
 		span.ProjectID = project.ID
 		h.Consumer.AddSpan(ctx, span)
 	}
 
+	if n := throttle.Throttled(); n > 0 {
+		countThrottled(ctx, project.ID, n)
+		return throttle.Err()
+	}
 	return nil
 }
 
@@ -0,0 +1,229 @@
+package tracing
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	zipkinmodel "github.com/openzipkin/zipkin-go/model"
+	zipkinproto "github.com/openzipkin/zipkin-go/proto/zipkin_proto3"
+	"github.com/segmentio/encoding/json"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+
+	"github.com/uptrace/bunrouter"
+	"github.com/uptrace/opentelemetry-go-extra/otelzap"
+	"github.com/uptrace/pkg/idgen"
+	"github.com/uptrace/uptrace/pkg/attrkey"
+	"github.com/uptrace/uptrace/pkg/bunapp"
+	"github.com/uptrace/uptrace/pkg/org"
+)
+
+type ZipkinHandlerParams struct {
+	fx.In
+
+	Logger     *otelzap.Logger
+	Projects   *org.ProjectGateway
+	Dispatcher *SpanDispatcher
+}
+
+type ZipkinHandler struct {
+	*ZipkinHandlerParams
+}
+
+func NewZipkinHandler(p ZipkinHandlerParams) *ZipkinHandler {
+	return &ZipkinHandler{&p}
+}
+
+func registerZipkinHandler(h *ZipkinHandler, p bunapp.RouterParams) {
+	p.Router.WithGroup("/api/v2", func(g *bunrouter.Group) {
+		g.POST("/spans", h.Spans)
+	})
+}
+
+func (h *ZipkinHandler) Spans(w http.ResponseWriter, req bunrouter.Request) error {
+	ctx := req.Context()
+
+	dsn, err := org.DSNFromRequest(req)
+	if err != nil {
+		return err
+	}
+
+	project, err := h.Projects.SelectByDSN(ctx, dsn)
+	if err != nil {
+		return err
+	}
+
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.SetAttributes(attribute.Int64("project", int64(project.ID)))
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+
+	var zspans []*zipkinmodel.SpanModel
+
+	switch contentType := req.Header.Get("Content-Type"); contentType {
+	case xprotobufContentType, protobufContentType:
+		zspans, err = zipkinproto.ParseSpans(body, false)
+		if err != nil {
+			return err
+		}
+	case jsonContentType, "":
+		if err := json.Unmarshal(body, &zspans); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported content type: %q", contentType)
+	}
+
+	for _, zspan := range zspans {
+		span := new(Span)
+		initSpanFromZipkin(span, zspan)
+		span.ProjectID = project.ID
+		h.Dispatcher.AddSpan(ctx, span)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+//------------------------------------------------------------------------------
+
+// sharedSpanIDMask is used to derive a span id for the server side of a shared span,
+// because Zipkin reports both client and server sides using the same span id.
+const sharedSpanIDMask = 0x5a5a5a5a5a
+
+func initSpanFromZipkin(dest *Span, src *zipkinmodel.SpanModel) {
+	dest.TraceID = idgen.NewTraceIDLowHigh(src.TraceID.High, src.TraceID.Low)
+	dest.ID = idgen.SpanIDFromUint64(uint64(src.ID))
+	if src.ParentID != nil {
+		dest.ParentID = idgen.SpanIDFromUint64(uint64(*src.ParentID))
+	}
+	dest.Kind = zipkinSpanKind(src.Kind)
+
+	if src.Shared && dest.Kind == ServerSpanKind {
+		dest.ParentID = dest.ID
+		dest.ID ^= sharedSpanIDMask
+	}
+
+	dest.Name = src.Name
+	dest.Time = src.Timestamp
+	dest.Duration = src.Duration
+	dest.StatusCode = OKStatusCode
+
+	dest.Attrs = make(AttrMap, len(src.Tags)+4)
+	if ep := src.LocalEndpoint; ep != nil {
+		if ep.ServiceName != "" {
+			dest.Attrs[attrkey.ServiceName] = ep.ServiceName
+		}
+		if ip := zipkinEndpointIP(ep); ip != "" {
+			dest.Attrs[attrkey.HostIP] = ip
+		}
+	}
+	if ep := src.RemoteEndpoint; ep != nil {
+		if ep.ServiceName != "" {
+			dest.Attrs[attrkey.PeerService] = ep.ServiceName
+		}
+		addrKey, portKey := attrkey.ServerAddress, attrkey.ServerPort
+		if dest.Kind == ServerSpanKind || dest.Kind == ConsumerSpanKind {
+			addrKey, portKey = attrkey.ClientAddress, attrkey.ClientPort
+		}
+		if ip := zipkinEndpointIP(ep); ip != "" {
+			dest.Attrs[addrKey] = ip
+		}
+		if ep.Port != 0 {
+			dest.Attrs[portKey] = int64(ep.Port)
+		}
+	}
+
+	for key, value := range src.Tags {
+		setZipkinTag(dest, key, value)
+	}
+
+	dest.Events = make([]*SpanEvent, len(src.Annotations))
+	for i := range src.Annotations {
+		ann := &src.Annotations[i]
+		dest.Events[i] = &SpanEvent{
+			Name:  ann.Value,
+			Time:  ann.Timestamp,
+			Attrs: NewAttrMap(),
+		}
+	}
+}
+
+var zipkinTagNames = map[string]string{
+	"http.method":          attrkey.HTTPRequestMethod,
+	"http.path":            attrkey.URLPath,
+	"http.url":             attrkey.URLFull,
+	"http.route":           attrkey.HTTPRoute,
+	"http.status_code":     attrkey.HTTPResponseStatusCode,
+	"sql.query":            attrkey.DBStatement,
+	"otel.scope.name":      attrkey.OtelLibraryName,
+	"otel.library.name":    attrkey.OtelLibraryName,
+	"otel.scope.version":   attrkey.OtelLibraryVersion,
+	"otel.library.version": attrkey.OtelLibraryVersion,
+}
+
+func setZipkinTag(span *Span, key, value string) {
+	switch key {
+	case "error":
+		span.StatusCode = ErrorStatusCode
+		if value != "" && value != "true" {
+			span.StatusMessage = value
+		}
+		return
+	case "otel.status_code":
+		if strings.EqualFold(value, "error") {
+			span.StatusCode = ErrorStatusCode
+		}
+		return
+	case "otel.status_description":
+		span.StatusMessage = value
+		return
+	}
+
+	if name, ok := zipkinTagNames[key]; ok {
+		if name == attrkey.HTTPResponseStatusCode {
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				span.Attrs[name] = n
+				return
+			}
+		}
+		span.Attrs[name] = value
+		return
+	}
+
+	if key = attrkey.Clean(key); key != "" {
+		span.Attrs[key] = value
+	}
+}
+
+func zipkinSpanKind(kind zipkinmodel.Kind) string {
+	switch kind {
+	case zipkinmodel.Server:
+		return ServerSpanKind
+	case zipkinmodel.Client:
+		return ClientSpanKind
+	case zipkinmodel.Producer:
+		return ProducerSpanKind
+	case zipkinmodel.Consumer:
+		return ConsumerSpanKind
+	}
+	return InternalSpanKind
+}
+
+func zipkinEndpointIP(ep *zipkinmodel.Endpoint) string {
+	if len(ep.IPv4) > 0 {
+		return ep.IPv4.String()
+	}
+	if len(ep.IPv6) > 0 {
+		return ep.IPv6.String()
+	}
+	return ""
+}
@@ -79,6 +79,68 @@ func (l *Limiter) AllowN(now unixtime.Nano, amount int64) int64 {
 	return 0
 }
 
+// Delay returns how long to wait until the amount of tokens is available.
+func (l *Limiter) Delay(now unixtime.Nano, amount int64) time.Duration {
+	if amount > l.burst {
+		amount = l.burst
+	}
+	lastTime := unixtime.Nano(l.lastTime.Load())
+	if delay := lastTime.Add(l.limit.durationFromTokens(amount)).Sub(now); delay > 0 {
+		return delay
+	}
+	return 0
+}
+
+// ReserveN takes the amount of tokens, possibly in advance, and returns a reservation
+// that tells how long to wait until the tokens are available.
+func (l *Limiter) ReserveN(now unixtime.Nano, amount int64) *Reservation {
+	if amount > l.burst {
+		amount = l.burst
+	}
+	if amount <= 0 {
+		return &Reservation{lim: l}
+	}
+	if l.limit <= 0 {
+		return &Reservation{lim: l, delay: InfDuration}
+	}
+	burstDur := l.limit.durationFromTokens(l.burst)
+	dur := l.limit.durationFromTokens(amount)
+	for {
+		lastTime := unixtime.Nano(l.lastTime.Load())
+		base := lastTime
+		if now.Sub(base) > burstDur {
+			base = now.Add(-burstDur)
+		}
+		newTime := base.Add(dur)
+		if l.lastTime.CompareAndSwap(int64(lastTime), int64(newTime)) {
+			r := &Reservation{lim: l, tokens: amount}
+			if delay := newTime.Sub(now); delay > 0 {
+				r.delay = delay
+			}
+			return r
+		}
+	}
+}
+
+// Reservation holds the tokens taken by ReserveN until they are canceled.
+type Reservation struct {
+	lim    *Limiter
+	tokens int64
+	delay  time.Duration
+}
+
+// Delay returns how long to wait until the reserved tokens are available.
+func (r *Reservation) Delay() time.Duration { return r.delay }
+
+// Cancel returns the reserved tokens to the limiter.
+func (r *Reservation) Cancel() {
+	if r.tokens == 0 {
+		return
+	}
+	r.lim.lastTime.Add(-int64(r.lim.limit.durationFromTokens(r.tokens)))
+	r.tokens = 0
+}
+
 type LimiterMap struct {
 	new   func(key uint64) *Limiter
 	table *xsync.MapOf[uint64, *Limiter]
@@ -33,6 +33,78 @@ func TestLimiter(t *testing.T) {
 		}
 	}
 }
+func TestLimiterDelay(t *testing.T) {
+	now := unixtime.Now()
+
+	type Test struct {
+		name   string
+		allow  int64
+		after  time.Duration
+		amount int64
+		delay  time.Duration
+	}
+
+	tests := []Test{
+		{name: "full bucket", amount: 5, delay: 0},
+		{name: "empty bucket", allow: 5, amount: 1, delay: 10 * time.Millisecond},
+		{name: "empty bucket n", allow: 5, amount: 3, delay: 30 * time.Millisecond},
+		{name: "partially refilled", allow: 5, after: 25 * time.Millisecond, amount: 1, delay: 0},
+		{name: "partially refilled n", allow: 5, after: 25 * time.Millisecond, amount: 3, delay: 5 * time.Millisecond},
+		{name: "n > burst", allow: 5, amount: 100, delay: 50 * time.Millisecond},
+		{name: "n > burst full bucket", amount: 100, delay: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lim := NewLimiter(Every(10*time.Millisecond), 5)
+			if test.allow > 0 {
+				require.Equal(t, test.allow, lim.AllowN(now, test.allow))
+			}
+			require.Equal(t, test.delay, lim.Delay(now.Add(test.after), test.amount))
+		})
+	}
+}
+func TestLimiterReserveN(t *testing.T) {
+	now := unixtime.Now()
+	lim := NewLimiter(Every(10*time.Millisecond), 5)
+
+	r := lim.ReserveN(now, 3)
+	require.Equal(t, time.Duration(0), r.Delay())
+
+	r = lim.ReserveN(now, 3)
+	require.Equal(t, 10*time.Millisecond, r.Delay())
+	r.Cancel()
+	r.Cancel()
+
+	require.Equal(t, time.Duration(0), lim.Delay(now, 2))
+	require.Equal(t, int64(2), lim.AllowN(now, 2))
+	require.Equal(t, int64(0), lim.AllowN(now, 1))
+
+	r = lim.ReserveN(now.Add(time.Hour), 100)
+	require.Equal(t, time.Duration(0), r.Delay())
+	require.Equal(t, int64(0), lim.AllowN(now.Add(time.Hour), 1))
+}
+func TestLimiterReserveNParallel(t *testing.T) {
+	now := unixtime.Now()
+	lim := NewLimiter(Every(time.Millisecond), 1000)
+	var reserved atomic.Int64
+	var group syncutil.Group
+	for i := 0; i < runtime.NumCPU(); i++ {
+		group.Go(func() error {
+			for i := 0; i < 1000; i++ {
+				r := lim.ReserveN(now, 1)
+				if r.Delay() > 0 {
+					r.Cancel()
+					continue
+				}
+				reserved.Add(1)
+			}
+			return nil
+		})
+	}
+	group.Wait()
+	require.LessOrEqual(t, reserved.Load(), int64(1000))
+	require.Positive(t, reserved.Load())
+}
 func TestLimiterParallel(t *testing.T) {
 	lim := NewLimiter(Every(time.Millisecond), 1)
 	var allowed atomic.Int64